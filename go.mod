@@ -6,6 +6,8 @@ toolchain go1.24.0
 
 require (
 	github.com/blang/semver/v4 v4.0.0
+	github.com/creack/pty v1.1.24
+	github.com/fsnotify/fsnotify v1.8.0
 	github.com/gomarkdown/markdown v0.0.0-20241105142532-d03b89096d81
 	github.com/gorilla/sessions v1.4.0
 	github.com/monopole/shexec v0.2.1
@@ -17,6 +19,7 @@ require (
 	github.com/yuin/goldmark v1.7.8
 	github.com/yuin/goldmark-highlighting/v2 v2.0.0-20230729083705-37449abec8cc
 	go.abhg.dev/goldmark/mermaid v0.5.0
+	golang.org/x/text v0.23.0
 )
 
 require (
@@ -29,6 +32,6 @@ require (
 	github.com/spf13/pflag v1.0.6 // indirect
 	github.com/tdewolff/parse/v2 v2.7.19 // indirect
 	golang.org/x/net v0.38.0 // indirect
-	golang.org/x/text v0.23.0 // indirect
+	golang.org/x/sys v0.31.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,121 @@
+// Package ansi deals with the ANSI escape sequences that command line
+// tools use to color their output, e.g. "\x1b[32m" for green.
+package ansi
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// csi matches a control sequence introducer and its arguments,
+// e.g. "\x1b[1;31m".  SGR (Select Graphic Rendition), the sequences
+// that set colors, are the common case, but cursor motion and the
+// like are matched as well, since none of it helps in a browser.
+var csi = regexp.MustCompile("\x1b\\[([0-9;?]*)[ -/]*([@-~])")
+
+// Strip removes escape sequences from the string.
+func Strip(s string) string {
+	return csi.ReplaceAllString(s, "")
+}
+
+// palette holds the basic eight colors, followed by their bright
+// versions, in SGR order (black, red, green, yellow, blue, magenta,
+// cyan, white).
+var palette = [16]string{
+	"#000000", "#cd3131", "#0dbc79", "#e5e510",
+	"#2472c8", "#bc3fbc", "#11a8cd", "#e5e5e5",
+	"#666666", "#f14c4c", "#23d18b", "#f5f543",
+	"#3b8eea", "#d670d6", "#29b8db", "#ffffff",
+}
+
+const noColor = -1
+
+// style is the rendition in effect at some point in the text.
+type style struct {
+	fg, bg int
+	bold   bool
+}
+
+var plain = style{fg: noColor, bg: noColor}
+
+// apply changes the style per the parameters of an SGR sequence.
+// Unsupported parameters (underline, 256 colors, etc.) are ignored.
+func (st *style) apply(params string) {
+	if params == "" {
+		*st = plain
+		return
+	}
+	for _, p := range strings.Split(params, ";") {
+		n, err := strconv.Atoi(p)
+		switch {
+		case err != nil && p != "":
+			continue
+		case n == 0:
+			*st = plain
+		case n == 1:
+			st.bold = true
+		case n == 22:
+			st.bold = false
+		case n >= 30 && n <= 37:
+			st.fg = n - 30
+		case n == 39:
+			st.fg = noColor
+		case n >= 40 && n <= 47:
+			st.bg = n - 40
+		case n == 49:
+			st.bg = noColor
+		case n >= 90 && n <= 97:
+			st.fg = n - 90 + 8
+		case n >= 100 && n <= 107:
+			st.bg = n - 100 + 8
+		}
+	}
+}
+
+// css returns the inline CSS for the style.
+func (st style) css() string {
+	var rules []string
+	if st.fg != noColor {
+		rules = append(rules, "color:"+palette[st.fg])
+	}
+	if st.bg != noColor {
+		rules = append(rules, "background-color:"+palette[st.bg])
+	}
+	if st.bold {
+		rules = append(rules, "font-weight:bold")
+	}
+	return strings.Join(rules, ";")
+}
+
+// ToHtml converts the SGR sequences in the string to spans styled
+// with the basic sixteen colors and bold.  The text is escaped, so the
+// result is safe to assign to innerHTML.  Other sequences are dropped.
+func ToHtml(s string) template.HTML {
+	var b strings.Builder
+	st := plain
+	write := func(text string) {
+		if text == "" {
+			return
+		}
+		if css := st.css(); css != "" {
+			b.WriteString("<span style='" + css + "'>")
+			b.WriteString(html.EscapeString(text))
+			b.WriteString("</span>")
+			return
+		}
+		b.WriteString(html.EscapeString(text))
+	}
+	prev := 0
+	for _, m := range csi.FindAllStringSubmatchIndex(s, -1) {
+		write(s[prev:m[0]])
+		if s[m[4]:m[5]] == "m" {
+			st.apply(s[m[2]:m[3]])
+		}
+		prev = m[1]
+	}
+	write(s[prev:])
+	return template.HTML(b.String())
+}
@@ -0,0 +1,88 @@
+package ansi_test
+
+import (
+	"testing"
+
+	. "github.com/monopole/mdrip/v2/internal/ansi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStrip(t *testing.T) {
+	for n, tc := range map[string]struct {
+		arg  string
+		want string
+	}{
+		"empty": {},
+		"plain": {
+			arg:  "hello there",
+			want: "hello there",
+		},
+		"lsColor": {
+			arg: "\x1b[0m\x1b[01;34mbin\x1b[0m  \x1b[01;32mrun.sh\x1b[0m  notes.txt\n" +
+				"\x1b[01;36mlink\x1b[0m\n",
+			want: "bin  run.sh  notes.txt\nlink\n",
+		},
+		"boldRed": {
+			arg:  "\x1b[1;31mFAIL\x1b[m done",
+			want: "FAIL done",
+		},
+		"cursorMotion": {
+			arg:  "50%\x1b[2K\x1b[1G100%",
+			want: "50%100%",
+		},
+		"loneEscape": {
+			arg:  "a\x1bb",
+			want: "a\x1bb",
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			assert.Equal(t, tc.want, Strip(tc.arg))
+		})
+	}
+}
+
+func TestToHtml(t *testing.T) {
+	for n, tc := range map[string]struct {
+		arg  string
+		want string
+	}{
+		"empty": {},
+		"plainIsEscaped": {
+			arg:  "a < b && c",
+			want: "a &lt; b &amp;&amp; c",
+		},
+		"green": {
+			arg:  "ok \x1b[32mPASS\x1b[0m done",
+			want: "ok <span style='color:#0dbc79'>PASS</span> done",
+		},
+		"boldRedThenReset": {
+			arg:  "\x1b[1;31mFAIL\x1b[m",
+			want: "<span style='color:#cd3131;font-weight:bold'>FAIL</span>",
+		},
+		"brightOnBackground": {
+			arg: "\x1b[94;41mx\x1b[39my\x1b[49mz",
+			want: "<span style='color:#3b8eea;background-color:#cd3131'>x</span>" +
+				"<span style='background-color:#cd3131'>y</span>z",
+		},
+		"boldOff": {
+			arg:  "\x1b[1mx\x1b[22my",
+			want: "<span style='font-weight:bold'>x</span>y",
+		},
+		"escapedInsideColor": {
+			arg:  "\x1b[33m<warn>\x1b[0m",
+			want: "<span style='color:#e5e510'>&lt;warn&gt;</span>",
+		},
+		"nonSgrDropped": {
+			arg:  "50%\x1b[2K\x1b[1G100%",
+			want: "50%100%",
+		},
+		"unsupportedIgnored": {
+			arg:  "\x1b[4mx\x1b[0m",
+			want: "x",
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			assert.Equal(t, tc.want, string(ToHtml(tc.arg)))
+		})
+	}
+}
@@ -28,6 +28,9 @@ func NewCommand(ldr *loader.FsLoader, p parsren.MdParserRenderer) *cobra.Command
 				return nil
 			}
 			fld.Accept(p)
+			if err = p.Error(); err != nil {
+				return err
+			}
 			loader.PrintTitles(os.Stdout, p.Filter(parsren.AllBlocks))
 			return nil
 		},
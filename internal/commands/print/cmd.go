@@ -58,6 +58,9 @@ cleaner output, showing only the failing block and its output streams.
 				loader.NewVisitorDump(os.Stdout).VisitFolder(fld)
 			}
 			fld.Accept(p)
+			if err = p.Error(); err != nil {
+				return err
+			}
 			filter := parsren.AllBlocksButSkip
 			if flags.label != "" {
 				filter = func(b *loader.CodeBlock) bool {
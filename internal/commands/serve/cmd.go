@@ -1,31 +1,84 @@
 package serve
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/monopole/mdrip/v2/internal/loader"
 	"github.com/monopole/mdrip/v2/internal/parsren"
+	"github.com/monopole/mdrip/v2/internal/shell"
 	"github.com/monopole/mdrip/v2/internal/tmux"
 	"github.com/monopole/mdrip/v2/internal/utils"
 	"github.com/monopole/mdrip/v2/internal/web/server"
 	"github.com/spf13/cobra"
 )
 
-const cmdName = "serve"
+const (
+	cmdName          = "serve"
+	durationStartup  = 10 * time.Second
+	durationShutdown = 3 * time.Second
+)
 
 type myFlags struct {
 	port        int
 	title       string
 	useHostName bool
+	watch       bool
+	dryRun      bool
+	shell       string
+	shellArgs   []string
+	wrapper     []string
+	isolate     bool
+	interactive bool
+	runLangs    []string
+	staticIcon  bool
+	extraCss    []string
+	timeout     time.Duration
+	routePrefix string
+	cookieName  string
+	cookieAge   time.Duration
+	secure      bool
+	sameSite    string
+	auditLog    bool
+	redact      []string
+	history     string
+	encoding    string
+	profile     string
+	maxRuns     int
+	queueWait   time.Duration
+	allowed     []string
+	firstWord   bool
+	socket      string
+	certFile    string
+	keyFile     string
+	redirect    int
+}
+
+// address for the server, a Unix socket if one was named.
+func (fl *myFlags) address() string {
+	if fl.socket != "" {
+		return server.UnixPrefix + fl.socket
+	}
+	return fl.hostAndPort()
 }
 
 // hostAndPort for the server.
 func (fl *myFlags) hostAndPort() string {
+	return fl.hostName() + ":" + strconv.Itoa(fl.port)
+}
+
+// redirectAddress is where to serve HTTP to HTTPS redirects.
+func (fl *myFlags) redirectAddress() string {
+	return fl.hostName() + ":" + strconv.Itoa(fl.redirect)
+}
+
+func (fl *myFlags) hostName() string {
 	hostname := "" // docker breaks if one uses localhost here
 	if fl.useHostName {
 		var err error
@@ -34,7 +87,7 @@ func (fl *myFlags) hostAndPort() string {
 			slog.Error("trouble with hostname", "err", err)
 		}
 	}
-	return hostname + ":" + strconv.Itoa(fl.port)
+	return hostname
 }
 
 func makeTitle(t string, args []string) string {
@@ -63,17 +116,79 @@ func NewCommand(ldr *loader.FsLoader, p parsren.MdParserRenderer) *cobra.Command
 			if len(args) == 0 {
 				args = []string{string(loader.CurrentDir)}
 			}
+			p.SetRunnableLanguages(loader.NewLanguageSet(flags.runLangs...))
 			dl := server.NewDataLoader(
 				ldr, args, p, makeTitle(flags.title, args))
 			// Heat up the cache, and see if the args are okay.
 			if err := dl.LoadAndRender(); err != nil {
 				return fmt.Errorf("data loader fail; %w", err)
 			}
-			s, err := server.NewServer(dl, getCommandRunner())
+			runner, err := getCommandRunner(&flags)
+			if err != nil {
+				return err
+			}
+			if sh, ok := runner.(*shell.ManagedShell); ok {
+				defer func() { _ = sh.Stop(durationShutdown) }()
+			}
+			if flags.history != "" {
+				runner = shell.NewHistory(runner, flags.history)
+			}
+			s, err := server.NewServer(dl, runner)
 			if err != nil {
 				return err
 			}
-			return s.Serve(flags.hostAndPort())
+			s.SetStaticFavicon(flags.staticIcon)
+			s.SetExtraCss(flags.extraCss)
+			s.SetRunTimeout(flags.timeout)
+			if err = s.SetMaxRuns(flags.maxRuns, flags.queueWait); err != nil {
+				return err
+			}
+			if err = s.SetRoutePrefix(flags.routePrefix); err != nil {
+				return err
+			}
+			if err = s.SetCookieName(flags.cookieName); err != nil {
+				return err
+			}
+			if err = s.SetCookieMaxAge(flags.cookieAge); err != nil {
+				return err
+			}
+			if strings.EqualFold(flags.sameSite, "none") && !flags.secure {
+				return fmt.Errorf("--cookie-same-site none requires --cookie-secure")
+			}
+			s.SetCookieSecure(flags.secure)
+			if err = s.SetCookieSameSite(flags.sameSite); err != nil {
+				return err
+			}
+			if err = s.SetAllowedCommands(flags.allowed, flags.firstWord); err != nil {
+				return err
+			}
+			s.SetAuditLog(flags.auditLog)
+			if len(flags.redact) > 0 {
+				r, err := server.RedactPatterns(flags.redact)
+				if err != nil {
+					return err
+				}
+				s.SetRedactor(r)
+			}
+			if err = s.SetTls(flags.certFile, flags.keyFile); err != nil {
+				return err
+			}
+			if flags.redirect > 0 {
+				if flags.certFile == "" {
+					return fmt.Errorf("--redirect-http-port requires --cert-file")
+				}
+				s.SetHttpRedirect(flags.redirectAddress())
+			}
+			if flags.watch {
+				stop, err := s.Watch(server.DefaultDebounce)
+				if err != nil {
+					// Not fatal; one can still reload by hand.
+					slog.Warn("unable to watch for changes", "err", err)
+				} else {
+					defer stop()
+				}
+			}
+			return s.Serve(flags.address())
 		},
 	}
 	// TODO: pull title from the first header of the first markdown file?
@@ -87,30 +202,237 @@ func NewCommand(ldr *loader.FsLoader, p parsren.MdParserRenderer) *cobra.Command
 		"port",
 		8080,
 		"Port at which to serve HTTP requests for the demo.")
+	c.Flags().StringVar(
+		&flags.socket,
+		"socket",
+		"",
+		"Path to a Unix domain socket at which to serve HTTP requests,\n"+
+			"e.g. for use behind a reverse proxy; overrides --port.")
+	c.Flags().StringVar(
+		&flags.certFile,
+		"cert-file",
+		"",
+		"Path to a TLS certificate file; with --key-file, serve HTTPS.")
+	c.Flags().StringVar(
+		&flags.keyFile,
+		"key-file",
+		"",
+		"Path to the TLS private key file matching --cert-file.")
+	c.Flags().IntVar(
+		&flags.redirect,
+		"redirect-http-port",
+		0,
+		"With --cert-file, also serve HTTP at this port, redirecting to HTTPS.")
 	c.Flags().BoolVar(
 		&flags.useHostName,
 		"use-host-name",
 		false,
 		"Use the 'hostname' utility to specify where to serve, else implicitly use 'localhost'.")
+	c.Flags().BoolVar(
+		&flags.watch,
+		"watch",
+		false,
+		"Watch the markdown for changes, and reload automatically.")
+	c.Flags().BoolVar(
+		&flags.dryRun,
+		"dry-run",
+		false,
+		"Print code blocks to stdout rather than sending them to "+tmux.PgmName+".")
+	c.Flags().StringVar(
+		&flags.shell,
+		"shell",
+		"",
+		"Run code blocks in a shell managed by "+utils.PgmName+", e.g. /bin/bash,\n"+
+			"rather than sending them to "+tmux.PgmName+".  Blocks that use exec to\n"+
+			"replace the shell, or to redirect its stdin, stdout or stderr,\n"+
+			"are refused; do such things in a subshell, i.e. ( ... ).")
+	c.Flags().StringSliceVar(
+		&flags.shellArgs,
+		"shell-args",
+		nil,
+		"With --shell, arguments for it, e.g. '--norc,--noprofile'; or with\n"+
+			"--shell docker, e.g. 'exec,-i,mycontainer,bash' to run blocks in a container.")
+	c.Flags().StringSliceVar(
+		&flags.wrapper,
+		"command-wrapper",
+		nil,
+		"With --shell, a command prefix, e.g. 'timeout,30', used to run\n"+
+			"each block in its own subshell, e.g. for time limits or sandboxing.")
+	c.Flags().BoolVar(
+		&flags.isolate,
+		"isolate",
+		false,
+		"With --shell, run each block in its own subshell, so that e.g. a cd\n"+
+			"in one block doesn't affect the next.")
+	c.Flags().BoolVar(
+		&flags.interactive,
+		"interactive",
+		false,
+		"With --shell, run the blocks on a pseudo-terminal, for programs like\n"+
+			"ssh or top that need one; stderr is then merged into stdout.")
+	c.Flags().StringVar(
+		&flags.encoding,
+		"output-encoding",
+		"",
+		"With --shell, the encoding of the blocks' output, e.g. latin1,\n"+
+			"converted to UTF-8 for display; 'auto' means per the locale.")
+	c.Flags().StringVar(
+		&flags.profile,
+		"profile",
+		"",
+		"With --shell, a script, or the path to one, e.g. setup.sh, run in\n"+
+			"the shell before any block, e.g. to define functions the blocks use.")
+	c.Flags().StringSliceVar(
+		&flags.runLangs,
+		"run-langs",
+		loader.DefaultRunnableLanguageNames,
+		"Fenced code block languages that may be run; the empty string\n"+
+			"means blocks with no language.")
+	c.Flags().BoolVar(
+		&flags.staticIcon,
+		"static-favicon",
+		false,
+		"Serve a static favicon, rather than generating an animated one.")
+	c.Flags().DurationVar(
+		&flags.timeout,
+		"block-timeout",
+		5*time.Minute,
+		"How long a code block may run; the web app waits a little longer.\n"+
+			"Zero means no limit beyond the shell's own.")
+	c.Flags().IntVar(
+		&flags.maxRuns,
+		"max-runs",
+		0,
+		"How many block or tag runs may be under way at once; zero means\n"+
+			"no limit.  Runs beyond the limit are refused with status 429.")
+	c.Flags().DurationVar(
+		&flags.queueWait,
+		"run-queue-timeout",
+		0,
+		"With --max-runs, how long a run beyond the limit waits for\n"+
+			"another to finish before it's refused; zero means don't wait.")
+	c.Flags().StringSliceVar(
+		&flags.extraCss,
+		"extra-css",
+		nil,
+		"URLs of stylesheets to load after mdrip's own, e.g. a theme.")
+	c.Flags().StringVar(
+		&flags.routePrefix,
+		"route-prefix",
+		"",
+		"A path, e.g. '/mdrip', below which to serve everything,\n"+
+			"e.g. when behind a proxy alongside other apps.")
+	c.Flags().StringVar(
+		&flags.cookieName,
+		"cookie-name",
+		utils.PgmName,
+		"The name of the session cookie.")
+	c.Flags().DurationVar(
+		&flags.cookieAge,
+		"cookie-max-age",
+		server.DefaultCookieMaxAge,
+		"How long the session cookie lasts; zero means until the browser closes.")
+	c.Flags().BoolVar(
+		&flags.secure,
+		"cookie-secure",
+		false,
+		"Mark the session cookie Secure, so it's only sent over HTTPS,\n"+
+			"e.g. when serving HTTPS or behind a proxy that does.")
+	c.Flags().StringVar(
+		&flags.sameSite,
+		"cookie-same-site",
+		"",
+		"The session cookie's SameSite attribute: lax, strict or none;\n"+
+			"none requires --cookie-secure.")
+	c.Flags().StringArrayVar(
+		&flags.allowed,
+		"allowed-command",
+		nil,
+		"A regular expression, e.g. 'kubectl get .*', matching whole commands\n"+
+			"that blocks may run; blocks with other commands are refused.\n"+
+			"May be repeated.  Without it, any command may run.")
+	c.Flags().BoolVar(
+		&flags.firstWord,
+		"allowed-command-first-word",
+		false,
+		"Match --allowed-command patterns against only the first word of\n"+
+			"each command, e.g. 'kubectl|echo', allowing any arguments.")
+	c.Flags().BoolVar(
+		&flags.auditLog,
+		"audit-log",
+		false,
+		"Log a record of each code block run: session, code, time and result.")
+	c.Flags().StringArrayVar(
+		&flags.redact,
+		"audit-redact",
+		nil,
+		"A regular expression matching secrets to mask in audit records;\n"+
+			"if it has groups, only the groups are masked, e.g. 'TOKEN=(\\S+)'.\n"+
+			"May be repeated.")
 	return c
 }
 
-func getCommandRunner() io.Writer {
+func getCommandRunner(flags *myFlags) (shell.Executor, error) {
+	if flags.dryRun {
+		return &dryRunner{w: os.Stdout}, nil
+	}
+	if len(flags.wrapper) > 0 && flags.shell == "" {
+		return nil, fmt.Errorf("--command-wrapper requires --shell")
+	}
+	if flags.isolate && flags.shell == "" {
+		return nil, fmt.Errorf("--isolate requires --shell")
+	}
+	if flags.interactive && flags.shell == "" {
+		return nil, fmt.Errorf("--interactive requires --shell")
+	}
+	if flags.interactive && flags.isolate {
+		return nil, fmt.Errorf("--interactive and --isolate don't mix")
+	}
+	if flags.shell != "" {
+		sh := shell.NewManagedShell(flags.shell, flags.shellArgs...)
+		sh.SetCommandWrapper(flags.wrapper)
+		sh.SetIsolated(flags.isolate)
+		sh.SetInteractive(flags.interactive)
+		sh.SetProfileScript(flags.profile)
+		if err := sh.SetOutputEncoding(flags.encoding); err != nil {
+			return nil, err
+		}
+		if err := sh.Start(durationStartup); err != nil {
+			// Serve the markdown anyway, e.g. for read-only docs
+			// on an image lacking the shell.
+			err = fmt.Errorf("unable to start %s; %w", flags.shell, err)
+			slog.Warn("code blocks won't run", "err", err)
+			return shell.NewUnavailable(err), nil
+		}
+		return sh, nil
+	}
 	tx, err := tmux.NewTmux(tmux.PgmName)
 	if err != nil || tx == nil {
 		slog.Warn(tmux.PgmName+" not available", "err", err)
-		return &fakeTmux{}
+		return &fakeTmux{}, nil
 	}
 	if !tx.IsUp() {
 		slog.Warn(tmux.PgmName + " executable present, but not running")
-		return &fakeTmux{}
+		return &fakeTmux{}, nil
 	}
-	return tx
+	return tx, nil
 }
 
 type fakeTmux struct{}
 
-func (tx *fakeTmux) Write(bytes []byte) (int, error) {
-	slog.Debug("Would run", "codeSnip", utils.Summarize(bytes))
-	return 0, nil
+func (tx *fakeTmux) Execute(_ context.Context, code string) (*shell.Result, error) {
+	slog.Debug("Would run", "codeSnip", utils.Summarize([]byte(code)))
+	return &shell.Result{}, nil
+}
+
+// dryRunner shows the code blocks it's asked to run.
+type dryRunner struct {
+	w io.Writer
+}
+
+func (dr *dryRunner) Execute(ctx context.Context, code string) (*shell.Result, error) {
+	if _, err := fmt.Fprintln(dr.w, "# Would run:"); err != nil {
+		return nil, err
+	}
+	return (&shell.Echo{W: dr.w}).Execute(ctx, code)
 }
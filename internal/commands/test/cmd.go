@@ -2,12 +2,13 @@ package test
 
 import (
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/monopole/mdrip/v2/internal/loader"
 	"github.com/monopole/mdrip/v2/internal/parsren"
+	"github.com/monopole/mdrip/v2/internal/shell"
 	"github.com/monopole/shexec"
-	"github.com/monopole/shexec/channeler"
 	"github.com/spf13/cobra"
 )
 
@@ -15,12 +16,19 @@ const (
 	cmdName          = "test"
 	durationStartup  = 10 * time.Second
 	durationShutdown = 3 * time.Second
-	rumple           = "rumpleStiltSkin"
 )
 
 type myFlags struct {
 	quiet        bool
+	dryRun       bool
+	trace        bool
+	wrapper      []string
+	isolate      bool
+	interactive  bool
 	label        string
+	shell        string
+	shellArgs    []string
+	profile      string
 	blockTimeOut time.Duration
 }
 
@@ -48,14 +56,16 @@ and its output and error streams.
 				return err
 			}
 			fld.Accept(p)
+			if err = p.Error(); err != nil {
+				return err
+			}
 			filter := parsren.AllBlocks
 			if flags.label != "" {
 				filter = func(b *loader.CodeBlock) bool {
 					return b.HasLabel(loader.Label(flags.label))
 				}
 			}
-			return runTheBlocks(
-				p.Filter(filter), flags.quiet, flags.blockTimeOut)
+			return runTheBlocks(p.Filter(filter), &flags)
 		},
 		SilenceUsage: true,
 	}
@@ -69,6 +79,52 @@ and its output and error streams.
 		"quiet",
 		false,
 		"Suppress printing of code block names during test.")
+	c.Flags().BoolVar(
+		&flags.dryRun,
+		"dry-run",
+		false,
+		"Print the code blocks that would be run, but don't run them.")
+	c.Flags().BoolVar(
+		&flags.trace,
+		"trace",
+		false,
+		"Trace each command (as in 'set -x') to stderr as it runs.")
+	c.Flags().StringSliceVar(
+		&flags.wrapper,
+		"command-wrapper",
+		nil,
+		"A command prefix, e.g. 'timeout,30', used to run each block\n"+
+			"in its own subshell, e.g. for time limits or sandboxing.")
+	c.Flags().BoolVar(
+		&flags.isolate,
+		"isolate",
+		false,
+		"Run each block in its own subshell, so that e.g. a cd in one block\n"+
+			"doesn't affect the next.")
+	c.Flags().BoolVar(
+		&flags.interactive,
+		"interactive",
+		false,
+		"Run the blocks on a pseudo-terminal, for programs like ssh or top\n"+
+			"that need one; stderr is then merged into stdout.")
+	c.Flags().StringVar(
+		&flags.shell,
+		"shell",
+		shell.DefaultPath,
+		"The shell to run the blocks in. POSIX shells like dash are detected,\n"+
+			"and driven without bashisms.")
+	c.Flags().StringSliceVar(
+		&flags.shellArgs,
+		"shell-args",
+		nil,
+		"Arguments for the shell, e.g. '--norc,--noprofile', given before -e;\n"+
+			"or with --shell docker, e.g. 'exec,-i,mycontainer,bash'.")
+	c.Flags().StringVar(
+		&flags.profile,
+		"profile",
+		"",
+		"A script, or the path to one, e.g. setup.sh, run in the shell\n"+
+			"before any block, e.g. to define functions the blocks use.")
 	c.Flags().DurationVar(
 		&flags.blockTimeOut,
 		"block-time-out",
@@ -78,39 +134,57 @@ and its output and error streams.
 	return c
 }
 
-func runTheBlocks(
-	blocks []*loader.CodeBlock, quiet bool, timeout time.Duration) error {
-	const (
-		unlikelyWordOut = rumple + "Out"
-		unlikelyWordErr = rumple + "Err"
-	)
-	sh := shexec.NewShell(shexec.Parameters{
-		Params: channeler.Params{Path: "/bin/bash", Args: []string{"-e"}},
-		SentinelOut: shexec.Sentinel{
-			C: "echo " + unlikelyWordOut,
-			V: unlikelyWordOut,
-		},
-		SentinelErr: shexec.Sentinel{
-			C: "echo " + unlikelyWordErr + " 1>&2",
-			V: unlikelyWordErr,
-		},
-	})
+func runTheBlocks(blocks []*loader.CodeBlock, flags *myFlags) error {
+	sh := shell.NewManagedShell(
+		flags.shell, append(slices.Clone(flags.shellArgs), "-e")...)
+	sh.SetDryRun(flags.dryRun)
+	sh.SetCommandWrapper(flags.wrapper)
+	sh.SetIsolated(flags.isolate)
+	sh.SetInteractive(flags.interactive)
+	sh.SetProfileScript(flags.profile)
 	if err := sh.Start(durationStartup); err != nil {
 		return err
 	}
-	r := makeReporter(quiet, blocks)
+	r := makeReporter(flags.quiet, blocks)
 	for _, b := range blocks {
 		r.header(b)
 		if b.HasLabel(loader.SkipLabel) {
 			r.skip()
 			continue
 		}
-		c := shexec.NewRecallCommander(b.Code())
-		if err := sh.Run(timeout, c); err != nil {
+		code := b.Code()
+		if flags.trace && b.Interpreter() == "" {
+			code = shell.Traced(code)
+		}
+		if b.Interpreter() == "" {
+			code = shell.WithEnv(loader.ParseEnvOverrides(b.Labels()), code)
+		}
+		c := shexec.NewRecallCommander(code)
+		if err := runBlock(sh, b, c, flags); err != nil {
 			r.fail(err, b, c)
 			return fmt.Errorf("code block %q failed", b.UniqName())
 		}
+		if flags.dryRun {
+			r.plan(c)
+			continue
+		}
 		r.pass()
 	}
-	return sh.Stop(durationShutdown, "")
+	return sh.Stop(durationShutdown)
+}
+
+// runBlock runs the block in the managed shell, unless the
+// block names its own interpreter.
+func runBlock(
+	sh *shell.ManagedShell, b *loader.CodeBlock,
+	c shexec.Commander, flags *myFlags) error {
+	if interp := b.Interpreter(); interp != "" && !sh.DryRun() {
+		argv := []string{interp}
+		if env := loader.ParseEnvOverrides(b.Labels()); len(env) > 0 {
+			argv = append(append([]string{"env"}, env...), argv...)
+		}
+		argv = append(slices.Clone(flags.wrapper), argv...)
+		return shell.RunOnce(flags.blockTimeOut, c, argv[0], argv[1:]...)
+	}
+	return sh.Run(flags.blockTimeOut, c)
 }
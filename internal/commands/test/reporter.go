@@ -78,6 +78,21 @@ func (r *reporter) pass() {
 	fmt.Println()
 }
 
+// plan shows the would-be command of a dry run.
+func (r *reporter) plan(c *shexec.RecallCommander) {
+	if !r.quiet {
+		fmt.Print(colGray)
+		fmt.Print("DRY RUN")
+		fmt.Print(colReset)
+		fmt.Println()
+	}
+	fmt.Print(colCyan)
+	for _, line := range c.DataOut() {
+		fmt.Println(" ", line)
+	}
+	fmt.Print(colReset)
+}
+
 func (r *reporter) fail(
 	_ error, b *loader.CodeBlock, c *shexec.RecallCommander) {
 	// TODO: Get a better error from the infrastructure for reporting.
@@ -91,7 +106,7 @@ func (r *reporter) fail(
 		fmt.Println()
 	}
 
-	_, _ = fmt.Fprintf(os.Stderr, "%s %s:\n", b.Path(), b.UniqName())
+	_, _ = fmt.Fprintf(os.Stderr, "%s %s:\n", b.Location(), b.UniqName())
 	_, _ = fmt.Fprint(os.Stderr, colCyan)
 	for _, line := range strings.Split(b.Code(), "\n") {
 		if len(line) > 0 {
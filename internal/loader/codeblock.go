@@ -9,6 +9,34 @@ import (
 	"github.com/monopole/mdrip/v2/internal/loader/lexer"
 )
 
+// LanguageSet is a set of fenced code block languages, e.g. "bash".
+type LanguageSet map[string]bool
+
+// NewLanguageSet returns a set holding the given languages.
+func NewLanguageSet(langs ...string) LanguageSet {
+	result := make(LanguageSet, len(langs))
+	for _, l := range langs {
+		result[strings.ToLower(strings.TrimSpace(l))] = true
+	}
+	return result
+}
+
+// Has is true if the set has the language (ignoring case).
+func (ls LanguageSet) Has(lang string) bool {
+	return ls[strings.ToLower(lang)]
+}
+
+var (
+	// DefaultRunnableLanguageNames are the fence languages of blocks
+	// that mdrip offers to run. The empty string covers blocks
+	// with no language named in the fence.
+	DefaultRunnableLanguageNames = []string{
+		"", "sh", "bash", "shell", "console"}
+
+	// DefaultRunnableLanguages is DefaultRunnableLanguageNames as a set.
+	DefaultRunnableLanguages = NewLanguageSet(DefaultRunnableLanguageNames...)
+)
+
 // CodeBlock groups code from a FencedCodeBlock with a set of labels.
 type CodeBlock struct {
 	// Labels on a block.  This is a list, rather than a set, because
@@ -16,13 +44,20 @@ type CodeBlock struct {
 	labels     LabelList
 	titleWords []string
 	code       string
-	index      int
-	parent     *MyFile
+	// lang is the language named in the fence info string, e.g. "bash".
+	lang string
+	// runnable is true if the block can be sent to a shell.
+	runnable bool
+	index    int
+	// line is the one-relative number of the line holding the
+	// block's opening fence in the parent file, or zero if unknown.
+	line   int
+	parent *MyFile
 }
 
 func NewCodeBlock(
 	fi *MyFile, code string, index int, labels ...Label) *CodeBlock {
-	b := &CodeBlock{code: code, index: index, parent: fi}
+	b := &CodeBlock{code: code, index: index, parent: fi, runnable: true}
 	b.AddLabels(labels)
 	return b
 }
@@ -37,7 +72,29 @@ const (
 	maxWordSize  = 5
 )
 
-// ResetTitle sets the title words for the block.
+// Line is the one-relative number of the line holding the block's
+// opening fence, or zero if unknown.
+func (cb *CodeBlock) Line() int {
+	return cb.line
+}
+
+// SetLine sets the line number of the block's opening fence.
+func (cb *CodeBlock) SetLine(n int) {
+	cb.line = n
+}
+
+// Location is the block's path and line number joined by a colon,
+// in the style of compiler error messages, e.g. "doc/intro.md:42".
+func (cb *CodeBlock) Location() string {
+	if cb.line < 1 {
+		return string(cb.Path())
+	}
+	return string(cb.Path()) + ":" + strconv.Itoa(cb.line)
+}
+
+// ResetTitle sets the title words for the block.  Generated names
+// are made unique by counting them in disAmbig, which should already
+// hold the names given to blocks in the file by name labels.
 func (cb *CodeBlock) ResetTitle(disAmbig map[string]int) {
 	var normal []string
 	var special []string
@@ -48,14 +105,17 @@ func (cb *CodeBlock) ResetTitle(disAmbig map[string]int) {
 			normal = append(normal, string(l))
 		}
 	}
-	var first string
-	if len(normal) > 0 {
+	first := cb.Name()
+	switch {
+	case first != "":
+		// Leave it as is; it's up to the author to keep it unique.
+	case len(normal) > 0:
 		first = normal[0]
 		normal = normal[1:]
-	} else {
+	default:
 		first = lexer.MakeIdentifier(cb.code, maxWordsInId, maxWordSize)
 	}
-	if disAmbig != nil {
+	if disAmbig != nil && cb.Name() == "" {
 		c := disAmbig[first]
 		c++
 		disAmbig[first] = c
@@ -66,8 +126,15 @@ func (cb *CodeBlock) ResetTitle(disAmbig map[string]int) {
 	cb.titleWords = append(append([]string{first}, normal...), special...)
 }
 
+// Name returns the name given the block by a name label, or the
+// empty string if it has none.
+func (cb *CodeBlock) Name() string {
+	return ParseName(cb.labels)
+}
+
 // UniqName returns the name of the code block, assured to be
-// unique within the file it came from.
+// unique within the file it came from.  It's the block's Name if
+// it has one, else one generated from its labels or code.
 func (cb *CodeBlock) UniqName() string {
 	return cb.titleWords[0]
 }
@@ -91,6 +158,41 @@ func (cb *CodeBlock) Code() string {
 	return cb.code
 }
 
+// Language is the language named in the fence, or empty if none named.
+func (cb *CodeBlock) Language() string {
+	return cb.lang
+}
+
+// SetLanguage sets the language of the block, and
+// whether the block is runnable given that language.
+func (cb *CodeBlock) SetLanguage(l string, runnable LanguageSet) {
+	cb.lang = l
+	cb.runnable = runnable.Has(l)
+}
+
+// IsRunnable is true if the block's language suggests that it
+// can be sent to a shell, or if it names its own interpreter.
+func (cb *CodeBlock) IsRunnable() bool {
+	return cb.runnable || cb.Interpreter() != ""
+}
+
+// Interpreter returns the program named by an interpreter label
+// on the block, or the empty string if the block should be run
+// by the shell.
+func (cb *CodeBlock) Interpreter() string {
+	for _, l := range cb.labels {
+		if s := l.Interpreter(); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// Labels returns the block's labels.
+func (cb *CodeBlock) Labels() LabelList {
+	return cb.labels
+}
+
 // HasLabel is true if the block has the given label argument.
 func (cb *CodeBlock) HasLabel(label Label) bool {
 	return cb.labels.Contains(label)
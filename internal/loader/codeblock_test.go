@@ -77,3 +77,35 @@ func Test_codeBlock_HasLabel(t *testing.T) {
 		})
 	}
 }
+
+func TestCodeBlockInterpreter(t *testing.T) {
+	for n, tc := range map[string]struct {
+		labels []Label
+		interp string
+		name   string
+	}{
+		"none": {
+			labels: []Label{"protein"},
+			name:   "protein",
+		},
+		"python": {
+			labels: []Label{"interp=python3", "protein"},
+			interp: "python3",
+			name:   "protein",
+		},
+		"emptyInterp": {
+			labels: []Label{"interp="},
+			name:   "interp=",
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			b := NewCodeBlock(nil, "print(1)", 0, tc.labels...)
+			b.ResetTitle(nil)
+			assert.Equal(t, tc.interp, b.Interpreter())
+			assert.Equal(t, tc.name, b.UniqName())
+			if tc.interp != "" {
+				assert.True(t, b.IsRunnable())
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package loader
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
@@ -26,6 +27,19 @@ func New(fs afero.Fs, allowedFile FsFilter, allowedFolder FsFilter) *FsLoader {
 	}
 }
 
+// NewFromFS returns a loader reading the given read-only file system,
+// e.g. an embed.FS holding docs baked into a binary, or, in tests,
+// an fstest.MapFS.  Per fs.FS, the paths to load are slash-separated
+// and unrooted, e.g. "." or "docs", rather than "/docs".
+func NewFromFS(fsys fs.FS, allowedFile FsFilter, allowedFolder FsFilter) *FsLoader {
+	return New(afero.FromIOFS{FS: fsys}, allowedFile, allowedFolder)
+}
+
+// Fs is the file system the loader reads.
+func (fsl *FsLoader) Fs() afero.Fs {
+	return fsl.fs.Fs
+}
+
 const (
 	ReadmeFileName   = "README.md"
 	OrderingFileName = "README_ORDER.txt"
@@ -109,7 +123,10 @@ func (fsl *FsLoader) LoadOneTree(rawPath FilePath) (*MyFolder, error) {
 // If an "OrderingFileName" is found in a folder, it's used to sort the files
 // and sub-folders in that folder's in-memory representation. An ordering file
 // is just lines of text, one name per line. Ordered files appear first, with
-// the remainder in the order imposed by fs.ReadDir.
+// the remainder in lexical order by name, as imposed by afero's ReadDir,
+// which sorts what the file system returns.  So the order, and the file
+// indices that follow from it, are the same from one load to the next,
+// whatever the file system.
 //
 // Any error returned will be from the file system.
 func (fsl *FsLoader) LoadFolder(rawPath FilePath) (*MyFolder, error) {
@@ -2,9 +2,11 @@ package loader_test
 
 import (
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"os"
 	"testing"
+	"testing/fstest"
 
 	. "github.com/monopole/mdrip/v2/internal/loader"
 	"github.com/spf13/afero"
@@ -316,6 +318,20 @@ func TestLoadFolderFromMemoryHappy(t *testing.T) {
 				return NewFolder("/").AddFile(md[10]).AddFolder(jjj).AddFolder(mmm)
 			},
 		},
+		"reorderingFileWithCrlf": {
+			fillFs: func(tt *testing.T, fs afero.Fs) {
+				makeLargeAbsFs(tt, fs)
+				assert.NoError(tt, afero.WriteFile(fs, "/jjj/"+OrderingFileName,
+					[]byte("ccc\r\n bbb \r\n\r\naaa\r\n"), RW))
+			},
+			pathToLoad: "/jjj",
+			expectedFld: func() *MyFolder {
+				bbb := NewFolder("bbb").AddFile(md[5])
+				ccc := NewFolder("ccc").AddFile(md[2]).AddFile(md[3])
+				aaa := NewFolder("aaa").AddFile(md[0]).AddFile(md[1])
+				return NewFolder("/jjj").AddFile(md[4]).AddFolder(ccc).AddFolder(bbb).AddFolder(aaa)
+			},
+		},
 	} {
 		t.Run(n, func(t *testing.T) {
 			fs := afero.NewMemMapFs() // afero.NewOsFs()
@@ -339,6 +355,59 @@ func TestLoadFolderFromMemoryHappy(t *testing.T) {
 	}
 }
 
+// The in-memory file system keeps folders in maps, so it lists
+// them in no particular order; loads must come out the same anyway.
+func TestLoadFolderOrderIsStable(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	makeLargeAbsFs(t, fs)
+	ldr := New(fs, IsMarkDownFile, IsWhatever)
+	first, err := ldr.LoadFolder("/")
+	assert.NoError(t, err)
+	want := filePaths(first)
+	assert.Len(t, want, len(md))
+	for i := 0; i < 20; i++ {
+		fld, err := ldr.LoadFolder("/")
+		assert.NoError(t, err)
+		assert.Equal(t, want, filePaths(fld), "load %d", i)
+	}
+}
+
+func TestLoadFolderFromFS(t *testing.T) {
+	ldr := NewFromFS(fstest.MapFS{
+		ReadmeFileName:  {Data: readmeMd.C()},
+		"b/f01.md":      {Data: md[1].C()},
+		"b/f00.md":      {Data: md[0].C()},
+		"b/pic.png":     {Data: []byte("not markdown")},
+		".git/hooks.md": {Data: md[2].C()},
+	}, IsMarkDownFile, InNotIgnorableFolder)
+	fld, err := ldr.LoadFolder(CurrentDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []FilePath{"README.md", "b/f00.md", "b/f01.md"}, filePaths(fld))
+
+	fld, err = ldr.LoadFolder("b/f01.md")
+	assert.NoError(t, err)
+	assert.Equal(t, []FilePath{"b/f01.md"}, filePaths(fld))
+
+	_, err = ldr.LoadFolder("nope")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+// filePaths returns the paths of the files in the folder, in visiting order.
+func filePaths(fld *MyFolder) []FilePath {
+	v := &pathCollector{}
+	fld.Accept(v)
+	return v.paths
+}
+
+type pathCollector struct {
+	paths []FilePath
+}
+
+func (v *pathCollector) VisitTopFolder(fl *MyTopFolder) { fl.VisitChildren(v) }
+func (v *pathCollector) VisitFolder(fl *MyFolder)       { fl.VisitChildren(v) }
+func (v *pathCollector) VisitFile(fi *MyFile)           { v.paths = append(v.paths, fi.Path()) }
+func (v *pathCollector) Error() error                   { return nil }
+
 const runTheUnportableLocalFileSystemDependentTests = false
 
 func TestLoadOneTree(t *testing.T) {
@@ -1,5 +1,13 @@
 package loader
 
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
 // Label is used to select code blocks, and group them into
 // categories, e.g. run these blocks under test, run these blocks to do setup, etc.
 type Label string
@@ -14,10 +22,74 @@ const (
 
 	// SkipLabel is used on blocks that should be skipped in some context.
 	SkipLabel = Label(`skip`)
+
+	// InterpLabelPrefix starts a label naming a program, other than
+	// the shell, that should read the block from stdin and run it,
+	// e.g. @interp=python3
+	InterpLabelPrefix = `interp=`
+
+	// ExpectFailLabel marks a block that's expected to fail, i.e.
+	// that should exit with any non-zero status.
+	ExpectFailLabel = Label(`expect_fail`)
+
+	// ExitLabelPrefix starts a label pinning the exit status expected
+	// of a block, e.g. @exit=2
+	ExitLabelPrefix = `exit=`
+
+	// AssertLabelPrefix starts a label carrying text that should
+	// appear in a block's stdout, e.g. @assert=hello
+	AssertLabelPrefix = `assert=`
+
+	// AssertReLabelPrefix starts a label carrying a regular expression
+	// that should match a block's stdout, e.g. @assertre=^v[0-9]+\.
+	AssertReLabelPrefix = `assertre=`
+
+	// TimeoutLabelPrefix starts a label limiting the time, in seconds,
+	// that a block may run, e.g. @timeout=90
+	TimeoutLabelPrefix = `timeout=`
+
+	// NameLabelPrefix starts a label giving a block a name that's
+	// stable across edits, unlike a generated one, e.g. @name=install
+	NameLabelPrefix = `name=`
+
+	// EnvLabelPrefix starts a label setting an environment variable
+	// for just the one block, e.g. @env=DEBUG=1
+	EnvLabelPrefix = `env=`
 )
 
+// AnyFailure is the exit status returned by ParseExpectedExit for
+// blocks that should fail with any non-zero status.
+const AnyFailure = -1
+
 type LabelList []Label
 
+// NewLabelList returns the labels of the blocks, each label once,
+// in order of first appearance, including special labels like
+// @skip, which matter to whatever runs the blocks.
+func NewLabelList(cbs []*CodeBlock) LabelList {
+	return newLabelList(cbs, func(Label) bool { return true })
+}
+
+// NewUserLabelList is NewLabelList sans special labels, leaving
+// the categories an author gave the blocks, e.g. for navigation.
+func NewUserLabelList(cbs []*CodeBlock) LabelList {
+	return newLabelList(cbs, func(l Label) bool { return !l.IsSpecial() })
+}
+
+func newLabelList(cbs []*CodeBlock, keep func(Label) bool) LabelList {
+	result := LabelList{}
+	seen := map[Label]bool{}
+	for _, b := range cbs {
+		for _, l := range b.Labels() {
+			if !seen[l] && keep(l) {
+				seen[l] = true
+				result = append(result, l)
+			}
+		}
+	}
+	return result
+}
+
 func NewBlockNameList(cbs []*CodeBlock) []string {
 	labels := make([]string, len(cbs))
 	for j, block := range cbs {
@@ -26,6 +98,15 @@ func NewBlockNameList(cbs []*CodeBlock) []string {
 	return labels
 }
 
+// Strings returns the labels as strings.
+func (lst LabelList) Strings() []string {
+	result := make([]string, len(lst))
+	for i := range lst {
+		result[i] = string(lst[i])
+	}
+	return result
+}
+
 func (lst LabelList) Contains(l Label) bool {
 	for i := range lst {
 		if lst[i] == l {
@@ -36,7 +117,185 @@ func (lst LabelList) Contains(l Label) bool {
 }
 
 func (l Label) IsSpecial() bool {
-	return l == SleepLabel || l == SkipLabel
+	if l == SleepLabel || l == SkipLabel || l == ExpectFailLabel {
+		return true
+	}
+	if _, ok := l.expectedExit(); ok {
+		return true
+	}
+	if l.isAssertion() {
+		return true
+	}
+	if _, ok := l.timeout(); ok {
+		return true
+	}
+	if l.name() != "" {
+		return true
+	}
+	if _, ok := l.envOverride(); ok {
+		return true
+	}
+	return l.Interpreter() != ""
+}
+
+func (l Label) isAssertion() bool {
+	return strings.HasPrefix(string(l), AssertLabelPrefix) ||
+		strings.HasPrefix(string(l), AssertReLabelPrefix)
+}
+
+// OutputAssertion is a claim, made by a label, about a block's stdout.
+// Since labels can't hold spaces, use a regular expression with \s
+// to match text with spaces.
+type OutputAssertion struct {
+	// Label is the label making the claim.
+	Label Label
+	// Substring, if not empty, must appear in the output.
+	Substring string
+	// Pattern, if not nil, must match the output.
+	Pattern *regexp.Regexp
+}
+
+// ParseOutputAssertions returns the output assertions made by the labels.
+// It's an error if a regular expression won't compile.
+func ParseOutputAssertions(lst LabelList) ([]OutputAssertion, error) {
+	var result []OutputAssertion
+	for _, l := range lst {
+		if s, found := strings.CutPrefix(string(l), AssertReLabelPrefix); found {
+			re, err := regexp.Compile(s)
+			if err != nil {
+				return nil, fmt.Errorf("bad regexp in label %q; %w", l, err)
+			}
+			result = append(result, OutputAssertion{Label: l, Pattern: re})
+			continue
+		}
+		if s, found := strings.CutPrefix(string(l), AssertLabelPrefix); found && s != "" {
+			result = append(result, OutputAssertion{Label: l, Substring: s})
+		}
+	}
+	return result, nil
+}
+
+// expectedExit returns the exit status pinned by an exit label.
+func (l Label) expectedExit() (int, bool) {
+	s, found := strings.CutPrefix(string(l), ExitLabelPrefix)
+	if !found {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// ParseExpectedExit returns the exit status that labels say a block
+// should have, and true, if the labels say so, else 0 and false.
+// An ExpectFailLabel yields AnyFailure.  If there's more than one
+// such label, the first wins.
+func ParseExpectedExit(lst LabelList) (int, bool) {
+	for _, l := range lst {
+		if l == ExpectFailLabel {
+			return AnyFailure, true
+		}
+		if n, ok := l.expectedExit(); ok {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// timeout returns the time limit set by a timeout label.
+func (l Label) timeout() (time.Duration, bool) {
+	s, found := strings.CutPrefix(string(l), TimeoutLabelPrefix)
+	if !found {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// ParseTimeout returns the time limit that labels set on a block,
+// or zero if they don't set one.  If there's more than one timeout
+// label, the first wins.
+func ParseTimeout(lst LabelList) time.Duration {
+	for _, l := range lst {
+		if d, ok := l.timeout(); ok {
+			return d
+		}
+	}
+	return 0
+}
+
+// name returns the name given by a name label, or the empty string.
+func (l Label) name() string {
+	s, found := strings.CutPrefix(string(l), NameLabelPrefix)
+	if !found {
+		return ""
+	}
+	return s
+}
+
+// ParseName returns the name that labels give a block, or the empty
+// string if they don't name it.  If there's more than one name
+// label, the first wins.
+func ParseName(lst LabelList) string {
+	for _, l := range lst {
+		if s := l.name(); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// envNameRe matches the names a shell allows for variables.
+var envNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// envOverride returns the KEY=VALUE set by an env label.
+func (l Label) envOverride() (string, bool) {
+	s, found := strings.CutPrefix(string(l), EnvLabelPrefix)
+	if !found {
+		return "", false
+	}
+	k, _, found := strings.Cut(s, "=")
+	if !found || !envNameRe.MatchString(k) {
+		return "", false
+	}
+	return s, true
+}
+
+// ParseEnvOverrides returns the KEY=VALUE settings that env labels
+// make for a block, in label order.  Labels naming the same KEY
+// are all returned, so the last wins.
+func ParseEnvOverrides(lst LabelList) []string {
+	var result []string
+	for _, l := range lst {
+		if kv, ok := l.envOverride(); ok {
+			result = append(result, kv)
+		}
+	}
+	return result
+}
+
+// ExitMatches is true if the exit status matches the expected
+// status, which may be AnyFailure.
+func ExitMatches(status, expected int) bool {
+	if expected == AnyFailure {
+		return status != 0
+	}
+	return status == expected
+}
+
+// Interpreter returns the interpreter named by the label,
+// or the empty string if the label doesn't name one.
+func (l Label) Interpreter() string {
+	s, _ := strings.CutPrefix(string(l), InterpLabelPrefix)
+	if len(s) == len(l) {
+		return ""
+	}
+	return s
 }
 
 // Equals is true if the slices have the same contents, ordering irrelevant.
@@ -0,0 +1,188 @@
+package loader_test
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	. "github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseExpectedExit(t *testing.T) {
+	for n, tc := range map[string]struct {
+		labels LabelList
+		want   int
+		found  bool
+	}{
+		"default": {
+			labels: LabelList{"hello", SleepLabel},
+		},
+		"expectFail": {
+			labels: LabelList{"hello", ExpectFailLabel},
+			want:   AnyFailure,
+			found:  true,
+		},
+		"exit2": {
+			labels: LabelList{"exit=2"},
+			want:   2,
+			found:  true,
+		},
+		"exit0": {
+			labels: LabelList{"exit=0"},
+			found:  true,
+		},
+		"badNumber": {
+			labels: LabelList{"exit=two", "exit=-3"},
+		},
+		"firstWins": {
+			labels: LabelList{"exit=3", ExpectFailLabel},
+			want:   3,
+			found:  true,
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			got, found := ParseExpectedExit(tc.labels)
+			assert.Equal(t, tc.want, got)
+			assert.Equal(t, tc.found, found)
+		})
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	for n, tc := range map[string]struct {
+		labels LabelList
+		want   time.Duration
+	}{
+		"none":      {labels: LabelList{"hello", SleepLabel}},
+		"seconds":   {labels: LabelList{"hello", "timeout=90"}, want: 90 * time.Second},
+		"bad":       {labels: LabelList{"timeout=2m", "timeout=0", "timeout=-1"}},
+		"firstWins": {labels: LabelList{"timeout=5", "timeout=9"}, want: 5 * time.Second},
+	} {
+		t.Run(n, func(t *testing.T) {
+			assert.Equal(t, tc.want, ParseTimeout(tc.labels))
+		})
+	}
+}
+
+func TestParseName(t *testing.T) {
+	for n, tc := range map[string]struct {
+		labels LabelList
+		want   string
+	}{
+		"none":      {labels: LabelList{"hello", "timeout=5"}},
+		"empty":     {labels: LabelList{"name="}},
+		"named":     {labels: LabelList{"hello", "name=install"}, want: "install"},
+		"firstWins": {labels: LabelList{"name=a", "name=b"}, want: "a"},
+	} {
+		t.Run(n, func(t *testing.T) {
+			assert.Equal(t, tc.want, ParseName(tc.labels))
+		})
+	}
+}
+
+func TestParseEnvOverrides(t *testing.T) {
+	for n, tc := range map[string]struct {
+		labels LabelList
+		want   []string
+	}{
+		"none":     {labels: LabelList{"hello", "timeout=5"}},
+		"one":      {labels: LabelList{"hello", "env=DEBUG=1"}, want: []string{"DEBUG=1"}},
+		"empty":    {labels: LabelList{"env=DEBUG="}, want: []string{"DEBUG="}},
+		"equals":   {labels: LabelList{"env=OPTS=a=b"}, want: []string{"OPTS=a=b"}},
+		"repeated": {labels: LabelList{"env=A=1", "env=B=2"}, want: []string{"A=1", "B=2"}},
+		"bad":      {labels: LabelList{"env=", "env=DEBUG", "env=1X=2", "env=A-B=3"}},
+	} {
+		t.Run(n, func(t *testing.T) {
+			assert.Equal(t, tc.want, ParseEnvOverrides(tc.labels))
+		})
+	}
+}
+
+func TestUniqNamePrefersName(t *testing.T) {
+	disAmbig := map[string]int{"install": 1}
+	b := NewCodeBlock(nil, "echo hi", 0, "protein", "name=install")
+	b.ResetTitle(disAmbig)
+	assert.Equal(t, "install", b.Name())
+	assert.Equal(t, "install", b.UniqName())
+	assert.Equal(t, "install protein name=install", b.Title())
+
+	// A generated name steers clear of the given one.
+	b = NewCodeBlock(nil, "echo hi", 1, "install")
+	b.ResetTitle(disAmbig)
+	assert.Equal(t, "", b.Name())
+	assert.Equal(t, "install2", b.UniqName())
+}
+
+func TestNewLabelLists(t *testing.T) {
+	blocks := []*CodeBlock{
+		NewCodeBlock(nil, "echo a", 0, "setup", SleepLabel, "name=install"),
+		NewCodeBlock(nil, "echo b", 1, SkipLabel, "setup", "test"),
+		NewCodeBlock(nil, "print(1)", 2, "interp=python3", "timeout=5", "assert=1"),
+		NewCodeBlock(nil, "false", 3, ExpectFailLabel, "exit=1"),
+	}
+	assert.Equal(t, LabelList{"setup", "test"}, NewUserLabelList(blocks))
+	assert.Equal(t, LabelList{
+		"setup", SleepLabel, "name=install", SkipLabel, "test",
+		"interp=python3", "timeout=5", "assert=1", ExpectFailLabel, "exit=1",
+	}, NewLabelList(blocks))
+	assert.Empty(t, NewUserLabelList(nil))
+}
+
+func TestExitMatches(t *testing.T) {
+	assert.True(t, ExitMatches(0, 0))
+	assert.False(t, ExitMatches(1, 0))
+	assert.True(t, ExitMatches(2, 2))
+	assert.False(t, ExitMatches(0, 2))
+	assert.True(t, ExitMatches(1, AnyFailure))
+	assert.True(t, ExitMatches(127, AnyFailure))
+	assert.False(t, ExitMatches(0, AnyFailure))
+}
+
+func TestExpectLabelsAreSpecial(t *testing.T) {
+	b := NewCodeBlock(nil, "false", 0, "exit=1", ExpectFailLabel, "timeout=5", "protein")
+	b.ResetTitle(nil)
+	assert.Equal(t, "protein", b.UniqName())
+}
+
+func TestParseOutputAssertions(t *testing.T) {
+	for n, tc := range map[string]struct {
+		labels  LabelList
+		want    []OutputAssertion
+		wantErr bool
+	}{
+		"none": {
+			labels: LabelList{"hello", "exit=2"},
+		},
+		"substring": {
+			labels: LabelList{"hello", "assert=v1.2"},
+			want: []OutputAssertion{
+				{Label: "assert=v1.2", Substring: "v1.2"},
+			},
+		},
+		"emptySubstringIgnored": {
+			labels: LabelList{"assert="},
+		},
+		"both": {
+			labels: LabelList{"assertre=^ok\\s", "assert=done"},
+			want: []OutputAssertion{
+				{Label: "assertre=^ok\\s", Pattern: regexp.MustCompile("^ok\\s")},
+				{Label: "assert=done", Substring: "done"},
+			},
+		},
+		"badRegexp": {
+			labels:  LabelList{"assertre=(unclosed"},
+			wantErr: true,
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			got, err := ParseOutputAssertions(tc.labels)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
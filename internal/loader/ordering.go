@@ -20,12 +20,20 @@ func IsOrderingFile(info os.FileInfo) bool {
 }
 
 // LoadOrderFile returns a list of names specify file name order priority.
+// Surrounding whitespace, e.g. the carriage returns of a file edited
+// on Windows, is trimmed from each name, and empty lines are dropped.
 func LoadOrderFile(fs *afero.Afero, path string) ([]string, error) {
 	contents, err := fs.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	return strings.Split(string(contents), "\n"), nil
+	var result []string
+	for _, line := range strings.Split(string(contents), "\n") {
+		if name := strings.TrimSpace(line); name != "" {
+			result = append(result, name)
+		}
+	}
+	return result, nil
 }
 
 func ReorderFolders(x []*MyFolder, ordering []string) []*MyFolder {
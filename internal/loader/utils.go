@@ -3,6 +3,7 @@ package loader
 import (
 	"path/filepath"
 	"strings"
+	"unicode"
 )
 
 // DirBase behavior:
@@ -21,6 +22,8 @@ func DirBase(path string) (dir, base string) {
 	return filepath.Dir(path), filepath.Base(path)
 }
 
+// CommentBody returns what's inside the HTML comment, or the
+// empty string if s isn't a comment.
 func CommentBody(s string) string {
 	const (
 		begin = "<!--"
@@ -36,10 +39,11 @@ func CommentBody(s string) string {
 	return s[len(begin) : len(s)-len(end)]
 }
 
+// ParseLabels returns the labels in the string, i.e. the
+// whitespace separated words starting with '@', without the '@'.
 func ParseLabels(s string) (result []Label) {
 	const labelPrefixChar = uint8('@')
-	items := strings.Split(s, " ")
-	for _, word := range items {
+	for _, word := range strings.Fields(s) {
 		i := 0
 		for i < len(word) && word[i] == labelPrefixChar {
 			i++
@@ -50,3 +54,26 @@ func ParseLabels(s string) (result []Label) {
 	}
 	return
 }
+
+// ParseInfoString splits a fence info string, e.g.
+// "bash {setup, timeout=5}", into the language and the labels
+// listed in braces after it.  The labels may be separated by
+// commas or spaces, and needn't start with '@'.
+func ParseInfoString(info string) (lang string, result []Label) {
+	info = strings.TrimSpace(info)
+	if i := strings.Index(info, "{"); i >= 0 && strings.HasSuffix(info, "}") {
+		for _, word := range strings.FieldsFunc(
+			info[i+1:len(info)-1], func(r rune) bool {
+				return r == ',' || unicode.IsSpace(r)
+			}) {
+			if word = strings.TrimLeft(word, "@"); word != "" {
+				result = append(result, Label(word))
+			}
+		}
+		info = info[:i]
+	}
+	if f := strings.Fields(info); len(f) > 0 {
+		lang = f[0]
+	}
+	return
+}
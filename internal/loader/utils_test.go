@@ -203,6 +203,18 @@ func TestParseLabels(t *testing.T) {
 			data: "  @aa @b  @   @@ccc @@@ @@@d ",
 			want: []Label{"aa", "b", "ccc", "d"},
 		},
+		"keyValue": {
+			data: " @setup @sleep=3 @skip ",
+			want: []Label{"setup", "sleep=3", "skip"},
+		},
+		"otherWhitespace": {
+			data: "\n\t@aa\n@b\t@c\r\n",
+			want: []Label{"aa", "b", "c"},
+		},
+		"notLabels": {
+			data: " mail me@example.com about it ",
+			want: nil,
+		},
 	}
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -212,3 +224,64 @@ func TestParseLabels(t *testing.T) {
 		})
 	}
 }
+
+func TestParseInfoString(t *testing.T) {
+	tests := map[string]struct {
+		data     string
+		wantLang string
+		want     []Label
+	}{
+		"empty": {},
+		"langOnly": {
+			data:     "bash",
+			wantLang: "bash",
+		},
+		"langAndMore": {
+			data:     " bash title=x ",
+			wantLang: "bash",
+		},
+		"commas": {
+			data:     "bash {setup, timeout=5}",
+			wantLang: "bash",
+			want:     []Label{"setup", "timeout=5"},
+		},
+		"spaces": {
+			data:     "bash {setup  skip}",
+			wantLang: "bash",
+			want:     []Label{"setup", "skip"},
+		},
+		"atSigns": {
+			data:     "bash { @setup,@skip, }",
+			wantLang: "bash",
+			want:     []Label{"setup", "skip"},
+		},
+		"noSpaceBeforeBrace": {
+			data:     "bash{setup}",
+			wantLang: "bash",
+			want:     []Label{"setup"},
+		},
+		"noLang": {
+			data: "{setup}",
+			want: []Label{"setup"},
+		},
+		"emptyBraces": {
+			data:     "bash {}",
+			wantLang: "bash",
+		},
+		"unclosed": {
+			data:     "bash {setup",
+			wantLang: "bash",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			lang, got := ParseInfoString(tc.data)
+			if lang != tc.wantLang {
+				t.Errorf("got lang = %q, want %q", lang, tc.wantLang)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("got = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package loader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"strconv"
+)
+
+// VisitorHasher hashes the paths and contents of the files it visits,
+// so that two loads of the same content have the same Sum.
+type VisitorHasher struct {
+	h hash.Hash
+}
+
+func NewVisitorHasher() *VisitorHasher {
+	return &VisitorHasher{h: sha256.New()}
+}
+
+func (v *VisitorHasher) VisitTopFolder(fl *MyTopFolder) {
+	fl.VisitChildren(v)
+}
+
+func (v *VisitorHasher) VisitFolder(fl *MyFolder) {
+	fl.VisitChildren(v)
+}
+
+func (v *VisitorHasher) VisitFile(fi *MyFile) {
+	// The lengths keep e.g. a path's end from passing as content.
+	for _, b := range [][]byte{[]byte(fi.Path()), fi.C()} {
+		v.h.Write([]byte(strconv.Itoa(len(b)) + ":"))
+		v.h.Write(b)
+	}
+}
+
+// Sum is the hash, in hex, of the files visited so far.
+func (v *VisitorHasher) Sum() string {
+	return hex.EncodeToString(v.h.Sum(nil))
+}
+
+func (v *VisitorHasher) Error() error { return nil }
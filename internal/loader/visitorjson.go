@@ -0,0 +1,108 @@
+package loader
+
+import (
+	"github.com/monopole/mdrip/v2/internal/utils"
+)
+
+// FolderDump is the JSON form of a folder, as built by VisitorJson.
+type FolderDump struct {
+	Name    string        `json:"name"`
+	Files   []*FileDump   `json:"files,omitempty"`
+	Folders []*FolderDump `json:"folders,omitempty"`
+}
+
+// FileDump is the JSON form of a file.
+type FileDump struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	// Summary is the start of the file's content.
+	Summary string       `json:"summary"`
+	Blocks  []*BlockDump `json:"blocks"`
+}
+
+// BlockDump is the JSON form of a code block.
+type BlockDump struct {
+	UniqName string   `json:"uniqName"`
+	Title    string   `json:"title"`
+	Line     int      `json:"line,omitempty"`
+	Language string   `json:"language,omitempty"`
+	Labels   []string `json:"labels"`
+	Code     string   `json:"code"`
+}
+
+// NewBlockDump returns the JSON form of the block.
+func NewBlockDump(b *CodeBlock) *BlockDump {
+	return &BlockDump{
+		UniqName: b.UniqName(),
+		Title:    b.Title(),
+		Line:     b.Line(),
+		Language: b.Language(),
+		Labels:   b.Labels().Strings(),
+		Code:     b.Code(),
+	}
+}
+
+// VisitorJson builds a FolderDump of the tree it visits; it's the
+// structured counterpart of VisitorDump.  Each file is given
+// the blocks, among those passed to NewVisitorJson, that it holds.
+type VisitorJson struct {
+	blocks map[FilePath][]*BlockDump
+	result *FolderDump
+	// current is the folder being visited.
+	current *FolderDump
+}
+
+func NewVisitorJson(blocks []*CodeBlock) *VisitorJson {
+	v := &VisitorJson{blocks: make(map[FilePath][]*BlockDump)}
+	for _, b := range blocks {
+		v.blocks[b.Path()] = append(v.blocks[b.Path()], NewBlockDump(b))
+	}
+	return v
+}
+
+// Result returns the dump of the visited tree, or nil if
+// nothing was visited.
+func (v *VisitorJson) Result() *FolderDump {
+	return v.result
+}
+
+func (v *VisitorJson) VisitTopFolder(fl *MyTopFolder) {
+	v.visitFolder(fl.Name(), fl.VisitChildren)
+}
+
+func (v *VisitorJson) VisitFolder(fl *MyFolder) {
+	v.visitFolder(fl.Name(), fl.VisitChildren)
+}
+
+func (v *VisitorJson) visitFolder(name string, visitChildren func(TreeVisitor)) {
+	fd := &FolderDump{Name: name}
+	parent := v.current
+	if parent == nil {
+		v.result = fd
+	} else {
+		parent.Folders = append(parent.Folders, fd)
+	}
+	v.current = fd
+	visitChildren(v)
+	v.current = parent
+}
+
+func (v *VisitorJson) VisitFile(fi *MyFile) {
+	fd := &FileDump{
+		Name:    fi.Name(),
+		Path:    string(fi.Path()),
+		Summary: utils.Summarize(fi.C()),
+		Blocks:  v.blocks[fi.Path()],
+	}
+	if fd.Blocks == nil {
+		fd.Blocks = []*BlockDump{}
+	}
+	if v.current == nil {
+		// A lone file; give it an unnamed folder.
+		v.result = &FolderDump{}
+		v.current = v.result
+	}
+	v.current.Files = append(v.current.Files, fd)
+}
+
+func (v *VisitorJson) Error() error { return nil }
@@ -33,6 +33,9 @@ type MdParserRenderer interface {
 	// Reset resets the parser.  Handy if you want to run another visitation,
 	// and don't want data to accumulate.
 	Reset()
+	// SetRunnableLanguages sets the fence languages of blocks that
+	// may be run in a shell.  It affects subsequent visitations.
+	SetRunnableLanguages(loader.LanguageSet)
 }
 
 type RenderedMdFile struct {
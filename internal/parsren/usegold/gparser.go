@@ -43,6 +43,9 @@ type GParser struct {
 	// by some containing web application.
 	// The renderMdFiles also contain any extracted code blocks.
 	renderMdFiles []*parsren.RenderedMdFile
+
+	// runnable holds the fence languages of blocks that can be run.
+	runnable loader.LanguageSet
 }
 
 const (
@@ -88,10 +91,15 @@ func NewGParser() *GParser {
 			util.Prioritized(&codeblock.HighlightedCbRenderer{}, priority)),
 	)
 	return &GParser{
-		p: gp,
+		p:        gp,
+		runnable: loader.DefaultRunnableLanguages,
 	}
 }
 
+func (v *GParser) SetRunnableLanguages(ls loader.LanguageSet) {
+	v.runnable = ls
+}
+
 func (v *GParser) Reset() {
 	v.err = nil
 	v.renderMdFiles = nil
@@ -150,23 +158,46 @@ func (v *GParser) VisitFile(fi *loader.MyFile) {
 		hBlocks[i] = v.swapOutFcbForHcb(fencedBlocks[i])
 	}
 
+	for i, hcb := range hBlocks {
+		inventory = append(inventory, v.convertHighlightedToLoaderCodeBlock(hcb, i))
+	}
+
 	// To assure no two titles in the same file match.
 	titleDisambiguate := make(map[string]int)
+	named := make(map[string]*loader.CodeBlock)
+	for _, lCb := range inventory {
+		name := lCb.Name()
+		if name == "" {
+			continue
+		}
+		if other, ok := named[name]; ok {
+			if v.err == nil {
+				v.err = fmt.Errorf(
+					"%s: block name %q is already used at line %d",
+					lCb.Location(), name, other.Line())
+			}
+			continue
+		}
+		named[name] = lCb
+		titleDisambiguate[name] = 1
+	}
 
 	// This loop does two things:
 	// - add title and indices to each HighlightedCodeBlock
-	// - build a distinct inventory of all code blocks for other purposes,
+	// - finish the inventory of all code blocks for other purposes,
 	//   e.g. rendering in a left nav.
 	for i, hcb := range hBlocks {
-		lCb := v.convertHighlightedToLoaderCodeBlock(hcb, i)
+		lCb := inventory[i]
 		lCb.ResetTitle(titleDisambiguate)
-		inventory = append(inventory, lCb)
 		// Store zero-relative indices as node attributes
 		// in the syntax tree for later use in rendering
 		// div 'id' or 'data-' attributes.
 		hcb.FileIndex = len(v.renderMdFiles)
 		hcb.BlockIndex = i
 		hcb.Title = lCb.Title()
+		hcb.Language = lCb.Language()
+		hcb.Labels = lCb.Labels().Strings()
+		hcb.Runnable = lCb.IsRunnable()
 		// hcb.dump(v.currentFile.C(), 0)
 	}
 
@@ -236,9 +267,43 @@ func (v *GParser) convertHighlightedToLoaderCodeBlock(
 	lCb := loader.NewCodeBlock(
 		v.currentFile, v.nodeText(hCb.FirstChild()), index)
 	v.maybeAddLabels(lCb, hCb.PreviousSibling())
+	if fcb, ok := hCb.FirstChild().(*ast.FencedCodeBlock); ok {
+		var info string
+		if fcb.Info != nil {
+			info = string(fcb.Info.Segment.Value(v.currentFile.C()))
+		}
+		// Labels in the fence, e.g. "bash {setup}", follow
+		// those in a preceding comment.
+		lang, labels := loader.ParseInfoString(info)
+		lCb.AddLabels(labels)
+		lCb.SetLanguage(lang, v.runnable)
+		lCb.SetLine(fenceLine(fcb, v.currentFile.C()))
+	}
 	return lCb
 }
 
+// fenceLine returns the one-relative line number of the opening
+// fence of the block, or zero if it cannot be determined.
+func fenceLine(fcb *ast.FencedCodeBlock, source []byte) int {
+	switch {
+	case fcb.Info != nil:
+		// The info string, e.g. "bash", sits on the fence line.
+		return lineOf(source, fcb.Info.Segment.Start)
+	case fcb.Lines().Len() > 0:
+		// The fence is the line above the first line of code.
+		return lineOf(source, fcb.Lines().At(0).Start) - 1
+	}
+	return 0
+}
+
+// lineOf returns the one-relative line number of the byte offset.
+func lineOf(source []byte, offset int) int {
+	return bytes.Count(source[:min(offset, len(source))], []byte("\n")) + 1
+}
+
+// maybeAddLabels gives the block the labels, e.g. @setup or @exit=2,
+// found in an HTML comment immediately preceding the block.
+// Other comments and HTML leave the block without labels.
 func (v *GParser) maybeAddLabels(cb *loader.CodeBlock, prev ast.Node) {
 	if prev != nil && prev.Kind() == ast.KindHTMLBlock {
 		if htmlBlock, ok := prev.(*ast.HTMLBlock); ok {
@@ -246,8 +311,14 @@ func (v *GParser) maybeAddLabels(cb *loader.CodeBlock, prev ast.Node) {
 			// If it's an HTML comment, try to extract labels.
 			// If no labels found, the label array remains empty,
 			// i.e. no label defaults are actually stored here.
-			cb.AddLabels(
-				loader.ParseLabels(loader.CommentBody(v.nodeText(htmlBlock))))
+			text := v.nodeText(htmlBlock)
+			if htmlBlock.HasClosure() {
+				// A comment spanning lines keeps its last line, the
+				// one with the "-->", apart from the others.
+				s := htmlBlock.ClosureLine
+				text += string(v.currentFile.C()[s.Start:s.Stop])
+			}
+			cb.AddLabels(loader.ParseLabels(loader.CommentBody(text)))
 		}
 	}
 }
@@ -3,6 +3,7 @@ package usegold_test
 import (
 	_ "embed"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/monopole/mdrip/v2/internal/loader"
@@ -88,7 +89,7 @@ func TestRenderingHtmlFromStringConstants(t *testing.T) {
 			html: (`
 <h1 id="header">header</h1>
 <p>Some text before a code block.</p>
-<div class='codeBlockContainer' id='codeBlockId0'>
+<div class='codeBlockContainer' id='codeBlockId0' data-lang='' data-labels='' data-runnable='true'>
 <div class='codeBlockControl'>
 <span class='codeBlockTitle'> echoAlphaWhichFind </span>
 </div>
@@ -107,7 +108,7 @@ which find
 <h1 id="header">header</h1>
 <p>Some text before a code block.</p>
 <!-- @theOne  @two  @three -->
-<div class='codeBlockContainer' id='codeBlockId0'>
+<div class='codeBlockContainer' id='codeBlockId0' data-lang='' data-labels='theOne two three' data-runnable='true'>
 <div class='codeBlockControl'>
 <span class='codeBlockTitle'> theOne two three </span>
 </div>
@@ -123,7 +124,7 @@ which ls
 </blockquote>
 <p>A comment between the code blocks.</p>
 <!-- @myFour @leFive -->
-<div class='codeBlockContainer' id='codeBlockId1'>
+<div class='codeBlockContainer' id='codeBlockId1' data-lang='' data-labels='myFour leFive' data-runnable='true'>
 <div class='codeBlockControl'>
 <span class='codeBlockTitle'> myFour leFive </span>
 </div>
@@ -132,7 +133,7 @@ which ls
 which ls
 </code></pre>
 </div></div><p>The next block has no labels.</p>
-<div class='codeBlockContainer' id='codeBlockId2'>
+<div class='codeBlockContainer' id='codeBlockId2' data-lang='' data-labels='' data-runnable='true'>
 <div class='codeBlockControl'>
 <span class='codeBlockTitle'> echoGammaWhichCat </span>
 </div>
@@ -156,6 +157,114 @@ which cat
 	}
 }
 
+func TestRenderingBlockMetadata(t *testing.T) {
+	p := NewGParser()
+	loader.NewFile("langEx", []byte(`
+# header
+<!-- @setup @skip -->
+`+"```bash"+`
+echo alpha
+`+"```"+`
+
+`+"```"+`
+echo beta
+`+"```"+`
+`)).Accept(p)
+	if !assert.Equal(t, 1, len(p.RenderedMdFiles())) {
+		t.FailNow()
+	}
+	f := p.RenderedMdFiles()[0]
+	if !assert.Equal(t, 2, len(f.Blocks)) {
+		t.FailNow()
+	}
+	assert.Equal(t, "bash", f.Blocks[0].Language())
+	assert.Equal(t, "", f.Blocks[1].Language())
+	assert.Contains(t, string(f.Html),
+		"id='codeBlockId0' data-lang='bash' data-labels='setup skip' data-runnable='true'>")
+	assert.Contains(t, string(f.Html),
+		"id='codeBlockId1' data-lang='' data-labels='' data-runnable='true'>")
+}
+
+func TestRenderingRunnability(t *testing.T) {
+	const mixed = `
+# header
+` + "```bash" + `
+echo bash
+` + "```" + `
+` + "```yaml" + `
+kind: Pod
+` + "```" + `
+` + "```" + `
+echo none
+` + "```" + `
+` + "```go" + `
+package main
+` + "```" + `
+` + "```Console" + `
+echo console
+` + "```" + `
+`
+	for n, tc := range map[string]struct {
+		langs loader.LanguageSet
+		want  []bool
+	}{
+		"default": {
+			want: []bool{true, false, true, false, true},
+		},
+		"custom": {
+			langs: loader.NewLanguageSet("yaml", "go"),
+			want:  []bool{false, true, false, true, false},
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			p := NewGParser()
+			if tc.langs != nil {
+				p.SetRunnableLanguages(tc.langs)
+			}
+			loader.NewFile("mixed", []byte(mixed)).Accept(p)
+			f := p.RenderedMdFiles()[0]
+			if !assert.Equal(t, len(tc.want), len(f.Blocks)) {
+				t.FailNow()
+			}
+			for i, b := range f.Blocks {
+				assert.Equal(t, tc.want[i], b.IsRunnable(), "block %d", i)
+				assert.Contains(t, string(f.Html), fmt.Sprintf(
+					"id='codeBlockId%d' data-lang='%s' data-labels='' data-runnable='%t'>",
+					i, b.Language(), tc.want[i]))
+			}
+		})
+	}
+}
+
+func TestBlockLineNumbers(t *testing.T) {
+	p := NewGParser()
+	loader.NewFile("lines.md", []byte(`# header
+
+Some text.
+`+"```bash"+`
+echo alpha
+`+"```"+`
+
+- a list item with a block
+  `+"```"+`
+  echo beta
+  `+"```"+`
+
+`+"```"+`
+`+"```"+`
+`)).Accept(p)
+	f := p.RenderedMdFiles()[0]
+	if !assert.Equal(t, 3, len(f.Blocks)) {
+		t.FailNow()
+	}
+	assert.Equal(t, 4, f.Blocks[0].Line())
+	assert.Equal(t, "lines.md:4", f.Blocks[0].Location())
+	assert.Equal(t, 9, f.Blocks[1].Line())
+	// An empty block with no info string has no position info.
+	assert.Equal(t, 0, f.Blocks[2].Line())
+	assert.Equal(t, "lines.md", f.Blocks[2].Location())
+}
+
 func TestParsingBlocksFromStringConstants(t *testing.T) {
 	tests := map[string]struct {
 		file           *loader.MyFile
@@ -220,6 +329,148 @@ which ls
 	}
 }
 
+func TestParsingLabelsFromComments(t *testing.T) {
+	for n, tc := range map[string]struct {
+		md   string
+		want []loader.LabelList
+	}{
+		"oneLineComment": {
+			md:   "<!-- @setup @sleep=3 @skip -->\n```\necho a\n```\n",
+			want: []loader.LabelList{{"setup", "sleep=3", "skip"}},
+		},
+		"noSpaces": {
+			md:   "<!--@setup-->\n```\necho a\n```\n",
+			want: []loader.LabelList{{"setup"}},
+		},
+		"multiLineComment": {
+			md:   "<!--\n  @setup\n\t@exit=2\n-->\n```\necho a\n```\n",
+			want: []loader.LabelList{{"setup", "exit=2"}},
+		},
+		"blankLineBetween": {
+			md:   "<!-- @setup -->\n\n```\necho a\n```\n",
+			want: []loader.LabelList{{"setup"}},
+		},
+		"unrelatedComment": {
+			md:   "<!-- TODO: mail me@example.com -->\n```\necho a\n```\n",
+			want: []loader.LabelList{nil},
+		},
+		"notAComment": {
+			md:   "<div>@setup</div>\n\n```\necho a\n```\n",
+			want: []loader.LabelList{nil},
+		},
+		"paragraphBetween": {
+			md:   "<!-- @setup -->\n\nSome words.\n\n```\necho a\n```\n",
+			want: []loader.LabelList{nil},
+		},
+		"eachBlockItsOwn": {
+			md: "<!-- @one -->\n```\necho a\n```\n" +
+				"```\necho b\n```\n" +
+				"<!-- @three @3 -->\n```\necho c\n```\n",
+			want: []loader.LabelList{{"one"}, nil, {"three", "3"}},
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			p := NewGParser()
+			loader.NewFile("f.md", []byte(tc.md)).Accept(p)
+			if !assert.Equal(t, 1, len(p.RenderedMdFiles())) {
+				t.FailNow()
+			}
+			blocks := p.RenderedMdFiles()[0].Blocks
+			if !assert.Equal(t, len(tc.want), len(blocks)) {
+				t.FailNow()
+			}
+			for i, b := range blocks {
+				assert.Equal(t, tc.want[i], b.Labels(), "block %d", i)
+			}
+		})
+	}
+}
+
+func TestParsingLabelsFromFence(t *testing.T) {
+	for n, tc := range map[string]struct {
+		md       string
+		wantLang string
+		want     loader.LabelList
+	}{
+		"noBraces": {
+			md:       "```bash\necho a\n```\n",
+			wantLang: "bash",
+		},
+		"braces": {
+			md:       "```bash {setup, timeout=5}\necho a\n```\n",
+			wantLang: "bash",
+			want:     loader.LabelList{"setup", "timeout=5"},
+		},
+		"bracesNoLang": {
+			md:   "```{setup skip}\necho a\n```\n",
+			want: loader.LabelList{"setup", "skip"},
+		},
+		"afterComment": {
+			md:       "<!-- @first -->\n```bash {second}\necho a\n```\n",
+			wantLang: "bash",
+			want:     loader.LabelList{"first", "second"},
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			p := NewGParser()
+			loader.NewFile("f.md", []byte(tc.md)).Accept(p)
+			f := p.RenderedMdFiles()[0]
+			if !assert.Equal(t, 1, len(f.Blocks)) {
+				t.FailNow()
+			}
+			b := f.Blocks[0]
+			assert.Equal(t, tc.wantLang, b.Language())
+			assert.Equal(t, tc.want, b.Labels())
+			assert.Equal(t, "echo a\n", b.Code())
+			assert.Contains(t, string(f.Html), fmt.Sprintf(
+				"data-lang='%s' data-labels='%s'",
+				tc.wantLang, strings.Join(tc.want.Strings(), " ")))
+		})
+	}
+}
+
+func TestBlockNames(t *testing.T) {
+	for n, tc := range map[string]struct {
+		md      string
+		want    []string
+		wantErr string
+	}{
+		"absent": {
+			md:   "```\necho a\n```\n```\necho a\n```\n",
+			want: []string{"echo", "echo2"},
+		},
+		"explicit": {
+			md: "<!-- @name=first -->\n```\necho a\n```\n" +
+				"```bash {name=second, setup}\necho b\n```\n",
+			want: []string{"first", "second"},
+		},
+		"generatedAvoidsExplicit": {
+			md: "<!-- @setup -->\n```\necho a\n```\n" +
+				"<!-- @name=setup -->\n```\necho b\n```\n",
+			want: []string{"setup2", "setup"},
+		},
+		"duplicate": {
+			md: "<!-- @name=twin -->\n```\necho a\n```\n" +
+				"<!-- @name=twin -->\n```\necho b\n```\n",
+			wantErr: `f.md:6: block name "twin" is already used at line 2`,
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			p := NewGParser()
+			loader.NewFile("f.md", []byte(tc.md)).Accept(p)
+			if tc.wantErr != "" {
+				if assert.Error(t, p.Error()) {
+					assert.Equal(t, tc.wantErr, p.Error().Error())
+				}
+				return
+			}
+			assert.NoError(t, p.Error())
+			assert.Equal(t, tc.want,
+				loader.NewBlockNameList(p.RenderedMdFiles()[0].Blocks))
+		})
+	}
+}
+
 func TestParsingTree(t *testing.T) {
 	var p parsren.MdParserRenderer
 	{ // TODO: try embedding the file system
@@ -0,0 +1,45 @@
+package shell
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultBinaryThreshold is the fraction of unprintable characters
+// above which a line of output is deemed to be binary data.
+const DefaultBinaryThreshold = 0.3
+
+// IsBinary is true if more than the given fraction of the characters
+// in the line are unprintable.  Bytes that aren't valid UTF-8 count
+// as unprintable.  Tabs, carriage returns and escapes don't, since
+// they're expected in text output from command line tools.
+func IsBinary(line string, threshold float64) bool {
+	if line == "" {
+		return false
+	}
+	total, bad := 0, 0
+	for i := 0; i < len(line); {
+		r, size := utf8.DecodeRuneInString(line[i:])
+		i += size
+		total++
+		if r == utf8.RuneError && size == 1 {
+			bad++
+			continue
+		}
+		if !unicode.IsPrint(r) && r != '\t' && r != '\r' && r != '\x1b' {
+			bad++
+		}
+	}
+	return float64(bad)/float64(total) > threshold
+}
+
+// SummarizeBinary returns the line unchanged, unless it looks like
+// binary data, in which case a short description of it is returned.
+// A threshold of zero or less turns off the check.
+func SummarizeBinary(line string, threshold float64) string {
+	if threshold <= 0 || !IsBinary(line, threshold) {
+		return line
+	}
+	return fmt.Sprintf("<binary data: %d bytes>", len(line))
+}
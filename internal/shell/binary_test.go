@@ -0,0 +1,53 @@
+package shell_test
+
+import (
+	"testing"
+
+	. "github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummarizeBinary(t *testing.T) {
+	for n, tc := range map[string]struct {
+		line      string
+		threshold float64
+		want      string
+	}{
+		"empty": {
+			threshold: DefaultBinaryThreshold,
+		},
+		"text": {
+			line:      "hello\tthere",
+			threshold: DefaultBinaryThreshold,
+			want:      "hello\tthere",
+		},
+		"unicode": {
+			line:      "héllo wörld ✓",
+			threshold: DefaultBinaryThreshold,
+			want:      "héllo wörld ✓",
+		},
+		"colored": {
+			line:      "\x1b[32mPASS\x1b[0m",
+			threshold: DefaultBinaryThreshold,
+			want:      "\x1b[32mPASS\x1b[0m",
+		},
+		"nuls": {
+			line:      "ELF\x00\x00\x00\x02\x01\x00\x00\xfe\xff",
+			threshold: DefaultBinaryThreshold,
+			want:      "<binary data: 12 bytes>",
+		},
+		"fewNuls": {
+			line:      "mostly text with one \x00 in it",
+			threshold: DefaultBinaryThreshold,
+			want:      "mostly text with one \x00 in it",
+		},
+		"checkOff": {
+			line: "\x00\x00\x00",
+			want: "\x00\x00\x00",
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			assert.Equal(t, tc.want, SummarizeBinary(tc.line, tc.threshold))
+		})
+	}
+}
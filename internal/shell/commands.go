@@ -0,0 +1,169 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrHiddenCommand is returned by SplitCommands for code that runs
+// commands it can't single out, e.g. by command substitution.
+var ErrHiddenCommand = errors.New("code runs commands that can't be singled out")
+
+// SplitCommands returns the simple commands in the code, e.g.
+// "echo a", "ls" and "wc -l" for "echo a; ls | wc -l", trimmed, with
+// comments dropped and continued lines joined.  The parts of a list
+// or pipeline, i.e. the commands around ;, &, &&, ||, | and newlines,
+// are returned separately.  Here-document bodies are data, and not
+// returned.  Keywords like if and then aren't told apart from
+// commands, so "if true; then ls; fi" yields "if true", "then ls"
+// and "fi"; likewise a subshell's parenthesis stays with its first
+// command.
+//
+// Code with commands nested in other commands, by command or process
+// substitution, e.g. $(...), `...` or <(...), even within double quotes
+// or an unquoted here-document, is refused with ErrHiddenCommand.
+// Code leaving a quote open is refused with ErrIncomplete.
+//
+// Like checkCode, it's a heuristic, meant to be strict rather than
+// lenient; it knows nothing of case patterns.
+func SplitCommands(code string) ([]string, error) {
+	lines := strings.Split(code, "\n")
+	var (
+		result    []string
+		cmd       strings.Builder
+		open      byte // the open quote, if any
+		dollarQ   bool // quote is from $'
+		quoteLine int
+		pending   []heredoc
+	)
+	flush := func() {
+		if s := strings.TrimSpace(cmd.String()); s != "" {
+			result = append(result, s)
+		}
+		cmd.Reset()
+	}
+	hidden := func(i int, what string) error {
+		return fmt.Errorf("%w; line %d: %s", ErrHiddenCommand, i+1, what)
+	}
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		continued := false
+		for j := 0; j < len(line); j++ {
+			ch := line[j]
+			switch open {
+			case '\'':
+				cmd.WriteByte(ch)
+				if ch == '\\' && dollarQ && j+1 < len(line) {
+					j++
+					cmd.WriteByte(line[j])
+				} else if ch == '\'' {
+					open = 0
+				}
+				continue
+			case '"':
+				if ch == '`' || isSubstitution(line[j:]) {
+					return nil, hidden(i, "command substitution")
+				}
+				cmd.WriteByte(ch)
+				if ch == '\\' && j+1 < len(line) {
+					j++
+					cmd.WriteByte(line[j])
+				} else if ch == '"' {
+					open = 0
+				}
+				continue
+			}
+			switch {
+			case ch == '\\':
+				if j+1 == len(line) {
+					continued = true
+					break
+				}
+				cmd.WriteString(line[j : j+2])
+				j++
+			case ch == '\'' || ch == '"':
+				open, quoteLine = ch, i+1
+				dollarQ = ch == '\'' && j > 0 && line[j-1] == '$'
+				cmd.WriteByte(ch)
+			case ch == '#' && (j == 0 || strings.IndexByte(" \t;&|()", line[j-1]) >= 0):
+				j = len(line)
+			case ch == '`' || isSubstitution(line[j:]):
+				return nil, hidden(i, "command substitution")
+			case (ch == '<' || ch == '>') && strings.HasPrefix(line[j+1:], "("):
+				return nil, hidden(i, "process substitution")
+			case strings.HasPrefix(line[j:], "<<") && !strings.HasPrefix(line[j:], "<<<"):
+				h, end := parseHeredoc(line, j+2)
+				cmd.WriteString(line[j : end+1])
+				j = end
+				if h.word != "" {
+					h.line = i + 1
+					pending = append(pending, h)
+				}
+			case ch == ';' || ch == '|':
+				flush()
+				if j+1 < len(line) && (line[j+1] == ch || line[j+1] == '&') {
+					j++
+				}
+			case ch == '&' && (j == 0 || (line[j-1] != '>' && line[j-1] != '<')) &&
+				!strings.HasPrefix(line[j+1:], ">"):
+				flush()
+				if strings.HasPrefix(line[j+1:], "&") {
+					j++
+				}
+			default:
+				cmd.WriteByte(ch)
+			}
+		}
+		if open != 0 {
+			cmd.WriteByte('\n')
+			continue
+		}
+		if continued {
+			continue
+		}
+		flush()
+		for _, h := range pending {
+			for i++; ; i++ {
+				if i >= len(lines) {
+					return nil, fmt.Errorf("%w; line %d: here-document lacks "+
+						"a line holding only %q", ErrIncomplete, h.line, h.word)
+				}
+				body := lines[i]
+				if h.stripTabs {
+					body = strings.TrimLeft(body, "\t")
+				}
+				if body == h.word {
+					break
+				}
+				if !h.quoted && (strings.Contains(body, "`") || hasSubstitution(body)) {
+					return nil, hidden(i, "command substitution in a here-document")
+				}
+			}
+		}
+		pending = nil
+	}
+	if open != 0 {
+		return nil, fmt.Errorf("%w; line %d: unterminated %c quote",
+			ErrIncomplete, quoteLine, open)
+	}
+	flush()
+	return result, nil
+}
+
+// isSubstitution is true if s starts a command substitution, i.e. $(,
+// but not an arithmetic expansion, i.e. $((.
+func isSubstitution(s string) bool {
+	return strings.HasPrefix(s, "$(") && !strings.HasPrefix(s, "$((")
+}
+
+// hasSubstitution is true if s holds a command substitution.
+func hasSubstitution(s string) bool {
+	for i := strings.Index(s, "$("); i >= 0; i = strings.Index(s, "$(") {
+		if isSubstitution(s[i:]) {
+			return true
+		}
+		s = s[i+3:]
+	}
+	return false
+}
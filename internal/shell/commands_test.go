@@ -0,0 +1,92 @@
+package shell_test
+
+import (
+	"testing"
+
+	. "github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitCommands(t *testing.T) {
+	for n, tc := range map[string]struct {
+		code    string
+		want    []string
+		wantErr error
+	}{
+		"empty":     {code: "\n  \n"},
+		"one":       {code: "echo hello\n", want: []string{"echo hello"}},
+		"lines":     {code: "echo a\n\nls -l\n", want: []string{"echo a", "ls -l"}},
+		"semicolon": {code: "echo a; ls", want: []string{"echo a", "ls"}},
+		"andOr": {
+			code: "true && echo a || echo b",
+			want: []string{"true", "echo a", "echo b"},
+		},
+		"pipeline": {
+			code: "kubectl get pods | grep web |& wc -l",
+			want: []string{"kubectl get pods", "grep web", "wc -l"},
+		},
+		"background": {code: "sleep 1 & wait", want: []string{"sleep 1", "wait"}},
+		"redirections": {
+			code: "echo a 2>&1 >/dev/null &>/tmp/x <&-",
+			want: []string{"echo a 2>&1 >/dev/null &>/tmp/x <&-"},
+		},
+		"quotedOperators": {
+			code: `echo 'a; rm -rf /' "b | c" $'d\'; e'`,
+			want: []string{`echo 'a; rm -rf /' "b | c" $'d\'; e'`},
+		},
+		"escapedOperator": {code: `echo a\; ls`, want: []string{`echo a\; ls`}},
+		"comment": {
+			code: "# setup\necho a # ; rm -rf /\necho b#c",
+			want: []string{"echo a", "echo b#c"},
+		},
+		"continued": {
+			code: "kubectl get \\\n  pods",
+			want: []string{"kubectl get   pods"},
+		},
+		"multiLineQuote": {
+			code: "echo 'a\nb'; ls",
+			want: []string{"echo 'a\nb'", "ls"},
+		},
+		"heredoc": {
+			code: "cat <<EOF | wc -l\nrm -rf /; ls\nEOF\necho done",
+			want: []string{"cat <<EOF", "wc -l", "echo done"},
+		},
+		"quotedHeredocMayHoldSubstitution": {
+			code: "cat <<'EOF'\n$(rm -rf /)\nEOF",
+			want: []string{"cat <<'EOF'"},
+		},
+		"arithmetic": {code: "echo $((1 + 2))", want: []string{"echo $((1 + 2))"}},
+		"keywords": {
+			code: "if true; then ls; fi",
+			want: []string{"if true", "then ls", "fi"},
+		},
+		"substitution": {
+			code: "echo $(rm -rf /)", wantErr: ErrHiddenCommand,
+		},
+		"substitutionInDoubleQuotes": {
+			code: `echo "$(rm -rf /)"`, wantErr: ErrHiddenCommand,
+		},
+		"substitutionInArithmetic": {
+			code: "echo $(( $(rm -rf /) ))", wantErr: ErrHiddenCommand,
+		},
+		"backquote": {code: "echo `ls`", wantErr: ErrHiddenCommand},
+		"processSubstitution": {
+			code: "diff <(ls a) b", wantErr: ErrHiddenCommand,
+		},
+		"substitutionInHeredoc": {
+			code: "cat <<EOF\n$(rm -rf /)\nEOF", wantErr: ErrHiddenCommand,
+		},
+		"unterminatedQuote":   {code: "echo 'a", wantErr: ErrIncomplete},
+		"unterminatedHeredoc": {code: "cat <<EOF\na", wantErr: ErrIncomplete},
+	} {
+		t.Run(n, func(t *testing.T) {
+			got, err := SplitCommands(tc.code)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrContainerNotRunning is returned by Start if the shell is to run
+// in a container, by docker or podman exec, that isn't running.
+var ErrContainerNotRunning = errors.New("container not running")
+
+// containerLaunchers are programs whose exec command runs a program
+// in a container, e.g. "docker exec -i web bash".
+var containerLaunchers = map[string]bool{
+	"docker": true,
+	"podman": true,
+}
+
+// execFlagsWithValue are the flags of docker and podman exec that
+// take a value, which, unless given with "=", is the next argument.
+var execFlagsWithValue = map[string]bool{
+	"-e": true, "--env": true, "--env-file": true,
+	"-u": true, "--user": true,
+	"-w": true, "--workdir": true,
+	"--detach-keys": true,
+}
+
+// containerExec describes a shell run by docker or podman exec.
+type containerExec struct {
+	// container is the name or ID of the container.
+	container string
+	// program is the program run in the container, e.g. bash.
+	program string
+	// tty is true if a tty is asked for, per -t.
+	tty bool
+}
+
+// parseContainerExec returns a description of the shell if it's run
+// as e.g. "docker exec -i web bash", else nil.
+func parseContainerExec(path string, args []string) *containerExec {
+	if !containerLaunchers[filepath.Base(path)] ||
+		len(args) == 0 || args[0] != "exec" {
+		return nil
+	}
+	ce := &containerExec{}
+	rest := args[1:]
+	for len(rest) > 0 && strings.HasPrefix(rest[0], "-") {
+		f := rest[0]
+		rest = rest[1:]
+		if f == "--" {
+			break
+		}
+		name, _, hasValue := strings.Cut(f, "=")
+		switch {
+		case name == "--tty":
+			ce.tty = true
+		case !strings.HasPrefix(f, "--") && !hasValue:
+			// Short flags may be combined, e.g. -it, the last one
+			// perhaps taking a value, e.g. -iu root.
+			ce.tty = ce.tty || strings.ContainsRune(f, 't')
+			name = "-" + f[len(f)-1:]
+		}
+		if execFlagsWithValue[name] && !hasValue && len(rest) > 0 {
+			rest = rest[1:]
+		}
+	}
+	if len(rest) == 0 {
+		return nil
+	}
+	ce.container = rest[0]
+	if len(rest) > 1 {
+		ce.program = rest[1]
+	}
+	return ce
+}
+
+// checkContainer returns an error if the shell is run by docker or
+// podman exec in a way that can't work: in a container that isn't
+// running, which would otherwise be reported as the shell failing
+// to start, or with a tty, which merges stderr into stdout, leaving
+// no way to tell where a command's stderr ends.
+func (ms *ManagedShell) checkContainer(d time.Duration) error {
+	ce := parseContainerExec(ms.path, ms.args)
+	if ce == nil {
+		return nil
+	}
+	if ce.tty {
+		return fmt.Errorf("%s exec must not be given -t; "+
+			"a tty merges the shell's stderr into its stdout", ms.path)
+	}
+	ctx, cancel := context.WithTimeout(
+		context.Background(), min(d, maxProbeTime))
+	defer cancel()
+	var errOut bytes.Buffer
+	cmd := exec.CommandContext(ctx, ms.path,
+		"inspect", "--format", "{{.State.Running}}", ce.container)
+	cmd.Stderr = &errOut
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("%w; unable to inspect container %q; %w; %s",
+			ErrContainerNotRunning, ce.container, err,
+			strings.TrimSpace(errOut.String()))
+	}
+	if strings.TrimSpace(string(out)) != "true" {
+		return fmt.Errorf("%w; container %q", ErrContainerNotRunning, ce.container)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package shell_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	. "github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDocker knows of two containers, web, which is running, and
+// stopped, which isn't.  It runs exec'd programs on the host, after
+// skipping exec's flags (none of them taking a value) and the name
+// of the container.
+const fakeDocker = `#!/bin/sh
+case "$1" in
+inspect)
+  case "$4" in
+  web) echo true ;;
+  stopped) echo false ;;
+  *) echo "Error: No such object: $4" >&2; exit 1 ;;
+  esac ;;
+exec)
+  shift
+  while case "$1" in -*) true ;; *) false ;; esac; do shift; done
+  shift
+  exec "$@" ;;
+esac
+`
+
+// writeFakeDocker returns the path to a fakeDocker.
+func writeFakeDocker(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "docker")
+	assert.NoError(t, os.WriteFile(path, []byte(fakeDocker), 0o755))
+	return path
+}
+
+func TestContainerExec(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShellArgs(
+		[]string{writeFakeDocker(t), "exec", "-i", "web", shPath})
+	// Per the program run in the container.
+	assert.True(t, ms.Posix())
+	assert.NoError(t, ms.Start(timeout))
+	defer func() { _ = ms.Stop(timeout) }()
+	res, err := ms.Execute(context.Background(), "echo out; echo err >&2; (exit 4)")
+	assert.NoError(t, err)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, []string{"out"}, res.Stdout)
+		assert.Equal(t, []string{"err"}, res.Stderr)
+		assert.Equal(t, 4, res.ExitCode)
+	}
+}
+
+func TestContainerExecIsolated(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShellArgs(
+		[]string{writeFakeDocker(t), "exec", "-i", "web", shPath})
+	ms.SetIsolated(true)
+	assert.NoError(t, ms.Start(timeout))
+	defer func() { _ = ms.Stop(timeout) }()
+	// The subshell is the container's shell, not docker.
+	res, err := ms.Execute(context.Background(), "cd /tmp && pwd")
+	assert.NoError(t, err)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, []string{"/tmp"}, res.Stdout)
+	}
+}
+
+func TestContainerExecFailsToStart(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	docker := writeFakeDocker(t)
+	for n, tc := range map[string]struct {
+		args    []string
+		want    string
+		wantErr error
+	}{
+		"stopped": {
+			args:    []string{"exec", "-i", "stopped", "bash"},
+			want:    `"stopped"`,
+			wantErr: ErrContainerNotRunning,
+		},
+		"unknown": {
+			args:    []string{"exec", "-i", "-u", "root", "nope", "bash"},
+			want:    "No such object: nope",
+			wantErr: ErrContainerNotRunning,
+		},
+		"tty": {
+			args: []string{"exec", "-it", "web", "bash"},
+			want: "-t",
+		},
+		"longTty": {
+			args: []string{"exec", "--interactive", "--tty", "web", "bash"},
+			want: "-t",
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			ms := NewManagedShellArgs(append([]string{docker}, tc.args...))
+			err := ms.Start(timeout)
+			assert.ErrorContains(t, err, tc.want)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// TestDockerExec runs a real shell in a real container, named by
+// $MDRIP_TEST_CONTAINER, e.g.
+//
+//	docker run -d --rm --name mdrip-test debian sleep infinity
+//	MDRIP_TEST_CONTAINER=mdrip-test go test ./internal/shell/...
+func TestDockerExec(t *testing.T) {
+	container := os.Getenv("MDRIP_TEST_CONTAINER")
+	if container == "" {
+		t.Skip("skipping since MDRIP_TEST_CONTAINER not set")
+	}
+	docker, err := exec.LookPath("docker")
+	if err != nil {
+		t.Skip("skipping since docker not found")
+	}
+	ms := NewManagedShellArgs([]string{docker, "exec", "-i", container, "sh"})
+	assert.NoError(t, ms.Start(timeout))
+	defer func() { _ = ms.Stop(timeout) }()
+	res, err := ms.Execute(context.Background(),
+		"cd /tmp\necho out; echo err >&2\nprintf 'no newline'")
+	assert.NoError(t, err)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, []string{"out", "no newline"}, res.Stdout)
+		assert.Equal(t, []string{"err"}, res.Stderr)
+	}
+	// State persists between runs, in the container.
+	res, err = ms.Execute(context.Background(), "pwd")
+	assert.NoError(t, err)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, []string{"/tmp"}, res.Stdout)
+	}
+}
@@ -0,0 +1,90 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// EncodingAuto, as a name given to SetOutputEncoding, means use the
+// charset named by the locale, per LC_ALL, LC_CTYPE or LANG.
+const EncodingAuto = "auto"
+
+// SetOutputEncoding names the character encoding, e.g. latin1 or
+// windows-1252, of the output of the code the shell runs.  Captured
+// output is converted from it to UTF-8, so that output from programs
+// emitting something else isn't garbled, or summarized as binary.
+// The empty string or utf-8 means the output is passed through
+// as is; that's the default.  EncodingAuto means use the locale's
+// charset, if it names one that's known and usable, and otherwise
+// pass the output through.  Encodings that don't encode newlines
+// as ASCII does, e.g. UTF-16, are refused, since output is read a
+// line at a time.
+func (ms *ManagedShell) SetOutputEncoding(name string) error {
+	auto := name == EncodingAuto
+	if auto {
+		name = localeCharset(os.Getenv)
+	}
+	enc, err := lookupEncoding(name)
+	if err != nil && !auto {
+		return err
+	}
+	ms.encoding = enc
+	return nil
+}
+
+// lookupEncoding returns the encoding with the given name, or nil
+// if it's UTF-8, i.e. if there's nothing to convert.
+func lookupEncoding(name string) (encoding.Encoding, error) {
+	if name == "" {
+		return nil, nil
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown output encoding %q; %w", name, err)
+	}
+	if canonical, _ := htmlindex.Name(enc); canonical == "utf-8" {
+		return nil, nil
+	}
+	if s, err := enc.NewEncoder().String("a\n"); err != nil || s != "a\n" {
+		return nil, fmt.Errorf(
+			"output encoding %q isn't ASCII compatible", name)
+	}
+	return enc, nil
+}
+
+// localeCharset returns the charset named by the locale, e.g.
+// ISO-8859-1 for de_DE.ISO-8859-1@euro, or the empty string if the
+// locale doesn't name one.  As with setlocale, LC_ALL overrides
+// LC_CTYPE, which overrides LANG.
+func localeCharset(getenv func(string) string) string {
+	for _, k := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		v := getenv(k)
+		if v == "" {
+			continue
+		}
+		_, charset, found := strings.Cut(v, ".")
+		if !found {
+			return ""
+		}
+		charset, _, _ = strings.Cut(charset, "@")
+		return charset
+	}
+	return ""
+}
+
+// decode converts the line from the output encoding to UTF-8.
+// Bytes that can't be converted are left as they are.
+func (ms *ManagedShell) decode(line string) string {
+	if ms.encoding == nil {
+		return line
+	}
+	s, err := ms.encoding.NewDecoder().String(line)
+	if err != nil {
+		return line
+	}
+	return s
+}
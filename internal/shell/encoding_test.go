@@ -0,0 +1,105 @@
+package shell_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	. "github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetOutputEncoding(t *testing.T) {
+	for n, tc := range map[string]struct {
+		name string
+		bad  bool
+	}{
+		"empty":  {},
+		"utf8":   {name: "utf-8"},
+		"utf8Uc": {name: "UTF8"},
+		"latin1": {name: "latin1"},
+		"iso":    {name: "ISO-8859-1"},
+		"sjis":   {name: "shift_jis"},
+		"auto":   {name: EncodingAuto},
+		"utf16":  {name: "utf-16le", bad: true},
+		"bogus":  {name: "klingon", bad: true},
+	} {
+		t.Run(n, func(t *testing.T) {
+			err := NewManagedShell(shPath).SetOutputEncoding(tc.name)
+			if tc.bad {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestExecuteConvertsOutput(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	// "café" and "naïve" in Latin-1.
+	const code = "printf 'caf\\351\\n'\nprintf 'na\\357ve\\n' >&2"
+	for n, tc := range map[string]struct {
+		encoding string
+		locale   string
+		wantOut  string
+		wantErr  string
+	}{
+		"passThrough": {
+			wantOut: "caf\xe9",
+			wantErr: "na\xefve",
+		},
+		"latin1": {
+			encoding: "latin1",
+			wantOut:  "café",
+			wantErr:  "naïve",
+		},
+		"fromLocale": {
+			encoding: EncodingAuto,
+			locale:   "de_DE.ISO-8859-1@euro",
+			wantOut:  "café",
+			wantErr:  "naïve",
+		},
+		"unknownLocale": {
+			encoding: EncodingAuto,
+			locale:   "tlh_QO.KLINGON",
+			wantOut:  "caf\xe9",
+			wantErr:  "na\xefve",
+		},
+		"utf8Locale": {
+			encoding: EncodingAuto,
+			locale:   "en_US.UTF-8",
+			wantOut:  "caf\xe9",
+			wantErr:  "na\xefve",
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			t.Setenv("LC_ALL", tc.locale)
+			ms := NewManagedShell(shPath)
+			assert.NoError(t, ms.SetOutputEncoding(tc.encoding))
+			assert.NoError(t, ms.Start(timeout))
+			defer func() { _ = ms.Stop(timeout) }()
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			res, err := ms.Execute(ctx, code)
+			if assert.NoError(t, err) {
+				assert.Equal(t, []string{tc.wantOut}, res.Stdout)
+				assert.Equal(t, []string{tc.wantErr}, res.Stderr)
+			}
+
+			stdout, stderr, wait, err := ms.ExecuteReader(ctx, code)
+			if !assert.NoError(t, err) {
+				return
+			}
+			out, errs := readAll(t, stdout, stderr)
+			assert.Equal(t, []string{tc.wantOut}, out)
+			assert.Equal(t, []string{tc.wantErr}, errs)
+			exitCode, err := wait()
+			assert.NoError(t, err)
+			assert.Equal(t, 0, exitCode)
+		})
+	}
+}
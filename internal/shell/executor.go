@@ -0,0 +1,98 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Executor runs code, e.g. a code block, returning its output and
+// exit status.  Executors that can't capture output (e.g. one that
+// types the code into a terminal) return an empty Result.
+type Executor interface {
+	Execute(ctx context.Context, code string) (*Result, error)
+}
+
+// ResolveTimeout returns the time limit for running a block, giving
+// the first positive one of, in order of precedence:
+//
+//   - the override, e.g. from a request to run the block,
+//   - the limit set by the block's timeout label,
+//   - the default, e.g. a server's.
+//
+// Zero means no limit, beyond any the executor imposes itself.
+// Callers apply the limit to the context given to Execute.
+func ResolveTimeout(override, label, dflt time.Duration) time.Duration {
+	for _, d := range []time.Duration{override, label, dflt} {
+		if d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// Echo is an Executor that doesn't run code, but writes it to W,
+// if W isn't nil, and returns it as stdout.
+type Echo struct {
+	W io.Writer
+}
+
+var _ Executor = &Echo{}
+
+// Execute implements Executor.
+func (e *Echo) Execute(ctx context.Context, code string) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if e.W != nil {
+		if _, err := io.WriteString(e.W, code); err != nil {
+			return nil, err
+		}
+	}
+	return &Result{
+		Stdout: strings.Split(strings.TrimSuffix(code, "\n"), "\n"),
+	}, nil
+}
+
+// ErrUnavailable is reported by executors that can't run code at all.
+var ErrUnavailable = errors.New("code execution unavailable")
+
+// Unavailable is an Executor for when code can't be run, e.g.
+// because the shell wouldn't start.  It fails every request with
+// ErrUnavailable, wrapped with the reason, so that a server can go
+// on serving markdown while reporting why blocks won't run.
+type Unavailable struct {
+	reason error
+}
+
+var _ Executor = &Unavailable{}
+
+// NewUnavailable returns an Unavailable with the given reason.
+func NewUnavailable(reason error) *Unavailable {
+	return &Unavailable{reason: reason}
+}
+
+// Execute implements Executor.
+func (u *Unavailable) Execute(context.Context, string) (*Result, error) {
+	return nil, u.err()
+}
+
+// Cwd fails just as Execute does.
+func (u *Unavailable) Cwd() (string, error) {
+	return "", u.err()
+}
+
+// Restart fails just as Execute does.
+func (u *Unavailable) Restart(time.Duration) error {
+	return u.err()
+}
+
+func (u *Unavailable) err() error {
+	if u.reason == nil {
+		return ErrUnavailable
+	}
+	return fmt.Errorf("%w; %w", ErrUnavailable, u.reason)
+}
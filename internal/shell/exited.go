@@ -0,0 +1,125 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/monopole/shexec"
+)
+
+// ErrShellExited is returned for code that took the shell down
+// with it, e.g. by running exit, or failing under set -e, and for
+// any code sent to the shell afterward, until it's restarted.
+var ErrShellExited = errors.New("shell exited during command")
+
+// exitedError is the ErrShellExited of the run that took the shell
+// down, giving the shell's exit status.
+type exitedError struct {
+	status int
+}
+
+func (e *exitedError) Error() string {
+	return fmt.Sprintf("%s (status %d)", ErrShellExited, e.status)
+}
+
+func (e *exitedError) Is(target error) bool {
+	return target == ErrShellExited
+}
+
+// exitedMarker precedes the exit status that the shell's EXIT trap
+// prints if the shell exits.  Unlike exitMarker, what follows it
+// is the status of the shell itself.
+func (ms *ManagedShell) exitedMarker() string {
+	return ms.marker + "Down"
+}
+
+// trapExit has the just started shell print the exited marker and
+// its exit status when it exits, so that code that takes the shell
+// down can be told from a shell that's stuck.  Code that sets its
+// own EXIT trap replaces this one, leaving the status unknown.
+func (ms *ManagedShell) trapExit(d time.Duration) error {
+	c := shexec.NewRecallCommander(
+		`trap 'printf "\n%s%d\n" ` + ms.exitedMarker() + ` "$?"' EXIT`)
+	if err := ms.sh.Run(d, c); err != nil {
+		return fmt.Errorf("unable to trap the shell's exit; %w", err)
+	}
+	return nil
+}
+
+// exitCommander wraps a commander, holding back the line that the
+// shell's EXIT trap prints, and noting the exit status in it.
+type exitCommander struct {
+	shexec.Commander
+	marker string
+	mu     sync.Mutex
+	// status is the shell's exit status, if exited.
+	status int
+	exited bool
+	// outClosed is closed along with the stdout parser, which shexec
+	// does once it's seen the sentinel, or the end of stdout.
+	outClosed chan struct{}
+	closeOnce sync.Once
+}
+
+var _ shexec.Commander = &exitCommander{}
+
+func newExitCommander(c shexec.Commander, marker string) *exitCommander {
+	return &exitCommander{Commander: c, marker: marker, outClosed: make(chan struct{})}
+}
+
+func (c *exitCommander) ParseOut() io.WriteCloser {
+	return &exitFilter{c: c, w: c.Commander.ParseOut()}
+}
+
+// exitError returns the error for a run that failed with the given
+// error, saying the shell exited if it did.  A shell that exited
+// first closes some stream, failing the run, perhaps before its
+// last lines of stdout, the exit status among them, are written,
+// so they're awaited briefly, unless the context is done.
+func (c *exitCommander) exitError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() == nil {
+		select {
+		case <-c.outClosed:
+		case <-time.After(abandonGrace):
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.exited {
+		return err
+	}
+	return &exitedError{status: c.status}
+}
+
+// exitFilter passes lines to a stdout parser, but for the line the
+// shell's EXIT trap prints.
+type exitFilter struct {
+	c *exitCommander
+	w io.WriteCloser
+}
+
+func (f *exitFilter) Write(data []byte) (int, error) {
+	if s, ok := strings.CutPrefix(string(data), f.c.marker); ok {
+		if status, err := strconv.Atoi(s); err == nil {
+			f.c.mu.Lock()
+			f.c.status, f.c.exited = status, true
+			f.c.mu.Unlock()
+			return len(data), nil
+		}
+	}
+	return f.w.Write(data)
+}
+
+func (f *exitFilter) Close() error {
+	defer f.c.closeOnce.Do(func() { close(f.c.outClosed) })
+	return f.w.Close()
+}
@@ -0,0 +1,80 @@
+package shell_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	. "github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/monopole/shexec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecuteShellExits(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	for n, tc := range map[string]struct {
+		args        []string
+		interactive bool
+		code        string
+		wantOut     []string
+		wantErr     []string
+		wantStatus  int
+	}{
+		"exit": {
+			code:    "echo hi; echo err >&2; exit 3",
+			wantOut: []string{"hi"}, wantErr: []string{"err"}, wantStatus: 3,
+		},
+		"setE": {
+			args: []string{"-e"}, code: "echo hi\nfalse\necho never",
+			wantOut: []string{"hi"}, wantStatus: 1,
+		},
+		"interactive": {
+			interactive: true, code: "echo hi; echo err >&2; exit 3",
+			wantOut: []string{"hi", "err"}, wantStatus: 3,
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			ms := NewManagedShell(shPath, tc.args...)
+			ms.SetInteractive(tc.interactive)
+			assert.NoError(t, ms.Start(timeout))
+			defer func() { _ = ms.Stop(timeout) }()
+			res, err := ms.Execute(context.Background(), tc.code)
+			assert.ErrorIs(t, err, ErrShellExited)
+			assert.ErrorContains(t, err, "shell exited during command (status")
+			if assert.NotNil(t, res) {
+				assert.Equal(t, tc.wantOut, res.Stdout)
+				assert.Equal(t, tc.wantErr, res.Stderr)
+				assert.Equal(t, tc.wantStatus, res.ExitCode)
+			}
+			assert.False(t, ms.Info().Alive)
+
+			// The shell stays down until restarted.
+			_, err = ms.Execute(context.Background(), "echo hi")
+			assert.ErrorIs(t, err, ErrShellExited)
+			assert.ErrorContains(t, err, "restart")
+			assert.NoError(t, ms.Restart(timeout))
+			res, err = ms.Execute(context.Background(), "echo back")
+			assert.NoError(t, err)
+			if assert.NotNil(t, res) {
+				assert.Equal(t, []string{"back"}, res.Stdout)
+			}
+		})
+	}
+}
+
+func TestRunShellExits(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	assert.NoError(t, ms.Start(timeout))
+	defer func() { _ = ms.Stop(timeout) }()
+	c := shexec.NewRecallCommander("echo hi; exit 3")
+	err := ms.Run(timeout, c)
+	assert.ErrorIs(t, err, ErrShellExited)
+	assert.ErrorContains(t, err, "status 3")
+	// The line the shell prints on exit is held back.
+	assert.Equal(t, []string{"hi"}, c.DataOut())
+}
@@ -0,0 +1,228 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrWouldDesync is returned by Execute for code that would leave
+// the shell unable to report where the code's output ends, e.g.
+// by closing or redirecting its stdout with exec.  See checkCode.
+var ErrWouldDesync = errors.New("code would desync the shell")
+
+var (
+	// execCmd matches exec at the start of a command, along with
+	// the rest of that command, counting the & in e.g. 2>&1 as part
+	// of the command.  An exec inside (...) runs in a
+	// subshell, so '(' isn't counted as a command start.
+	execCmd = regexp.MustCompile(
+		`(?:^|[;&|{]|\b(?:then|do|else)\s)\s*exec\b((?:&>|[<>]&|[^;&|])*)`)
+	// redirection matches a redirection word, e.g. 2>&1, >file, <&-.
+	redirection = regexp.MustCompile(`^(\d*)(&>>?|>>|>&|>\||<>|<&|<<|>|<)(.*)$`)
+)
+
+// checkCode returns an error wrapping ErrWouldDesync if the code
+// has a command that would change the managed shell's stdin, stdout
+// or stderr for good, or replace the shell altogether.
+//
+// The check is a heuristic over lines of text, not a parse.  It can
+// be fooled, e.g. by an exec built at run time with eval, and it may
+// complain about the word exec after a ';' in a quoted string.
+// Code run by a command wrapper runs in a subshell, and isn't checked.
+func checkCode(code string) error {
+	for i, line := range strings.Split(code, "\n") {
+		if j := strings.Index(line, "#"); j >= 0 &&
+			(j == 0 || line[j-1] == ' ' || line[j-1] == '\t') {
+			line = line[:j]
+		}
+		for _, m := range execCmd.FindAllStringSubmatch(line, -1) {
+			if why := execProblem(strings.Fields(m[1])); why != "" {
+				return fmt.Errorf("%w; line %d: %q %s; run it in a subshell, "+
+					"i.e. ( ... ), instead", ErrWouldDesync, i+1,
+					strings.TrimSpace(m[0]), why)
+			}
+		}
+	}
+	return nil
+}
+
+// execProblem returns what's wrong with an exec having the given
+// arguments, or the empty string if there's nothing wrong.  An exec
+// with only redirections changes the shell's file descriptors, which
+// is fine for descriptors other than stdin, stdout and stderr.
+func execProblem(args []string) string {
+	for i := 0; i < len(args); i++ {
+		m := redirection.FindStringSubmatch(args[i])
+		if m == nil {
+			return "replaces the shell"
+		}
+		fd, op := m[1], m[2]
+		if m[3] == "" {
+			// The target is the next word, e.g. "> file".
+			i++
+		}
+		switch {
+		case strings.HasPrefix(op, "&"):
+			return "redirects stdout and stderr"
+		case fd == "" && strings.HasPrefix(op, "<"), fd == "0":
+			return "redirects stdin, from which the shell reads commands"
+		case fd == "" && strings.HasPrefix(op, ">"), fd == "1":
+			return "redirects stdout"
+		case fd == "2":
+			return "redirects stderr"
+		}
+	}
+	return ""
+}
+
+// ErrIncomplete is returned by Execute for code that leaves a quote
+// or here-document open, so that the shell would go on reading, taking
+// the commands that mark the end of the code's output as part of it,
+// and the run would hang until it timed out.  See checkComplete.
+var ErrIncomplete = errors.New("code is incomplete")
+
+// heredoc is a here-document begun, but not yet ended, by the code.
+type heredoc struct {
+	// word is the delimiter that ends it, sans quotes.
+	word string
+	// stripTabs is true for <<-, which ignores leading tabs.
+	stripTabs bool
+	// quoted is true if any of the word is quoted, in which case
+	// the body isn't expanded.
+	quoted bool
+	line   int
+}
+
+// checkComplete returns an error wrapping ErrIncomplete if the code
+// has an unterminated quote or backquote, or a here-document
+// lacking its delimiter line.  A trailing backslash isn't a problem,
+// since the shell is sent an empty line after the code.
+//
+// Like checkCode, it's a heuristic; it knows nothing of case patterns
+// or of quotes nested in backquotes, and treats $'...' as a kind of
+// single quote.
+func checkComplete(code string) error {
+	lines := strings.Split(code, "\n")
+	var (
+		open      byte // the open quote, if any
+		dollarQ   bool // quote is from $'
+		quoteLine int
+		arith     int // depth of (( ... ))
+		pending   []heredoc
+	)
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		for j := 0; j < len(line); j++ {
+			ch := line[j]
+			switch open {
+			case '\'':
+				if ch == '\\' && dollarQ {
+					j++
+				} else if ch == '\'' {
+					open = 0
+				}
+				continue
+			case '"', '`':
+				if ch == '\\' {
+					j++
+				} else if ch == open {
+					open = 0
+				}
+				continue
+			}
+			switch {
+			case ch == '\\':
+				j++
+			case ch == '\'' || ch == '"' || ch == '`':
+				open, quoteLine = ch, i+1
+				dollarQ = ch == '\'' && j > 0 && line[j-1] == '$'
+			case ch == '#' && (j == 0 || strings.IndexByte(" \t;&|()", line[j-1]) >= 0):
+				j = len(line)
+			case strings.HasPrefix(line[j:], "(("):
+				arith++
+				j++
+			case arith > 0 && strings.HasPrefix(line[j:], "))"):
+				arith--
+				j++
+			case arith == 0 && strings.HasPrefix(line[j:], "<<") &&
+				!strings.HasPrefix(line[j:], "<<<"):
+				var h heredoc
+				h, j = parseHeredoc(line, j+2)
+				if h.word != "" {
+					h.line = i + 1
+					pending = append(pending, h)
+				}
+			}
+		}
+		if open != 0 {
+			continue
+		}
+		// The bodies of here-documents begun on this line come next.
+		for _, h := range pending {
+			for i++; ; i++ {
+				if i >= len(lines) {
+					return fmt.Errorf("%w; line %d: here-document lacks "+
+						"a line holding only %q", ErrIncomplete, h.line, h.word)
+				}
+				body := lines[i]
+				if h.stripTabs {
+					body = strings.TrimLeft(body, "\t")
+				}
+				if body == h.word {
+					break
+				}
+			}
+		}
+		pending = nil
+	}
+	if open != 0 {
+		return fmt.Errorf("%w; line %d: unterminated %c quote",
+			ErrIncomplete, quoteLine, open)
+	}
+	return nil
+}
+
+// parseHeredoc parses the delimiter following the << at line[j-2:j],
+// returning the here-document, which has no word if it isn't
+// one after all, e.g. in $((1 << 2)), and the index of the
+// delimiter's last byte.
+func parseHeredoc(line string, j int) (heredoc, int) {
+	var h heredoc
+	if j < len(line) && line[j] == '-' {
+		h.stripTabs = true
+		j++
+	}
+	for j < len(line) && (line[j] == ' ' || line[j] == '\t') {
+		j++
+	}
+	var word strings.Builder
+	for ; j < len(line); j++ {
+		ch := line[j]
+		switch {
+		case ch == '\'' || ch == '"':
+			end := strings.IndexByte(line[j+1:], ch)
+			if end < 0 {
+				return heredoc{}, len(line)
+			}
+			word.WriteString(line[j+1 : j+1+end])
+			j += end + 1
+			h.quoted = true
+		case ch == '\\' && j+1 < len(line):
+			j++
+			word.WriteByte(line[j])
+			h.quoted = true
+		case strings.IndexByte(" \t;&|<>()", ch) >= 0:
+			h.word = word.String()
+			return h, j - 1
+		default:
+			if word.Len() == 0 && ch >= '0' && ch <= '9' {
+				return heredoc{}, j
+			}
+			word.WriteByte(ch)
+		}
+	}
+	h.word = word.String()
+	return h, j - 1
+}
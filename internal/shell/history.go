@@ -0,0 +1,226 @@
+package shell
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/monopole/mdrip/v2/internal/utils"
+)
+
+// DefaultHistoryMaxSize is the size, in bytes, beyond which
+// a history file is rotated, unless SetMaxSize says otherwise.
+const DefaultHistoryMaxSize = 1 << 20
+
+// HistoryEntry is one line of a history file.
+type HistoryEntry struct {
+	// Time is when the code started running.
+	Time time.Time `json:"time"`
+	// ExitCode is the exit status of the code's last command.
+	ExitCode int `json:"exitCode"`
+	// Error is set if the code didn't run to completion.
+	Error string `json:"error,omitempty"`
+	Code  string `json:"code"`
+}
+
+// History is an Executor that appends each piece of code it's given,
+// along with when it ran and how it turned out, to a file, e.g.
+// ~/.mdrip_history, so that one can look back at what was run across
+// restarts.  The code is run by another Executor.
+//
+// Each entry is a HistoryEntry in JSON form, on a line of its own,
+// written with one call to write on a file opened for appending, so
+// entries from concurrent runs, even from other processes, don't mix.
+// Once the file exceeds its max size, it's renamed with the suffix
+// ".1", replacing any older one, and a new file is started.
+type History struct {
+	ex      Executor
+	path    string
+	maxSize int64
+	mu      sync.Mutex
+}
+
+var _ Executor = &History{}
+
+// NewHistory returns a History appending to the file at path the
+// code run by ex.
+func NewHistory(ex Executor, path string) *History {
+	return &History{ex: ex, path: path, maxSize: DefaultHistoryMaxSize}
+}
+
+// SetMaxSize sets the size, in bytes, beyond which the file is
+// rotated; zero or less means never rotate.
+func (h *History) SetMaxSize(n int64) {
+	h.maxSize = n
+}
+
+// Path returns the path to the history file.
+func (h *History) Path() string {
+	return h.path
+}
+
+type sourceKey struct{}
+
+// WithSource returns a copy of the context carrying the code as
+// written, e.g. a block's text, before it's wrapped to run, e.g. by
+// PipedTo, Traced or WithEnv, so that History records it rather than
+// the wrapping.
+func WithSource(ctx context.Context, code string) context.Context {
+	return context.WithValue(ctx, sourceKey{}, code)
+}
+
+// sourceOf returns the code carried by the context, if any, else
+// the code given.
+func sourceOf(ctx context.Context, code string) string {
+	if s, ok := ctx.Value(sourceKey{}).(string); ok {
+		return s
+	}
+	return code
+}
+
+// Execute implements Executor.  The code recorded is that carried by
+// the context, per WithSource, if any.  Trouble writing the history
+// is logged, but doesn't fail the run.
+func (h *History) Execute(ctx context.Context, code string) (*Result, error) {
+	start := time.Now()
+	out, err := h.ex.Execute(ctx, code)
+	e := HistoryEntry{Time: start, Code: sourceOf(ctx, code)}
+	if out != nil {
+		e.ExitCode = out.ExitCode
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if hErr := h.Append(e); hErr != nil {
+		utils.Logger(ctx).Warn("unable to write history", "err", hErr)
+	}
+	return out, err
+}
+
+// ErrNoCwd is returned by History's Cwd if the executor it wraps
+// has no working directory to report, e.g. if it's tmux.
+var ErrNoCwd = errors.New("code runner has no working directory")
+
+// Cwd reports the working directory of the wrapped executor's shell,
+// if it can.
+func (h *History) Cwd() (string, error) {
+	if c, ok := h.ex.(interface{ Cwd() (string, error) }); ok {
+		return c.Cwd()
+	}
+	return "", ErrNoCwd
+}
+
+// ErrNoRestart is returned by History's Restart if the executor
+// it wraps can't be restarted, e.g. if it's tmux.
+var ErrNoRestart = errors.New("code runner can't be restarted")
+
+// Restart restarts the wrapped executor's shell, if it can.
+// The history file is kept.
+func (h *History) Restart(d time.Duration) error {
+	if r, ok := h.ex.(interface{ Restart(time.Duration) error }); ok {
+		return r.Restart(d)
+	}
+	return ErrNoRestart
+}
+
+// Info describes the wrapped executor's shell, if it can; if not,
+// the info is zero.
+func (h *History) Info() ShellInfo {
+	if i, ok := h.ex.(interface{ Info() ShellInfo }); ok {
+		return i.Info()
+	}
+	return ShellInfo{}
+}
+
+// SetTerminalSize sizes the terminal of the wrapped executor's
+// shell, if it has one.
+func (h *History) SetTerminalSize(rows, cols int) error {
+	if ts, ok := h.ex.(interface{ SetTerminalSize(int, int) error }); ok {
+		return ts.SetTerminalSize(rows, cols)
+	}
+	return nil
+}
+
+// Stop stops the wrapped executor's shell, if it has one.
+func (h *History) Stop(d time.Duration) error {
+	if st, ok := h.ex.(interface{ Stop(time.Duration) error }); ok {
+		return st.Stop(d)
+	}
+	return nil
+}
+
+// StopContext stops the wrapped executor's shell, if it has one,
+// waiting for it until the context is done.
+func (h *History) StopContext(ctx context.Context) error {
+	if st, ok := h.ex.(interface{ StopContext(context.Context) error }); ok {
+		return st.StopContext(ctx)
+	}
+	return nil
+}
+
+// Append writes the entry to the file, first rotating the file
+// if the entry would take it beyond its max size.
+func (h *History) Append(e HistoryEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err = h.maybeRotate(int64(len(line))); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(line); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// maybeRotate renames the file if adding n bytes would take it
+// beyond its max size.  A file that's empty is never rotated,
+// so that an entry bigger than the max size still gets written.
+func (h *History) maybeRotate(n int64) error {
+	if h.maxSize <= 0 {
+		return nil
+	}
+	info, err := os.Stat(h.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 || info.Size()+n <= h.maxSize {
+		return nil
+	}
+	return os.Rename(h.path, h.path+".1")
+}
+
+// ReadHistory returns the entries in the history file at path.
+func ReadHistory(path string) ([]HistoryEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	var result []HistoryEntry
+	for d := json.NewDecoder(f); d.More(); {
+		var e HistoryEntry
+		if err = d.Decode(&e); err != nil {
+			return result, fmt.Errorf("bad history in %s; %w", path, err)
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
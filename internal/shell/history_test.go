@@ -0,0 +1,104 @@
+package shell_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/monopole/mdrip/v2/internal/shell/shelltest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".mdrip_history")
+	boom := errors.New("boom")
+	h := NewHistory(shelltest.NewFakeExecutor().
+		On("false", Result{ExitCode: 1}).
+		FailOn("sleep 99", Result{}, boom), path)
+	before := time.Now()
+	for _, code := range []string{"echo hi\n", "false", "cd /tmp\nls\n"} {
+		_, err := h.Execute(context.Background(), code)
+		assert.NoError(t, err)
+	}
+	_, err := h.Execute(context.Background(), "sleep 99")
+	assert.ErrorIs(t, err, boom)
+
+	// A second History on the same file appends to it, as after a restart.
+	_, err = NewHistory(&Echo{}, path).Execute(context.Background(), "pwd")
+	assert.NoError(t, err)
+
+	entries, err := ReadHistory(path)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	if !assert.Equal(t, 5, len(entries)) {
+		t.FailNow()
+	}
+	for i, want := range []HistoryEntry{
+		{Code: "echo hi\n"},
+		{Code: "false", ExitCode: 1},
+		{Code: "cd /tmp\nls\n"},
+		{Code: "sleep 99", Error: "boom"},
+		{Code: "pwd"},
+	} {
+		assert.False(t, entries[i].Time.Before(before.Truncate(time.Second)))
+		entries[i].Time = time.Time{}
+		assert.Equal(t, want, entries[i])
+	}
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestHistoryWithSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".mdrip_history")
+	ex := shelltest.NewFakeExecutor()
+	h := NewHistory(ex, path)
+	const src = "echo $DEBUG\n"
+	wrapped := Traced(WithEnv([]string{"DEBUG=1"}, src))
+	_, err := h.Execute(WithSource(context.Background(), src), wrapped)
+	assert.NoError(t, err)
+	// The wrapped code is what runs; the source is what's recorded.
+	assert.Equal(t, []string{wrapped}, ex.Calls())
+	entries, err := ReadHistory(path)
+	if assert.NoError(t, err) && assert.Equal(t, 1, len(entries)) {
+		assert.Equal(t, src, entries[0].Code)
+	}
+}
+
+func TestHistoryRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	h := NewHistory(&Echo{}, path)
+	h.SetMaxSize(200)
+	for i := 0; i < 10; i++ {
+		_, err := h.Execute(context.Background(), strings.Repeat("x", 40))
+		assert.NoError(t, err)
+	}
+	for _, p := range []string{path, path + ".1"} {
+		info, err := os.Stat(p)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		assert.LessOrEqual(t, info.Size(), int64(200))
+		entries, err := ReadHistory(p)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, entries)
+	}
+	_, err := os.Stat(path + ".2")
+	assert.True(t, os.IsNotExist(err))
+
+	// An entry bigger than the max still gets written.
+	big := strings.Repeat("y", 500)
+	_, err = h.Execute(context.Background(), big)
+	assert.NoError(t, err)
+	entries, err := ReadHistory(path)
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(entries)) {
+		assert.Equal(t, big, entries[0].Code)
+	}
+}
@@ -0,0 +1,91 @@
+package shell
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/monopole/shexec"
+)
+
+// ShellInfo describes a managed shell, e.g. to diagnose a shell
+// that's stuck or leaked.  The zero value means no shell at all;
+// a shell that isn't started has just a Path.
+type ShellInfo struct {
+	// Path is the shell program.
+	Path string `json:"path"`
+	// PID is the shell's process ID, per the shell, so for a shell
+	// run by e.g. docker exec, its ID in the container.  It's zero
+	// if the shell never started.
+	PID int `json:"pid"`
+	// StartedAt is when the shell last started.
+	StartedAt time.Time `json:"startedAt"`
+	// Alive is true if the shell started, and hasn't since been
+	// stopped or failed, e.g. by timing out.
+	Alive bool `json:"alive"`
+	// CommandsRun counts the runs sent to the shell since it started.
+	CommandsRun int `json:"commandsRun"`
+}
+
+// String is the info in one line, for the debug page.
+func (si ShellInfo) String() string {
+	state := "dead"
+	if si.Alive {
+		state = "alive"
+	}
+	return fmt.Sprintf("%s pid %d started %s, %s, %d commands run",
+		si.Path, si.PID, si.StartedAt.Format(time.RFC3339), state, si.CommandsRun)
+}
+
+// shellStats tracks the use of a started shell.  A run abandoned by
+// a shell that's since restarted updates the old shell's stats.
+type shellStats struct {
+	mu   sync.Mutex
+	info ShellInfo
+}
+
+func (st *shellStats) ran(err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.info.CommandsRun++
+	if err != nil {
+		// Per shexec, a failed run leaves the shell dead.
+		st.info.Alive = false
+	}
+}
+
+func (st *shellStats) stopped() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.info.Alive = false
+}
+
+// Info describes the shell.  It doesn't wait for a running command.
+func (ms *ManagedShell) Info() ShellInfo {
+	if st := ms.stats.Load(); st != nil {
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		return st.info
+	}
+	return ShellInfo{Path: ms.path}
+}
+
+// startStats asks the just started shell its PID, and starts
+// tracking its use.
+func (ms *ManagedShell) startStats(d time.Duration) error {
+	c := shexec.NewRecallCommander(ms.printCmd("$$"))
+	if err := ms.sh.Run(d, c); err != nil {
+		return fmt.Errorf("unable to get the shell's PID; %w", err)
+	}
+	if len(c.DataOut()) != 1 {
+		return fmt.Errorf("unexpected PID output %v", c.DataOut())
+	}
+	pid, err := strconv.Atoi(c.DataOut()[0])
+	if err != nil {
+		return fmt.Errorf("bad PID %q; %w", c.DataOut()[0], err)
+	}
+	ms.stats.Store(&shellStats{info: ShellInfo{
+		Path: ms.path, PID: pid, StartedAt: time.Now(), Alive: true}})
+	return nil
+}
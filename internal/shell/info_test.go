@@ -0,0 +1,61 @@
+package shell_test
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInfo(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	assert.Equal(t, ShellInfo{Path: shPath}, ms.Info())
+
+	before := time.Now()
+	assert.NoError(t, ms.Start(timeout))
+	info := ms.Info()
+	assert.Equal(t, shPath, info.Path)
+	assert.True(t, info.Alive)
+	assert.Positive(t, info.PID)
+	assert.False(t, info.StartedAt.Before(before))
+	assert.Zero(t, info.CommandsRun)
+
+	res, err := ms.Execute(context.Background(), "echo $$")
+	assert.NoError(t, err)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, []string{strconv.Itoa(info.PID)}, res.Stdout)
+	}
+	_, err = ms.Execute(context.Background(), "true")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, ms.Info().CommandsRun)
+
+	// A restarted shell is a new process, with a fresh count.
+	assert.NoError(t, ms.Restart(timeout))
+	assert.NotEqual(t, info.PID, ms.Info().PID)
+	assert.Zero(t, ms.Info().CommandsRun)
+	assert.True(t, ms.Info().Alive)
+
+	assert.NoError(t, ms.Stop(timeout))
+	assert.False(t, ms.Info().Alive)
+}
+
+func TestInfoAfterTimeout(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	assert.NoError(t, ms.Start(timeout))
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err := ms.Execute(ctx, "sleep 2")
+	assert.Error(t, err)
+	assert.False(t, ms.Info().Alive)
+	assert.Equal(t, 1, ms.Info().CommandsRun)
+}
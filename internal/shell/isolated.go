@@ -0,0 +1,143 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/monopole/shexec"
+)
+
+// Usage is the resource usage of code run in a process of its own,
+// i.e. by an isolated shell.
+type Usage struct {
+	// User is the CPU time spent in user mode.
+	User time.Duration
+	// System is the CPU time spent in the kernel.
+	System time.Duration
+	// MaxRssKb is the most memory, in kilobytes, the process, or
+	// its largest child, had resident at once.  It's zero where
+	// the platform can't say.
+	MaxRssKb int64
+}
+
+// usageOf returns the resource usage of the finished process,
+// or nil if it never started.
+func usageOf(ps *os.ProcessState) *Usage {
+	if ps == nil {
+		return nil
+	}
+	return &Usage{
+		User:     ps.UserTime(),
+		System:   ps.SystemTime(),
+		MaxRssKb: maxRssKb(ps),
+	}
+}
+
+// spawned is true if code runs in a process of its own,
+// rather than in the managed shell; see SetIsolated.
+func (ms *ManagedShell) spawned() bool {
+	return ms.isolated && !ms.dryRun
+}
+
+// spawnCmd returns a command that runs the code, after the profile
+// script, if any, in a new process of the shell, as
+//
+//	wrapper... {shellPath} {args...} -c '{profile}\n{code}'
+func (ms *ManagedShell) spawnCmd(ctx context.Context, code string) (*exec.Cmd, error) {
+	if strings.TrimSpace(ms.profile) != "" {
+		p, err := profileCode(ms.profile)
+		if err != nil {
+			return nil, fmt.Errorf("profile script; %w", err)
+		}
+		code = p + "\n" + code
+	}
+	argv := slices.Concat(
+		ms.wrapper, []string{ms.path}, ms.args, []string{"-c", code})
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	// Don't wait long on a background process still holding
+	// stdout or stderr open once the shell is done.
+	cmd.WaitDelay = abandonGrace
+	return cmd, nil
+}
+
+// spawn runs the code per spawnCmd, killing it if the context is
+// done first, and returns its output, exit status and resource
+// usage.  Code like "exit 3" is fine, since the process is its own.
+func (ms *ManagedShell) spawn(ctx context.Context, code string) (*Result, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, writeTimeout)
+		defer cancel()
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	cmd, err := ms.spawnCmd(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	var out, errOut bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &errOut
+	err = cmd.Run()
+	if st := ms.stats.Load(); st != nil {
+		// The managed shell is unaffected by how the run went.
+		st.ran(nil)
+	}
+	res := &Result{
+		Stdout: ms.summarize(ms.lines(out.String())),
+		Stderr: ms.summarize(ms.lines(errOut.String())),
+		Usage:  usageOf(cmd.ProcessState),
+	}
+	if ctx.Err() != nil {
+		return res, fmt.Errorf("%s didn't finish; %w", ms.path, ctx.Err())
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.Exited() {
+		res.ExitCode = exitErr.ExitCode()
+		return res, nil
+	}
+	return res, err
+}
+
+// runSpawned is Run for a spawned shell.  As with RunOnce,
+// a non-zero exit is an error.
+func (ms *ManagedShell) runSpawned(d time.Duration, c shexec.Commander) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	res, err := ms.spawn(ctx, c.Command())
+	if res != nil {
+		if wErr := writeLines(c.ParseOut(), joinLines(res.Stdout)); wErr != nil {
+			return wErr
+		}
+		if wErr := writeLines(c.ParseErr(), joinLines(res.Stderr)); wErr != nil {
+			return wErr
+		}
+	}
+	if err == nil && res.ExitCode != 0 {
+		err = fmt.Errorf("exit status %d", res.ExitCode)
+	}
+	return err
+}
+
+// splitLines splits the output into lines, sans newlines.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// joinLines undoes splitLines.
+func joinLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
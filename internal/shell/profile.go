@@ -0,0 +1,71 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/monopole/shexec"
+)
+
+// SetProfileScript arranges for Start, and so Restart, to run the
+// given script in the shell before any other code, e.g. to define
+// the variables and functions that a tutorial assumes the reader
+// got by sourcing its setup.sh.  The script is either the path to a
+// file, which is sourced (with "."), or, failing that, the code
+// itself.  Start fails if the script does, i.e. if it leaves the
+// shell with a non-zero exit status, or takes the shell down.
+//
+// The script is run by the managed shell itself, even given a
+// command wrapper, so what it defines reaches wrapped commands
+// only if exported.  It isn't run in dry-run mode.
+func (ms *ManagedShell) SetProfileScript(s string) {
+	ms.profile = s
+}
+
+// profileCode returns the code that runs the profile script.
+func profileCode(s string) (string, error) {
+	if strings.ContainsRune(s, '\n') {
+		return s, nil
+	}
+	fi, err := os.Stat(s)
+	if err != nil || !fi.Mode().IsRegular() {
+		return s, nil
+	}
+	// Unless it has a slash, "." looks for the file in PATH.
+	p, err := filepath.Abs(s)
+	if err != nil {
+		return "", err
+	}
+	return ". " + quote(p), nil
+}
+
+// runProfile runs the profile script, if any, in the shell, which
+// must have just started.
+func (ms *ManagedShell) runProfile(d time.Duration) error {
+	if strings.TrimSpace(ms.profile) == "" {
+		return nil
+	}
+	code, err := profileCode(ms.profile)
+	if err == nil {
+		err = checkCode(code)
+	}
+	if err == nil {
+		err = checkComplete(code)
+	}
+	if err != nil {
+		return fmt.Errorf("profile script; %w", err)
+	}
+	c := shexec.NewRecallCommander(ms.withExitStatus(code))
+	res, err := ms.result(c, ms.sh.Run(d, c))
+	if err != nil {
+		return fmt.Errorf("profile script; %w", err)
+	}
+	if res.ExitCode != 0 {
+		return fmt.Errorf("profile script exited with status %d; stderr: %s",
+			res.ExitCode, strings.Join(res.Stderr, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package shell_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfileScript(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	const setup = "greet() { echo \"hello $1\"; }\nexport WHO=world\n"
+	path := filepath.Join(t.TempDir(), "setup.sh")
+	assert.NoError(t, os.WriteFile(path, []byte(setup), 0o644))
+	for n, tc := range map[string]struct {
+		profile string
+	}{
+		"inline": {profile: setup},
+		"file":   {profile: path},
+	} {
+		t.Run(n, func(t *testing.T) {
+			ms := NewManagedShell(shPath)
+			ms.SetProfileScript(tc.profile)
+			assert.NoError(t, ms.Start(timeout))
+			defer func() { _ = ms.Stop(timeout) }()
+			res, err := ms.Execute(context.Background(), `greet "$WHO"`)
+			assert.NoError(t, err)
+			if assert.NotNil(t, res) {
+				assert.Equal(t, []string{"hello world"}, res.Stdout)
+			}
+
+			// It's run again on restart.
+			assert.NoError(t, ms.Restart(timeout))
+			res, err = ms.Execute(context.Background(), "greet again")
+			assert.NoError(t, err)
+			if assert.NotNil(t, res) {
+				assert.Equal(t, []string{"hello again"}, res.Stdout)
+			}
+		})
+	}
+}
+
+func TestProfileScriptFails(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	for n, tc := range map[string]struct {
+		profile string
+		want    string
+	}{
+		"exitStatus": {
+			profile: "echo setting up\necho oops >&2\nfalse\n",
+			want:    "oops",
+		},
+		"incomplete": {profile: "echo 'setting up\n", want: ErrIncomplete.Error()},
+		"desync":     {profile: "exec 1>&-\n", want: ErrWouldDesync.Error()},
+	} {
+		t.Run(n, func(t *testing.T) {
+			ms := NewManagedShell(shPath)
+			ms.SetProfileScript(tc.profile)
+			err := ms.Start(timeout)
+			assert.ErrorContains(t, err, "profile script")
+			assert.ErrorContains(t, err, tc.want)
+			_, err = ms.Execute(context.Background(), "echo hi")
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,270 @@
+//go:build unix
+
+package shell
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/monopole/shexec"
+)
+
+// winSize is the size of an interactive shell's terminal.  It's
+// shared by a ManagedShell and the shell it runs, so that the size
+// may change while the shell runs a command.
+type winSize struct {
+	mu         sync.Mutex
+	rows, cols int
+	// tty is the terminal of the running shell, if any.
+	tty *os.File
+}
+
+// set sets the size, resizing the terminal of the running shell,
+// if any, whose foreground command then gets SIGWINCH.
+func (ws *winSize) set(rows, cols int) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.rows, ws.cols = rows, cols
+	if ws.tty == nil {
+		return nil
+	}
+	return ws.resize()
+}
+
+// attach sizes the terminal of a just started shell, and
+// makes it the one to resize.
+func (ws *winSize) attach(tty *os.File) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.tty = tty
+	return ws.resize()
+}
+
+// detach forgets the terminal of a stopped shell, unless
+// another shell has since been attached.
+func (ws *winSize) detach(tty *os.File) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.tty == tty {
+		ws.tty = nil
+	}
+}
+
+func (ws *winSize) resize() error {
+	if err := pty.Setsize(ws.tty, &pty.Winsize{
+		Rows: uint16(ws.rows), Cols: uint16(ws.cols)}); err != nil {
+		return fmt.Errorf("unable to size the pty; %w", err)
+	}
+	return nil
+}
+
+// ptyShell is a shexec.Shell whose commands run on a pseudo-terminal,
+// for programs, e.g. ssh, top or an editor, that misbehave without
+// one.  The shell reads its commands from a pipe, as shexec's does,
+// so it isn't itself interactive: nothing is typed at the terminal,
+// so nothing is echoed, and the shell prints no prompts.  Each
+// command's stdin, stdout and stderr are the terminal, which is also
+// the shell's controlling terminal, so stderr comes mixed into
+// stdout, and there's just the one sentinel.
+type ptyShell struct {
+	path     string
+	args     []string
+	sentinel shexec.Sentinel
+	size     *winSize
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	tty      *os.File
+	// lines holds the lines the terminal prints, sans line endings;
+	// it's closed once the terminal is.
+	lines <-chan string
+	// done is closed once the shell is stopped.
+	done chan struct{}
+}
+
+var _ shexec.Shell = &ptyShell{}
+
+// newPtyShell returns a shell, in the off state, that runs its
+// commands on a pseudo-terminal of the given size, and prints the
+// sentinel per s.C.
+func newPtyShell(
+	path string, args []string, s shexec.Sentinel, size *winSize) shexec.Shell {
+	return &ptyShell{path: path, args: args, sentinel: s, size: size}
+}
+
+// Start implements shexec.Shell.
+func (p *ptyShell) Start(d time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd != nil {
+		return errors.New("shell already started")
+	}
+	tty, term, err := pty.Open()
+	if err != nil {
+		return fmt.Errorf("unable to open a pty; %w", err)
+	}
+	defer func() { _ = term.Close() }()
+	if err = p.size.attach(tty); err != nil {
+		p.size.detach(tty)
+		_ = tty.Close()
+		return err
+	}
+	cmd := exec.Command(p.path, p.args...)
+	cmd.Stdout, cmd.Stderr = term, term
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 1}
+	stdin, err := cmd.StdinPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		p.size.detach(tty)
+		_ = tty.Close()
+		return err
+	}
+	lines := make(chan string)
+	p.cmd, p.stdin, p.tty = cmd, stdin, tty
+	p.lines, p.done = lines, make(chan struct{})
+	go scan(tty, lines, p.done)
+	if err = p.await(d, shexec.DevNull, ""); err != nil {
+		p.kill()
+		return fmt.Errorf("shell didn't start; %w", err)
+	}
+	return nil
+}
+
+// scan sends the lines that the terminal prints to the channel,
+// until the terminal closes, or done is.
+func scan(tty io.Reader, lines chan<- string, done <-chan struct{}) {
+	defer close(lines)
+	r := bufio.NewReader(tty)
+	for {
+		line, err := r.ReadString('\n')
+		// The terminal turns "\n" into "\r\n".
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if line != "" || err == nil {
+			select {
+			case lines <- line:
+			case <-done:
+				return
+			}
+		}
+		if err != nil {
+			// Once the shell and its children are gone, reading
+			// the terminal fails, e.g. with EIO.
+			return
+		}
+	}
+}
+
+// Run implements shexec.Shell.  The command's output, all of it
+// on stdout, is written to the commander's stdout parser.
+func (p *ptyShell) Run(d time.Duration, c shexec.Commander) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd == nil {
+		return errNotStarted
+	}
+	err := p.await(d, c.ParseOut(), c.Command())
+	if cErr := c.ParseErr().Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		// As with shexec, a failed run leaves the shell dead.
+		p.kill()
+	}
+	return err
+}
+
+// await sends the code to the shell, with its stdin redirected from
+// the terminal, then the sentinel command, and writes the lines that
+// come before the sentinel, waiting at most the given duration.
+// The code runs in a group, rather than in a subshell, so what it
+// does to the shell, e.g. a cd, persists.
+func (p *ptyShell) await(d time.Duration, w io.WriteCloser, code string) error {
+	script := p.sentinel.C + "\n"
+	if strings.TrimSpace(code) != "" {
+		script = "{\n" + strings.TrimSuffix(code, "\n") + "\n} </dev/tty\n" + script
+	}
+	written := make(chan error, 1)
+	go func() {
+		_, err := io.WriteString(p.stdin, script)
+		written <- err
+	}()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case err := <-written:
+			if err != nil {
+				return fmt.Errorf("unable to write to the shell; %w", err)
+			}
+			written = nil
+		case line, ok := <-p.lines:
+			if !ok {
+				// As shexec does at the end of stdout.
+				_ = w.Close()
+				return fmt.Errorf("shell exited before sentinel %q found", p.sentinel.V)
+			}
+			before, found := strings.CutSuffix(line, p.sentinel.V)
+			if before != "" || !found {
+				if _, err := w.Write([]byte(before)); err != nil {
+					return err
+				}
+			}
+			if found {
+				return w.Close()
+			}
+		case <-timer.C:
+			return fmt.Errorf("sentinel %q not found in %s", p.sentinel.V, d)
+		}
+	}
+}
+
+// Stop implements shexec.Shell.
+func (p *ptyShell) Stop(d time.Duration, cmd string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd == nil {
+		return errNotStarted
+	}
+	if cmd != "" {
+		_, _ = io.WriteString(p.stdin, cmd+"\n")
+	}
+	_ = p.stdin.Close()
+	exited := make(chan error, 1)
+	go func() { exited <- p.cmd.Wait() }()
+	var err error
+	select {
+	case err = <-exited:
+	case <-time.After(d):
+		_ = p.cmd.Process.Kill()
+		err = fmt.Errorf("shell didn't stop in %s", d)
+		<-exited
+	}
+	p.closed()
+	return err
+}
+
+// kill kills the shell, leaving it stopped.
+func (p *ptyShell) kill() {
+	_ = p.cmd.Process.Kill()
+	_ = p.cmd.Wait()
+	p.closed()
+}
+
+// closed releases what's left of the stopped shell.
+func (p *ptyShell) closed() {
+	close(p.done)
+	p.size.detach(p.tty)
+	_ = p.tty.Close()
+	p.cmd = nil
+}
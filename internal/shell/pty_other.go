@@ -0,0 +1,39 @@
+//go:build !unix
+
+package shell
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/monopole/shexec"
+)
+
+var errNoPty = errors.New("interactive mode needs a unix pseudo-terminal")
+
+// winSize is the size of an interactive shell's terminal,
+// which, without a pseudo-terminal, is just remembered.
+type winSize struct {
+	mu         sync.Mutex
+	rows, cols int
+}
+
+func (ws *winSize) set(rows, cols int) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.rows, ws.cols = rows, cols
+	return nil
+}
+
+// ptyShell stands in for the unix shell of the same name,
+// failing to start.
+type ptyShell struct{}
+
+func newPtyShell(string, []string, shexec.Sentinel, *winSize) shexec.Shell {
+	return &ptyShell{}
+}
+
+func (*ptyShell) Start(time.Duration) error                 { return errNoPty }
+func (*ptyShell) Run(time.Duration, shexec.Commander) error { return errNoPty }
+func (*ptyShell) Stop(time.Duration, string) error          { return errNoPty }
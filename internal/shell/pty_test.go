@@ -0,0 +1,135 @@
+package shell_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	. "github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInteractive(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	for n, tc := range map[string]struct {
+		interactive bool
+		wantOut     []string
+		wantErr     []string
+	}{
+		"pipes": {wantOut: []string{"no tty"}, wantErr: []string{"err"}},
+		// The terminal merges stderr into stdout.
+		"pty": {interactive: true, wantOut: []string{"tty", "err"}},
+	} {
+		t.Run(n, func(t *testing.T) {
+			ms := NewManagedShell(shPath)
+			ms.SetInteractive(tc.interactive)
+			assert.Equal(t, tc.interactive, ms.Interactive())
+			assert.NoError(t, ms.Start(timeout))
+			defer func() { _ = ms.Stop(timeout) }()
+			res, err := ms.Execute(context.Background(),
+				"if [ -t 0 ] && [ -t 1 ] && [ -t 2 ]; then echo tty; else echo no tty; fi\n"+
+					"echo err >&2\ncd /tmp\n(exit 3)")
+			assert.NoError(t, err)
+			if assert.NotNil(t, res) {
+				assert.Equal(t, tc.wantOut, res.Stdout)
+				assert.Equal(t, tc.wantErr, res.Stderr)
+				assert.Equal(t, 3, res.ExitCode)
+			}
+			// The shell's state persists between runs.
+			cwd, err := ms.Cwd()
+			assert.NoError(t, err)
+			assert.Equal(t, "/tmp", cwd)
+		})
+	}
+}
+
+func TestInteractiveTimeout(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	ms.SetInteractive(true)
+	assert.NoError(t, ms.Start(timeout))
+	defer func() { _ = ms.Stop(timeout) }()
+	// Nobody types at the terminal, so read waits.
+	ctx, cancel := context.WithTimeout(context.Background(), timeout/3)
+	defer cancel()
+	res, err := ms.Execute(ctx, "echo waiting\nread x")
+	assert.Error(t, err)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, []string{"waiting"}, res.Stdout)
+	}
+	// The shell is dead, but restarts.
+	assert.False(t, ms.Info().Alive)
+	assert.NoError(t, ms.Restart(timeout))
+	res, err = ms.Execute(context.Background(), "echo back")
+	assert.NoError(t, err)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, []string{"back"}, res.Stdout)
+	}
+}
+
+func TestInteractiveTerminalSize(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	if _, err := exec.LookPath("tput"); err != nil {
+		t.Skip("skipping since tput not found")
+	}
+	const code = "TERM=${TERM:-xterm} tput cols; stty size"
+	ms := NewManagedShell(shPath)
+	ms.SetInteractive(true)
+	assert.NoError(t, ms.Start(timeout))
+	defer func() { _ = ms.Stop(timeout) }()
+	res, err := ms.Execute(context.Background(), code)
+	assert.NoError(t, err)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, []string{"80", "24 80"}, res.Stdout)
+	}
+
+	// A started shell is resized at once.
+	assert.NoError(t, ms.SetTerminalSize(40, 132))
+	res, err = ms.Execute(context.Background(), code)
+	assert.NoError(t, err)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, []string{"132", "40 132"}, res.Stdout)
+	}
+
+	// The size survives a restart.
+	assert.NoError(t, ms.Restart(timeout))
+	res, err = ms.Execute(context.Background(), code)
+	assert.NoError(t, err)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, []string{"132", "40 132"}, res.Stdout)
+	}
+
+	assert.Error(t, ms.SetTerminalSize(0, 80))
+	assert.Error(t, ms.SetTerminalSize(24, 1<<16))
+}
+
+func TestInteractiveTerminalSizeWithHistory(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	ms.SetInteractive(true)
+	assert.NoError(t, ms.Start(timeout))
+	defer func() { _ = ms.Stop(timeout) }()
+	h := NewHistory(ms, filepath.Join(t.TempDir(), "history"))
+
+	// Sizing goes through the history to the shell.
+	assert.NoError(t, h.SetTerminalSize(40, 132))
+	res, err := h.Execute(context.Background(), "stty size")
+	assert.NoError(t, err)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, []string{"40 132"}, res.Stdout)
+	}
+	assert.Error(t, h.SetTerminalSize(0, 80))
+
+	// An executor without a terminal has nothing to size.
+	assert.NoError(t, NewHistory(&Echo{}, h.Path()).SetTerminalSize(40, 132))
+}
@@ -0,0 +1,174 @@
+package shell
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/monopole/mdrip/v2/internal/utils"
+)
+
+// ExecuteReader is like Execute, except that rather than gathering
+// the code's output, it returns readers of the output as it arrives,
+// e.g. to copy huge output to an HTTP response, along with a function
+// that waits for the code to finish, returning its exit status.
+// The line carrying the exit status never reaches the stdout reader.
+// Both readers must be drained, or closed, for the code to finish,
+// and nothing else can run in the shell until then; output arriving
+// after a reader is closed is discarded.  As with Execute, empty
+// lines are dropped, and lines that look like binary data are
+// summarized.  If the code fails to finish, the readers end with
+// the error that wait returns.
+func (ms *ManagedShell) ExecuteReader(ctx context.Context, code string) (
+	stdout, stderr io.ReadCloser, wait func() (int, error), err error) {
+	log := utils.Logger(ctx)
+	log.Debug("executing for reading",
+		"shell", ms.path, "code", utils.Summarize([]byte(code)))
+	if err = ctx.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+	if strings.TrimSpace(code) == "" {
+		return io.NopCloser(strings.NewReader("")),
+			io.NopCloser(strings.NewReader("")),
+			func() (int, error) { return 0, nil }, nil
+	}
+	code, d, err := ms.prepare(ctx, code)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	outR, outW := io.Pipe()
+	errR, errW := io.Pipe()
+	c := &streamCommander{
+		out: &lineStreamer{
+			w: outW, binaryThreshold: ms.binaryThreshold, decode: ms.decode},
+		err: &lineStreamer{
+			w: errW, binaryThreshold: ms.binaryThreshold, decode: ms.decode},
+	}
+	if ms.dryRun {
+		c.cmd = code
+	} else {
+		c.cmd = ms.withExitStatus(code)
+		c.out.exitMarker = ms.exitMarker()
+	}
+	var (
+		exitCode int
+		runErr   error
+		done     = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		runErr = ms.run(ctx, d, c)
+		_ = c.out.Close()
+		_ = c.err.Close()
+		if runErr == nil && !ms.dryRun {
+			exitCode, runErr = c.out.exitStatus()
+		}
+		if runErr != nil {
+			log.Debug("execution failed", "err", runErr)
+		} else {
+			log.Debug("executed", "exitCode", exitCode)
+		}
+		_ = outW.CloseWithError(runErr)
+		_ = errW.CloseWithError(runErr)
+	}()
+	return outR, errR, func() (int, error) {
+		<-done
+		return exitCode, runErr
+	}, nil
+}
+
+// streamCommander is a shexec.Commander passing output to streamers.
+type streamCommander struct {
+	cmd string
+	out *lineStreamer
+	err *lineStreamer
+}
+
+func (c *streamCommander) Command() string          { return c.cmd }
+func (c *streamCommander) ParseOut() io.WriteCloser { return c.out }
+func (c *streamCommander) ParseErr() io.WriteCloser { return c.err }
+
+// lineStreamer writes the lines it's given, one per call to Write,
+// to w, restoring the newlines.  If exitMarker isn't empty, the
+// last line is held back until Close, in case it's the exit status.
+type lineStreamer struct {
+	mu              sync.Mutex
+	w               io.Writer
+	binaryThreshold float64
+	// decode converts a line to UTF-8.
+	decode     func(string) string
+	exitMarker string
+	held       *string
+	// status is the last line, if it carried the exit status.
+	status string
+	// closed means discard anything written.
+	closed bool
+}
+
+func (ls *lineStreamer) Write(data []byte) (int, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.closed || len(data) == 0 {
+		return len(data), nil
+	}
+	line := string(data)
+	if ls.decode != nil {
+		line = ls.decode(line)
+	}
+	line = SummarizeBinary(line, ls.binaryThreshold)
+	if ls.exitMarker == "" {
+		ls.emit(line)
+		return len(data), nil
+	}
+	if ls.held != nil {
+		ls.emit(*ls.held)
+	}
+	ls.held = &line
+	return len(data), nil
+}
+
+// emit writes the line, giving up on the writer if it fails,
+// e.g. because the reader was closed.
+func (ls *lineStreamer) emit(line string) {
+	if ls.w == nil {
+		return
+	}
+	if _, err := io.WriteString(ls.w, line+"\n"); err != nil {
+		ls.w = nil
+	}
+}
+
+// Close writes the held line, unless it's the exit status.
+// It's called by shexec when all the output has arrived, and
+// again once the run is over, in case shexec gave up.
+func (ls *lineStreamer) Close() error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.closed {
+		return nil
+	}
+	ls.closed = true
+	if ls.held == nil {
+		return nil
+	}
+	if strings.HasPrefix(*ls.held, ls.exitMarker) {
+		ls.status = strings.TrimPrefix(*ls.held, ls.exitMarker)
+	} else {
+		ls.emit(*ls.held)
+	}
+	ls.held = nil
+	return nil
+}
+
+// exitStatus returns the exit status found by Close.
+func (ls *lineStreamer) exitStatus() (int, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.status == "" {
+		return 0, fmt.Errorf("no exit status found in output")
+	}
+	return strconv.Atoi(ls.status)
+}
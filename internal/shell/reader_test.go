@@ -0,0 +1,163 @@
+package shell_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/stretchr/testify/assert"
+)
+
+// readAll returns the lines of both readers, read concurrently.
+func readAll(t *testing.T, stdout, stderr io.Reader) (out, errs []string) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b, err := io.ReadAll(stderr)
+		assert.NoError(t, err)
+		errs = strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	}()
+	b, err := io.ReadAll(stdout)
+	assert.NoError(t, err)
+	<-done
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n"), errs
+}
+
+func TestExecuteReader(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	assert.NoError(t, ms.Start(timeout))
+	defer func() { _ = ms.Stop(timeout) }()
+	for n, tc := range map[string]struct {
+		code     string
+		wantOut  []string
+		wantErr  []string
+		wantExit int
+	}{
+		"ok": {
+			code:    "echo hello\necho there\n",
+			wantOut: []string{"hello", "there"},
+			wantErr: []string{""},
+		},
+		"noTrailingNewline": {
+			code:    "printf abc",
+			wantOut: []string{"abc"},
+			wantErr: []string{""},
+		},
+		"both": {
+			code:     "echo out\necho oops >&2\n(exit 3)",
+			wantOut:  []string{"out"},
+			wantErr:  []string{"oops"},
+			wantExit: 3,
+		},
+		"blank": {
+			code:    " \n",
+			wantOut: []string{""},
+			wantErr: []string{""},
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			stdout, stderr, wait, err := ms.ExecuteReader(context.Background(), tc.code)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			out, errs := readAll(t, stdout, stderr)
+			code, err := wait()
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantExit, code)
+			assert.Equal(t, tc.wantOut, out)
+			assert.Equal(t, tc.wantErr, errs)
+		})
+	}
+}
+
+func TestExecuteReaderLargeOutput(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	assert.NoError(t, ms.Start(timeout))
+	defer func() { _ = ms.Stop(timeout) }()
+	const (
+		numLines = 40000
+		lineLen  = 99
+	)
+	// About 4MB, read a line at a time rather than all at once.
+	stdout, stderr, wait, err := ms.ExecuteReader(context.Background(),
+		"i=0; while [ $i -lt 4 ]; do i=$((i+1)); "+
+			"head -c 990000 /dev/zero | tr '\\000' x | fold -w 99; echo; done")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer func() { _ = stdout.Close() }()
+	go func() { _, _ = io.Copy(io.Discard, stderr) }()
+	sc := bufio.NewScanner(stdout)
+	lines, last := 0, ""
+	for sc.Scan() {
+		if len(sc.Text()) != lineLen {
+			t.Fatalf("line %d has length %d", lines, len(sc.Text()))
+		}
+		lines++
+		last = sc.Text()
+	}
+	assert.NoError(t, sc.Err())
+	code, err := wait()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, numLines, lines)
+	assert.Equal(t, strings.Repeat("x", lineLen), last,
+		"the exit status shouldn't reach the reader")
+}
+
+func TestExecuteReaderClosedEarly(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	assert.NoError(t, ms.Start(timeout))
+	defer func() { _ = ms.Stop(timeout) }()
+	stdout, stderr, wait, err := ms.ExecuteReader(context.Background(),
+		"i=0; while [ $i -lt 20000 ]; do i=$((i+1)); echo line $i; done")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "line 1\n", line)
+	// The rest is discarded.
+	assert.NoError(t, stdout.Close())
+	assert.NoError(t, stderr.Close())
+	code, err := wait()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, code)
+
+	res, err := ms.Execute(context.Background(), "echo again")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"again"}, res.Stdout)
+}
+
+func TestExecuteReaderDryRun(t *testing.T) {
+	ms := NewManagedShell(shPath)
+	ms.SetDryRun(true)
+	stdout, stderr, wait, err := ms.ExecuteReader(context.Background(), "cd /tmp\nls\n")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	out, _ := readAll(t, stdout, stderr)
+	code, err := wait()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, []string{"cd /tmp", "ls"}, out)
+}
+
+func TestExecuteReaderRejectsDesync(t *testing.T) {
+	ms := NewManagedShell(shPath)
+	_, _, _, err := ms.ExecuteReader(context.Background(), "exec 1>&-")
+	assert.ErrorIs(t, err, ErrWouldDesync)
+}
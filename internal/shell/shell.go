@@ -0,0 +1,1039 @@
+// Package shell runs code blocks, chiefly in a long-lived shell
+// subprocess, so that what one block does, e.g. a cd or an export,
+// is seen by the next.
+//
+// The Executor interface is what the rest of mdrip runs code with.
+// ManagedShell implements it over shexec.Shell, building sentinels
+// that suit the shell driven, be it bash, a POSIX shell like dash
+// (see SetPosix), or a shell in a container reached by docker or
+// podman exec.  A ManagedShell runs code in one of a few modes:
+//
+//   - by default, in the shell itself;
+//   - isolated, each block in a subshell of its own, reporting
+//     its resource usage (see SetIsolated and SetCommandWrapper);
+//   - interactive, on a pseudo-terminal, for programs that want
+//     one (see SetInteractive);
+//   - dry run, echoing the code rather than running it.
+//
+// It reports code that takes the shell down, or that leaves it
+// unusable, e.g. by timing out, until the shell is restarted.
+//
+// Other Executors wrap or stand in for a ManagedShell: History
+// records what's run to a file, Echo just echoes the code, and
+// Unavailable fails every run, for a server with no shell.
+// Helpers like Traced, WithEnv and PipedTo wrap a block's code
+// before it's run.
+package shell
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/monopole/mdrip/v2/internal/utils"
+	"github.com/monopole/shexec"
+	"github.com/monopole/shexec/channeler"
+	"golang.org/x/text/encoding"
+)
+
+const (
+	// DefaultPath is the shell used if none is specified.
+	DefaultPath = "/bin/bash"
+
+	rumple = "rumpleStiltSkin"
+
+	// writeTimeout is how long a command may run by default.
+	writeTimeout = 5 * time.Minute
+
+	// maxProbeTime and maxProbeStderr limit the time spent, and
+	// the output kept, when diagnosing a shell that won't start.
+	maxProbeTime   = 2 * time.Second
+	maxProbeStderr = 1024
+)
+
+// posixShells are the base names of shells that may lack bash
+// extensions, such that the sentinel commands must stick to
+// builtins guaranteed by POSIX.
+var posixShells = map[string]bool{
+	"sh":      true,
+	"dash":    true,
+	"ash":     true,
+	"busybox": true,
+}
+
+var errNotStarted = errors.New("shell not started")
+
+// ErrShellLost is returned for code sent to the shell after a run
+// that failed, e.g. by timing out, left the shell unusable, until
+// the shell is restarted.  Such a shell is killed at once.
+var ErrShellLost = errors.New("shell lost to an earlier failed run")
+
+// ErrAbandoned is returned for code that the shell was still taking,
+// or running, when the context was done, e.g. because the shell
+// stopped reading its stdin, and for any code sent to the shell
+// before the abandoned code finishes.
+var ErrAbandoned = errors.New("shell abandoned a run")
+
+var _ Executor = &ManagedShell{}
+
+// ManagedShell runs commands in a long-lived shell subprocess.
+// It's safe for concurrent use; commands run one at a time.
+type ManagedShell struct {
+	// mu guards sh, exited, lost and pending, and is held for the whole
+	// of a start or a run, so that commands run one at a time.
+	mu    sync.Mutex
+	path  string
+	args  []string
+	posix bool
+	// dryRun, if true, means commands are echoed rather than run,
+	// and no subprocess is started.
+	dryRun bool
+	// wrapper, if not empty, is a command prefix used to run each
+	// command in its own subshell; see SetCommandWrapper.
+	wrapper []string
+	// isolated, if true, means each command runs in its own subshell,
+	// even without a wrapper; see SetIsolated.
+	isolated bool
+	// interactive, if true, means commands run on a pseudo-terminal;
+	// see SetInteractive.
+	interactive bool
+	// size is that of an interactive shell's terminal;
+	// see SetTerminalSize.
+	size *winSize
+	// binaryThreshold determines which lines of captured output
+	// are summarized as binary data; see SetBinaryThreshold.
+	binaryThreshold float64
+	// trimOutput, if true, means blank lines are dropped from the
+	// output; see SetTrimOutput.
+	trimOutput bool
+	// encoding, if not nil, is that of the output, which is converted
+	// to UTF-8; see SetOutputEncoding.
+	encoding encoding.Encoding
+	// profile, if not empty, is run by Start; see SetProfileScript.
+	profile string
+	// marker starts the words the shell prints to delimit the output
+	// of a command.  It's random, so that output can't fake it.
+	marker string
+	// pending, if not nil, gets the error, if any, of an abandoned
+	// run once it finishes.
+	pending <-chan error
+	// exited, if not nil, is the error of the run that took the shell
+	// down; it's cleared by Start.
+	exited *exitedError
+	// lost, if not nil, is the error of a run that failed otherwise,
+	// leaving the shell unusable; it's cleared by Start.
+	lost error
+	// stats, if not nil, tracks the use of the started shell,
+	// for Info.
+	stats atomic.Pointer[shellStats]
+	sh    shexec.Shell
+}
+
+// NewManagedShell returns a shell in the off state, to be run as
+// the program at the given path with the given arguments; see
+// NewManagedShellArgs.
+func NewManagedShell(path string, args ...string) *ManagedShell {
+	return NewManagedShellArgs(append([]string{path}, args...))
+}
+
+// NewManagedShellArgs returns a shell in the off state, to be run
+// per the argv, e.g. {"bash", "--norc", "--noprofile"}, or, to run
+// the blocks in a container, {"docker", "exec", "-i", "web", "sh"}.
+// The program, argv[0], is DefaultPath if argv is empty or argv[0]
+// is.  The posix mode is automatically set if the program appears to
+// be a POSIX shell like dash, or, for docker or podman exec, if the
+// program run in the container does; otherwise, if the program just
+// launches a POSIX shell, set it with SetPosix.
+//
+// Run by docker or podman exec, the shell runs in the container,
+// whose name is checked by Start; give exec -i, since the shell
+// reads its commands from stdin, but not -t, which would merge
+// stderr into stdout.
+func NewManagedShellArgs(argv []string) *ManagedShell {
+	path := DefaultPath
+	if len(argv) > 0 && argv[0] != "" {
+		path = argv[0]
+	}
+	var args []string
+	if len(argv) > 1 {
+		args = argv[1:]
+	}
+	posix := IsPosixShell(path)
+	if ce := parseContainerExec(path, args); ce != nil {
+		posix = posixShells[filepath.Base(ce.program)]
+	}
+	return &ManagedShell{
+		path:            path,
+		args:            args,
+		posix:           posix,
+		size:            &winSize{rows: DefaultTerminalRows, cols: DefaultTerminalCols},
+		binaryThreshold: DefaultBinaryThreshold,
+		trimOutput:      true,
+		marker:          newMarker(),
+	}
+}
+
+// newMarker returns an unlikely word, different every time.
+func newMarker() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return rumple + hex.EncodeToString(b)
+}
+
+// exitMarker precedes the exit status that Execute has the shell
+// print after running code.
+func (ms *ManagedShell) exitMarker() string {
+	return ms.marker + "Exit"
+}
+
+// IsPosixShell is true if the path looks like a shell that might
+// not understand bashisms.  Symlinks are followed, so on systems
+// where /bin/sh points to dash, /bin/sh is seen as dash.
+func IsPosixShell(path string) bool {
+	if posixShells[filepath.Base(path)] {
+		return true
+	}
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		return posixShells[filepath.Base(p)]
+	}
+	return false
+}
+
+// Path is the path to the shell program.
+func (ms *ManagedShell) Path() string {
+	return ms.path
+}
+
+// Posix is true if the shell is in posix mode.
+func (ms *ManagedShell) Posix() bool {
+	return ms.posix
+}
+
+// SetPosix forces the posix mode on or off, overriding auto-detection.
+// It has no effect on a shell that's already started.
+func (ms *ManagedShell) SetPosix(b bool) {
+	ms.posix = b
+}
+
+// SetDryRun turns dry-run mode on or off.  In dry-run mode, Run
+// sends the command itself to the commander's stdout parser rather
+// than executing it, so nothing touches the system.
+func (ms *ManagedShell) SetDryRun(b bool) {
+	ms.dryRun = b
+}
+
+// DryRun is true if the shell is in dry-run mode.
+func (ms *ManagedShell) DryRun() bool {
+	return ms.dryRun
+}
+
+// SetCommandWrapper arranges for each command sent to Run to be
+// run as
+//
+//	wrapper... {shellPath} -c '{command}'
+//
+// e.g. with a wrapper of {"timeout", "30"}, or {"firejail", "--quiet",
+// "--"}, to impose a time limit or a sandbox.  The wrapper is used as
+// given; include "--" in it if the wrapper program needs one.
+//
+// Since a wrapped command runs in a new subshell, changes it makes to
+// the shell's state (cd, variables, functions) don't persist to the
+// next command.  The sentinel commands that shexec sends after each
+// command aren't wrapped, and are run by the managed shell itself, so
+// they still mark the end of the output even if the wrapper kills
+// the command.
+func (ms *ManagedShell) SetCommandWrapper(wrapper []string) {
+	ms.wrapper = wrapper
+}
+
+// SetIsolated arranges, if b is true, for each command sent to Run
+// or Execute to be run in a fresh process of the shell, started by
+// the ManagedShell rather than by the managed shell, as
+//
+//	wrapper... {shellPath} {args...} -c '{profile}\n{command}'
+//
+// where wrapper is the command wrapper, if any, and profile the code
+// that runs the profile script, if any.  It trades the persistence of
+// state across commands for isolation: e.g. a cd, or a set -e, in one
+// command doesn't affect the next.  Given docker or podman exec, the
+// command runs in a new process of the program in the container.
+// Since the process is mdrip's own, Execute reports its resource
+// usage.
+func (ms *ManagedShell) SetIsolated(b bool) {
+	ms.isolated = b
+}
+
+// Isolated is true if each command runs in its own subshell,
+// because of SetIsolated or SetCommandWrapper.
+func (ms *ManagedShell) Isolated() bool {
+	return ms.isolated || len(ms.wrapper) > 0
+}
+
+// SetInteractive arranges, if b is true, for the commands sent to
+// Run or Execute to run on a pseudo-terminal, which is their stdin,
+// stdout and stderr, for programs, e.g. ssh, top or an editor, that
+// need a terminal to behave.  Without a reader typing at it, an
+// interactive program still runs until it's done or times out.
+//
+// Since the terminal merges stderr into stdout, Execute reports all
+// output as stdout.  The shell still reads its commands from a pipe,
+// so it prints no prompts and doesn't echo the commands.  It has no
+// effect on a shell that's already started, or on an isolated
+// shell's spawned processes, and it needs a unix system; elsewhere,
+// Start fails.
+func (ms *ManagedShell) SetInteractive(b bool) {
+	ms.interactive = b
+}
+
+// Interactive is true if commands run on a pseudo-terminal.
+func (ms *ManagedShell) Interactive() bool {
+	return ms.interactive
+}
+
+// DefaultTerminalRows and DefaultTerminalCols are the size of an
+// interactive shell's terminal, unless set by SetTerminalSize.
+const (
+	DefaultTerminalRows = 24
+	DefaultTerminalCols = 80
+)
+
+// SetTerminalSize sets the size, in rows and columns, of an
+// interactive shell's terminal, e.g. to fit the reader's window,
+// so that programs like ls or tput see it.  Set on a started shell,
+// it takes effect at once, even while a command runs, giving the
+// command SIGWINCH.  It's an error unless both are positive; for
+// a shell that isn't interactive, the size is merely remembered.
+func (ms *ManagedShell) SetTerminalSize(rows, cols int) error {
+	if rows <= 0 || cols <= 0 || rows > math.MaxUint16 || cols > math.MaxUint16 {
+		return fmt.Errorf("bad terminal size %dx%d", cols, rows)
+	}
+	return ms.size.set(rows, cols)
+}
+
+// SetBinaryThreshold sets the fraction of unprintable characters
+// above which Capture replaces a line with a summary rather than
+// returning binary data.  Zero turns off the check.
+func (ms *ManagedShell) SetBinaryThreshold(f float64) {
+	ms.binaryThreshold = f
+}
+
+// SetTrimOutput arranges, if b is true, as it is by default, for
+// Execute to drop blank lines from both stdout and stderr, which is
+// tidy for display, or, if b is false, to keep them all, trailing
+// ones too, for output whose whitespace matters, e.g. a file's
+// content.  Either way, whether the last line ended in a newline
+// isn't recorded.
+func (ms *ManagedShell) SetTrimOutput(b bool) {
+	ms.trimOutput = b
+}
+
+// lines splits the output into lines, less blank ones if trimming.
+func (ms *ManagedShell) lines(s string) []string {
+	lines := splitLines(s)
+	if ms.trimOutput {
+		lines = slices.DeleteFunc(lines, func(l string) bool { return l == "" })
+	}
+	return lines
+}
+
+// wrap applies the command wrapper, if any, to the code, or,
+// if the shell is isolated, puts the code in a subshell.
+func (ms *ManagedShell) wrap(code string) string {
+	if !ms.Isolated() {
+		return code
+	}
+	args := make([]string, 0, len(ms.wrapper)+3)
+	for _, w := range ms.wrapper {
+		args = append(args, quote(w))
+	}
+	args = append(args,
+		quote(ms.subshellPath()), "-c", quote(strings.TrimSuffix(code, "\n")))
+	return strings.Join(args, " ") + "\n"
+}
+
+// subshellPath is the shell to run a wrapped command in, i.e. the
+// managed shell's own program, as the managed shell sees it.
+func (ms *ManagedShell) subshellPath() string {
+	if ce := parseContainerExec(ms.path, ms.args); ce != nil && ce.program != "" {
+		return ce.program
+	}
+	return ms.path
+}
+
+// quote single-quotes the string for the shell.
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// printCmd returns a command that prints the given word followed by
+// a newline.  In posix mode, printf is used instead of echo, since
+// the behavior of echo (flags, escapes) varies across shells.
+func (ms *ManagedShell) printCmd(word string) string {
+	if ms.posix {
+		return "printf '%s\\n' " + word
+	}
+	return "echo " + word
+}
+
+func (ms *ManagedShell) parameters() shexec.Parameters {
+	return shexec.Parameters{
+		Params: channeler.Params{Path: ms.path, Args: ms.args},
+		SentinelOut: shexec.Sentinel{
+			C: ms.printCmd(ms.marker + "Out"),
+			V: ms.marker + "Out",
+		},
+		SentinelErr: shexec.Sentinel{
+			C: ms.printCmd(ms.marker+"Err") + " 1>&2",
+			V: ms.marker + "Err",
+		},
+	}
+}
+
+// newShell returns the shell to start, in the off state.
+func (ms *ManagedShell) newShell() shexec.Shell {
+	p := ms.parameters()
+	if ms.interactive {
+		return newPtyShell(ms.path, ms.args, p.SentinelOut, ms.size)
+	}
+	return shexec.NewShell(p)
+}
+
+// Start starts the shell, waiting the given duration for
+// the sentinels to show up, and for the profile script, if any,
+// to run.
+func (ms *ManagedShell) Start(d time.Duration) error {
+	if ms.dryRun {
+		return nil
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.start(d)
+}
+
+// start is Start, for a caller holding ms.mu.
+func (ms *ManagedShell) start(d time.Duration) error {
+	ms.stats.Store(nil)
+	ms.exited, ms.lost = nil, nil
+	if err := ms.checkContainer(d); err != nil {
+		return err
+	}
+	ms.sh = ms.newShell()
+	if err := ms.sh.Start(d); err != nil {
+		ms.sh = nil
+		if msg := ms.probeStartup(d); msg != "" {
+			return fmt.Errorf("%w; shell stderr: %s", err, msg)
+		}
+		return err
+	}
+	err := ms.trapExit(d)
+	if err == nil {
+		err = ms.startStats(d)
+	}
+	if err == nil {
+		err = ms.runProfile(d)
+	}
+	if err != nil {
+		// The shell may be fine, but it isn't what the blocks expect.
+		_ = ms.sh.Stop(d, "")
+		ms.sh = nil
+		ms.stats.Store(nil)
+		return err
+	}
+	return nil
+}
+
+// Restart stops the shell, if it's running, and starts a new one,
+// waiting the given duration for each, so that code sent afterward
+// runs with a fresh environment and working directory.  A shell that
+// won't stop, e.g. one whose run was abandoned, is left behind.
+func (ms *ManagedShell) Restart(d time.Duration) error {
+	if ms.dryRun {
+		return nil
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.sh != nil {
+		if ms.pending == nil {
+			// The old shell may be broken, so stopping it may fail.
+			_ = ms.sh.Stop(d, "")
+		}
+		ms.sh, ms.pending = nil, nil
+	}
+	return ms.start(d)
+}
+
+// probeStartup briefly runs the shell on its own, returning the
+// start of what it writes to stderr.  It's used to diagnose a shell
+// that won't start, since shexec discards startup stderr (so that
+// chatter from rc files doesn't pollute the first command's stderr).
+func (ms *ManagedShell) probeStartup(d time.Duration) string {
+	ctx, cancel := context.WithTimeout(
+		context.Background(), min(d, maxProbeTime))
+	defer cancel()
+	var errOut bytes.Buffer
+	cmd := exec.CommandContext(ctx, ms.path, ms.args...)
+	cmd.Stdin = strings.NewReader("exit\n")
+	cmd.Stderr = &errOut
+	_ = cmd.Run()
+	msg := strings.TrimSpace(errOut.String())
+	if len(msg) > maxProbeStderr {
+		// Back off to the start of a rune, so as not to split one.
+		n := maxProbeStderr
+		for n > 0 && !utf8.RuneStart(msg[n]) {
+			n--
+		}
+		msg = msg[:n] + "..."
+	}
+	return msg
+}
+
+// Run runs the command held by the commander, waiting
+// at most the given duration for it to finish.
+func (ms *ManagedShell) Run(d time.Duration, c shexec.Commander) error {
+	if ms.spawned() {
+		return ms.runSpawned(d, c)
+	}
+	if ms.Isolated() {
+		c = &wrappedCommander{Commander: c, cmd: ms.wrap(c.Command())}
+	}
+	return ms.run(context.Background(), d, c)
+}
+
+// abandonGrace is how long run waits, once the context is done,
+// for shexec to report its own timeout, which comes with partial
+// output, before abandoning the run.
+const abandonGrace = 250 * time.Millisecond
+
+// run runs the commander's command as is.
+// Writing the command to the shell's stdin can block if the shell
+// isn't reading it, e.g. while an earlier command runs, so the run
+// happens in a goroutine that's abandoned if the context is done
+// first.  An abandoned run may yet write to the commander.
+// Once code takes the shell down, the run, and any after it,
+// fail with ErrShellExited.  Once a run fails otherwise, e.g. by
+// timing out, which leaves shexec's shell unusable, any run after it
+// fails with ErrShellLost.
+func (ms *ManagedShell) run(
+	ctx context.Context, d time.Duration, c shexec.Commander) error {
+	if ms.dryRun {
+		return echoCommand(c)
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.sh == nil {
+		return errNotStarted
+	}
+	if ms.exited != nil {
+		return fmt.Errorf("%w earlier, with status %d; restart the shell",
+			ErrShellExited, ms.exited.status)
+	}
+	if ms.pending != nil {
+		select {
+		case err := <-ms.pending:
+			ms.pending = nil
+			if err != nil {
+				ms.lost = err
+			}
+		default:
+			return fmt.Errorf("%w; an earlier run hasn't finished", ErrAbandoned)
+		}
+	}
+	if ms.lost != nil {
+		return fmt.Errorf("%w (%v); restart the shell", ErrShellLost, ms.lost)
+	}
+	ec := newExitCommander(c, ms.exitedMarker())
+	done := make(chan error, 1)
+	// The goroutine may outlive the lock, if the run is abandoned.
+	sh, st := ms.sh, ms.stats.Load()
+	go func() {
+		err := sh.Run(d, ec)
+		if st != nil {
+			st.ran(err)
+		}
+		if err != nil {
+			ms.reap(sh, st)
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return ms.runErr(ctx, ec, err)
+	case <-ctx.Done():
+	}
+	select {
+	case err := <-done:
+		return ms.runErr(ctx, ec, err)
+	case <-time.After(abandonGrace):
+	}
+	pending := make(chan error, 1)
+	go func() {
+		pending <- <-done
+	}()
+	ms.pending = pending
+	return fmt.Errorf("%w; %w", ErrAbandoned, ctx.Err())
+}
+
+// runErr returns the error for a finished run that failed with
+// the given error, remembering if the shell exited.  The caller
+// holds ms.mu.
+func (ms *ManagedShell) runErr(
+	ctx context.Context, ec *exitCommander, err error) error {
+	err = ec.exitError(ctx, err)
+	var exited *exitedError
+	if errors.As(err, &exited) {
+		ms.exited = exited
+		return err
+	}
+	if err != nil {
+		ms.lost = err
+	}
+	return deadlineErr(ctx, err)
+}
+
+// deadlineSlack allows for shexec's timer, which Execute sets from
+// the context's deadline, firing a little before the context's own.
+const deadlineSlack = 50 * time.Millisecond
+
+// deadlineErr marks a failed run's error as context.DeadlineExceeded
+// if it came at about the context's deadline; shexec's own timeout
+// error doesn't say so.
+func deadlineErr(ctx context.Context, err error) error {
+	if err == nil || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < deadlineSlack {
+		return fmt.Errorf("%w; %w", err, context.DeadlineExceeded)
+	}
+	return err
+}
+
+// Result is the outcome of code run by Execute.
+type Result struct {
+	Stdout []string
+	Stderr []string
+	// ExitCode is the exit status of the last command run.
+	ExitCode int
+	// Usage is the resource usage of the run, if it ran in a process
+	// of its own, as it does in an isolated shell, else nil.
+	Usage *Usage
+}
+
+// Execute runs the code, returning its output and the exit status
+// of its last command, i.e. "$?", as if the code had been typed into
+// a terminal.  A failing command isn't an error unless it takes the
+// shell down with it (e.g. if the shell was started with -e), or the
+// code runs exit, in which case the error is ErrShellExited, the
+// Result holds the output that came before, and ExitCode is the
+// shell's exit status.  The shell must then be restarted.
+// The code may run until the context's deadline, if any, else for
+// a default time.  Lines of output that look like binary data are
+// summarized.
+// In dry-run mode, the code is returned as stdout, with exit status 0.
+// Code that would desync the shell, e.g. "exec 1>&-", is rejected
+// with ErrWouldDesync, and code leaving a quote or here-document open
+// with ErrIncomplete, unless the shell is isolated, e.g. by a
+// command wrapper.
+// If the context is done before the shell reports on the code, e.g.
+// because a command isn't finishing or the shell isn't reading its
+// stdin, the run is abandoned, returning ErrAbandoned.
+// On timeout or abandonment, the Result holds the output that came
+// before, but no exit status.
+// Logs go to the context's logger, per utils.Logger.
+func (ms *ManagedShell) Execute(ctx context.Context, code string) (*Result, error) {
+	log := utils.Logger(ctx)
+	log.Debug("executing", "shell", ms.path, "code", utils.Summarize([]byte(code)))
+	res, err := ms.execute(ctx, code)
+	if err != nil {
+		log.Debug("execution failed", "err", err)
+		return res, err
+	}
+	log.Debug("executed", "exitCode", res.ExitCode)
+	return res, nil
+}
+
+func (ms *ManagedShell) execute(ctx context.Context, code string) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(code) == "" {
+		// Nothing to do, so don't bother the shell.
+		return &Result{}, nil
+	}
+	if ms.spawned() {
+		return ms.spawn(ctx, code)
+	}
+	code, d, err := ms.prepare(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	if ms.dryRun {
+		c := shexec.NewRecallCommander(code)
+		err = ms.run(ctx, d, c)
+		return &Result{Stdout: c.DataOut()}, err
+	}
+	// The run may be abandoned while it's still writing output, so
+	// the output is kept such that what's come so far can be read.
+	c := &syncCommander{cmd: ms.withExitStatus(code), keepBlank: !ms.trimOutput}
+	res, err := ms.result(c, ms.run(ctx, d, c))
+	var exited *exitedError
+	if errors.As(err, &exited) {
+		res.ExitCode = exited.status
+	}
+	return res, err
+}
+
+// outputHolder holds the output of a run.
+type outputHolder interface {
+	DataOut() []string
+	DataErr() []string
+}
+
+// result returns the output held by the commander, which ran code
+// prepared by withExitStatus, along with the code's exit status.
+// The error is that of the run, if any, in which case the output is
+// what came before the run failed, or was abandoned.
+func (ms *ManagedShell) result(c outputHolder, err error) (*Result, error) {
+	res := &Result{
+		Stdout: ms.summarize(c.DataOut()),
+		Stderr: ms.summarize(c.DataErr()),
+	}
+	if err != nil {
+		return res, err
+	}
+	n := len(res.Stdout)
+	if n == 0 || !strings.HasPrefix(res.Stdout[n-1], ms.exitMarker()) {
+		return res, fmt.Errorf("no exit status found in output")
+	}
+	res.ExitCode, err = strconv.Atoi(
+		strings.TrimPrefix(res.Stdout[n-1], ms.exitMarker()))
+	res.Stdout = res.Stdout[:n-1]
+	if n > 1 && res.Stdout[n-2] == "" {
+		// It's from the newline printed before the marker.
+		res.Stdout = res.Stdout[:n-2]
+	}
+	return res, err
+}
+
+// prepare checks the code, returning it ready to send to the shell,
+// along with how long it may run per the context.
+func (ms *ManagedShell) prepare(
+	ctx context.Context, code string) (string, time.Duration, error) {
+	d := writeTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		d = time.Until(deadline)
+	}
+	if !ms.dryRun && !ms.Isolated() {
+		if err := checkCode(code); err != nil {
+			return "", 0, err
+		}
+		if err := checkComplete(code); err != nil {
+			return "", 0, err
+		}
+	}
+	return strings.TrimSuffix(ms.wrap(code), "\n"), d, nil
+}
+
+// withExitStatus returns the code followed by a command printing
+// the exit marker and the code's exit status.
+// The empty line after the code ends the code's last command even if
+// its last line ends with a backslash, which would otherwise join the
+// printf to that command.  The newline printed before the marker
+// assures the marker starts a line, even if the code's output doesn't
+// end with a newline.  The empty line that results otherwise is
+// dropped by the commander.
+func (ms *ManagedShell) withExitStatus(code string) string {
+	return code + "\n\nprintf '\\n%s%d\\n' " + ms.exitMarker() + " \"$?\"\n"
+}
+
+// wrappedCommander replaces the command of the commander it wraps.
+type wrappedCommander struct {
+	shexec.Commander
+	cmd string
+}
+
+func (c *wrappedCommander) Command() string {
+	return c.cmd
+}
+
+// syncCommander, like shexec.RecallCommander, remembers the
+// non-empty lines of output it sees, or, given keepBlank, all of
+// them, but allows them to be read while they're still being written.
+type syncCommander struct {
+	cmd       string
+	keepBlank bool
+	mu        sync.Mutex
+	out       []string
+	err       []string
+}
+
+func (c *syncCommander) Command() string          { return c.cmd }
+func (c *syncCommander) ParseOut() io.WriteCloser { return &syncAbsorber{c: c, lines: &c.out} }
+func (c *syncCommander) ParseErr() io.WriteCloser { return &syncAbsorber{c: c, lines: &c.err} }
+
+// DataOut returns a copy of the stdout lines seen so far.
+func (c *syncCommander) DataOut() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.out)
+}
+
+// DataErr returns a copy of the stderr lines seen so far.
+func (c *syncCommander) DataErr() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.err)
+}
+
+// syncAbsorber appends the lines written to it to one of its
+// commander's streams.
+type syncAbsorber struct {
+	c     *syncCommander
+	lines *[]string
+}
+
+func (ab *syncAbsorber) Close() error { return nil }
+
+func (ab *syncAbsorber) Write(data []byte) (int, error) {
+	if len(data) > 0 || ab.c.keepBlank {
+		ab.c.mu.Lock()
+		*ab.lines = append(*ab.lines, string(data))
+		ab.c.mu.Unlock()
+	}
+	return len(data), nil
+}
+
+func (ms *ManagedShell) summarize(lines []string) []string {
+	for i := range lines {
+		lines[i] = SummarizeBinary(ms.decode(lines[i]), ms.binaryThreshold)
+	}
+	return lines
+}
+
+// Cwd returns the shell's current working directory.
+func (ms *ManagedShell) Cwd() (string, error) {
+	c := shexec.NewRecallCommander("pwd")
+	if err := ms.Run(writeTimeout, c); err != nil {
+		return "", err
+	}
+	if len(c.DataOut()) != 1 {
+		return "", fmt.Errorf("unexpected pwd output %v", c.DataOut())
+	}
+	return c.DataOut()[0], nil
+}
+
+// Stop stops the shell, waiting at most the given duration;
+// see StopContext.
+func (ms *ManagedShell) Stop(d time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return ms.StopContext(ctx)
+}
+
+// StopContext stops the shell, waiting for it to exit until the
+// context is done.  A shell that hasn't exited by then, e.g. one
+// still running a command, or one stuck in uninterruptible sleep,
+// is sent SIGKILL, and StopContext returns an error rather than
+// wait for the shell to be reaped.  A shell run by docker or podman
+// exec isn't killed, since its PID is the one in the container.
+// A run in progress, unless abandoned, is first let finish.
+func (ms *ManagedShell) StopContext(ctx context.Context) error {
+	if ms.dryRun {
+		return nil
+	}
+	ms.mu.Lock()
+	sh, lost := ms.sh, ms.lost != nil || ms.exited != nil
+	ms.mu.Unlock()
+	if sh == nil {
+		return errNotStarted
+	}
+	if lost {
+		// The failed run already reaped the shell.
+		if st := ms.stats.Load(); st != nil {
+			st.stopped()
+		}
+		return nil
+	}
+	st := ms.stats.Load()
+	if st != nil {
+		st.stopped()
+	}
+	d := writeTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		// Past the deadline, so that it's the context that's done
+		// first, if the shell won't stop.
+		d = time.Until(deadline) + abandonGrace
+	}
+	stopped := make(chan error, 1)
+	go func() { stopped <- sh.Stop(d, "") }()
+	select {
+	case err := <-stopped:
+		return err
+	case <-ctx.Done():
+	}
+	if err := ms.kill(st); err != nil {
+		return fmt.Errorf("shell didn't stop; %w; %w", ctx.Err(), err)
+	}
+	return fmt.Errorf("shell didn't stop, so was killed; %w", ctx.Err())
+}
+
+// kill sends SIGKILL to the shell with the given stats.
+func (ms *ManagedShell) kill(st *shellStats) error {
+	if parseContainerExec(ms.path, ms.args) != nil {
+		return fmt.Errorf("unable to kill a shell run by %s exec", ms.path)
+	}
+	if st == nil {
+		return errors.New("unable to kill the shell; its PID is unknown")
+	}
+	st.mu.Lock()
+	pid := st.info.PID
+	st.mu.Unlock()
+	p, err := os.FindProcess(pid)
+	if err == nil {
+		err = p.Kill()
+	}
+	if err != nil {
+		return fmt.Errorf("unable to kill the shell; %w", err)
+	}
+	return nil
+}
+
+// reap kills, and waits for, the shell with the given stats, once a
+// run fails.  shexec does neither, so the shell, e.g. one still
+// running code that timed out, would otherwise be left running, or
+// left a zombie, after shexec gives up on it.  A pty shell kills
+// itself, and a shell run by docker or podman exec can't be killed.
+func (ms *ManagedShell) reap(sh shexec.Shell, st *shellStats) {
+	if _, ok := sh.(*ptyShell); ok || st == nil ||
+		parseContainerExec(ms.path, ms.args) != nil {
+		return
+	}
+	st.mu.Lock()
+	pid := st.info.PID
+	st.mu.Unlock()
+	if pid <= 0 {
+		return
+	}
+	if p, err := os.FindProcess(pid); err == nil {
+		// It may have exited already, e.g. if the run took it down.
+		_ = p.Kill()
+		_, _ = p.Wait()
+	}
+}
+
+// Traced wraps the code so that the shell prints each command to
+// stderr, as modified by expansion, before running it.
+// The trace goes to stderr only, so it can't confuse the hunt
+// for the stdout sentinel, and the command that turns tracing
+// back off is itself hidden from the trace.
+func Traced(code string) string {
+	// Restore the code's exit status after turning tracing off.
+	const rc = rumple + "Rc"
+	return "set -x\n" + strings.TrimSuffix(code, "\n") +
+		"\n\n{ " + rc + "=$?; set +x; } 2>/dev/null; (exit $" + rc + ")\n"
+}
+
+// WithEnv wraps the code so that it runs with the environment
+// variables, each given as KEY=VALUE, exported just for its duration.
+// Afterward each variable is restored to its earlier value, or unset
+// if it had none, so the setting doesn't leak into later commands,
+// while whatever else the code does to the shell, e.g. a cd, stays.
+// A variable that was set but not exported stays exported.
+func WithEnv(env []string, code string) string {
+	if len(env) == 0 {
+		return code
+	}
+	const (
+		rc    = rumple + "Rc"
+		saved = rumple + "Env"
+	)
+	var pre, post strings.Builder
+	for i, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		old, wasSet := saved+strconv.Itoa(i), saved+"Set"+strconv.Itoa(i)
+		fmt.Fprintf(&pre, "%s=${%s+x}; %s=${%s-}; export %s=%s\n",
+			wasSet, k, old, k, k, quote(v))
+	}
+	// Restore in reverse, in case a variable is set more than once.
+	for i := len(env) - 1; i >= 0; i-- {
+		k, _, _ := strings.Cut(env[i], "=")
+		old, wasSet := saved+strconv.Itoa(i), saved+"Set"+strconv.Itoa(i)
+		fmt.Fprintf(&post,
+			" if [ -n \"$%s\" ]; then %s=$%s; else unset %s; fi; unset %s %s;",
+			wasSet, k, old, k, old, wasSet)
+	}
+	return pre.String() + strings.TrimSuffix(code, "\n") +
+		"\n\n{ " + rc + "=$?;" + post.String() + " } 2>/dev/null; (exit $" + rc + ")\n"
+}
+
+// RunOnce pipes the command held by the commander into a new
+// subprocess running the given interpreter, e.g. python3, rather
+// than into a managed shell.  The subprocess output is parsed just
+// as the managed shell's would be.  A non-zero exit is an error.
+func RunOnce(
+	d time.Duration, c shexec.Commander, interp string, args ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	var out, errOut bytes.Buffer
+	cmd := exec.CommandContext(ctx, interp, args...)
+	cmd.Stdin = strings.NewReader(c.Command())
+	cmd.Stdout = &out
+	cmd.Stderr = &errOut
+	runErr := cmd.Run()
+	if err := writeLines(c.ParseOut(), out.String()); err != nil {
+		return err
+	}
+	if err := writeLines(c.ParseErr(), errOut.String()); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s didn't finish in %s", interp, d)
+	}
+	return runErr
+}
+
+// PipedTo returns a shell command that feeds the code, via a quoted
+// here-document, to the given interpreter.  It's for interactive
+// shells (e.g. in tmux) that can't be handed a subprocess.
+func PipedTo(interp, code string) string {
+	const eof = rumple + "EOF"
+	return interp + " <<'" + eof + "'\n" +
+		strings.TrimSuffix(code, "\n") + "\n" + eof + "\n"
+}
+
+// echoCommand writes the command to the commander's
+// stdout parser, as if the shell had echoed it.
+func echoCommand(c shexec.Commander) error {
+	if err := c.ParseErr().Close(); err != nil {
+		return err
+	}
+	return writeLines(c.ParseOut(), c.Command())
+}
+
+// writeLines writes the text line by line, without newlines,
+// as shexec does, then closes the writer.
+func writeLines(w io.WriteCloser, text string) error {
+	if text != "" {
+		for _, line := range strings.Split(
+			strings.TrimSuffix(text, "\n"), "\n") {
+			if _, err := w.Write([]byte(line)); err != nil {
+				return err
+			}
+		}
+	}
+	return w.Close()
+}
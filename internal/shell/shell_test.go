@@ -0,0 +1,1046 @@
+package shell_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	. "github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/monopole/mdrip/v2/internal/utils"
+	"github.com/monopole/shexec"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	shPath  = "/bin/sh"
+	timeout = 3 * time.Second
+)
+
+func TestIsPosixShell(t *testing.T) {
+	for n, tc := range map[string]struct {
+		path string
+		want bool
+	}{
+		"bash":    {path: "/bin/bash", want: false},
+		"dash":    {path: "/bin/dash", want: true},
+		"ash":     {path: "/usr/bin/ash", want: true},
+		"zsh":     {path: "/usr/bin/zsh", want: false},
+		"relSh":   {path: "sh", want: true},
+		"busybox": {path: "/bin/busybox", want: true},
+	} {
+		t.Run(n, func(t *testing.T) {
+			assert.Equal(t, tc.want, IsPosixShell(tc.path))
+		})
+	}
+}
+
+func TestNewManagedShellDefaults(t *testing.T) {
+	ms := NewManagedShell("")
+	assert.Equal(t, DefaultPath, ms.Path())
+	assert.True(t, NewManagedShell(shPath).Posix())
+}
+
+func TestRunUnderSh(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	// Force posix mode, in case /bin/sh is a link to bash.
+	ms.SetPosix(true)
+	assert.NoError(t, ms.Start(timeout))
+	c := shexec.NewRecallCommander(`
+x=hello
+printf '%s\n' "$x"
+echo "to stderr" 1>&2
+`)
+	assert.NoError(t, ms.Run(timeout, c))
+	assert.Equal(t, []string{"hello"}, c.DataOut())
+	assert.Equal(t, []string{"to stderr"}, c.DataErr())
+
+	c = shexec.NewRecallCommander("printf '%s\\n' again")
+	assert.NoError(t, ms.Run(timeout, c))
+	assert.Equal(t, []string{"again"}, c.DataOut())
+	assert.Empty(t, c.DataErr())
+	assert.NoError(t, ms.Stop(timeout))
+}
+
+func TestRunBeforeStart(t *testing.T) {
+	ms := NewManagedShell(shPath)
+	assert.Error(t, ms.Run(timeout, shexec.NewRecallCommander("date")))
+	assert.Error(t, ms.Stop(timeout))
+}
+
+func TestDryRun(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "precious.txt")
+	assert.NoError(t, os.WriteFile(f, []byte("hey"), 0644))
+	ms := NewManagedShell("/not/a/real/shell")
+	ms.SetDryRun(true)
+	assert.NoError(t, ms.Start(timeout))
+	c := shexec.NewRecallCommander("echo deleting\nrm " + f + "\n")
+	assert.NoError(t, ms.Run(timeout, c))
+	assert.Equal(t, []string{"echo deleting", "rm " + f}, c.DataOut())
+	assert.Empty(t, c.DataErr())
+	assert.NoError(t, ms.Stop(timeout))
+	_, err := os.Stat(f)
+	assert.NoError(t, err, "file should still exist")
+}
+
+func TestTraced(t *testing.T) {
+	for _, path := range []string{shPath, DefaultPath} {
+		if _, err := os.Stat(path); err != nil {
+			t.Log("skipping since " + path + " not found")
+			continue
+		}
+		t.Run(path, func(t *testing.T) {
+			const code = "x=hello\necho \"$x\"\n"
+			ms := NewManagedShell(path)
+			assert.NoError(t, ms.Start(timeout))
+			c := shexec.NewRecallCommander(code)
+			assert.NoError(t, ms.Run(timeout, c))
+			assert.Equal(t, []string{"hello"}, c.DataOut())
+			assert.Empty(t, c.DataErr())
+
+			c = shexec.NewRecallCommander(Traced(code))
+			assert.NoError(t, ms.Run(timeout, c))
+			assert.Equal(t, []string{"hello"}, c.DataOut())
+			assert.Equal(t, []string{"+ x=hello", "+ echo hello"}, c.DataErr())
+
+			// Tracing is off again for the next command.
+			c = shexec.NewRecallCommander(code)
+			assert.NoError(t, ms.Run(timeout, c))
+			assert.Empty(t, c.DataErr())
+			assert.NoError(t, ms.Stop(timeout))
+		})
+	}
+}
+
+func TestWithEnv(t *testing.T) {
+	for _, path := range []string{shPath, DefaultPath} {
+		if _, err := os.Stat(path); err != nil {
+			t.Log("skipping since " + path + " not found")
+			continue
+		}
+		t.Run(path, func(t *testing.T) {
+			ms := NewManagedShell(path)
+			assert.NoError(t, ms.Start(timeout))
+			defer func() { _ = ms.Stop(timeout) }()
+			run := func(code string) *Result {
+				res, err := ms.Execute(context.Background(), code)
+				assert.NoError(t, err)
+				if !assert.NotNil(t, res) {
+					t.FailNow()
+				}
+				return res
+			}
+			run("KEEP=old; unset NEW")
+			res := run(WithEnv(
+				[]string{"NEW=it's new", "KEEP=x", "KEEP=y"},
+				"sh -c 'echo \"$NEW|$KEEP\"'\ncd /tmp\n(exit 3)\n"))
+			assert.Equal(t, []string{"it's new|y"}, res.Stdout)
+			assert.Empty(t, res.Stderr)
+			assert.Equal(t, 3, res.ExitCode)
+
+			res = run(`echo "${NEW-unset}|$KEEP"; pwd`)
+			assert.Equal(t, []string{"unset|old", "/tmp"}, res.Stdout)
+		})
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	py, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("skipping since python3 not found")
+	}
+	c := shexec.NewRecallCommander(`
+import sys
+print("hello from", "python")
+print("oops", file=sys.stderr)
+`)
+	assert.NoError(t, RunOnce(timeout, c, py, "-"))
+	assert.Equal(t, []string{"hello from python"}, c.DataOut())
+	assert.Equal(t, []string{"oops"}, c.DataErr())
+
+	c = shexec.NewRecallCommander("import sys; sys.exit(3)")
+	err = RunOnce(timeout, c, py, "-")
+	var exitErr *exec.ExitError
+	if assert.ErrorAs(t, err, &exitErr) {
+		assert.Equal(t, 3, exitErr.ExitCode())
+	}
+	assert.Empty(t, c.DataOut())
+}
+
+func TestPipedTo(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	assert.NoError(t, ms.Start(timeout))
+	c := shexec.NewRecallCommander(PipedTo("cat", "echo $HOME\n"))
+	assert.NoError(t, ms.Run(timeout, c))
+	assert.Equal(t, []string{"echo $HOME"}, c.DataOut())
+	assert.NoError(t, ms.Stop(timeout))
+}
+
+func TestExecuteAndCwd(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	dir := t.TempDir()
+	ms := NewManagedShell(shPath)
+	assert.NoError(t, ms.Start(timeout))
+	_, err := ms.Execute(context.Background(), "cd "+dir+"\n")
+	assert.NoError(t, err)
+	cwd, err := ms.Cwd()
+	assert.NoError(t, err)
+	want, _ := filepath.EvalSymlinks(dir)
+	if got, _ := filepath.EvalSymlinks(cwd); assert.NotEmpty(t, got) {
+		assert.Equal(t, want, got)
+	}
+	assert.NoError(t, ms.Stop(timeout))
+}
+
+func TestCommandWrapper(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	if _, err := exec.LookPath("timeout"); err != nil {
+		t.Skip("skipping since timeout not found")
+	}
+	ms := NewManagedShell(shPath)
+	ms.SetCommandWrapper([]string{"timeout", "1"})
+	assert.NoError(t, ms.Start(timeout))
+
+	c := shexec.NewRecallCommander("echo \"it's\" fine")
+	assert.NoError(t, ms.Run(timeout, c))
+	assert.Equal(t, []string{"it's fine"}, c.DataOut())
+
+	start := time.Now()
+	c = shexec.NewRecallCommander("echo early\nsleep 5\necho late\n")
+	assert.NoError(t, ms.Run(timeout, c))
+	assert.Less(t, time.Since(start), 4*time.Second)
+	assert.Equal(t, []string{"early"}, c.DataOut())
+
+	// The shell survives the timeout.
+	c = shexec.NewRecallCommander("echo again")
+	assert.NoError(t, ms.Run(timeout, c))
+	assert.Equal(t, []string{"again"}, c.DataOut())
+	assert.NoError(t, ms.Stop(timeout))
+}
+
+func TestCommandWrapperDryRun(t *testing.T) {
+	ms := NewManagedShell(shPath)
+	ms.SetDryRun(true)
+	ms.SetCommandWrapper([]string{"timeout", "30"})
+	c := shexec.NewRecallCommander("cd /tmp\n")
+	assert.NoError(t, ms.Run(timeout, c))
+	assert.Equal(t,
+		[]string{"'timeout' '30' '" + shPath + "' -c 'cd /tmp'"}, c.DataOut())
+}
+
+func TestIsolated(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	assert.False(t, ms.Isolated())
+	ms.SetIsolated(true)
+	assert.True(t, ms.Isolated())
+	assert.NoError(t, ms.Start(timeout))
+	defer func() { _ = ms.Stop(timeout) }()
+	dir, err := os.Getwd()
+	assert.NoError(t, err)
+
+	res, err := ms.Execute(context.Background(),
+		"set -e\ncd /tmp\nx=1\npwd\nfalse\necho unreached\n")
+	assert.NoError(t, err)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, []string{"/tmp"}, res.Stdout)
+		assert.Equal(t, 1, res.ExitCode)
+	}
+
+	// Nothing leaks into the next block.
+	res, err = ms.Execute(context.Background(), "pwd; echo \"${x-unset}\"; false; echo ok")
+	assert.NoError(t, err)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, []string{dir, "unset", "ok"}, res.Stdout)
+		assert.Equal(t, 0, res.ExitCode)
+	}
+}
+
+func TestIsolatedUsage(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	ms.SetIsolated(true)
+	ms.SetProfileScript("greet() { echo \"hello $1\"; }")
+	assert.NoError(t, ms.Start(timeout))
+	defer func() { _ = ms.Stop(timeout) }()
+	res, err := ms.Execute(context.Background(),
+		"i=0; while [ $i -lt 100000 ]; do i=$((i+1)); done; greet $i; exit 3")
+	assert.NoError(t, err)
+	if !assert.NotNil(t, res) {
+		return
+	}
+	assert.Equal(t, []string{"hello 100000"}, res.Stdout)
+	assert.Equal(t, 3, res.ExitCode)
+	if assert.NotNil(t, res.Usage) {
+		assert.Positive(t, res.Usage.User+res.Usage.System)
+		if runtime.GOOS == "linux" {
+			assert.Positive(t, res.Usage.MaxRssKb)
+		}
+	}
+
+	// Not so from the managed shell.
+	ms.SetIsolated(false)
+	res, err = ms.Execute(context.Background(), "echo hi")
+	assert.NoError(t, err)
+	if assert.NotNil(t, res) {
+		assert.Nil(t, res.Usage)
+	}
+}
+
+func TestIsolatedTimeout(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	ms.SetIsolated(true)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	res, err := ms.Execute(ctx, "echo started; sleep 5")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 2*time.Second)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, []string{"started"}, res.Stdout)
+	}
+}
+
+func TestTrimOutput(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	const code = "printf 'a\\n\\n  b\\n\\n'; printf 'x\\n\\ny\\n\\n' >&2"
+	for n, tc := range map[string]struct {
+		keep        bool
+		isolated    bool
+		interactive bool
+		wantOut     []string
+		wantErr     []string
+	}{
+		"trim": {
+			wantOut: []string{"a", "  b"}, wantErr: []string{"x", "y"}},
+		"keep": {
+			keep:    true,
+			wantOut: []string{"a", "", "  b", ""}, wantErr: []string{"x", "", "y", ""}},
+		"trimIsolated": {
+			isolated: true,
+			wantOut:  []string{"a", "  b"}, wantErr: []string{"x", "y"}},
+		"keepIsolated": {
+			keep: true, isolated: true,
+			wantOut: []string{"a", "", "  b", ""}, wantErr: []string{"x", "", "y", ""}},
+		"trimInteractive": {
+			interactive: true,
+			wantOut:     []string{"a", "  b", "x", "y"}},
+		"keepInteractive": {
+			keep: true, interactive: true,
+			wantOut: []string{"a", "", "  b", "", "x", "", "y", ""}},
+	} {
+		t.Run(n, func(t *testing.T) {
+			ms := NewManagedShell(shPath)
+			ms.SetTrimOutput(!tc.keep)
+			ms.SetIsolated(tc.isolated)
+			ms.SetInteractive(tc.interactive)
+			assert.NoError(t, ms.Start(timeout))
+			defer func() { _ = ms.Stop(timeout) }()
+			res, err := ms.Execute(context.Background(), code)
+			assert.NoError(t, err)
+			if assert.NotNil(t, res) {
+				assert.Equal(t, tc.wantOut, res.Stdout)
+				assert.Equal(t, tc.wantErr, res.Stderr)
+			}
+			// No output is no lines, rather than a blank one.
+			res, err = ms.Execute(context.Background(), "true")
+			assert.NoError(t, err)
+			if assert.NotNil(t, res) {
+				assert.Empty(t, res.Stdout)
+				assert.Empty(t, res.Stderr)
+			}
+		})
+	}
+}
+
+func TestExecuteBinary(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	const code = `printf 'text\n\000\001\002\003\n'`
+	ms := NewManagedShell(shPath)
+	assert.NoError(t, ms.Start(timeout))
+	res, err := ms.Execute(context.Background(), code)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"text", "<binary data: 4 bytes>"}, res.Stdout)
+
+	ms.SetBinaryThreshold(0)
+	res, err = ms.Execute(context.Background(), code)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"text", "\x00\x01\x02\x03"}, res.Stdout)
+	assert.NoError(t, ms.Stop(timeout))
+}
+
+func TestStartFailureIncludesStderr(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath, "-c", "echo 'bad rc file on line 3' 1>&2; exit 3")
+	err := ms.Start(500 * time.Millisecond)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "bad rc file on line 3")
+	}
+	assert.Error(t, ms.Run(timeout, shexec.NewRecallCommander("date")))
+}
+
+func TestStartFailureStderrTruncated(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	// The 1024 byte limit falls in the middle of the first "é".
+	long := strings.Repeat("a", 1023)
+	ms := NewManagedShell(shPath, "-c", "echo '"+long+"éé' 1>&2; exit 3")
+	err := ms.Start(500 * time.Millisecond)
+	if assert.Error(t, err) {
+		assert.True(t, utf8.ValidString(err.Error()))
+		assert.True(t, strings.HasSuffix(err.Error(), "shell stderr: "+long+"..."),
+			err.Error())
+	}
+}
+
+func TestNewManagedShellArgs(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("skipping since bash not found")
+	}
+	env, err := exec.LookPath("env")
+	if err != nil {
+		t.Skip("skipping since env not found")
+	}
+	// Interactive bash reads $HOME/.bashrc, unless told not to.
+	home := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(home, ".bashrc"),
+		[]byte("alias hi='echo from rc'\nexport FROM_RC=yes\n"), 0o644))
+	for n, tc := range map[string]struct {
+		args []string
+		want []string
+	}{
+		"rc":   {args: []string{"-i"}, want: []string{"yes", "aliased"}},
+		"norc": {args: []string{"--norc", "--noprofile", "-i"}, want: []string{"none", "none"}},
+	} {
+		t.Run(n, func(t *testing.T) {
+			ms := NewManagedShellArgs(
+				append([]string{env, "HOME=" + home, bash}, tc.args...))
+			assert.Equal(t, env, ms.Path())
+			assert.NoError(t, ms.Start(timeout))
+			defer func() { _ = ms.Stop(timeout) }()
+			res, err := ms.Execute(context.Background(),
+				"echo ${FROM_RC:-none}\n"+
+					"if alias hi >/dev/null 2>&1; then echo aliased; else echo none; fi")
+			assert.NoError(t, err)
+			if assert.NotNil(t, res) {
+				assert.Equal(t, tc.want, res.Stdout)
+			}
+		})
+	}
+
+	// With no argv, the default shell is used.
+	assert.Equal(t, DefaultPath, NewManagedShellArgs(nil).Path())
+}
+
+// The sentinel hunt is done by shexec, which scans the shell's
+// output line by line and compares whole lines to the sentinels,
+// so cost should grow linearly with output size.
+func BenchmarkRunLargeOutput(b *testing.B) {
+	if _, err := os.Stat(shPath); err != nil {
+		b.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	if err := ms.Start(timeout); err != nil {
+		b.Fatal(err)
+	}
+	defer func() { _ = ms.Stop(timeout) }()
+	for _, lines := range []int{1000, 10000, 100000} {
+		b.Run(strconv.Itoa(lines), func(b *testing.B) {
+			cmd := "i=0; while [ $i -lt " + strconv.Itoa(lines) + " ]; do " +
+				"echo \"line $i of some reasonably long output\"; i=$((i+1)); done"
+			for b.Loop() {
+				c := shexec.NewRecallCommander(cmd)
+				if err := ms.Run(time.Minute, c); err != nil {
+					b.Fatal(err)
+				}
+				if len(c.DataOut()) != lines {
+					b.Fatalf("got %d lines, want %d", len(c.DataOut()), lines)
+				}
+			}
+		})
+	}
+}
+
+func TestExecute(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	assert.NoError(t, ms.Start(timeout))
+	for n, tc := range map[string]struct {
+		code string
+		want Result
+	}{
+		"ok": {
+			code: "echo hello\n",
+			want: Result{Stdout: []string{"hello"}},
+		},
+		"noTrailingNewline": {
+			code: "printf 'no newline'",
+			want: Result{Stdout: []string{"no newline"}},
+		},
+		"lastCommandFails": {
+			code: "echo before\nls /nonexistent 2>/dev/null",
+			want: Result{Stdout: []string{"before"}, ExitCode: 2},
+		},
+		"earlierFailureIgnored": {
+			code: "false\necho after",
+			want: Result{Stdout: []string{"after"}},
+		},
+		"explicitStatus": {
+			code: "echo oops 1>&2\n(exit 7)",
+			want: Result{Stderr: []string{"oops"}, ExitCode: 7},
+		},
+		"tracedStatus": {
+			code: Traced("(exit 4)"),
+			want: Result{Stderr: []string{"+ exit 4"}, ExitCode: 4},
+		},
+		"trailingBackslash": {
+			code: "echo a \\",
+			want: Result{Stdout: []string{"a"}},
+		},
+		"continuedLines": {
+			code: "echo a \\\n  b \\\n  c",
+			want: Result{Stdout: []string{"a b c"}},
+		},
+		"heredoc": {
+			code: "cat <<EOF\nhello\n  there\nEOF\necho after",
+			want: Result{Stdout: []string{"hello", "  there", "after"}},
+		},
+		"heredocStripTabs": {
+			code: "cat <<-END\n\thello\n\tEND",
+			want: Result{Stdout: []string{"hello"}},
+		},
+		"heredocQuoted": {
+			code: "x=no\ncat <<'EOF'\n$x\nEOF",
+			want: Result{Stdout: []string{"$x"}},
+		},
+		"multiLineQuote": {
+			code: "echo 'a\nb'",
+			want: Result{Stdout: []string{"a", "b"}},
+		},
+		"shiftNotHeredoc": {
+			code: "echo $((1<<2))",
+			want: Result{Stdout: []string{"4"}},
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			res, err := ms.Execute(ctx, tc.code)
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, tc.want.Stdout, res.Stdout)
+			assert.ElementsMatch(t, tc.want.Stderr, res.Stderr)
+			assert.Equal(t, tc.want.ExitCode, res.ExitCode)
+		})
+	}
+	assert.NoError(t, ms.Stop(timeout))
+}
+
+func TestExecuteEmpty(t *testing.T) {
+	// The shell isn't started, so any attempt to use it would fail.
+	ms := NewManagedShell(shPath)
+	for n, code := range map[string]string{
+		"empty":      "",
+		"newline":    "\n",
+		"whitespace": " \t\n  \r\n",
+	} {
+		t.Run(n, func(t *testing.T) {
+			res, err := ms.Execute(context.Background(), code)
+			assert.NoError(t, err)
+			assert.Equal(t, &Result{}, res)
+		})
+	}
+}
+
+func TestExecuteRejectsDesync(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	assert.NoError(t, ms.Start(timeout))
+	for n, tc := range map[string]struct {
+		code string
+		bad  bool
+		want []string
+	}{
+		"closeStdout":       {code: "exec 1>&-", bad: true},
+		"closeStdoutNoFd":   {code: "echo hi\nexec >&-", bad: true},
+		"stdoutToFile":      {code: "exec > /dev/null", bad: true},
+		"stderrToStdout":    {code: "exec 2>&1", bad: true},
+		"stdin":             {code: "exec </dev/null", bad: true},
+		"both":              {code: "exec &>/dev/null", bad: true},
+		"replaceShell":      {code: "echo hi; exec sleep 1", bad: true},
+		"inBraces":          {code: "{ exec >&-; }", bad: true},
+		"afterThen":         {code: "if true; then exec 1>&-; fi", bad: true},
+		"otherFd":           {code: "exec 3>/dev/null\necho ok", want: []string{"ok"}},
+		"subshell":          {code: "(exec 1>&-)\necho ok", want: []string{"ok"}},
+		"notACommand":       {code: "echo exec >&-\necho ok", want: []string{"ok"}},
+		"comment":           {code: "echo ok # exec 1>&-", want: []string{"ok"}},
+		"redirectOneCmd":    {code: "echo gone >&-\necho ok", want: []string{"ok"}},
+		"commandSubstitute": {code: "echo $(exec echo ok)", want: []string{"ok"}},
+	} {
+		t.Run(n, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			res, err := ms.Execute(ctx, tc.code)
+			if tc.bad {
+				assert.ErrorIs(t, err, ErrWouldDesync)
+				assert.Nil(t, res)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tc.want, res.Stdout)
+			}
+		})
+	}
+	// The shell is still in sync.
+	res, err := ms.Execute(context.Background(), "echo still here")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"still here"}, res.Stdout)
+	}
+	assert.NoError(t, ms.Stop(timeout))
+}
+
+func TestExecuteHeredocToFile(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	assert.NoError(t, ms.Start(timeout))
+	const doc = "name: demo\nvalue: $HOME\n  indented\n"
+	for n, tc := range map[string]struct {
+		// code writes doc to the file named by F.
+		code string
+		// traced means wrap the code with Traced.
+		traced bool
+	}{
+		"terminatorLast": {
+			code: "cat <<'EOF' >\"$F\"\n" + doc + "EOF",
+		},
+		"terminatorThenNewline": {
+			code: "cat <<'EOF' >\"$F\"\n" + doc + "EOF\n",
+		},
+		"commandAfter": {
+			code: "cat <<'EOF' >\"$F\"\n" + doc + "EOF\necho done >/dev/null",
+		},
+		"stripTabs": {
+			code: "cat <<-'EOF' >\"$F\"\n\t" +
+				strings.ReplaceAll(strings.TrimSuffix(doc, "\n"), "\n", "\n\t") +
+				"\n\tEOF",
+		},
+		"traced": {
+			code:   "cat <<'EOF' >\"$F\"\n" + doc + "EOF",
+			traced: true,
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			f := filepath.Join(t.TempDir(), "out.yaml")
+			code := "F=" + f + "\n" + tc.code
+			if tc.traced {
+				code = Traced(code)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			res, err := ms.Execute(ctx, code)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, 0, res.ExitCode)
+			assert.Empty(t, res.Stdout)
+			got, err := os.ReadFile(f)
+			assert.NoError(t, err)
+			assert.Equal(t, doc, string(got))
+		})
+	}
+	assert.NoError(t, ms.Stop(timeout))
+}
+
+func TestExecuteRejectsIncomplete(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	assert.NoError(t, ms.Start(timeout))
+	for n, code := range map[string]string{
+		"singleQuote":     "echo 'hello",
+		"doubleQuote":     "echo \"hello\necho there",
+		"backtick":        "echo `date",
+		"heredoc":         "cat <<EOF\nhello",
+		"heredocIndented": "cat <<EOF\nhello\n  EOF",
+		"secondHeredoc":   "cat <<A <<B\na\nA\nb",
+	} {
+		t.Run(n, func(t *testing.T) {
+			res, err := ms.Execute(context.Background(), code)
+			assert.ErrorIs(t, err, ErrIncomplete)
+			assert.Nil(t, res)
+		})
+	}
+	// The shell is still in sync.
+	res, err := ms.Execute(context.Background(), "echo still here")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"still here"}, res.Stdout)
+	}
+	assert.NoError(t, ms.Stop(timeout))
+}
+
+func TestExecuteOutputLikeMarkers(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	assert.NoError(t, ms.Start(timeout))
+	// The words that once delimited output are now just words.
+	code := "echo rumpleStiltSkinOut\necho rumpleStiltSkinErr 1>&2\n" +
+		"echo rumpleStiltSkinExit9\n(exit 3)"
+	res, err := ms.Execute(context.Background(), code)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"rumpleStiltSkinOut", "rumpleStiltSkinExit9"}, res.Stdout)
+		assert.Equal(t, []string{"rumpleStiltSkinErr"}, res.Stderr)
+		assert.Equal(t, 3, res.ExitCode)
+	}
+	res, err = ms.Execute(context.Background(), "echo next")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"next"}, res.Stdout)
+	}
+	assert.NoError(t, ms.Stop(timeout))
+}
+
+func TestExecuteDryRunSkipsDesyncCheck(t *testing.T) {
+	ms := NewManagedShell("/not/a/real/shell")
+	ms.SetDryRun(true)
+	assert.NoError(t, ms.Start(timeout))
+	res, err := ms.Execute(context.Background(), "exec 1>&-")
+	assert.NoError(t, err, "dry runs don't run anything")
+	assert.Equal(t, []string{"exec 1>&-"}, res.Stdout)
+}
+
+func TestExecuteTimeout(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	assert.NoError(t, ms.Start(timeout))
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	res, err := ms.Execute(ctx, "echo early\necho oops >&2\nsleep 3\necho late\n")
+	assert.Less(t, time.Since(start), 2*time.Second)
+	assert.Error(t, err)
+	// Output from before the deadline is kept, on both streams.
+	if assert.NotNil(t, res) {
+		assert.Equal(t, []string{"early"}, res.Stdout)
+		assert.Equal(t, []string{"oops"}, res.Stderr)
+	}
+
+	// The timeout leaves the shell unusable, so it's killed, and
+	// code sent to it fails until it's restarted.
+	pid := ms.Info().PID
+	assert.False(t, ms.Info().Alive)
+	_, err = ms.Execute(context.Background(), "echo again")
+	assert.ErrorIs(t, err, ErrShellLost)
+	assert.ErrorContains(t, err, "restart the shell")
+	assert.NotErrorIs(t, err, context.DeadlineExceeded)
+	if p, err := os.FindProcess(pid); assert.NoError(t, err) {
+		assert.Error(t, p.Signal(syscall.Signal(0)), "shell still running")
+	}
+
+	// The cut-off output doesn't leak into the next result.
+	assert.NoError(t, ms.Restart(timeout))
+	res, err = ms.Execute(context.Background(), "echo again")
+	assert.NoError(t, err)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, []string{"again"}, res.Stdout)
+	}
+	assert.NoError(t, ms.Stop(timeout))
+
+	// A lost shell, already killed, stops without fuss.
+	assert.NoError(t, ms.Start(timeout))
+	ctx, cancel = context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, err = ms.Execute(ctx, "sleep 3")
+	assert.Error(t, err)
+	assert.NoError(t, ms.Stop(timeout))
+}
+
+func TestExecuteAbandonsBlockedWrite(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	assert.NoError(t, ms.Start(timeout))
+	// While sleep runs, the shell doesn't read its stdin, so writing
+	// more code than the pipe holds blocks.  The context has no
+	// deadline, so only its cancellation can end the wait.
+	code := "sleep 2\n" + strings.Repeat(": "+strings.Repeat("x", 100)+"\n", 3000)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(300*time.Millisecond, cancel)
+	start := time.Now()
+	res, err := ms.Execute(ctx, code)
+	assert.Less(t, time.Since(start), 2*time.Second)
+	assert.ErrorIs(t, err, ErrAbandoned)
+	assert.ErrorIs(t, err, context.Canceled)
+	if assert.NotNil(t, res) {
+		assert.Empty(t, res.Stdout)
+	}
+
+	// Until the abandoned run finishes, others fail at once.
+	start = time.Now()
+	_, err = ms.Execute(context.Background(), "echo again")
+	assert.ErrorIs(t, err, ErrAbandoned)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// Then the shell is usable again.
+	assert.Eventually(t, func() bool {
+		res, err = ms.Execute(context.Background(), "echo again")
+		return err == nil
+	}, 10*time.Second, 100*time.Millisecond)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, []string{"again"}, res.Stdout)
+	}
+	assert.NoError(t, ms.Stop(timeout))
+}
+
+func TestExecuteAbandonedKeepsOutput(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	assert.NoError(t, ms.Start(timeout))
+	defer func() { _ = ms.Stop(timeout) }()
+	// Without a deadline, shexec doesn't time the run out, so
+	// cancellation abandons it.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(300*time.Millisecond, cancel)
+	res, err := ms.Execute(ctx, "echo early\necho oops >&2\nsleep 1\necho late\n")
+	assert.ErrorIs(t, err, ErrAbandoned)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, []string{"early"}, res.Stdout)
+		assert.Equal(t, []string{"oops"}, res.Stderr)
+	}
+	// The late output, from the abandoned run, doesn't leak into
+	// the next result.
+	assert.Eventually(t, func() bool {
+		res, err = ms.Execute(context.Background(), "echo again")
+		return err == nil
+	}, 10*time.Second, 100*time.Millisecond)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, []string{"again"}, res.Stdout)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	assert.NoError(t, ms.Start(timeout))
+	defer func() { _ = ms.Stop(timeout) }()
+	_, err := ms.Execute(context.Background(), "export GREETING=hello\ncd /\n")
+	assert.NoError(t, err)
+
+	assert.NoError(t, ms.Restart(timeout))
+	res, err := ms.Execute(context.Background(), "echo ${GREETING:-gone}; pwd")
+	assert.NoError(t, err)
+	if assert.NotNil(t, res) && assert.Equal(t, 2, len(res.Stdout)) {
+		assert.Equal(t, "gone", res.Stdout[0])
+		assert.NotEqual(t, "/", res.Stdout[1])
+	}
+
+	// A shell that was never started is simply started.
+	ms2 := NewManagedShell(shPath)
+	assert.NoError(t, ms2.Restart(timeout))
+	defer func() { _ = ms2.Stop(timeout) }()
+	res, err = ms2.Execute(context.Background(), "echo hi")
+	assert.NoError(t, err)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, []string{"hi"}, res.Stdout)
+	}
+}
+
+func TestStopContext(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	assert.NoError(t, ms.Start(timeout))
+	assert.NoError(t, ms.StopContext(context.Background()))
+
+	// A shell busy with an abandoned run won't stop, so is killed.
+	assert.NoError(t, ms.Start(timeout))
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	_, err := ms.Execute(ctx, "sleep 5")
+	assert.ErrorIs(t, err, ErrAbandoned)
+	ctx, cancel = context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = ms.StopContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "killed")
+	assert.Less(t, time.Since(start), 2*time.Second)
+	assert.False(t, ms.Info().Alive)
+}
+
+// TestStopContextDuringRestart is meant for -race.
+func TestStopContextDuringRestart(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	assert.NoError(t, ms.Start(timeout))
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 3; i++ {
+			_ = ms.Restart(timeout)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 3; i++ {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			_ = ms.StopContext(ctx)
+			cancel()
+		}
+	}()
+	wg.Wait()
+	_ = ms.Stop(timeout)
+}
+
+// TestExecuteDuringStart is meant for -race.
+func TestExecuteDuringStart(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := NewManagedShell(shPath)
+	assert.NoError(t, ms.Start(timeout))
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 3; i++ {
+			_ = ms.Stop(timeout)
+			assert.NoError(t, ms.Start(timeout))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 3; i++ {
+			_, _ = ms.Execute(context.Background(), "echo hi")
+		}
+	}()
+	wg.Wait()
+	_ = ms.Stop(timeout)
+}
+
+func TestExecuteCancelled(t *testing.T) {
+	ms := NewManagedShell(shPath)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := ms.Execute(ctx, "echo hi")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestEcho(t *testing.T) {
+	var buf strings.Builder
+	res, err := (&Echo{W: &buf}).Execute(context.Background(), "cd /tmp\nls\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cd /tmp", "ls"}, res.Stdout)
+	assert.Equal(t, 0, res.ExitCode)
+	assert.Equal(t, "cd /tmp\nls\n", buf.String())
+
+	res, err = (&Echo{}).Execute(context.Background(), "date")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"date"}, res.Stdout)
+}
+
+func TestUnavailable(t *testing.T) {
+	reason := errors.New("no shell")
+	u := NewUnavailable(reason)
+	_, err := u.Execute(context.Background(), "date")
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.ErrorIs(t, err, reason)
+	_, err = u.Cwd()
+	assert.ErrorIs(t, err, ErrUnavailable)
+	_, err = NewUnavailable(nil).Execute(context.Background(), "date")
+	assert.Equal(t, ErrUnavailable, err)
+}
+
+func TestExecuteLogsToContextLogger(t *testing.T) {
+	var buf bytes.Buffer
+	ctx := utils.WithLogger(context.Background(), slog.New(
+		slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})).
+		With("requestId", "req-42"))
+	ms := NewManagedShell("/not/a/real/shell")
+	ms.SetDryRun(true)
+	assert.NoError(t, ms.Start(timeout))
+	_, err := ms.Execute(ctx, "echo hi\n")
+	assert.NoError(t, err)
+	assert.NoError(t, ms.Stop(timeout))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if assert.Len(t, lines, 2) {
+		for _, line := range lines {
+			assert.Contains(t, line, "requestId=req-42")
+		}
+		assert.Contains(t, lines[0], `code="echo hi"`)
+		assert.Contains(t, lines[1], "exitCode=0")
+	}
+}
+
+func TestResolveTimeout(t *testing.T) {
+	const o, l, d = time.Second, 2 * time.Second, 3 * time.Second
+	for n, tc := range map[string]struct {
+		override, label, dflt time.Duration
+		want                  time.Duration
+	}{
+		"none":           {},
+		"dflt":           {dflt: d, want: d},
+		"labelBeatsDflt": {label: l, dflt: d, want: l},
+		"overrideWins":   {override: o, label: l, dflt: d, want: o},
+		"overrideAlone":  {override: o, want: o},
+		"negativeIgnored": {
+			override: -o, label: -l, dflt: d, want: d},
+	} {
+		t.Run(n, func(t *testing.T) {
+			assert.Equal(t, tc.want, ResolveTimeout(tc.override, tc.label, tc.dflt))
+		})
+	}
+}
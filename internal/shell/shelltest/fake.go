@@ -0,0 +1,97 @@
+// Package shelltest provides a fake shell.Executor, so that code
+// that runs code blocks can be tested without a real shell.
+package shelltest
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/monopole/mdrip/v2/internal/shell"
+)
+
+// FakeExecutor is a shell.Executor returning canned results.
+// Rules are checked in the order added; the first rule matching
+// the code decides the result.  Code matching no rule gets an
+// empty result.  It's safe for concurrent use.
+type FakeExecutor struct {
+	mu    sync.Mutex
+	rules []rule
+	calls []string
+}
+
+type rule struct {
+	// code, if pattern is nil, must equal the code run,
+	// ignoring surrounding whitespace.
+	code    string
+	pattern *regexp.Regexp
+	res     shell.Result
+	err     error
+}
+
+func (r *rule) matches(code string) bool {
+	if r.pattern != nil {
+		return r.pattern.MatchString(code)
+	}
+	return r.code == strings.TrimSpace(code)
+}
+
+var _ shell.Executor = &FakeExecutor{}
+
+// NewFakeExecutor returns a FakeExecutor with no rules.
+func NewFakeExecutor() *FakeExecutor {
+	return &FakeExecutor{}
+}
+
+// On adds a rule returning the result for the given code, compared
+// to the code run after trimming surrounding whitespace from both.
+func (f *FakeExecutor) On(code string, res shell.Result) *FakeExecutor {
+	return f.add(rule{code: strings.TrimSpace(code), res: res})
+}
+
+// OnMatch adds a rule returning the result for code matching
+// the regular expression.  It panics if the expression is bad.
+func (f *FakeExecutor) OnMatch(pattern string, res shell.Result) *FakeExecutor {
+	return f.add(rule{pattern: regexp.MustCompile(pattern), res: res})
+}
+
+// FailOn adds a rule returning the error, and the partial result,
+// for code matching the regular expression.
+func (f *FakeExecutor) FailOn(
+	pattern string, res shell.Result, err error) *FakeExecutor {
+	return f.add(rule{pattern: regexp.MustCompile(pattern), res: res, err: err})
+}
+
+func (f *FakeExecutor) add(r rule) *FakeExecutor {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, r)
+	return f
+}
+
+// Calls returns the code passed to Execute, in order.
+func (f *FakeExecutor) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+// Execute implements shell.Executor.
+func (f *FakeExecutor) Execute(ctx context.Context, code string) (*shell.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, code)
+	for i := range f.rules {
+		if r := &f.rules[i]; r.matches(code) {
+			res := r.res
+			res.Stdout = append([]string(nil), r.res.Stdout...)
+			res.Stderr = append([]string(nil), r.res.Stderr...)
+			return &res, r.err
+		}
+	}
+	return &shell.Result{}, nil
+}
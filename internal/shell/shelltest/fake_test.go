@@ -0,0 +1,60 @@
+package shelltest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/monopole/mdrip/v2/internal/shell"
+	. "github.com/monopole/mdrip/v2/internal/shell/shelltest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeExecutor(t *testing.T) {
+	oops := errors.New("oops")
+	f := NewFakeExecutor().
+		On("echo hi", shell.Result{Stdout: []string{"hi"}}).
+		OnMatch(`^ls\b`, shell.Result{Stdout: []string{"a.md"}}).
+		FailOn(`sleep`, shell.Result{Stdout: []string{"early"}}, oops).
+		OnMatch(`.*`, shell.Result{Stderr: []string{"nope"}, ExitCode: 127})
+	for n, tc := range map[string]struct {
+		code string
+		want shell.Result
+		err  error
+	}{
+		"exact": {
+			code: "echo hi\n",
+			want: shell.Result{Stdout: []string{"hi"}}},
+		"pattern": {
+			code: "ls -l",
+			want: shell.Result{Stdout: []string{"a.md"}}},
+		"error": {
+			code: "echo early; sleep 99",
+			want: shell.Result{Stdout: []string{"early"}}, err: oops},
+		"fallThrough": {
+			code: "bogus",
+			want: shell.Result{Stderr: []string{"nope"}, ExitCode: 127}},
+	} {
+		t.Run(n, func(t *testing.T) {
+			res, err := f.Execute(context.Background(), tc.code)
+			assert.Equal(t, tc.err, err)
+			assert.ElementsMatch(t, tc.want.Stdout, res.Stdout)
+			assert.ElementsMatch(t, tc.want.Stderr, res.Stderr)
+			assert.Equal(t, tc.want.ExitCode, res.ExitCode)
+		})
+	}
+	assert.Len(t, f.Calls(), 4)
+}
+
+func TestFakeExecutorNoRules(t *testing.T) {
+	f := NewFakeExecutor()
+	res, err := f.Execute(context.Background(), "date")
+	assert.NoError(t, err)
+	assert.Empty(t, res.Stdout)
+	assert.Equal(t, []string{"date"}, f.Calls())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = f.Execute(ctx, "date")
+	assert.ErrorIs(t, err, context.Canceled)
+}
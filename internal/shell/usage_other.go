@@ -0,0 +1,10 @@
+//go:build !unix
+
+package shell
+
+import "os"
+
+// maxRssKb returns zero, since the platform can't say.
+func maxRssKb(_ *os.ProcessState) int64 {
+	return 0
+}
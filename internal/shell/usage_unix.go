@@ -0,0 +1,22 @@
+//go:build unix
+
+package shell
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// maxRssKb returns the process's peak resident set size in kilobytes.
+func maxRssKb(ps *os.ProcessState) int64 {
+	ru, ok := ps.SysUsage().(*syscall.Rusage)
+	if !ok || ru == nil {
+		return 0
+	}
+	if runtime.GOOS == "darwin" || runtime.GOOS == "ios" {
+		// Bytes, rather than kilobytes.
+		return int64(ru.Maxrss) / 1024
+	}
+	return int64(ru.Maxrss)
+}
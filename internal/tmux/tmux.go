@@ -1,6 +1,7 @@
 package tmux
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
@@ -8,6 +9,7 @@ import (
 	"os/exec"
 	"strings"
 
+	"github.com/monopole/mdrip/v2/internal/shell"
 	"github.com/monopole/mdrip/v2/internal/utils"
 )
 
@@ -17,7 +19,10 @@ type Tmux struct {
 	paneID string
 }
 
-var _ io.Writer = &Tmux{}
+var (
+	_ io.Writer      = &Tmux{}
+	_ shell.Executor = &Tmux{}
+)
 
 const (
 	// PgmName is the name of the tmux executable.
@@ -95,6 +100,31 @@ func (tx Tmux) Write(bytes []byte) (n int, err error) {
 	return len(bytes), nil
 }
 
+// Execute pastes the code into the tmux pane.  The code's output
+// goes to the pane, so the result is empty.
+func (tx Tmux) Execute(ctx context.Context, code string) (*shell.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Write([]byte(code)); err != nil {
+		return nil, err
+	}
+	return &shell.Result{}, nil
+}
+
+// Cwd returns the current working directory of the shell
+// running in the pane that Write pastes to.
+func (tx Tmux) Cwd() (string, error) {
+	cmd := exec.Command(
+		tx.path, "display-message", "-p", "-t", tx.paneID,
+		"#{pane_current_path}")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("unable to get tmux pane path; %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
 func (tx Tmux) Start() error {
 	cmd := exec.Command(tx.path, "new-session", "-s", SessionName, "-d")
 	out, err := cmd.Output()
@@ -4,7 +4,6 @@ import (
 	_ "embed"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/common"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/mdrip"
-	"github.com/monopole/mdrip/v2/internal/web/config"
 )
 
 const (
@@ -13,21 +12,28 @@ const (
 	MimeJs  = "application/javascript"
 	MimeCss = "text/css"
 
-	// classlessCss = "https://cdn.jsdelivr.net/npm/water.css@2/out/dark.css"
-	// classlessCss = "https://raw.githubusercontent.com/raj457036/attriCSS/master/themes/darkforest-green.css"
-	//
-	//   To load some "classless" css, throw in a line like
-	//     <link rel="stylesheet" href="` + classlessCss + `">
-	//   There are many classless css examples at
-	//      https://github.com/dbohdan/classless-css
-	//   Most of them mess with <body> and <pre>, screwing up mdrip's layout,
-	//   but maybe copy a subset.
+	// ContainerClass is the class of the element holding the whole app.
+	// mdrip's element rules are scoped under it, so that extra
+	// "classless" css, e.g.
+	//   https://cdn.jsdelivr.net/npm/water.css@2/out/dark.css
+	// (more at https://github.com/dbohdan/classless-css) can restyle
+	// <body> and <pre> without screwing up mdrip's layout.
+	ContainerClass = "mdripApp"
 )
 
+// TmplParams are the params of the web app template.
+type TmplParams struct {
+	*mdrip.TmplParams
+	// Theme is one of the config.Theme* values.
+	Theme string
+	// ExtraCss are the URLs of stylesheets to load after mdrip's own.
+	ExtraCss []string
+}
+
 var (
 	// Don't forget to set the content-type header if you use this.
 	cssViaLink = `<link rel='stylesheet' type='` + MimeCss +
-		`' href='` + config.Dynamic(config.RouteCss) + `' />`
+		`' href='{{.PathCss}}' />`
 
 	// Use this instead of cssViaLink to inject directly into the html response.
 	cssInjected = `<style> ` + mdrip.AllCss + ` </style>`
@@ -36,11 +42,12 @@ var (
 var (
 	html = `
 <!DOCTYPE html>
-<html lang="en">
+<html lang="en" data-theme='{{.Theme}}'>
   <head>
     <title>{{.AppState.Title}}</title>
-    ` + cssViaLink + `
-    <script type='` + MimeJs + `' src='` + config.Dynamic(config.RouteJs) + `'></script>
+    ` + cssViaLink + `{{range .ExtraCss}}
+    <link rel='stylesheet' type='` + MimeCss + `' href='{{.}}' />{{end}}
+    <script type='` + MimeJs + `' src='{{.PathJs}}'></script>
     <script type='` + MimeJs + `'>
       function makeEmptyCache() {
         let c = new Array({{len .AppState.RenderedFiles}});
@@ -58,13 +65,24 @@ var (
         as = new AppState(sc, {{.AppState.InitialRender}});
         nac = new MdRipController(as);
         sc.enable();
-        // Load the initial (zeroth) file.
-        as.loadCurrentFile(StartAt.Top, ActivateBlock.No);
+        sc.pollForReload((numFilesChanged) => {
+          if (numFilesChanged) {
+            // The nav must be rebuilt, so start over.
+            window.location.reload();
+            return;
+          }
+          as.refreshCurrentFile();
+        });
+        // Load the file named in the URL, at the block named in
+        // the fragment, if any.
+        nac.loadInitialFile();
       }
     </script>
   </head>
   <body onload='onLoad()'>
-  {{template "` + mdrip.TmplNameHtml + `" .}}
+  <div class='` + ContainerClass + `'>
+  {{template "` + mdrip.TmplNameHtml + `" .TmplParams}}
+  </div>
   </body>
 </html>
 `
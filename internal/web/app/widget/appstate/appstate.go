@@ -22,9 +22,11 @@ type Facts struct {
 	InitialCodeBlockIndex int
 	NumFolders            int
 	MaxCodeBlocksInAFile  int
-	MaxNavWordLength      int
-	IsNavVisible          bool
-	IsTitleVisible        bool
+	// MaxNavWordLength is the display width, in cells, of the widest
+	// word in the nav; wide characters count as two cells.
+	MaxNavWordLength int
+	IsNavVisible     bool
+	IsTitleVisible   bool
 }
 
 type InitialRender struct {
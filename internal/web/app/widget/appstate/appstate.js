@@ -17,6 +17,8 @@ class AppState {
         this.maxCodeBlocksInAFile = initRender.Facts.MaxCodeBlocksInAFile;
         this.isNavVisible = initRender.Facts.IsNavVisible;
         this.isTitleVisible = initRender.Facts.IsTitleVisible;
+        // The server renders the theme from the session into the page.
+        this.theme = document.documentElement.dataset.theme;
         this.file = {
             Html: "<p> Oops </p>",
             CodeBlockLabels: [],
@@ -34,6 +36,7 @@ class AppState {
         console.debug("myCodeBlockIndex = ",this.myCodeBlockIndex);
         console.debug("    isNavVisible = ",this.isNavVisible);
         console.debug("  isTitleVisible = ",this.isTitleVisible);
+        console.debug("           theme = ",this.theme);
         console.debug("    myNumFolders = ",this.myNumFolders);
         console.debug("    orderedPaths = ",this.orderedPaths);
     }
@@ -42,11 +45,43 @@ class AppState {
         this.sessionController.reload(doneClosure);
     }
 
-    runCodeBlock() {
+    // refreshCurrentFile re-fetches and re-renders the current file
+    // in place, e.g. after the server reloads its data.
+    refreshCurrentFile() {
+        this.loadCurrentFile(StartAt.Top, ActivateBlock.No, true);
+    }
+
+    getCwd(doneClosure) {
+        this.sessionController.getCwd(doneClosure);
+    }
+
+    resetShell(doneClosure) {
+        this.sessionController.resetShell(doneClosure);
+    }
+
+    search(query, isRegex, doneClosure) {
+        this.sessionController.search(query, isRegex, doneClosure);
+    }
+
+    listBlocks(doneClosure) {
+        this.sessionController.listBlocks(doneClosure);
+    }
+
+    // runNamedBlock runs the block of the file having the name,
+    // passing its RunResult, if any, to the resultClosure.
+    runNamedBlock(fileIndex, name, timeoutSec, resultClosure) {
+        this.sessionController.runNamedBlock(
+            fileIndex, name, timeoutSec, () => {}, resultClosure);
+    }
+
+    // runCodeBlock runs the active code block, passing its
+    // RunResult, if any, to the optional resultClosure.
+    runCodeBlock(timeoutSec, resultClosure) {
         let index = this.myCodeBlockIndex;
         this.sessionController.runBlock(
-            this.myFileIndex, this.myCodeBlockIndex,
-            () => {this.notifyCodeBlockRunReactors(index);});
+            this.myFileIndex, this.myCodeBlockIndex, timeoutSec,
+            () => {this.notifyCodeBlockRunReactors(index);},
+            resultClosure);
     }
 
     focusMarkdownRoot() {
@@ -72,8 +107,16 @@ class AppState {
         this.notifyLayoutReactors();
     }
 
+    // The theme is all CSS, so no reactors need to hear about it.
+    toggleTheme() {
+        this.theme = (this.theme === '{{.ThemeLight}}') ?
+            '{{.ThemeDark}}' : '{{.ThemeLight}}';
+        document.documentElement.dataset.theme = this.theme;
+        this.sessionController.saveTheme(this.theme);
+    }
+
     notifyLayoutReactors() {
-        this.sessionController.save(this);
+        this.sessionController.saveLayout(this);
         this.layoutReactors.forEach(
             (item,i) => {item.reactLayoutChange()});
     }
@@ -159,7 +202,10 @@ class AppState {
     // loadCurrentFile loads the current file and its labels,
     // and activate (or not) the topmost or bottommost code block,
     // depending on the navigation direction.
-    loadCurrentFile(direction, activate) {
+    // If force is true, reactors re-render even if the file index
+    // didn't change.  The optional doneClosure is called once
+    // the reactors have heard about the new file.
+    loadCurrentFile(direction, activate, force = false, doneClosure = null) {
         this.sessionController.getFileData(
             this.fileIndex,
             (file) => {
@@ -178,9 +224,12 @@ class AppState {
                     }
                 }
                 this.fileChangeReactors.forEach(
-                    (item,i) => {item.reactFileChange()});
+                    (item,i) => {item.reactFileChange(force)});
                 this.focusMarkdownRoot();
                 this.notifyCodeBlockChangeReactors();
+                if (doneClosure !== null) {
+                    doneClosure();
+                }
             })
     }
 
@@ -0,0 +1,25 @@
+package cmdpalette
+
+import (
+	_ "embed"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/common"
+)
+
+const (
+	TmplName = "tmplCmdPalette"
+)
+
+var (
+	//go:embed cmdpalette.html
+	html string
+
+	//go:embed cmdpalette.css
+	Css string
+
+	//go:embed cmdpalette.js
+	Js string
+)
+
+func AsTmpl() string {
+	return common.AsTmpl(TmplName, html)
+}
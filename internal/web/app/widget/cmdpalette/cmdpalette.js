@@ -0,0 +1,220 @@
+// CmdPaletteController is an overlay, toggled by ctrl-k, listing
+// the runnable code blocks of all the files by name.  Typing narrows
+// the list to the blocks whose file and name fuzzily match; Enter,
+// or a click, runs the selected block, showing its output below the
+// list until the palette is next opened.
+class CmdPaletteController {
+    constructor(as) {
+        this.appState = as;
+        this.el = getDocElByClass('cmdPalette');
+        this.elInput = getElByClass(this.el, 'cmdPaletteInput');
+        this.elList = getElByClass(this.el, 'cmdPaletteList');
+        this.elOutput = getElByClass(this.el, 'cmdPaletteOutput');
+        // blocks are the runnable blocks, refetched on each opening,
+        // since the server may have reloaded since the last one.
+        this.blocks = [];
+        // matches are the blocks shown, best match first.
+        this.matches = [];
+        this.selected = 0;
+        // numListings detects stale listings, e.g. from fast toggling.
+        this.numListings = 0;
+        this.wireUpHandlers();
+    }
+
+    // maxShown limits the matches shown, there being no point in
+    // scrolling through hundreds of them rather than typing more.
+    static get maxShown() {
+        return 50;
+    }
+
+    get isViz() {
+        return this.el.style.display === 'block';
+    }
+
+    toggle() {
+        if (this.isViz) {
+            this.hide();
+        } else {
+            this.show();
+        }
+    }
+
+    show() {
+        this.el.style.display = 'block';
+        this.elInput.value = '';
+        this.elOutput.style.display = 'none';
+        this.elOutput.textContent = '';
+        this.elInput.focus();
+        this.blocks = [];
+        this.showNote('loading...');
+        let n = ++this.numListings;
+        this.appState.listBlocks((blocks) => {
+            if (n !== this.numListings) {
+                return;
+            }
+            if (blocks === null) {
+                this.showNote('unable to list blocks');
+                return;
+            }
+            this.blocks = blocks.filter((b) => {return b.runnable;});
+            this.filter();
+        });
+    }
+
+    hide() {
+        this.el.style.display = 'none';
+        this.elInput.blur();
+    }
+
+    wireUpHandlers() {
+        this.elInput.addEventListener('keydown', (event) => {
+            // Don't let typing trigger the app's key commands.
+            event.stopPropagation();
+            if ((event.ctrlKey || event.metaKey) && event.key === 'k') {
+                event.preventDefault();
+                this.hide();
+                return;
+            }
+            switch (event.key) {
+                case 'Enter':
+                    if (this.matches.length > 0) {
+                        this.run(this.matches[this.selected]);
+                    }
+                    break;
+                case 'Escape':
+                    this.hide();
+                    break;
+                case 'ArrowDown':
+                    event.preventDefault();
+                    this.select(this.selected + 1);
+                    break;
+                case 'ArrowUp':
+                    event.preventDefault();
+                    this.select(this.selected - 1);
+                    break;
+                default:
+            }
+        });
+        this.elInput.addEventListener('input', () => {this.filter();});
+    }
+
+    // filter shows the blocks matching the input, best match first,
+    // and those equally good in document order.
+    filter() {
+        let query = this.elInput.value.trim();
+        let scored = [];
+        this.blocks.forEach((b, i) => {
+            let score = fuzzyMatchScore(query, b.path + ' ' + b.name);
+            if (score !== null) {
+                scored.push({b: b, score: score, i: i});
+            }
+        });
+        scored.sort((x, y) => {return (y.score - x.score) || (x.i - y.i);});
+        this.matches = scored.slice(
+            0, CmdPaletteController.maxShown).map((s) => {return s.b;});
+        this.elList.replaceChildren();
+        if (this.matches.length === 0) {
+            this.addNote(this.blocks.length === 0 ? 'no runnable blocks' : 'no matches');
+            return;
+        }
+        this.matches.forEach((b, i) => {
+            let el = document.createElement('div');
+            el.setAttribute('class', 'cmdPaletteItem');
+            let elName = document.createElement('span');
+            elName.setAttribute('class', 'cmdPaletteName');
+            elName.textContent = b.name;
+            let elPath = document.createElement('span');
+            elPath.setAttribute('class', 'cmdPalettePath');
+            elPath.textContent = b.path;
+            el.append(elName, elPath);
+            el.addEventListener('click', () => {
+                this.select(i);
+                this.run(b);
+            });
+            this.elList.appendChild(el);
+        });
+        if (scored.length > this.matches.length) {
+            this.addNote('more matches not shown');
+        }
+        this.select(0);
+    }
+
+    select(i) {
+        if (this.matches.length === 0) {
+            return;
+        }
+        this.selected = Math.min(Math.max(i, 0), this.matches.length - 1);
+        let items = this.elList.getElementsByClassName('cmdPaletteItem');
+        for (let j = 0; j < items.length; j++) {
+            items[j].classList.toggle('cmdPaletteSelected', j === this.selected);
+        }
+        items[this.selected].scrollIntoView({block: 'nearest'});
+    }
+
+    showNote(text) {
+        this.matches = [];
+        this.elList.replaceChildren();
+        this.addNote(text);
+    }
+
+    addNote(text) {
+        let el = document.createElement('div');
+        el.setAttribute('class', 'cmdPaletteNote');
+        el.textContent = text;
+        this.elList.appendChild(el);
+    }
+
+    run(b) {
+        this.elOutput.style.display = 'block';
+        this.elOutput.classList.remove('cmdPaletteOutputFailed');
+        this.elOutput.textContent = 'running ' + b.name + ' of ' + b.path + '...';
+        let n = this.numListings;
+        this.appState.runNamedBlock(
+            b.fileIndex, b.name, timeoutSecFromLabels(b.labels), (res) => {
+                if (n === this.numListings) {
+                    this.showOutput(res);
+                }
+            });
+    }
+
+    // showOutput shows the result of a run, per RunResult,
+    // as the code block does.
+    showOutput(res) {
+        let lines = [res.stdout, res.stderr].filter((s) => s);
+        if (res.error) {
+            lines.push(res.error);
+        } else if (res.exitCode !== 0) {
+            lines.push('exit status ' + res.exitCode);
+        }
+        this.elOutput.textContent = lines.length > 0 ? lines.join('\n') : '(no output)';
+        this.elOutput.classList.toggle(
+            'cmdPaletteOutputFailed', !!res.error || res.exitCode !== 0);
+    }
+}
+
+// fuzzyMatchScore returns how well the query matches the text, per
+// isFuzzyMatch, but ignoring spaces in the query, or null if it
+// doesn't match.  Runs of adjacent characters, and those starting
+// a word, score more, so e.g. 'dep' prefers 'deploy' to 'redeploy'.
+function fuzzyMatchScore(query, text) {
+    let q = query.toLowerCase().replace(/\s+/g, '');
+    let t = text.toLowerCase();
+    let score = 0;
+    let prev = -2;
+    let j = 0;
+    for (let i = 0; i < t.length && j < q.length; i++) {
+        if (t[i] !== q[j]) {
+            continue;
+        }
+        score++;
+        if (i === prev + 1) {
+            score += 2;
+        }
+        if (i === 0 || !/[a-z0-9]/.test(t[i - 1])) {
+            score += 3;
+        }
+        prev = i;
+        j++;
+    }
+    return j === q.length ? score : null;
+}
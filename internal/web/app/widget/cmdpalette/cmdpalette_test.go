@@ -0,0 +1,55 @@
+package cmdpalette_test
+
+import (
+	_ "embed"
+	"testing"
+
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/appstate"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/cmdpalette"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/common"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/session"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/testutil"
+)
+
+func TestWidget(t *testing.T) {
+	testutil.RenderHtmlToFile(
+		t, cmdpalette.AsTmpl()+tmplTestBody, makeParams())
+}
+
+func makeParams() any {
+	return struct {
+		common.ParamStructJsCss
+		AppState *appstate.AppState
+	}{
+		common.ParamDefaultJsCss,
+		testutil.MakeAppStateTest0(),
+	}
+}
+
+var (
+	tmplTestBody = `
+{{define "` + testutil.TmplTestName + `"}}
+<html><head>
+<style>
+` + common.Css + `
+` + cmdpalette.Css + `
+</style>
+<script type="text/javascript">
+` + common.Js + `
+` + session.Js + `
+` + appstate.Js + `
+` + cmdpalette.Js + `
+` + testutil.Js + `
+function onLoad() {
+  as = tstMakeAppState()
+  let cpc = new CmdPaletteController(as);
+  cpc.show();
+}
+</script>
+</head>
+<body onload='onLoad()'>
+{{ template "` + cmdpalette.TmplName + `" . }}
+</body></html>
+{{end}}
+`
+)
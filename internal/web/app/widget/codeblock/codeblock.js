@@ -18,6 +18,7 @@ class CodeBlockController {
     constructor(id) {
         this.id = id;
         this.el = null;
+        this.active = false;
         this.onClickFunctions = [];
     }
 
@@ -55,7 +56,45 @@ class CodeBlockController {
     }
 
     get isActive() {
-        return this.prompt.style.display === 'inline-block';
+        return this.active;
+    }
+
+    // isRunnable is false for blocks whose fence language (e.g. yaml)
+    // suggests they shouldn't be sent to a shell.
+    get isRunnable() {
+        return this.el.dataset.runnable !== 'false';
+    }
+
+    // timeoutSec is the time limit from the block's timeout label,
+    // or zero if it has none.
+    get timeoutSec() {
+        return timeoutSecFromLabels((this.el.dataset.labels || '').split(' '));
+    }
+
+    // isSkipped is true for blocks labelled to be skipped,
+    // e.g. by a step through the file.
+    get isSkipped() {
+        return (this.el.dataset.labels || '').split(' ').includes('{{.SkipLabel}}');
+    }
+
+    // showOutput shows the result of a run of the block, per
+    // RunResult, just below the block, replacing that of any
+    // earlier run.
+    showOutput(res) {
+        let out = this.el.nextElementSibling;
+        if (out === null || !out.classList.contains('codeBlockOutput')) {
+            out = document.createElement('pre');
+            out.setAttribute('class', 'codeBlockOutput');
+            this.el.after(out);
+        }
+        let lines = [res.stdout, res.stderr].filter((s) => s);
+        if (res.error) {
+            lines.push(res.error);
+        } else if (res.exitCode !== 0) {
+            lines.push('exit status ' + res.exitCode);
+        }
+        out.textContent = lines.length > 0 ? lines.join('\n') : '(no output)';
+        out.classList.toggle('codeBlockOutputFailed', !!res.error || res.exitCode !== 0);
     }
 
     toggle() {
@@ -67,6 +106,7 @@ class CodeBlockController {
     }
 
     deActivate() {
+        this.active = false;
         this.prompt.style.display = 'none';
         this.codeArea.style.boxShadow = '';
         this.codeArea.style.color = 'var(--color-code-inactive)';
@@ -74,7 +114,10 @@ class CodeBlockController {
 
 
     activate() {
-        this.prompt.style.display = 'inline-block';
+        this.active = true;
+        if (this.isRunnable) {
+            this.prompt.style.display = 'inline-block';
+        }
         // box-shadow is a rectangle shape - a shadow - behind the object.
         //     If no offset, it's invisible.
         // box-shadow: 'color [inset] offset-x offset-y blur-radius spread-radius'
@@ -2,6 +2,8 @@ package codeblock
 
 import (
 	"fmt"
+	"html"
+	"strings"
 
 	"github.com/yuin/goldmark/ast"
 	"github.com/yuin/goldmark/util"
@@ -28,6 +30,12 @@ type HighlightedCodeBlock struct {
 	FileIndex  int
 	BlockIndex int
 	Title      string
+	// Language is the language named in the fence info string.
+	Language string
+	// Labels are all the block's labels, including special labels.
+	Labels []string
+	// Runnable is true if the block may be sent to a shell.
+	Runnable bool
 }
 
 // Dump implements Node.dump.
@@ -36,6 +44,9 @@ func (n *HighlightedCodeBlock) Dump(source []byte, level int) {
 		"FileIndex":  fmt.Sprintf("%d", n.FileIndex),
 		"BlockIndex": fmt.Sprintf("%d", n.BlockIndex),
 		"Title":      fmt.Sprintf("%s", n.Title),
+		"Language":   n.Language,
+		"Labels":     strings.Join(n.Labels, " "),
+		"Runnable":   fmt.Sprintf("%t", n.Runnable),
 	}
 	ast.DumpHelper(n, source, level, m, nil)
 }
@@ -48,16 +59,23 @@ func (n *HighlightedCodeBlock) Kind() ast.NodeKind {
 // render renders a HighlightedCodeBlock with the id and styling elements needed
 // to get something that both looks like a terminal and is properly
 // hooked up to the javascript that does a copy and POST back to the server.
+// The block's language, labels and runnability are rendered as
+// data attributes for use by the javascript.
 func (n *HighlightedCodeBlock) render(
 	w util.BufWriter, entering bool) (ast.WalkStatus, error) {
 	if entering {
 		_, _ = w.WriteString(
-			fmt.Sprintf(`<div class='codeBlockContainer' id='codeBlockId%d'>
+			fmt.Sprintf(`<div class='codeBlockContainer' id='codeBlockId%d' data-lang='%s' data-labels='%s' data-runnable='%t'>
 <div class='codeBlockControl'>
 <span class='codeBlockTitle'> %s </span>
 </div>
 <div class='codeBlockPrompt'> %s </div>
-<div class='codeBlockArea'>`, n.BlockIndex, n.Title, CbPrompt))
+<div class='codeBlockArea'>`,
+				n.BlockIndex,
+				html.EscapeString(n.Language),
+				html.EscapeString(strings.Join(n.Labels, " ")),
+				n.Runnable,
+				n.Title, CbPrompt))
 		return ast.WalkContinue, nil
 	}
 	_, _ = w.WriteString(`</div></div>`)
@@ -30,7 +30,8 @@ func MakeFuncMap() map[string]interface{} {
 				Label string
 			}{Id: i, Label: string(lab)}
 		},
-		// It seems like an "em" is about 5/6 of one average character.
+		// It seems like an "em" is about 5/6 of one average character
+		// cell; wide characters take two cells.
 		"numCharsToEm": func(i int) string {
 			return fmt.Sprintf("%.1fem", 5.0*float32(i)/6.0)
 		},
@@ -1,6 +1,23 @@
 
 const BadId = -1;
 
+// BlockFragmentPrefix starts a URL fragment naming a code block in
+// the current file by its zero-relative index, e.g. '#block-3'.
+const BlockFragmentPrefix = '#block-';
+
+// blockIndexFromFragment returns the code block index named by the
+// URL fragment, or BadId if the fragment doesn't name one.
+function blockIndexFromFragment(hash) {
+    if (!hash.startsWith(BlockFragmentPrefix)) {
+        return BadId;
+    }
+    let s = hash.substring(BlockFragmentPrefix.length);
+    if (!/^[0-9]+$/.test(s)) {
+        return BadId;
+    }
+    return parseInt(s, 10);
+}
+
 function getDocElByClass(n) {
     return getElByClass(document, n);
 }
@@ -9,6 +26,19 @@ function getElByClass(el, name) {
     return el.getElementsByClassName(name)[0];
 }
 
+// timeoutSecFromLabels returns the time limit from a code block's
+// timeout label, or zero if it has none.
+function timeoutSecFromLabels(labels) {
+    for (const label of labels) {
+        if (label.startsWith('{{.TimeoutLabelPrefix}}')) {
+            let n = parseInt(
+                label.substring('{{.TimeoutLabelPrefix}}'.length), 10);
+            return n > 0 ? n : 0;
+        }
+    }
+    return 0;
+}
+
 // randomInt returns a random int in [0..(n-1)].
 function randomInt(n) {
     return Math.floor(Math.random() * n)
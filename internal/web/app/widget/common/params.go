@@ -1,6 +1,7 @@
 package common
 
 import (
+	"github.com/monopole/mdrip/v2/internal/loader"
 	"github.com/monopole/mdrip/v2/internal/web/config"
 )
 
@@ -9,20 +10,51 @@ type ParamStructJsCss struct {
 
 	MaxNavWordLength int
 
+	// PathRoot is the path of the served folder, ending in a slash.
+	PathRoot             string
+	PathJs               string
+	PathCss              string
 	PathRunBlock         string
 	PathSave             string
 	PathReload           string
+	PathLoadStatus       string
+	PathCwd              string
+	PathReset            string
+	PathSearch           string
+	PathFiles            string
 	PathGetHtmlForFile   string
 	PathGetLabelsForFile string
+	PathGetBlocksForFile string
 
 	KeyMdSessID    string
 	KeyMdFileIndex string
 	KeyBlockIndex  string
+	KeyBlockName   string
 	KeyIsTitleOn   string
 	KeyIsNavOn     string
+	KeyTheme       string
+	KeySearchQuery string
+	KeySearchRegex string
+	KeyRows        string
+	KeyCols        string
+
+	ThemeDark  string
+	ThemeLight string
 
 	MdSessID          string
 	TransitionSpeedMs int
+	// ReloadPollMs is how often the client asks if the server reloaded.
+	ReloadPollMs int
+	// RunTimeoutMs, if positive, is how long the client waits
+	// for the server to run a code block.
+	RunTimeoutMs int
+	// RunTimeoutGraceMs is how much longer than a block's timeout
+	// label the client waits for the server to run the block.
+	RunTimeoutGraceMs int
+	// TimeoutLabelPrefix starts a code block's timeout label.
+	TimeoutLabelPrefix string
+	// SkipLabel marks a code block that a step through a file skips.
+	SkipLabel string
 }
 
 var (
@@ -31,19 +63,64 @@ var (
 
 		MaxNavWordLength: 43,
 
+		PathRoot:             "/",
+		PathJs:               config.Dynamic(config.RouteJs),
+		PathCss:              config.Dynamic(config.RouteCss),
 		PathSave:             config.Dynamic(config.RouteSave),
 		PathReload:           config.Dynamic(config.RouteReload),
+		PathLoadStatus:       config.Dynamic(config.RouteLoadStatus),
+		PathCwd:              config.Dynamic(config.RouteCwd),
+		PathReset:            config.Dynamic(config.RouteReset),
+		PathSearch:           config.Dynamic(config.RouteSearch),
+		PathFiles:            config.Dynamic(config.RouteFiles),
 		PathGetHtmlForFile:   config.Dynamic(config.RouteHtmlForFile),
 		PathGetLabelsForFile: config.Dynamic(config.RouteLabelsForFile),
+		PathGetBlocksForFile: config.Dynamic(config.RouteBlocksForFile),
 		PathRunBlock:         config.Dynamic(config.RouteRunBlock),
 
 		KeyMdFileIndex: config.KeyMdFileIndex,
 		KeyBlockIndex:  config.KeyBlockIndex,
+		KeyBlockName:   config.KeyBlockName,
 		KeyIsTitleOn:   config.KeyIsTitleOn,
 		KeyIsNavOn:     config.KeyIsNavOn,
+		KeyTheme:       config.KeyTheme,
+		KeySearchQuery: config.KeySearchQuery,
+		KeySearchRegex: config.KeySearchRegex,
+		KeyRows:        config.KeyRows,
+		KeyCols:        config.KeyCols,
 		KeyMdSessID:    config.KeyMdSessID,
 
+		ThemeDark:  config.ThemeDark,
+		ThemeLight: config.ThemeLight,
+
 		MdSessID:          "notARealSessId",
 		TransitionSpeedMs: 250,
+		ReloadPollMs:      3000,
+
+		TimeoutLabelPrefix: loader.TimeoutLabelPrefix,
+		SkipLabel:          string(loader.SkipLabel),
 	}
 )
+
+// AddRoutePrefix puts the prefix, e.g. "/mdrip", in front of
+// all the paths, for serving the app below the root of a site.
+func (p *ParamStructJsCss) AddRoutePrefix(prefix string) {
+	for _, path := range []*string{
+		&p.PathRoot,
+		&p.PathJs,
+		&p.PathCss,
+		&p.PathRunBlock,
+		&p.PathSave,
+		&p.PathReload,
+		&p.PathLoadStatus,
+		&p.PathCwd,
+		&p.PathReset,
+		&p.PathSearch,
+		&p.PathFiles,
+		&p.PathGetHtmlForFile,
+		&p.PathGetLabelsForFile,
+		&p.PathGetBlocksForFile,
+	} {
+		*path = prefix + *path
+	}
+}
@@ -0,0 +1,31 @@
+package common
+
+import (
+	"unicode"
+
+	"golang.org/x/text/width"
+)
+
+// TextWidth is the number of terminal-style cells the string
+// occupies when shown, e.g. in the nav.  Wide characters, like most
+// CJK characters and emoji, take two cells; runes that take no space
+// of their own, like combining accents and zero-width joiners, take
+// none; everything else takes one.
+func TextWidth(s string) int {
+	n := 0
+	for _, r := range s {
+		n += runeWidth(r)
+	}
+	return n
+}
+
+func runeWidth(r rune) int {
+	if unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) {
+		return 0
+	}
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	}
+	return 1
+}
@@ -5,6 +5,8 @@ class MdFilesController {
         this.root = document.getElementById("mdFilesRoot");
         this.myFileIndex = BadId;
         this.oldCodeBlockIndex = BadId;
+        // stepIndex is the block that runNextStep tries next.
+        this.stepIndex = 0;
         this.cbControllers = [];
         this.cbControllers = new Array(appState.maxCodeBlocksInAFile);
         for (let i = 0; i < appState.maxCodeBlocksInAFile; i++) {
@@ -27,11 +29,12 @@ class MdFilesController {
         return el;
     }
 
-    reactFileChange() {
-        if (this.myFileIndex === this.appState.fileIndex) {
+    reactFileChange(force) {
+        if (!force && this.myFileIndex === this.appState.fileIndex) {
             return;
         }
         this.myFileIndex = this.appState.fileIndex
+        this.stepIndex = 0;
 
         let newDiv = this.makeContentDiv();
         this.root.replaceChild(newDiv, this.root.firstElementChild);
@@ -63,7 +66,34 @@ class MdFilesController {
             console.debug('No active code block.');
             return;
         }
-        this.appState.runCodeBlock()
+        if (!this.cbControllers[this.cbIndex].isRunnable) {
+            console.debug('Active code block is not runnable.');
+            return;
+        }
+        this.appState.runCodeBlock(this.cbControllers[this.cbIndex].timeoutSec)
+    }
+
+    // runNextStep runs the file's blocks as numbered steps, one per
+    // call, skipping those that can't run or are labelled to be
+    // skipped, and shows each block's output below it.  After the
+    // last step, it starts over.
+    runNextStep() {
+        let n = this.appState.currCodeBlocks.length;
+        let i = this.stepIndex;
+        while (i < n && (!this.cbControllers[i].isRunnable ||
+            this.cbControllers[i].isSkipped)) {
+            i++;
+        }
+        if (i >= n) {
+            this.stepIndex = 0;
+            alert(n > 0 ? 'no more steps; starting over' : 'no steps');
+            return;
+        }
+        this.stepIndex = i + 1;
+        let cbc = this.cbControllers[i];
+        this.appState.setCodeBlockIndex(i);
+        cbc.scrollIntoView();
+        this.appState.runCodeBlock(cbc.timeoutSec, (res) => {cbc.showOutput(res);});
     }
 
     reactCodeBlockRun(index) {
@@ -87,9 +117,25 @@ class MdFilesController {
         let path = this.appState.currPath
         if (history.pushState) {
             window.history.pushState(
-                "not using data yet", "someTitle", "/" + path);
+                "not using data yet", "someTitle", "{{.PathRoot}}" + path);
         } else {
-            document.location.href = path;
+            document.location.href = "{{.PathRoot}}" + path;
+        }
+    }
+
+    // updateFragment makes the URL fragment name the active code block,
+    // so that the URL can be shared; see MdRipController.loadInitialFile.
+    updateFragment() {
+        if (window.location.origin.startsWith("file://")) {
+            return;
+        }
+        let hash = '';
+        if (this.appState.isGoodCurrCodeBlockIndex) {
+            hash = BlockFragmentPrefix + this.cbIndex;
+        }
+        if (window.location.hash !== hash) {
+            window.history.replaceState(
+                window.history.state, '', window.location.pathname + hash);
         }
     }
 
@@ -116,6 +162,7 @@ class MdFilesController {
             this.cbControllers[this.oldCodeBlockIndex].deActivate();
         }
         this.oldCodeBlockIndex = this.cbIndex
+        this.updateFragment();
         if (!this.appState.isGoodCurrCodeBlockIndex) {
             return;
         }
@@ -11,6 +11,7 @@ import (
 	"github.com/monopole/mdrip/v2/internal/parsren"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/appstate"
 	burgerbars "github.com/monopole/mdrip/v2/internal/web/app/widget/burgerbars1"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/cmdpalette"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/codeblock"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/codelabel"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/common"
@@ -25,7 +26,9 @@ import (
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/navleftroot"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/navrightroot"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/navtop"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/searchbox"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/session"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/themebutton"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/timeline"
 )
 
@@ -55,6 +58,7 @@ var (
 			common.Css,
 			burgerbars.Css,
 			helpbutton.Css,
+			themebutton.Css,
 			helpbox.Css,
 			mdfiles.Css,
 			codeblock.Css,
@@ -65,6 +69,8 @@ var (
 			navlefttopfolder.Css,
 			navleftfolder.Css,
 			navleftroot.Css,
+			searchbox.Css,
+			cmdpalette.Css,
 			navbottom.Css,
 			navcontentrow.Css,
 			navbottom.Css,
@@ -81,6 +87,7 @@ var (
 			codeblock.Js,
 			burgerbars.Js,
 			helpbutton.Js,
+			themebutton.Js,
 			helpbox.Js,
 			timeline.Js,
 			mdfiles.Js,
@@ -88,6 +95,8 @@ var (
 			navleftfile.Js,
 			navleftfolder.Js,
 			navleftroot.Js,
+			searchbox.Js,
+			cmdpalette.Js,
 			navcontentrow.Js,
 			navrightroot.Js,
 			navbottom.Js,
@@ -117,10 +126,12 @@ type TmplParams struct {
 	AppState      *appstate.AppState
 	BurgerBars    template.HTML
 	HelpButton    template.HTML
+	ThemeButton   template.HTML
 	TimelineId    string
 	TimelineRow   template.HTML
 	NavContentRow template.HTML
 	HelpBox       template.HTML
+	CmdPalette    template.HTML
 }
 
 type RenderingArgs struct {
@@ -148,23 +159,29 @@ func RenderFolder(rArgs *RenderingArgs) (
 		loader.NewTopFolder(rArgs.Folder).Accept(rArgs.Pr)
 		appState = appstate.New(
 			rArgs.DataSource, rArgs.Pr.RenderedMdFiles(), rArgs.Title)
-		maxLabelLen := 0
+		var names []string
 		for _, b := range rArgs.Pr.Filter(
 			func(b *loader.CodeBlock) bool { return true }) {
-			if l := len(b.UniqName()); l > maxLabelLen {
-				maxLabelLen = l
-			}
-		}
-		if maxFileNameLen > maxLabelLen {
-			appState.Facts.MaxNavWordLength = maxFileNameLen
-		} else {
-			appState.Facts.MaxNavWordLength = maxLabelLen
+			names = append(names, b.UniqName())
 		}
+		appState.Facts.MaxNavWordLength = max(
+			maxFileNameLen, ComputeMaxNavWordLength(names...))
 		appState.Facts.NumFolders = numFolders
 	}
 	return
 }
 
+// ComputeMaxNavWordLength returns the display width, in cells, of
+// the widest of the given words, e.g. code block names shown in the
+// nav.  It sizes the nav columns.
+func ComputeMaxNavWordLength(words ...string) int {
+	result := 0
+	for _, w := range words {
+		result = max(result, common.TextWidth(w))
+	}
+	return result
+}
+
 func MakeBaseParams(maxWordLen int) *TmplParams {
 	res := &TmplParams{
 		ParamStructJsCss: common.ParamDefaultJsCss,
@@ -181,7 +198,9 @@ func MakeParams(
 	tps.NavLeftRoot = lftNavRoot
 	tps.AppState = appState
 	tps.ContentLeft = common.MustRenderHtml(
-		navleftroot.AsTmpl(), tps, navleftroot.TmplName)
+		searchbox.AsTmpl(), tps, searchbox.TmplName) +
+		common.MustRenderHtml(
+			navleftroot.AsTmpl(), tps, navleftroot.TmplName)
 
 	tps.ContentRight = common.MustRenderHtml(
 		codelabel.AsTmpl()+navrightroot.AsTmpl(), tps, navrightroot.TmplName)
@@ -190,6 +209,8 @@ func MakeParams(
 
 	tps.HelpButton = common.MustRenderHtml(
 		helpbutton.AsTmpl(), tps, helpbutton.TmplName)
+	tps.ThemeButton = common.MustRenderHtml(
+		themebutton.AsTmpl(), tps, themebutton.TmplName)
 
 	tps.TimelineId = timelineIdTop
 	tps.TimelineRow = common.MustRenderHtml(
@@ -211,5 +232,7 @@ func MakeParams(
 
 	tps.HelpBox = common.MustRenderHtml(
 		helpbox.AsTmpl(), tps, helpbox.TmplName)
+	tps.CmdPalette = common.MustRenderHtml(
+		cmdpalette.AsTmpl(), tps, cmdpalette.TmplName)
 	return tps
 }
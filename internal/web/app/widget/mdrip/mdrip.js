@@ -56,25 +56,60 @@ class MdRipController {
             this.hbc.toggle();
         })
         this.bbc = new BurgerBarsController();
+        if (as.isNavVisible) {
+            this.bbc.turnOn();
+        }
+        this.tbc = new ThemeButtonController(getDocElByClass('themeButton'));
         this.crc = new NavigatedContentRowController(as);
         this.mfc = new MdFilesController(as);
-        let nlc = new NavLeftRootController(as);
+        this.ntc.onRunNext(() => {this.mfc.runNextStep();});
+        this.nlc = new NavLeftRootController(as);
+        this.sbc = new SearchBoxController(as);
+        this.cpc = new CmdPaletteController(as);
         let nrc = new NavRightRootController(as);
         this.mkc = new MonkeyController(as, this.hbc);
         this.wireUpHandlers();
     }
 
+    // loadInitialFile loads the file named by the URL path, and
+    // activates and scrolls to the code block named by the URL
+    // fragment, if any, e.g. '/doc.md#block-3'.
+    loadInitialFile() {
+        let i = blockIndexFromFragment(window.location.hash);
+        this.appState.loadCurrentFile(
+            StartAt.Top, ActivateBlock.No, false, () => {
+                if (!this.appState.isGoodCodeBlockIndex(i)) {
+                    return;
+                }
+                // Activation scrolls the block into view.
+                this.appState.setCodeBlockIndex(i);
+            });
+    }
+
     wireUpHandlers() {
         let nac = this;
         this.bbc.onClick(() => {nac.appState.toggleNav();})
+        this.tbc.onClick(() => {nac.appState.toggleTheme();})
         let keyHandler = function (event) {
             if (event.defaultPrevented) {
                 return;
             }
+            if ((event.ctrlKey || event.metaKey) && event.key === 'k') {
+                // Don't let the browser have it, e.g. for its search bar.
+                event.preventDefault();
+                nac.cpc.toggle();
+                return;
+            }
             switch (event.key) {
                 case 'r':
                     console.debug('reloading')
-                    nac.appState.reload(() => {window.location.href = "/"});
+                    nac.appState.reload((numFilesChanged) => {
+                        if (numFilesChanged) {
+                            window.location.href = "{{.PathRoot}}";
+                            return;
+                        }
+                        nac.appState.refreshCurrentFile();
+                    });
                     break;
                 case 'x':
                     nac.mfc.scrollToActiveCodeBlock();
@@ -85,6 +120,18 @@ class MdRipController {
                 case '-':
                     nac.appState.toggleTitle();
                     break;
+                case 't':
+                    nac.appState.toggleTheme();
+                    break;
+                case 'f':  // Find, in the left nav
+                    // Don't type the 'f' into the search box.
+                    event.preventDefault();
+                    if (!nac.appState.isNavVisible) {
+                        nac.bbc.toggle();
+                        nac.appState.toggleNav();
+                    }
+                    nac.sbc.focus();
+                    break;
                 case 'n':  // Show left and right nav
                     nac.bbc.toggle();
                     nac.appState.toggleNav();
@@ -97,12 +144,13 @@ class MdRipController {
                 case 'a':
                 case 'h':
                 case 'ArrowLeft':
-                    nac.appState.goPrevFile(ActivateBlock.No);
+                    // Skipping files hidden by the nav's filter.
+                    nac.nlc.goFile(-1);
                     break;
                 case 'd':
                 case 'l':
                 case 'ArrowRight':
-                    nac.appState.goNextFile(ActivateBlock.No);
+                    nac.nlc.goFile(1);
                     break;
                 default:
             }
@@ -7,6 +7,7 @@ import (
 	"github.com/monopole/mdrip/v2/internal/parsren/usegold"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/mdrip"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/testutil"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestWidget(t *testing.T) {
@@ -71,3 +72,28 @@ function onLoad() {
 {{end}}
 `
 )
+
+func TestComputeMaxNavWordLength(t *testing.T) {
+	for n, tc := range map[string]struct {
+		words []string
+		want  int
+	}{
+		"none":  {want: 0},
+		"empty": {words: []string{""}, want: 0},
+		"ascii": {words: []string{"install", "runTheTests", "go"}, want: 11},
+		// Precomposed, and with a combining acute accent.
+		"accents":         {words: []string{"café", "cafe\u0301"}, want: 4},
+		"combiningMarks":  {words: []string{"n\u0303o\u0308"}, want: 2},
+		"zeroWidthJoiner": {words: []string{"a\u200db"}, want: 2},
+		// Wide characters take two cells.
+		"cjk":       {words: []string{"設定する"}, want: 8},
+		"japanese":  {words: []string{"abc", "日本語"}, want: 6},
+		"mixed":     {words: []string{"go言語"}, want: 6},
+		"fullwidth": {words: []string{"ＡＢ"}, want: 4},
+		"emoji":     {words: []string{"deploy🚀"}, want: 8},
+	} {
+		t.Run(n, func(t *testing.T) {
+			assert.Equal(t, tc.want, mdrip.ComputeMaxNavWordLength(tc.words...))
+		})
+	}
+}
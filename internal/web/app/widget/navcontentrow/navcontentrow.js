@@ -57,8 +57,9 @@ class NavLrController {
 class NavigatedContentRowController {
     constructor(as) {
         this.appState = as;
-        this.isNavViz = as.isNavVisible;
-        this.isTitleViz = as.isTitleVisible;
+        // Start from the OOTB HTML layout: title showing, nav hidden.
+        this.isNavViz = false;
+        this.isTitleViz = true;
         let el = getDocElByClass('ncrWrapper');
         this.conNavLeft = new NavLrController(
             getElByClass(el,'ncrNavSpLeft'),
@@ -71,9 +72,7 @@ class NavigatedContentRowController {
         this.elCenter = getElByClass(el,'ncrContentCenter');
         as.addLayoutReactor(this);
         // If the incoming state doesn't look like the OOTB HTML layout...
-        if (!(this.isTitleViz === true && this.isNavViz === false)) {
-            this.reactLayoutChange()
-        }
+        this.reactLayoutChange()
     }
 
     reactLayoutChange() {
@@ -18,6 +18,19 @@ class NavLeftFileController {
         this.el.addEventListener('click', f);
     }
 
+    get name() {
+        return this.el.textContent.trim();
+    }
+
+    get isHidden() {
+        return this.el.classList.contains('navLeftHidden');
+    }
+
+    // setHidden hides the entry, e.g. if it doesn't match a filter.
+    setHidden(b) {
+        this.el.classList.toggle('navLeftHidden', b);
+    }
+
     activate() {
         this.el.classList.remove('navLeftFileDeactivated');
         this.el.classList.add('navLeftFileActivated');
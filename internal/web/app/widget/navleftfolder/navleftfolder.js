@@ -10,6 +10,7 @@ class NavLeftFolderController {
         if (el == null) {
             console.debug("Unable to find folder id = ", id)
         }
+        this.el = el;
         this.children = getElByClass(el, 'navLeftFolderChildren')
         el = getElByClass(el, 'navLeftFolderName')
         el.addEventListener('click', () => {this.toggle();});
@@ -27,6 +28,20 @@ class NavLeftFolderController {
         this.children.style.display = 'none';
     }
 
+    // hideIfEmpty hides the folder if all the files below it are
+    // hidden, e.g. by a filter, else shows it.
+    hideIfEmpty() {
+        let files = this.el.getElementsByClassName('navLeftFile');
+        let empty = true;
+        for (const f of files) {
+            if (!f.classList.contains('navLeftHidden')) {
+                empty = false;
+                break;
+            }
+        }
+        this.el.classList.toggle('navLeftHidden', empty);
+    }
+
     toggle() {
         if (this.isViz) {
             this.hideChildren();
@@ -3,6 +3,7 @@ class NavLeftRootController {
         this.appState = appState;
         this.myFileIndex = BadId;
         this.root = getDocElByClass('navLeftRoot');
+        this.elFilter = getElByClass(this.root, 'navLeftFilter');
         this.folderController = new Array(appState.numFolders);
         this.fileController = new Array(appState.numFiles);
         for (let i = 0; i < appState.numFolders; i++) {
@@ -30,6 +31,51 @@ class NavLeftRootController {
         this.root.addEventListener('click', f);
     }
 
+    get isFiltering() {
+        return this.elFilter.value !== '';
+    }
+
+    // filter hides the files whose names don't fuzzily match the
+    // filter, and the folders left empty.
+    filter() {
+        let q = this.elFilter.value;
+        this.root.classList.toggle('navLeftFiltering', q !== '');
+        for (const c of this.fileController) {
+            c.setHidden(!isFuzzyMatch(q, c.name));
+        }
+        for (const c of this.folderController) {
+            c.hideIfEmpty();
+        }
+    }
+
+    // goFile goes to the next file shown in the nav, in the given
+    // direction, skipping those hidden by the filter.
+    goFile(step) {
+        if (!this.isFiltering) {
+            if (step < 0) {
+                this.appState.goPrevFile(ActivateBlock.No);
+            } else {
+                this.appState.goNextFile(ActivateBlock.No);
+            }
+            return;
+        }
+        for (let i = this.appState.fileIndex + step;
+             i >= 0 && i < this.fileController.length; i += step) {
+            if (!this.fileController[i].isHidden) {
+                this.appState.setFileIndex(i);
+                return;
+            }
+        }
+    }
+
+    // goFirstFile goes to the first file shown in the nav.
+    goFirstFile() {
+        let i = this.fileController.findIndex((c) => !c.isHidden);
+        if (i >= 0) {
+            this.appState.setFileIndex(i);
+        }
+    }
+
     wireUpHandlers() {
         let me = this;
         for (let i = 0; i < this.fileController.length; i++) {
@@ -37,6 +83,30 @@ class NavLeftRootController {
                 me.appState.setFileIndex(i);
             });
         }
+        me.elFilter.addEventListener('input', () => {me.filter();});
+        me.elFilter.addEventListener('keydown', (event) => {
+            // Don't let typing trigger the nav's or the app's key commands.
+            event.stopPropagation();
+            switch (event.key) {
+                case 'Enter':
+                    me.goFirstFile();
+                    break;
+                case 'Escape':
+                    me.elFilter.value = '';
+                    me.filter();
+                    me.elFilter.blur();
+                    break;
+                case 'ArrowUp':
+                    event.preventDefault();
+                    me.goFile(-1);
+                    break;
+                case 'ArrowDown':
+                    event.preventDefault();
+                    me.goFile(1);
+                    break;
+                default:
+            }
+        });
         {
             let kh = function(event) {
                 switch (event.key) {
@@ -44,13 +114,13 @@ class NavLeftRootController {
                     case 'k':
                     case 'ArrowUp':
                         event.preventDefault();
-                        me.appState.goPrevFile(ActivateBlock.No);
+                        me.goFile(-1);
                         break;
                     case 'j':
                     case 's':
                     case 'ArrowDown':
                         event.preventDefault();
-                        me.appState.goNextFile(ActivateBlock.No);
+                        me.goFile(1);
                         break;
                     default:
                 }
@@ -61,7 +131,24 @@ class NavLeftRootController {
             me.root.focus();
         }
         me.root.onmouseout = function () {
-            me.root.blur();
+            if (document.activeElement !== me.elFilter) {
+                me.root.blur();
+            }
+        }
+    }
+}
+
+// isFuzzyMatch is true if the characters of the query appear in the
+// text in order, though not necessarily together, ignoring case,
+// e.g. 'gsd' matches 'gettingStarted.md'.
+function isFuzzyMatch(query, text) {
+    let q = query.toLowerCase();
+    let t = text.toLowerCase();
+    let j = 0;
+    for (let i = 0; i < t.length && j < q.length; i++) {
+        if (t[i] === q[j]) {
+            j++;
         }
     }
+    return j === q.length;
 }
@@ -85,7 +85,7 @@ func (v *Renderer) VisitFile(x *loader.MyFile) {
 	atp.FileName = strings.TrimSuffix(x.Name(), ".md")
 
 	{
-		length := (v.depth * indentPerDepth) + len(atp.FileName)
+		length := (v.depth * indentPerDepth) + common.TextWidth(atp.FileName)
 		if length > v.maxFileNameLength {
 			v.maxFileNameLength = length
 		}
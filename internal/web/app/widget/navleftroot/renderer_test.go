@@ -40,6 +40,22 @@ func TestRenderer(t *testing.T) {
 			want: (`
 <div class='navLeftFile navLeftFileDeactivated' id='navLeftFileId0'>
   B234567
+</div>`)[1:],
+		},
+		"japanese": {
+			input:          loader.NewEmptyFile("設定.md"),
+			maxFileNameLen: 4, /* two wide characters */
+			want: (`
+<div class='navLeftFile navLeftFileDeactivated' id='navLeftFileId0'>
+  設定
+</div>`)[1:],
+		},
+		"emoji": {
+			input:          loader.NewEmptyFile("ship🚀.md"),
+			maxFileNameLen: 6, /* 4 + one wide emoji */
+			want: (`
+<div class='navLeftFile navLeftFileDeactivated' id='navLeftFileId0'>
+  ship🚀
 </div>`)[1:],
 		},
 		"t1": {
@@ -18,8 +18,8 @@ class NavRightRootController {
         return this.appState.myCodeBlockIndex;
     }
 
-    reactFileChange() {
-        if (this.myFileIndex === this.appState.fileIndex) {
+    reactFileChange(force) {
+        if (!force && this.myFileIndex === this.appState.fileIndex) {
             return;
         }
         this.resetAllLabelControllers();
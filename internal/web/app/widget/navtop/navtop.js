@@ -1,18 +1,23 @@
 class NavTopController {
     constructor(as, tlc) {
         this.appState = as;
-        this.isTitleViz = as.isTitleVisible;
+        // Start from the OOTB HTML layout, which has the title showing.
+        this.isTitleViz = true;
         this.timelineController = tlc;
         this.styleHeader = document.getElementById('header').style;
         this.styleTitleDoc = getDocElByClass('nvtTitleDoc').style;
         this.styleTitleCurr = getDocElByClass('nvtTitleCurr');
+        this.elCwd = getDocElByClass('nvtCwd');
+        this.elReset = getDocElByClass('nvtReset');
+        this.elReset.addEventListener('click', () => {this.resetShell();});
+        this.elRunNext = getDocElByClass('nvtRunNext');
         this.setHeight('var(--layout-nav-top-height)');
         as.addFileChangeReactor(this);
         as.addLayoutReactor(this);
+        as.addCodeBlockRunReactor(this);
+        this.showCwd();
         // If the incoming state doesn't look like the OOTB HTML layout...
-        if (this.isTitleViz !== true) {
-            this.reactLayoutChange()
-        }
+        this.reactLayoutChange()
     }
 
     reactLayoutChange() {
@@ -30,6 +35,42 @@ class NavTopController {
         this.styleTitleCurr.innerHTML = this.appState.currPath;
     }
 
+    // A block that just ran may have changed the shell's directory.
+    reactCodeBlockRun(index) {
+        this.showCwd();
+    }
+
+    showCwd() {
+        this.appState.getCwd((dir) => {this.setCwd(dir);});
+    }
+
+    setCwd(dir) {
+        // Blank, rather than an error, if no shell can say.
+        this.elCwd.textContent = dir ? 'cwd: ' + dir : '';
+        // Only a shell that can say where it is can be reset, or run steps.
+        this.elReset.style.display = dir ? 'inline' : 'none';
+        this.elRunNext.style.display = dir ? 'inline' : 'none';
+    }
+
+    // onRunNext arranges for f to be called on a click of the
+    // run next step button.
+    onRunNext(f) {
+        this.elRunNext.addEventListener('click', f);
+    }
+
+    resetShell() {
+        if (!confirm('Restart the shell, losing its variables and directory?')) {
+            return;
+        }
+        this.appState.resetShell((dir) => {
+            if (dir === null) {
+                alert('unable to reset the shell');
+                return;
+            }
+            this.setCwd(dir);
+        });
+    }
+
     get height() {
         return this.styleHeader.height;
     }
@@ -9,6 +9,7 @@ import (
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/navtop"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/session"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/testutil"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/themebutton"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/timeline"
 	"html/template"
 	"testing"
@@ -27,6 +28,7 @@ func makeParams(tlId string, as *appstate.AppState) any {
 		TimelineId  string
 		BurgerBars  template.HTML
 		HelpButton  template.HTML
+		ThemeButton template.HTML
 		TimelineRow template.HTML
 	}{
 		ParamStructJsCss: common.ParamDefaultJsCss,
@@ -37,6 +39,8 @@ func makeParams(tlId string, as *appstate.AppState) any {
 		burgerbars.AsTmpl(), atp, burgerbars.TmplName)
 	atp.HelpButton = common.MustRenderHtml(
 		helpbutton.AsTmpl(), atp, helpbutton.TmplName)
+	atp.ThemeButton = common.MustRenderHtml(
+		themebutton.AsTmpl(), atp, themebutton.TmplName)
 	atp.TimelineRow = common.MustRenderHtml(
 		timeline.AsTmpl(), atp, timeline.TmplName)
 	return atp
@@ -49,6 +53,7 @@ var (
 <style>
 ` + common.Css + `
 ` + helpbutton.Css + `
+` + themebutton.Css + `
 ` + burgerbars.Css + `
 ` + timeline.Css + `
 ` + navtop.Css + `
@@ -59,6 +64,7 @@ var (
 ` + appstate.Js + `
 ` + burgerbars.Js + `
 ` + helpbutton.Js + `
+` + themebutton.Js + `
 ` + timeline.Js + `
 ` + navtop.Js + `
 ` + testutil.Js + `
@@ -0,0 +1,25 @@
+package searchbox
+
+import (
+	_ "embed"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/common"
+)
+
+const (
+	TmplName = "tmplSearchBox"
+)
+
+var (
+	//go:embed searchbox.html
+	html string
+
+	//go:embed searchbox.css
+	Css string
+
+	//go:embed searchbox.js
+	Js string
+)
+
+func AsTmpl() string {
+	return common.AsTmpl(TmplName, html)
+}
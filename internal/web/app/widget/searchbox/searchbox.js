@@ -0,0 +1,150 @@
+// SearchBoxController searches the text of all the markdown files,
+// listing the hits.  Clicking a hit shows its file, scrolled to the match.
+class SearchBoxController {
+    constructor(as) {
+        this.appState = as;
+        let el = getDocElByClass('searchBox');
+        this.elInput = getElByClass(el, 'searchInput');
+        this.elRegex = getElByClass(el, 'searchRegexToggle');
+        this.elResults = getElByClass(el, 'searchResults');
+        // numSearches detects stale results, e.g. from fast typing.
+        this.numSearches = 0;
+        this.typingTimer = null;
+        // pendingMatch is the match to scroll to once its file shows.
+        this.pendingMatch = null;
+        as.addFileChangeReactor(this);
+        this.wireUpHandlers();
+    }
+
+    focus() {
+        this.elInput.focus();
+        this.elInput.select();
+    }
+
+    wireUpHandlers() {
+        this.elInput.addEventListener('keydown', (event) => {
+            // Don't let typing trigger the app's key commands.
+            event.stopPropagation();
+            switch (event.key) {
+                case 'Enter':
+                    this.search();
+                    break;
+                case 'Escape':
+                    this.elInput.blur();
+                    break;
+                default:
+            }
+        });
+        this.elInput.addEventListener('input', () => {
+            window.clearTimeout(this.typingTimer);
+            this.typingTimer = window.setTimeout(() => {this.search();}, 300);
+        });
+        this.elRegex.addEventListener('change', () => {this.search();});
+    }
+
+    search() {
+        window.clearTimeout(this.typingTimer);
+        let n = ++this.numSearches;
+        let query = this.elInput.value;
+        if (query === '') {
+            this.elResults.replaceChildren();
+            return;
+        }
+        let isRegex = this.elRegex.checked;
+        this.appState.search(query, isRegex, (res) => {
+            if (n === this.numSearches) {
+                this.showResults(query, isRegex, res);
+            }
+        });
+    }
+
+    showResults(query, isRegex, res) {
+        this.elResults.replaceChildren();
+        if (res.error) {
+            this.addNote(res.error);
+            return;
+        }
+        if (res.hits.length === 0) {
+            this.addNote('no matches');
+            return;
+        }
+        // ordinal counts the earlier hits in the same file.
+        let ordinal = 0;
+        res.hits.forEach((hit, i) => {
+            if (i > 0 && res.hits[i - 1].fileIndex === hit.fileIndex) {
+                ordinal++;
+            } else {
+                ordinal = 0;
+            }
+            let m = {query: query, isRegex: isRegex, ordinal: ordinal};
+            let el = document.createElement('div');
+            el.setAttribute('class', 'searchHit');
+            let elLoc = document.createElement('div');
+            elLoc.setAttribute('class', 'searchHitLoc');
+            elLoc.textContent = hit.path + ':' + hit.line;
+            let elSnippet = document.createElement('div');
+            elSnippet.setAttribute('class', 'searchHitSnippet');
+            elSnippet.textContent = hit.snippet;
+            el.append(elLoc, elSnippet);
+            el.addEventListener('click', () => {this.goTo(hit.fileIndex, m);});
+            this.elResults.appendChild(el);
+        });
+        if (res.truncated) {
+            this.addNote('more matches not shown');
+        }
+    }
+
+    addNote(text) {
+        let el = document.createElement('div');
+        el.setAttribute('class', 'searchHitLoc');
+        el.textContent = text;
+        this.elResults.appendChild(el);
+    }
+
+    goTo(fileIndex, m) {
+        this.pendingMatch = m;
+        if (fileIndex === this.appState.fileIndex) {
+            this.reactFileChange();
+            return;
+        }
+        this.appState.setFileIndex(fileIndex);
+    }
+
+    reactFileChange() {
+        if (this.pendingMatch === null) {
+            return;
+        }
+        let m = this.pendingMatch;
+        this.pendingMatch = null;
+        // Wait for the file's scroll to the top to finish.
+        window.setTimeout(() => {this.scrollToMatch(m);}, 700);
+    }
+
+    // scrollToMatch scrolls to the markdown text matching the
+    // search, approximating the hit by its ordinal in the file.
+    scrollToMatch(m) {
+        let re;
+        try {
+            re = new RegExp(m.isRegex ? m.query :
+                m.query.replace(/[.*+?^${}()|[\]\\]/g, '\\$&'), 'i');
+        } catch (err) {
+            console.debug('regex unusable in javascript', err);
+            return;
+        }
+        let root = document.getElementById('mdFilesRoot');
+        let walker = document.createTreeWalker(root, NodeFilter.SHOW_TEXT);
+        let found = [];
+        while (walker.nextNode()) {
+            if (re.test(walker.currentNode.textContent)) {
+                found.push(walker.currentNode.parentElement);
+            }
+        }
+        if (found.length === 0) {
+            return;
+        }
+        let el = found[Math.min(m.ordinal, found.length - 1)];
+        el.scrollIntoView({block: 'center', behavior: 'smooth'});
+        el.classList.add('searchMatch');
+        window.setTimeout(() => {el.classList.remove('searchMatch');}, 2000);
+    }
+}
@@ -0,0 +1,56 @@
+package searchbox_test
+
+import (
+	_ "embed"
+	"testing"
+
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/appstate"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/common"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/searchbox"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/session"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/testutil"
+)
+
+func TestWidget(t *testing.T) {
+	testutil.RenderHtmlToFile(
+		t, searchbox.AsTmpl()+tmplTestBody, makeParams())
+}
+
+func makeParams() any {
+	return struct {
+		common.ParamStructJsCss
+		AppState *appstate.AppState
+	}{
+		common.ParamDefaultJsCss,
+		testutil.MakeAppStateTest0(),
+	}
+}
+
+var (
+	tmplTestBody = `
+{{define "` + testutil.TmplTestName + `"}}
+<html><head>
+<style>
+` + common.Css + `
+` + searchbox.Css + `
+</style>
+<script type="text/javascript">
+` + common.Js + `
+` + session.Js + `
+` + appstate.Js + `
+` + searchbox.Js + `
+` + testutil.Js + `
+function onLoad() {
+  as = tstMakeAppState()
+  let sbc = new SearchBoxController(as);
+  sbc.focus();
+}
+</script>
+</head>
+<body onload='onLoad()'>
+{{ template "` + searchbox.TmplName + `" . }}
+<div id='mdFilesRoot'></div>
+</body></html>
+{{end}}
+`
+)
@@ -59,6 +59,9 @@ func AssureDefaults(s *sessions.Session) {
 	if _, ok = s.Values[config.KeyBlockIndex].(int); !ok {
 		s.Values[config.KeyBlockIndex] = -1
 	}
+	if _, ok = s.Values[config.KeyTheme].(string); !ok {
+		s.Values[config.KeyTheme] = config.ThemeDark
+	}
 }
 
 // Bucket holds session state data, presumably associated with a cookie.
@@ -73,6 +76,8 @@ type Bucket struct {
 	MdFileIndex int
 	// The active block in that file.
 	BlockIndex int
+	// The color theme, one of the config.Theme* values.
+	Theme string
 }
 
 // ConvertToBucket creates a SessionData instance;
@@ -85,5 +90,6 @@ func ConvertToBucket(s *sessions.Session) *Bucket {
 		IsNavOn:     s.Values[config.KeyIsNavOn].(bool),
 		MdFileIndex: s.Values[config.KeyMdFileIndex].(int),
 		BlockIndex:  s.Values[config.KeyBlockIndex].(int),
+		Theme:       s.Values[config.KeyTheme].(string),
 	}
 }
@@ -8,6 +8,39 @@ class SessionController {
         // rfCache should be []HtmlAndLabels, i.e.
         // an array of { Html string, CodeBlockLabels, []labels CbRunCount[]int }.
         this.rfCache = rf;
+        // loadStatus is the most recent load status reported by the server.
+        this.loadStatus = null;
+        // termSize is the size of the window in rows and columns of
+        // text, sent with each run so that a shell running blocks on
+        // a terminal can size it to fit.
+        this.termSize = this.measureTermSize();
+        window.addEventListener('resize', () => {
+            this.termSize = this.measureTermSize();
+        });
+    }
+
+    // measureTermSize returns the size of the window, in rows and
+    // columns of monospace text.
+    measureTermSize() {
+        const dflt = {rows: 24, cols: 80};
+        if (!document.body) {
+            return dflt;
+        }
+        let probe = document.createElement('pre');
+        probe.style.position = 'absolute';
+        probe.style.visibility = 'hidden';
+        probe.style.margin = '0';
+        probe.textContent = 'M';
+        document.body.appendChild(probe);
+        let r = probe.getBoundingClientRect();
+        document.body.removeChild(probe);
+        if (r.width <= 0 || r.height <= 0) {
+            return dflt;
+        }
+        return {
+            rows: Math.max(1, Math.floor(window.innerHeight / r.height)),
+            cols: Math.max(1, Math.floor(window.innerWidth / r.width)),
+        };
     }
 
     enable() {
@@ -57,17 +90,73 @@ class SessionController {
             })
     }
 
+    // reload asks the server to reload its data, and then, unless
+    // the content is as before, calls doneClosure with a boolean
+    // that's true if the number of files might have changed.
     reload(doneClosure) {
         console.debug('Session calling server to reaload all data');
         fetch('{{.PathReload}}', {
             // See nearby note regarding POST.
             method: "POST",
+        }).then((r) => {
+            return r.json();
         }).then((r) => {
             console.debug('reloaded data')
-            doneClosure();
+            let old = this.loadStatus;
+            this.loadStatus = r;
+            if (old !== null && old.hash === r.hash) {
+                console.debug('content unchanged');
+                return;
+            }
+            this.forgetFiles();
+            doneClosure(old === null || old.numFiles !== r.numFiles);
+        }).catch((err) => {
+            console.debug('unable to reload', err);
         })
     }
 
+    // forgetFiles empties the cache of rendered files, so the
+    // next request for a file goes to the server.
+    forgetFiles() {
+        for (let i = 0; i < this.rfCache.length; i++) {
+            this.rfCache[i] = null;
+        }
+    }
+
+    // pollForReload periodically asks the server for its load status.
+    // If the server reloaded changed data since the last check (e.g.
+    // because some other client asked it to), the cache is emptied and
+    // onChange is called with a boolean that's true if the number
+    // of files changed, meaning the whole page must be refreshed.
+    pollForReload(onChange) {
+        if ({{.ReloadPollMs}} < 1) {
+            return;
+        }
+        window.setInterval(() => {
+            if (!this.enabled) {
+                return;
+            }
+            fetch('{{.PathLoadStatus}}')
+                .then((r) => {
+                    return r.json();
+                })
+                .then((r) => {
+                    let old = this.loadStatus;
+                    this.loadStatus = r;
+                    if (old === null || old.loadTime === r.loadTime ||
+                        old.hash === r.hash) {
+                        return;
+                    }
+                    console.debug('server reloaded data');
+                    this.forgetFiles();
+                    onChange(old.numFiles !== r.numFiles);
+                })
+                .catch((err) => {
+                    console.debug('unable to get load status', err);
+                })
+        }, {{.ReloadPollMs}});
+    }
+
     // A note regarding POST.
     // In the fetch calls below we're telling the server to do a thing that
     // changes things on the server side - so we use a POST per HTTP
@@ -100,7 +189,60 @@ class SessionController {
         })
     }
 
-    runBlock(fileIndex, codeBlockIndex, doneClosure) {
+    // saveLayout records the visibility of the title and nav in the
+    // session, so the server can render them on the next page load.
+    saveLayout(appState) {
+        this.saveAlways(
+            '{{.KeyIsTitleOn}}=' + appState.isTitleVisible
+            + '&{{.KeyIsNavOn}}=' + appState.isNavVisible);
+    }
+
+    // saveTheme records the theme in the session, likewise.
+    saveTheme(theme) {
+        this.saveAlways('{{.KeyTheme}}=' + theme);
+    }
+
+    // saveAlways saves the given params in the session, even if
+    // isSessionSavingEnabled is false.  The layout and theme are cheap
+    // to save, and change only when the user asks, unlike the file
+    // and block indices; the server keeps values of absent params.
+    saveAlways(query) {
+        if (!this.enabled) {
+            console.debug("session disabled; not saving", query)
+            return;
+        }
+        fetch('{{.PathSave}}?' + query, {
+            // See nearby note regarding POST.
+            method: "POST",
+        }).then((r) => {
+            console.debug('saved', query)
+        })
+    }
+
+    // runBlock runs the block, waiting a little longer than the
+    // block's own timeout, if it has one, else the server's default.
+    runBlock(fileIndex, codeBlockIndex, timeoutSec, doneClosure, resultClosure) {
+        this.postRun(
+            '{{.KeyMdFileIndex}}=' + fileIndex
+            + '&{{.KeyBlockIndex}}=' + codeBlockIndex,
+            timeoutSec, () => {
+                this.recordRunBlock(fileIndex, codeBlockIndex);
+                doneClosure();
+            }, resultClosure);
+    }
+
+    // runNamedBlock runs the block of the file having the name,
+    // i.e. its UniqName, as runBlock does.
+    runNamedBlock(fileIndex, name, timeoutSec, doneClosure, resultClosure) {
+        this.postRun(
+            '{{.KeyMdFileIndex}}=' + fileIndex
+            + '&{{.KeyBlockName}}=' + encodeURIComponent(name),
+            timeoutSec, doneClosure, resultClosure);
+    }
+
+    // postRun asks the server to run the block identified by the
+    // query, calling doneClosure once the server responds.
+    postRun(query, timeoutSec, doneClosure, resultClosure) {
         if (!this.enabled) {
             console.debug("session disabled; not running block")
             return;
@@ -111,17 +253,138 @@ class SessionController {
         }
         this.isCodeRunning = true;
         let me = this;
-        let url = '{{.PathRunBlock}}'
-            + '?{{.KeyMdFileIndex}}=' + fileIndex
-            + '&{{.KeyBlockIndex}}=' + codeBlockIndex
-            + '&{{.KeyMdSessID}}={{.MdSessID}}';
-        fetch(url, {
+        let url = '{{.PathRunBlock}}?' + query
+            + '&{{.KeyMdSessID}}={{.MdSessID}}'
+            + '&{{.KeyRows}}=' + this.termSize.rows
+            + '&{{.KeyCols}}=' + this.termSize.cols;
+        let opts = {
             // See nearby note regarding POST.
             method: "POST",
-        }).then((r) => {
+        };
+        // Give up eventually, rather than being busy forever.
+        let timer = null;
+        let waitMs = {{.RunTimeoutMs}};
+        if (timeoutSec > 0) {
+            waitMs = timeoutSec * 1000 + {{.RunTimeoutGraceMs}};
+        }
+        if (waitMs > 0) {
+            let ac = new AbortController();
+            opts.signal = ac.signal;
+            timer = window.setTimeout(() => {ac.abort();}, waitMs);
+        }
+        fetch(url, opts).then((r) => {
+            window.clearTimeout(timer);
             me.isCodeRunning = false;
-            this.recordRunBlock(fileIndex, codeBlockIndex);
             doneClosure();
+            return r.ok ? r.json() : null;
+        }).then((res) => {
+            if (res && resultClosure) {
+                resultClosure(res);
+            }
+            if (res && res.timedOut) {
+                alert('timed out after ' + res.timeoutMs / 1000 + 's');
+            }
+        }).catch((err) => {
+            window.clearTimeout(timer);
+            me.isCodeRunning = false;
+            if (err.name === 'AbortError') {
+                alert('timed out!');
+                return;
+            }
+            console.debug('unable to run block', err);
+        })
+    }
+
+    // getCwd passes the working directory of the shell running
+    // code blocks to the closure, or null if it's not available.
+    getCwd(doneClosure) {
+        if (!this.enabled) {
+            doneClosure(null);
+            return;
+        }
+        fetch('{{.PathCwd}}').then((r) => {
+            return r.ok ? r.text() : null;
+        }).then((dir) => {
+            doneClosure(dir);
+        }).catch((err) => {
+            console.debug('unable to get cwd', err);
+            doneClosure(null);
+        })
+    }
+
+    // resetShell asks the server to restart the shell running code
+    // blocks, then passes the new shell's working directory (empty
+    // if it can't say) to the closure, or null if it couldn't be reset.
+    resetShell(doneClosure) {
+        if (!this.enabled) {
+            doneClosure(null);
+            return;
+        }
+        fetch('{{.PathReset}}', {
+            // See nearby note regarding POST.
+            method: "POST",
+        }).then((r) => {
+            return r.ok ? r.json() : null;
+        }).then((r) => {
+            doneClosure(r === null ? null : (r.cwd || ''));
+        }).catch((err) => {
+            console.debug('unable to reset shell', err);
+            doneClosure(null);
+        })
+    }
+
+    // search passes the server's search result for the query to the
+    // closure, or an object holding only an error message.
+    search(query, isRegex, doneClosure) {
+        let url = '{{.PathSearch}}'
+            + '?{{.KeySearchQuery}}=' + encodeURIComponent(query)
+            + '&{{.KeySearchRegex}}=' + isRegex;
+        fetch(url).then((r) => {
+            if (r.ok) {
+                return r.json();
+            }
+            return r.text().then((t) => {
+                return {error: t.trim()};
+            });
+        }).then((res) => {
+            doneClosure(res);
+        }).catch((err) => {
+            console.debug('unable to search', err);
+            doneClosure({error: 'unable to search'});
+        })
+    }
+
+    // listBlocks passes the closure the code blocks of all the files,
+    // in order, each as {fileIndex, path, name, labels, runnable},
+    // or null if they can't be listed.
+    listBlocks(doneClosure) {
+        fetch('{{.PathFiles}}').then((r) => {
+            return r.json();
+        }).then((files) => {
+            return Promise.all(files.filter((f) => {
+                return f.blockCount > 0;
+            }).map((f) => {
+                return fetch('{{.PathGetBlocksForFile}}?{{.KeyMdFileIndex}}=' + f.index)
+                    .then((r) => {
+                        return r.json();
+                    })
+                    .then((infos) => {
+                        return infos.map((b) => {
+                            return {
+                                fileIndex: f.index,
+                                path: f.path,
+                                name: b.uniqName,
+                                labels: b.labels,
+                                runnable: b.runnable,
+                            };
+                        });
+                    });
+            }));
+        }).then((perFile) => {
+            doneClosure(perFile.flat());
+        }).catch((err) => {
+            console.debug('unable to list blocks', err);
+            doneClosure(null);
         })
     }
 
@@ -0,0 +1,25 @@
+package themebutton
+
+import (
+	_ "embed"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/common"
+)
+
+const (
+	TmplName = "tmplThemeButton"
+)
+
+var (
+	//go:embed themebutton.html
+	html string
+
+	//go:embed themebutton.css
+	Css string
+
+	//go:embed themebutton.js
+	Js string
+)
+
+func AsTmpl() string {
+	return common.AsTmpl(TmplName, html)
+}
@@ -0,0 +1,8 @@
+class ThemeButtonController {
+    constructor(el) {
+        this.butt = el;
+    }
+    onClick(f) {
+        this.butt.addEventListener('click', f);
+    }
+}
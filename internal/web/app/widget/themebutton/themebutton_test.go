@@ -0,0 +1,47 @@
+package themebutton_test
+
+import (
+	_ "embed"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/common"
+	"testing"
+
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/testutil"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/themebutton"
+)
+
+func TestWidget(t *testing.T) {
+	testutil.RenderHtmlToFile(
+		t, themebutton.AsTmpl()+tmplTestBody, makeParams())
+}
+
+func makeParams() any {
+	return struct {
+		common.ParamStructJsCss
+	}{
+		common.ParamDefaultJsCss,
+	}
+}
+
+var (
+	tmplTestBody = `
+{{define "` + testutil.TmplTestName + `"}}
+<html><head>
+<style>
+` + common.Css + `
+` + themebutton.Css + `
+</style>
+<script type="text/javascript">
+` + common.Js + `
+` + themebutton.Js + `
+function onLoad() {
+  let tbc = new ThemeButtonController(getDocElByClass("themeButton"))
+  tbc.onClick(()=>{console.log("themeButton clicked.");})
+}
+</script>
+</head>
+<body onload='onLoad()'>
+{{ template "` + themebutton.TmplName + `" . }}
+</body></html>
+{{end}}
+`
+)
@@ -36,6 +36,32 @@ const (
 	RouteDebug // debug
 	// RouteWebSocket sets up a socket.
 	RouteWebSocket // debug
+	// RouteLoadStatus is the GET endpoint describing the most recent data load,
+	// so that clients can notice a reload and re-fetch what they're showing.
+	RouteLoadStatus // loadStatus
+	// RouteCwd is the GET endpoint reporting the working directory of
+	// the shell receiving code blocks, as a prior block may have done a cd.
+	RouteCwd // cwd
+	// RouteVersion is the GET endpoint reporting the server's build provenance.
+	RouteVersion // version
+	// RouteSearch is the GET endpoint to search the text of all loaded markdown files.
+	RouteSearch // search
+	// RouteRunTag is the POST endpoint to run, in order, all the code
+	// blocks having a label, e.g. "smoke", across all markdown files.
+	RouteRunTag // runTag
+	// RouteBlocksForFile is the GET endpoint for metadata,
+	// e.g. language and runnability, of the code blocks of one markdown file.
+	RouteBlocksForFile // blocksForFile
+	// RouteReset is the POST endpoint to restart the shell receiving
+	// code blocks, clearing the environment and working directory
+	// left by earlier blocks.
+	RouteReset // reset
+	// RouteFiles is the GET endpoint listing the loaded markdown files,
+	// with their indices and titles, e.g. for building other UIs.
+	RouteFiles // files
+	// RouteRunFile is the POST endpoint to run, in order, all the code
+	// blocks of one markdown file.
+	RouteRunFile // runFile
 )
 
 func Dynamic(r Route) string {
@@ -56,4 +82,70 @@ const (
 	KeyMdFileIndex = "fix"
 	// KeyBlockIndex is the param name for the code block index.
 	KeyBlockIndex = "bix"
+	// KeyBlockName is the param name for the code block name, i.e. its
+	// UniqName, which may be used rather than the block index.
+	KeyBlockName = "bn"
+	// KeyTrace is the param name for the run-with-tracing boolean.
+	KeyTrace = "trace"
+	// KeyInterp is the param name for an interpreter to run a block,
+	// overriding any interpreter named in the block's labels.
+	KeyInterp = "interp"
+	// KeyColor is the param name for what to do with color escape
+	// codes in output captured from a block; see the Color* values.
+	KeyColor = "color"
+	// KeyDownload is the param name for a file name; if present, a
+	// block that succeeds has its stdout sent as a file of that name.
+	KeyDownload = "download"
+	// KeyTheme is the param name for the color theme; see the Theme* values.
+	KeyTheme = "theme"
+	// KeySearchQuery is the param name for the text to search for.
+	KeySearchQuery = "q"
+	// KeySearchRegex is the param name for the boolean meaning
+	// the search query is a regular expression.
+	KeySearchRegex = "re"
+	// KeyLabel is the param name for a code block label.
+	KeyLabel = "label"
+	// KeyInclude is the param name for a comma separated list of
+	// labels, limiting a run to blocks having any of them.
+	KeyInclude = "include"
+	// KeyExclude is the param name for a comma separated list of
+	// labels, leaving blocks having any of them out of a run.
+	KeyExclude = "exclude"
+	// KeyFailFast is the param name for the boolean meaning stop
+	// running a file's blocks at the first failure; it's the default.
+	KeyFailFast = "failFast"
+	// KeyRows and KeyCols are the param names for the size of the
+	// reader's window, in rows and columns of text, to which an
+	// interactive shell's terminal is sized.
+	KeyRows = "rows"
+	KeyCols = "cols"
+	// KeyFormat is the param name for the form of the debug page;
+	// see the Format* values.
+	KeyFormat = "format"
+)
+
+// Values for the KeyColor param.
+const (
+	// ColorStrip means remove escape codes; it's the default.
+	ColorStrip = "strip"
+	// ColorKeep means leave escape codes as is.
+	ColorKeep = "keep"
+	// ColorHtml means convert color escape codes to styled HTML spans.
+	ColorHtml = "html"
+)
+
+// Values for the KeyTheme param.
+const (
+	// ThemeDark is the default theme.
+	ThemeDark = "dark"
+	// ThemeLight is dark text on a light background.
+	ThemeLight = "light"
+)
+
+// Values for the KeyFormat param.
+const (
+	// FormatText is plain text; it's the default.
+	FormatText = "text"
+	// FormatJson is JSON.
+	FormatJson = "json"
 )
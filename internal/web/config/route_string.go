@@ -20,11 +20,20 @@ func _() {
 	_ = x[RouteQuit-9]
 	_ = x[RouteDebug-10]
 	_ = x[RouteWebSocket-11]
+	_ = x[RouteLoadStatus-12]
+	_ = x[RouteCwd-13]
+	_ = x[RouteVersion-14]
+	_ = x[RouteSearch-15]
+	_ = x[RouteRunTag-16]
+	_ = x[RouteBlocksForFile-17]
+	_ = x[RouteReset-18]
+	_ = x[RouteFiles-19]
+	_ = x[RouteRunFile-20]
 }
 
-const _Route_name = "RouteUnknownjscssreloadlabelsForFilehtmlForFilerunCodeBlocksaveimagequitdebugdebug"
+const _Route_name = "RouteUnknownjscssreloadlabelsForFilehtmlForFilerunCodeBlocksaveimagequitdebugdebugloadStatuscwdversionsearchrunTagblocksForFileresetfilesrunFile"
 
-var _Route_index = [...]uint8{0, 12, 14, 17, 23, 36, 47, 59, 63, 68, 72, 77, 82}
+var _Route_index = [...]uint8{0, 12, 14, 17, 23, 36, 47, 59, 63, 68, 72, 77, 82, 92, 95, 102, 108, 114, 127, 132, 137, 144}
 
 func (i Route) String() string {
 	if i < 0 || i >= Route(len(_Route_index)-1) {
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/monopole/mdrip/v2/internal/shell"
+)
+
+// ErrNotAllowed is returned for code having a command that
+// matches none of the allowed command patterns.
+var ErrNotAllowed = errors.New("command not allowed")
+
+// SetAllowedCommands limits the commands that code blocks may run,
+// e.g. for a locked down public demo.  Each command in a block, per
+// shell.SplitCommands, must match at least one of the regular
+// expressions, else the block is refused with status 403.  The
+// expressions are anchored, so "kubectl get .*" doesn't allow
+// "kubectl delete pod x # kubectl get".  If firstWord is true, they're
+// matched against each command's first word, e.g. "echo|kubectl",
+// rather than the whole command; that allows any arguments, including
+// redirections.  A block run by an interpreter counts as a single
+// command, the interpreter, so allowing it allows anything the block
+// says; the same goes for commands like eval, sh or xargs.
+// No patterns means allow everything; that's the default.
+func (ws *Server) SetAllowedCommands(patterns []string, firstWord bool) error {
+	ws.allowed = nil
+	ws.allowFirstWord = firstWord
+	for _, p := range patterns {
+		re, err := regexp.Compile("^(?:" + p + ")$")
+		if err != nil {
+			return fmt.Errorf("bad allowed command pattern %q; %w", p, err)
+		}
+		ws.allowed = append(ws.allowed, re)
+	}
+	return nil
+}
+
+// checkAllowed returns an error if the code, or its interpreter
+// if it has one, runs a command that isn't allowed.
+func (ws *Server) checkAllowed(code, interp string) error {
+	if len(ws.allowed) == 0 {
+		return nil
+	}
+	if interp != "" {
+		code = interp
+	}
+	cmds, err := shell.SplitCommands(code)
+	if err != nil {
+		return fmt.Errorf("%w; %w", ErrNotAllowed, err)
+	}
+	for _, c := range cmds {
+		if !ws.isAllowed(c) {
+			return fmt.Errorf("%w; %q matches none of the allowed patterns",
+				ErrNotAllowed, c)
+		}
+	}
+	return nil
+}
+
+func (ws *Server) isAllowed(cmd string) bool {
+	if ws.allowFirstWord {
+		cmd = strings.Fields(cmd)[0]
+	}
+	for _, re := range ws.allowed {
+		if re.MatchString(cmd) {
+			return true
+		}
+	}
+	return false
+}
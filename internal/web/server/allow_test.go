@@ -0,0 +1,99 @@
+package server_test
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/monopole/mdrip/v2/internal/shell/shelltest"
+	"github.com/monopole/mdrip/v2/internal/web/config"
+	. "github.com/monopole/mdrip/v2/internal/web/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowedCommands(t *testing.T) {
+	const md = "# hey\n" +
+		"```\nkubectl get pods\n```\n" + // 0
+		"```\necho hi | wc -l\n```\n" + // 1
+		"```\nkubectl delete pod web\n```\n" + // 2
+		"```\nkubectl get pods; rm -rf /\n```\n" + // 3
+		"```\necho $(rm -rf /)\n```\n" + // 4
+		"```\necho hi > /etc/motd\n```\n" + // 5
+		"```python\nprint('hi')\n```\n" // 6
+	for n, tc := range map[string]struct {
+		patterns  []string
+		firstWord bool
+		// allowed are the indices of the blocks that may run.
+		allowed []int
+	}{
+		"noPatterns": {allowed: []int{0, 1, 2, 3, 4, 5, 6}},
+		"wholeCommand": {
+			patterns: []string{`kubectl get \S+`, `echo [a-z ]*`, `wc -l`},
+			allowed:  []int{0, 1},
+		},
+		"firstWord": {
+			patterns:  []string{"kubectl", "echo|wc"},
+			firstWord: true,
+			allowed:   []int{0, 1, 2, 5},
+		},
+		"interpreter": {
+			patterns:  []string{"python3"},
+			firstWord: true,
+			allowed:   []int{6},
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			ex := shelltest.NewFakeExecutor()
+			s := makeServer(t, md, ex)
+			assert.NoError(t, s.SetAllowedCommands(tc.patterns, tc.firstWord))
+			h := s.Handler()
+			want := map[int]bool{}
+			for _, i := range tc.allowed {
+				want[i] = true
+			}
+			for i := 0; i < 7; i++ {
+				rec := doRequest(h, http.MethodPost,
+					config.Dynamic(config.RouteRunBlock)+"?"+
+						config.KeyMdSessID+"=abc&"+config.KeyMdFileIndex+"=0&"+
+						config.KeyBlockIndex+"="+strconv.Itoa(i)+
+						"&"+config.KeyInterp+"="+interpFor(i))
+				if want[i] {
+					assert.Equal(t, http.StatusOK, rec.Code, "block %d", i)
+					continue
+				}
+				assert.Equal(t, http.StatusForbidden, rec.Code, "block %d", i)
+				assert.Contains(t, rec.Body.String(), ErrNotAllowed.Error())
+			}
+			assert.Len(t, ex.Calls(), len(tc.allowed))
+		})
+	}
+}
+
+// interpFor returns the interpreter for the i'th block of
+// TestAllowedCommands.
+func interpFor(i int) string {
+	if i == 6 {
+		return "python3"
+	}
+	return ""
+}
+
+func TestAllowedCommandsRunTag(t *testing.T) {
+	ex := shelltest.NewFakeExecutor()
+	s := makeServer(t, "# hey\n"+
+		"<!-- @smoke -->\n```\nkubectl get pods\n```\n"+
+		"<!-- @smoke -->\n```\nkubectl delete pod web\n```\n", ex)
+	assert.NoError(t, s.SetAllowedCommands([]string{`kubectl get .*`}, false))
+	rec := doPost(s.Handler(), config.Dynamic(config.RouteRunTag)+
+		"?"+config.KeyLabel+"=smoke", "", "")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"kubectl delete pod web"`)
+	// Nothing ran, not even the allowed block.
+	assert.Empty(t, ex.Calls())
+}
+
+func TestSetAllowedCommandsBadPattern(t *testing.T) {
+	s := makeServer(t, "# hey\n```\necho hi\n```\n", shelltest.NewFakeExecutor())
+	err := s.SetAllowedCommands([]string{"echo (hi"}, false)
+	assert.ErrorContains(t, err, "echo (hi")
+}
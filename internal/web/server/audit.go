@@ -0,0 +1,144 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/session"
+	"github.com/monopole/mdrip/v2/internal/web/config"
+	"github.com/monopole/mdrip/v2/runner"
+)
+
+const (
+	// maxAuditCodeLen is the most code, in bytes, put in an audit record.
+	maxAuditCodeLen = 500
+	// redacted replaces the secrets masked by RedactPatterns.
+	redacted = "***"
+)
+
+// Redactor masks secrets, e.g. passwords, in code before it's
+// written to the audit log.
+type Redactor func(code string) string
+
+// RedactPatterns returns a Redactor replacing the text matching
+// any of the regular expressions with "***".  If a pattern has
+// groups, only the text matching the groups is replaced, e.g.
+// `TOKEN=(\S+)` masks the token but not the name.
+func RedactPatterns(patterns []string) (Redactor, error) {
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("bad redaction pattern; %w", err)
+		}
+		res[i] = re
+	}
+	return func(code string) string {
+		for _, re := range res {
+			code = redactMatches(re, code)
+		}
+		return code
+	}, nil
+}
+
+// redactMatches replaces the matches of re in s, or the groups
+// within the matches if re has groups.
+func redactMatches(re *regexp.Regexp, s string) string {
+	if re.NumSubexp() == 0 {
+		return re.ReplaceAllLiteralString(s, redacted)
+	}
+	var out []byte
+	last := 0
+	for _, m := range re.FindAllStringSubmatchIndex(s, -1) {
+		for g := 2; g < len(m); g += 2 {
+			if m[g] < last {
+				// The group didn't match, or is inside one done already.
+				continue
+			}
+			out = append(append(out, s[last:m[g]]...), redacted...)
+			last = m[g+1]
+		}
+	}
+	return string(append(out, s[last:]...))
+}
+
+// SetAuditLog turns on, or off, logging a record of each code
+// block run: who ran it, what, when, and how it turned out.
+func (ws *Server) SetAuditLog(on bool) {
+	ws.auditLog = on
+}
+
+// SetRedactor sets the function masking secrets in the code put
+// in audit records; nil means put the code as is.
+func (ws *Server) SetRedactor(r Redactor) {
+	ws.redactor = r
+}
+
+// audit logs a record of running the block, if audit logging is on.
+// The record holds the block's code as written, not as wrapped to
+// run, with the interpreter and environment, if any, given apart.
+// The code and environment are redacted, and the code truncated.
+func (ws *Server) audit(
+	req *http.Request, sessID session.TypeSessID, block *loader.CodeBlock,
+	interp string, start time.Time, d time.Duration, out *shell.Result, err error) {
+	if !ws.auditLog {
+		return
+	}
+	code := block.Code()
+	env := strings.Join(loader.ParseEnvOverrides(block.Labels()), " ")
+	if ws.redactor != nil {
+		code, env = ws.redactor(code), ws.redactor(env)
+	}
+	if len(code) > maxAuditCodeLen {
+		// Back off to the start of a rune, so as not to split one.
+		n := maxAuditCodeLen
+		for n > 0 && !utf8.RuneStart(code[n]) {
+			n--
+		}
+		code = code[:n] + "..."
+	}
+	attrs := []any{
+		config.KeyMdSessID, sessID,
+		"remoteAddr", req.RemoteAddr,
+		"file", block.Path(),
+		"block", block.UniqName(),
+		"code", code,
+		"start", start.UTC().Format(time.RFC3339Nano),
+		"duration", d,
+	}
+	if interp != "" {
+		attrs = append(attrs, config.KeyInterp, interp)
+	}
+	if env != "" {
+		attrs = append(attrs, "env", env)
+	}
+	if out != nil {
+		attrs = append(attrs, "exitCode", out.ExitCode)
+	}
+	if err != nil {
+		attrs = append(attrs, "err", err)
+	}
+	logger(req).Info("audit", attrs...)
+}
+
+// auditBlocks audits the blocks run per runner.RunBlocks, skipping
+// those that didn't run.  The results match the blocks in order,
+// though there may be fewer of them if the run stopped early.
+func (ws *Server) auditBlocks(
+	req *http.Request, blocks []*loader.CodeBlock, results []runner.BlockResult) {
+	sessID := session.TypeSessID(req.URL.Query().Get(config.KeyMdSessID))
+	for i := range results {
+		r := &results[i]
+		if r.Skipped || r.Start.IsZero() {
+			continue
+		}
+		ws.audit(req, sessID, blocks[i], blocks[i].Interpreter(), r.Start, r.Duration,
+			&shell.Result{ExitCode: r.ExitCode}, r.Err)
+	}
+}
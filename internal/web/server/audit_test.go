@@ -0,0 +1,194 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/monopole/mdrip/v2/internal/shell/shelltest"
+	"github.com/monopole/mdrip/v2/internal/web/config"
+	. "github.com/monopole/mdrip/v2/internal/web/server"
+	"github.com/stretchr/testify/assert"
+)
+
+// auditRecords returns the audit records among the JSON log lines.
+func auditRecords(t *testing.T, logs string) (result []map[string]any) {
+	for _, line := range strings.Split(strings.TrimSpace(logs), "\n") {
+		if line == "" {
+			continue
+		}
+		var rec map[string]any
+		if !assert.NoError(t, json.Unmarshal([]byte(line), &rec), line) {
+			continue
+		}
+		if rec["msg"] == "audit" {
+			result = append(result, rec)
+		}
+	}
+	return
+}
+
+func TestAuditLog(t *testing.T) {
+	const md = "# hey\n<!-- @name=login -->\n```\nexport TOKEN=s3cret\nfalse\n```\n"
+	for n, tc := range map[string]struct {
+		on       bool
+		patterns []string
+		wantCode string
+	}{
+		"off": {},
+		"on": {
+			on:       true,
+			wantCode: "export TOKEN=s3cret\nfalse\n",
+		},
+		"redacted": {
+			on:       true,
+			patterns: []string{`TOKEN=(\S+)`},
+			wantCode: "export TOKEN=***\nfalse\n",
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			s := makeServer(t, md, shelltest.NewFakeExecutor().
+				OnMatch("false", shell.Result{ExitCode: 1}))
+			s.SetAuditLog(tc.on)
+			if tc.patterns != nil {
+				r, err := RedactPatterns(tc.patterns)
+				if !assert.NoError(t, err) {
+					t.FailNow()
+				}
+				s.SetRedactor(r)
+			}
+			h := s.Handler()
+			var buf bytes.Buffer
+			old := slog.Default()
+			slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+			defer slog.SetDefault(old)
+			req := httptest.NewRequest(http.MethodPost, runBlockUrl(""), nil)
+			req.Header.Set(HeaderRequestId, "req-7")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			records := auditRecords(t, buf.String())
+			if !tc.on {
+				assert.Empty(t, records)
+				return
+			}
+			if !assert.Equal(t, 1, len(records)) {
+				t.FailNow()
+			}
+			r := records[0]
+			assert.Equal(t, "INFO", r["level"])
+			assert.Equal(t, "req-7", r["requestId"])
+			assert.Equal(t, "abc", r[config.KeyMdSessID])
+			assert.Equal(t, "a.md", r["file"])
+			assert.Equal(t, "login", r["block"])
+			assert.Equal(t, tc.wantCode, r["code"])
+			assert.Equal(t, float64(1), r["exitCode"])
+			assert.Contains(t, r, "start")
+			assert.Contains(t, r, "duration")
+			assert.Contains(t, r, "remoteAddr")
+			assert.NotContains(t, r, "err")
+		})
+	}
+}
+
+func TestAuditLogRunTagAndFile(t *testing.T) {
+	const md = "# hey\n<!-- @smoke -->\n```\necho a\n```\n" +
+		"<!-- @smoke @skip -->\n```\necho b\n```\n" +
+		"<!-- @smoke -->\n```\nfalse\n```\n"
+	for n, url := range map[string]string{
+		"runTag": config.Dynamic(config.RouteRunTag) +
+			"?" + config.KeyLabel + "=smoke",
+		"runFile": config.Dynamic(config.RouteRunFile) +
+			"?" + config.KeyMdFileIndex + "=0",
+	} {
+		t.Run(n, func(t *testing.T) {
+			s := makeServer(t, md, shelltest.NewFakeExecutor().
+				OnMatch("false", shell.Result{ExitCode: 1}))
+			s.SetAuditLog(true)
+			var buf bytes.Buffer
+			old := slog.Default()
+			slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+			defer slog.SetDefault(old)
+			rec := doPost(s.Handler(), url, "text/plain", "")
+			assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+			records := auditRecords(t, buf.String())
+			if !assert.Equal(t, 2, len(records)) {
+				t.FailNow()
+			}
+			assert.Equal(t, "echo a\n", records[0]["code"])
+			assert.Equal(t, float64(0), records[0]["exitCode"])
+			assert.Equal(t, "false\n", records[1]["code"])
+			assert.Equal(t, float64(1), records[1]["exitCode"])
+			for _, r := range records {
+				assert.Equal(t, "a.md", r["file"])
+				assert.Contains(t, r, "start")
+				assert.Contains(t, r, "duration")
+			}
+		})
+	}
+}
+
+func TestAuditLogUnwrappedCode(t *testing.T) {
+	const md = "# hey\n<!-- @env=DEBUG=1 -->\n```\nprint('hi')\n```\n"
+	s := makeServer(t, md, shelltest.NewFakeExecutor())
+	s.SetAuditLog(true)
+	var buf bytes.Buffer
+	old := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	defer slog.SetDefault(old)
+	rec := doPost(s.Handler(), runBlockUrl("&"+config.KeyInterp+"=python3&"+
+		config.KeyTrace+"=true"), "text/plain", "")
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	records := auditRecords(t, buf.String())
+	if !assert.Equal(t, 1, len(records)) {
+		t.FailNow()
+	}
+	assert.Equal(t, "print('hi')\n", records[0]["code"])
+	assert.Equal(t, "python3", records[0][config.KeyInterp])
+	assert.Equal(t, "DEBUG=1", records[0]["env"])
+}
+
+func TestAuditLogTruncatesOnRuneBoundary(t *testing.T) {
+	// The 500 byte limit falls in the middle of the last "é".
+	code := strings.Repeat("a", 499) + "éé"
+	s := makeServer(t, "# hey\n```\n"+code+"\n```\n", shelltest.NewFakeExecutor())
+	s.SetAuditLog(true)
+	var buf bytes.Buffer
+	old := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	defer slog.SetDefault(old)
+	rec := doPost(s.Handler(), runBlockUrl(""), "text/plain", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	records := auditRecords(t, buf.String())
+	if !assert.Equal(t, 1, len(records)) {
+		t.FailNow()
+	}
+	got := records[0]["code"].(string)
+	assert.True(t, utf8.ValidString(got))
+	assert.Equal(t, strings.Repeat("a", 499)+"...", got)
+}
+
+func TestRedactPatterns(t *testing.T) {
+	r, err := RedactPatterns([]string{
+		`(?i)password=(\S+)`, `ghp_[A-Za-z0-9]+`, `user=(\w+):(\w+)`})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t,
+		"login PASSWORD=*** user=***:*** --token ***\n",
+		r("login PASSWORD=hunter2 user=bob:pw --token ghp_abc123\n"))
+	assert.Equal(t, "nothing to hide", r("nothing to hide"))
+
+	_, err = RedactPatterns([]string{"("})
+	assert.Error(t, err)
+}
@@ -4,19 +4,30 @@ import (
 	"bytes"
 	"fmt"
 	"html/template"
+	"io"
+	"io/fs"
 	"log/slog"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/monopole/mdrip/v2/internal/loader"
 	"github.com/monopole/mdrip/v2/internal/parsren"
+	"github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/monopole/mdrip/v2/internal/web/app"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/appstate"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/common"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/mdrip"
+	"github.com/monopole/mdrip/v2/internal/web/app/widget/session"
 )
 
 // DataLoader is an embarrassment.
 // It's a computation cache around FsLoader.
+// It's safe for concurrent use; loads take a write lock, so that
+// readers never see a partially loaded state.
 type DataLoader struct {
+	// mu guards the fields below it.
+	mu          sync.RWMutex
 	ldr         *loader.FsLoader
 	pRen        parsren.MdParserRenderer
 	paths       []string
@@ -25,6 +36,32 @@ type DataLoader struct {
 	loadTime    time.Time
 	navLeftRoot template.HTML
 	appState    *appstate.AppState
+	// pages caches the rendered web app until the next load.
+	pages map[pageKey][]byte
+	// hash is the hash of the loaded content, per loader.VisitorHasher.
+	hash string
+	// changed is true if the most recent load's content differs
+	// from that of the load before it.
+	changed bool
+}
+
+// pageKey is what a rendering of the web app depends on,
+// beyond the loaded data.
+type pageKey struct {
+	// fileIndex is the index of the initial file to show.
+	fileIndex int
+	isNavOn   bool
+	isTitleOn bool
+	theme     string
+}
+
+// pageConfig is what a rendering of the web app depends on
+// that's fixed for the life of the server.
+type pageConfig struct {
+	// routePrefix, if not empty, precedes every path the app uses.
+	routePrefix string
+	// extraCss are the URLs of stylesheets to load after mdrip's own.
+	extraCss []string
 }
 
 const maxAge = 30 * time.Second
@@ -42,19 +79,68 @@ func NewDataLoader(
 	}
 }
 
+// NewDataLoaderFS returns a DataLoader for the markdown at the
+// given paths in the file system, e.g. an embed.FS, for serving
+// docs baked into a binary.  Paths are per fs.FS, e.g. ".".
+func NewDataLoaderFS(
+	fsys fs.FS, paths []string,
+	pRen parsren.MdParserRenderer, title string) *DataLoader {
+	return NewDataLoader(
+		loader.NewFromFS(fsys, loader.IsMarkDownFile, loader.InNotIgnorableFolder),
+		paths, pRen, title)
+}
+
 func (dl *DataLoader) Title() string {
 	return dl.title
 }
 
+// RenderedFiles returns the files from the most recent load.
+// A reload replaces, rather than modifies, the returned slice.
 func (dl *DataLoader) RenderedFiles() []*parsren.RenderedMdFile {
+	dl.mu.RLock()
+	defer dl.mu.RUnlock()
 	return dl.pRen.RenderedMdFiles()
 }
 
 func (dl *DataLoader) AllBlocks() []*loader.CodeBlock {
+	dl.mu.RLock()
+	defer dl.mu.RUnlock()
 	return dl.pRen.Filter(func(b *loader.CodeBlock) bool { return true })
 }
 
-func (dl *DataLoader) LoadAndRender() (err error) {
+// BlocksByLabel returns the blocks having the label, across all
+// the loaded files, in document order.
+func (dl *DataLoader) BlocksByLabel(l loader.Label) []*loader.CodeBlock {
+	dl.mu.RLock()
+	defer dl.mu.RUnlock()
+	return dl.pRen.Filter(func(b *loader.CodeBlock) bool { return b.HasLabel(l) })
+}
+
+// maxNavWordLength is needed to render JS and CSS.
+func (dl *DataLoader) maxNavWordLength() int {
+	dl.mu.RLock()
+	defer dl.mu.RUnlock()
+	return dl.appState.Facts.MaxNavWordLength
+}
+
+// LoadAndRender loads and renders the markdown, unless
+// it was recently loaded.
+func (dl *DataLoader) LoadAndRender() error {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	return dl.loadAndRender()
+}
+
+// Reload loads and renders the markdown, no matter how
+// recently it was loaded.
+func (dl *DataLoader) Reload() error {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.loadTime = time.UnixMicro(0)
+	return dl.loadAndRender()
+}
+
+func (dl *DataLoader) loadAndRender() (err error) {
 	if len(dl.paths) == 0 {
 		return fmt.Errorf("specify some paths to load")
 	}
@@ -74,14 +160,22 @@ func (dl *DataLoader) LoadAndRender() (err error) {
 		return fmt.Errorf("no markdown found at %s", dl.paths)
 	}
 	dl.loadTime = time.Now()
+	{
+		vh := loader.NewVisitorHasher()
+		dl.folder.Accept(vh)
+		dl.changed = vh.Sum() != dl.hash
+		dl.hash = vh.Sum()
+	}
 	{
 		vc := loader.NewVisitorCounter()
 		dl.folder.Accept(vc)
 		slog.Debug("Loaded",
 			"top", dl.folder.Path(),
 			"numFolders", vc.NumFolders,
-			"numFiles", vc.NumFiles)
+			"numFiles", vc.NumFiles,
+			"changed", dl.changed)
 	}
+	dl.pages = make(map[pageKey][]byte)
 	dl.navLeftRoot, dl.appState = mdrip.RenderFolder(
 		&mdrip.RenderingArgs{
 			Pr:         dl.pRen,
@@ -90,11 +184,106 @@ func (dl *DataLoader) LoadAndRender() (err error) {
 			Title:      dl.title,
 		},
 	)
+	if pErr := dl.pRen.Error(); pErr != nil {
+		// Serve what rendered; the author can fix the rest.
+		slog.Warn("trouble with markdown", "err", pErr)
+	}
 	return
 }
 
-func (dl *DataLoader) makeLastLoadTimeVeryOld() {
-	dl.loadTime = time.UnixMicro(0)
+// LoadStatus describes the most recent data load.
+type LoadStatus struct {
+	// LoadTime is the time of the last load in Unix milliseconds.
+	LoadTime int64 `json:"loadTime"`
+	// NumFiles is the number of rendered files.
+	NumFiles int `json:"numFiles"`
+	// Hash is a hash of the loaded files' paths and content, so
+	// that a client can tell if a reload changed anything.
+	Hash string `json:"hash"`
+	// Changed is true if the content differs from that of the load
+	// before, or if there was no load before.
+	Changed bool `json:"changed"`
+}
+
+// Status returns the status of the most recent load.
+func (dl *DataLoader) Status() LoadStatus {
+	dl.mu.RLock()
+	defer dl.mu.RUnlock()
+	return LoadStatus{
+		LoadTime: dl.loadTime.UnixMilli(),
+		NumFiles: len(dl.pRen.RenderedMdFiles()),
+		Hash:     dl.hash,
+		Changed:  dl.changed,
+	}
+}
+
+// renderApp renders the web app with the file at the given path as
+// the initial file, and the layout and theme saved in the session,
+// loading the extra stylesheets after its own.  Pages are cached,
+// so extraCss must not change.
+func (dl *DataLoader) renderApp(
+	path string, sess *session.Bucket, cfg *pageConfig) ([]byte, error) {
+	// Rendering modifies the app state, so take the write lock.
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.appState.SetInitialFileIndex(path)
+	dl.appState.Facts.IsNavVisible = sess.IsNavOn
+	dl.appState.Facts.IsTitleVisible = sess.IsHeaderOn
+	key := pageKey{
+		fileIndex: dl.appState.Facts.InitialFileIndex,
+		isNavOn:   sess.IsNavOn,
+		isTitleOn: sess.IsHeaderOn,
+		theme:     sess.Theme,
+	}
+	if page, ok := dl.pages[key]; ok {
+		return page, nil
+	}
+	tmpl, err := common.ParseAsHtmlTemplate(app.AsTmpl())
+	if err != nil {
+		return nil, fmt.Errorf("template parsing fail; %w", err)
+	}
+	tps := mdrip.MakeParams(dl.navLeftRoot, dl.appState)
+	tps.AddRoutePrefix(cfg.routePrefix)
+	var buf bytes.Buffer
+	if err = tmpl.ExecuteTemplate(&buf, app.TmplName, &app.TmplParams{
+		TmplParams: tps,
+		Theme:      sess.Theme,
+		ExtraCss:   cfg.extraCss,
+	}); err != nil {
+		return nil, fmt.Errorf("template rendering failure; %w", err)
+	}
+	dl.pages[key] = buf.Bytes()
+	return buf.Bytes(), nil
+}
+
+// dump writes the loaded folder and code blocks, for debugging.
+func (dl *DataLoader) dump(wr io.Writer) {
+	dl.mu.RLock()
+	defer dl.mu.RUnlock()
+	dl.folder.Accept(loader.NewVisitorDump(wr))
+	loader.PrintBlocks(wr,
+		dl.pRen.Filter(func(b *loader.CodeBlock) bool { return true }))
+}
+
+// DebugDump is the JSON form of the debug page.
+type DebugDump struct {
+	// Tree is the loaded folder, with each file's code blocks.
+	Tree *loader.FolderDump `json:"tree"`
+	// NumBlocks is the number of code blocks across all files.
+	NumBlocks int `json:"numBlocks"`
+	// Shells describes the shells running the code blocks, if the
+	// executor can describe them.
+	Shells []shell.ShellInfo `json:"shells,omitempty"`
+}
+
+// dumpJson returns the loaded folder and code blocks, for debugging.
+func (dl *DataLoader) dumpJson() *DebugDump {
+	dl.mu.RLock()
+	defer dl.mu.RUnlock()
+	blocks := dl.pRen.Filter(func(b *loader.CodeBlock) bool { return true })
+	v := loader.NewVisitorJson(blocks)
+	dl.folder.Accept(v)
+	return &DebugDump{Tree: v.Result(), NumBlocks: len(blocks)}
 }
 
 func (dl *DataLoader) getDataSource() string {
@@ -0,0 +1,173 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/monopole/mdrip/v2/internal/parsren/usegold"
+	"github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/monopole/mdrip/v2/internal/web/config"
+	. "github.com/monopole/mdrip/v2/internal/web/server"
+	"github.com/stretchr/testify/assert"
+)
+
+// Run with -race to be useful.
+func TestConcurrentReloadsAndRenders(t *testing.T) {
+	h := makeServer(t, "# hey\n```\necho hi\n```\n", &shell.Echo{}).Handler()
+	urls := []struct {
+		method string
+		url    string
+	}{
+		{http.MethodPost, config.Dynamic(config.RouteReload)},
+		{http.MethodGet, "/"},
+		{http.MethodGet, "/a.md"},
+		{http.MethodGet, config.Dynamic(config.RouteHtmlForFile) +
+			"?" + config.KeyMdFileIndex + "=0"},
+		{http.MethodGet, config.Dynamic(config.RouteLabelsForFile) +
+			"?" + config.KeyMdFileIndex + "=0"},
+		{http.MethodGet, config.Dynamic(config.RouteLoadStatus)},
+		{http.MethodGet, config.Dynamic(config.RouteJs)},
+	}
+	const rounds = 10
+	var wg sync.WaitGroup
+	for i := 0; i < rounds; i++ {
+		for _, u := range urls {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rec := doRequest(h, u.method, u.url)
+				assert.Equal(t, http.StatusOK, rec.Code,
+					u.url+" round "+strconv.Itoa(i))
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+func TestRenderedPageRefreshedOnReload(t *testing.T) {
+	const secondFile = "navLeftFileId1"
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(
+		filepath.Join(dir, "a.md"), []byte("# a\n"), 0644))
+	h := makeServerInDir(t, dir, &shell.Echo{}).Handler()
+	rec := doRequest(h, http.MethodGet, "/a.md")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), secondFile)
+
+	assert.NoError(t, os.WriteFile(
+		filepath.Join(dir, "b.md"), []byte("# b\n"), 0644))
+	rec = doRequest(h, http.MethodGet, "/a.md")
+	assert.NotContains(t, rec.Body.String(), secondFile, "should be cached")
+
+	doRequest(h, http.MethodPost, config.Dynamic(config.RouteReload))
+	rec = doRequest(h, http.MethodGet, "/a.md")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), secondFile)
+}
+
+func TestReloadReportsChange(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.md")
+	assert.NoError(t, os.WriteFile(path, []byte("# a\n"), 0644))
+	h := makeServerInDir(t, dir, &shell.Echo{}).Handler()
+	reload := func() LoadStatus {
+		rec := doRequest(h, http.MethodPost, config.Dynamic(config.RouteReload))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var ls LoadStatus
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ls))
+		return ls
+	}
+	first := reload()
+	assert.False(t, first.Changed)
+	assert.NotEmpty(t, first.Hash)
+
+	// Rewriting the same content changes nothing.
+	assert.NoError(t, os.WriteFile(path, []byte("# a\n"), 0644))
+	ls := reload()
+	assert.False(t, ls.Changed)
+	assert.Equal(t, first.Hash, ls.Hash)
+
+	assert.NoError(t, os.WriteFile(path, []byte("# a\n\nmore\n"), 0644))
+	ls = reload()
+	assert.True(t, ls.Changed)
+	assert.NotEqual(t, first.Hash, ls.Hash)
+
+	// A new file is a change, as is its removal.
+	assert.NoError(t, os.WriteFile(
+		filepath.Join(dir, "b.md"), []byte("# b\n"), 0644))
+	assert.True(t, reload().Changed)
+	assert.NoError(t, os.Remove(filepath.Join(dir, "b.md")))
+	assert.True(t, reload().Changed)
+	assert.False(t, reload().Changed)
+}
+
+func TestServeFromFS(t *testing.T) {
+	dl := NewDataLoaderFS(fstest.MapFS{
+		"a.md":        {Data: []byte("# hey\n```\necho hi\n```\n")},
+		"img/pic.png": {Data: []byte("pixels")},
+	}, []string{"."}, usegold.NewGParser(), "test")
+	if !assert.NoError(t, dl.LoadAndRender()) {
+		t.FailNow()
+	}
+	s, err := NewServer(dl, &shell.Echo{})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	h := s.Handler()
+	rec := doRequest(h, http.MethodGet, config.Dynamic(config.RouteFiles))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var files []FileInfo
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &files))
+	if assert.Len(t, files, 1) {
+		assert.Equal(t, "a.md", files[0].Path)
+		assert.Equal(t, 1, files[0].BlockCount)
+	}
+	rec = doRequest(h, http.MethodGet, "/a.md")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Files that aren't markdown are served from the same FS.
+	rec = doRequest(h, http.MethodGet, "/img/pic.png")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "pixels", rec.Body.String())
+	rec = doRequest(h, http.MethodGet, "/img/nope.png")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+// The cold case includes the reload that empties the page cache.
+func BenchmarkRenderWebApp(b *testing.B) {
+	dir := b.TempDir()
+	var md strings.Builder
+	for i := 0; i < 50; i++ {
+		md.WriteString("## section " + strconv.Itoa(i) + "\n```\necho hi\n```\n")
+	}
+	for i := 0; i < 10; i++ {
+		if err := os.WriteFile(filepath.Join(
+			dir, "f"+strconv.Itoa(i)+".md"), []byte(md.String()), 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	h := makeServerInDir(b, dir, &shell.Echo{}).Handler()
+	render := func(b *testing.B) {
+		if rec := doRequest(h, http.MethodGet, "/f3.md"); rec.Code != http.StatusOK {
+			b.Fatalf("got status %d", rec.Code)
+		}
+	}
+	b.Run("cold", func(b *testing.B) {
+		for b.Loop() {
+			doRequest(h, http.MethodPost, config.Dynamic(config.RouteReload))
+			render(b)
+		}
+	})
+	b.Run("warm", func(b *testing.B) {
+		for b.Loop() {
+			render(b)
+		}
+	})
+}
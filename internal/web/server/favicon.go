@@ -0,0 +1,40 @@
+package server
+
+import (
+	"embed"
+	"net/http"
+)
+
+const (
+	mimeGif = "image/gif"
+	mimePng = "image/png"
+
+	faviconFile = "favicon.png"
+)
+
+// faviconFs holds the static favicon, a frame of the Lissajous figure.
+//
+//go:embed favicon.png
+var faviconFs embed.FS
+
+// SetStaticFavicon arranges for the favicon to be a static image,
+// rather than an animated Lissajous figure generated per request.
+func (ws *Server) SetStaticFavicon(b bool) {
+	ws.staticFavicon = b
+}
+
+func (ws *Server) handleFavicon(w http.ResponseWriter, r *http.Request) {
+	if !ws.staticFavicon {
+		w.Header().Set("Content-Type", mimeGif)
+		Lissajous(w, 7, 3, 1)
+		return
+	}
+	data, err := faviconFs.ReadFile(faviconFile)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", mimePng)
+	w.Header().Set("Cache-Control", "max-age=86400")
+	_, _ = w.Write(data)
+}
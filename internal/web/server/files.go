@@ -0,0 +1,89 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
+)
+
+// FileInfo describes one of the loaded markdown files.
+type FileInfo struct {
+	// Index identifies the file in other requests, e.g. for its HTML.
+	Index int `json:"index"`
+	// Path is the path to the file.
+	Path string `json:"path"`
+	// Title is the text of the file's first heading, or if it
+	// has none, the file's name sans extension.
+	Title string `json:"title"`
+	// BlockCount is the number of code blocks in the file.
+	BlockCount int `json:"blockCount"`
+	// Labels are the categories of the file's blocks, per
+	// loader.NewUserLabelList, i.e. without special labels.
+	Labels []string `json:"labels"`
+}
+
+// handleGetFiles sends a FileInfo for each loaded file, in order.
+func (ws *Server) handleGetFiles(wr http.ResponseWriter, req *http.Request) {
+	jsn, err := json.Marshal(ws.dLoader.files())
+	if err != nil {
+		writeError(wr, req, http.StatusInternalServerError,
+			fmt.Errorf("handleGetFiles marshal; %w", err))
+		return
+	}
+	wr.Header().Set("Content-Type", "application/json")
+	_, _ = wr.Write(jsn)
+}
+
+// files returns a FileInfo for each loaded file.
+func (dl *DataLoader) files() []FileInfo {
+	dl.mu.RLock()
+	defer dl.mu.RUnlock()
+	src := make(sourceCollector)
+	if dl.folder != nil {
+		dl.folder.Accept(src)
+	}
+	result := []FileInfo{}
+	for _, f := range dl.pRen.RenderedMdFiles() {
+		result = append(result, FileInfo{
+			Index:      f.Index,
+			Path:       string(f.Path),
+			Title:      fileTitle(f.Path, src[f.Path]),
+			BlockCount: len(f.Blocks),
+			Labels:     loader.NewUserLabelList(f.Blocks).Strings(),
+		})
+	}
+	return result
+}
+
+// atxHeading matches a line like "## Title ##".
+var atxHeading = regexp.MustCompile(`^ {0,3}#{1,6}[ \t]+(.*?)(?:[ \t]+#+)?[ \t]*$`)
+
+// fileTitle returns the text of the first ATX heading in the
+// markdown, skipping fenced code, else the file's base name sans
+// extension.
+func fileTitle(path loader.FilePath, md []byte) string {
+	inFence := false
+	for _, line := range bytes.Split(md, []byte("\n")) {
+		trimmed := bytes.TrimLeft(line, " ")
+		if bytes.HasPrefix(trimmed, []byte("```")) ||
+			bytes.HasPrefix(trimmed, []byte("~~~")) {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			continue
+		}
+		if m := atxHeading.FindSubmatch(bytes.TrimRight(line, "\r")); m != nil &&
+			len(m[1]) > 0 {
+			return string(m[1])
+		}
+	}
+	base := filepath.Base(string(path))
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
@@ -0,0 +1,63 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/monopole/mdrip/v2/internal/web/config"
+	. "github.com/monopole/mdrip/v2/internal/web/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleGetFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, md := range map[string]string{
+		"a.md": "Intro.\n\n```\n# not a heading\necho a\n```\n\n" +
+			"## Apples ##\n\n<!-- @fruit @sleep -->\n```\necho b\n```\n\n# Later\n",
+		"b.md": "No heading here.\n\n```\necho c\n```\n",
+	} {
+		assert.NoError(t, os.WriteFile(
+			filepath.Join(dir, name), []byte(md), 0644))
+	}
+	h := makeServerInDir(t, dir, &shell.Echo{}).Handler()
+	rec := doRequest(h, http.MethodGet, config.Dynamic(config.RouteFiles))
+	if !assert.Equal(t, http.StatusOK, rec.Code) {
+		return
+	}
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var got []FileInfo
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, []FileInfo{
+		{Index: 0, Path: "a.md", Title: "Apples", BlockCount: 2,
+			Labels: []string{"fruit"}},
+		{Index: 1, Path: "b.md", Title: "b", BlockCount: 1, Labels: []string{}},
+	}, got)
+}
+
+func TestFileIndicesStableAcrossReloads(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"zed.md", "sub/b.md", "Apple.md", "a.md"} {
+		p := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		assert.NoError(t, os.WriteFile(p, []byte("# "+name+"\n"), 0644))
+	}
+	h := makeServerInDir(t, dir, &shell.Echo{}).Handler()
+	want := []string{"Apple.md", "a.md", "zed.md", "sub/b.md"}
+	for i := 0; i < 5; i++ {
+		rec := doRequest(h, http.MethodPost, config.Dynamic(config.RouteReload))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		rec = doRequest(h, http.MethodGet, config.Dynamic(config.RouteFiles))
+		var got []FileInfo
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+		var paths []string
+		for j, f := range got {
+			assert.Equal(t, j, f.Index)
+			paths = append(paths, f.Path)
+		}
+		assert.Equal(t, want, paths, "reload %d", i)
+	}
+}
@@ -1,17 +1,23 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	htmlTmpl "html/template"
-	"log/slog"
+	"mime"
 	"net/http"
 	"os"
+	"strings"
 	"time"
+	"unicode"
 
+	"github.com/monopole/mdrip/v2/internal/ansi"
 	"github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/monopole/mdrip/v2/internal/parsren"
+	"github.com/monopole/mdrip/v2/internal/provenance"
+	"github.com/monopole/mdrip/v2/internal/shell"
 	"github.com/monopole/mdrip/v2/internal/web/app"
-	"github.com/monopole/mdrip/v2/internal/web/app/widget/common"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/mdrip"
 	"github.com/monopole/mdrip/v2/internal/web/app/widget/session"
 	"github.com/monopole/mdrip/v2/internal/web/config"
@@ -21,185 +27,593 @@ import (
 // handleRenderWebApp sends a full "single-page" web app.
 // The app does XHRs as you click around or use keys.
 func (ws *Server) handleRenderWebApp(wr http.ResponseWriter, req *http.Request) {
-	slog.Debug("Rendering web app", "req", req.URL)
+	logger(req).Debug("Rendering web app", "req", req.URL)
 	var err error
-	mySess, _ := ws.store.Get(req, cookieName)
+	mySess, _ := ws.store.Get(req, ws.cookieName)
 	session.AssureDefaults(mySess)
 	if err = mySess.Save(req, wr); err != nil {
-		write500(wr, fmt.Errorf("session save fail; %w", err))
+		writeError(wr, req, http.StatusInternalServerError,
+			fmt.Errorf("session save fail; %w", err))
 		return
 	}
 	if err = ws.dLoader.LoadAndRender(); err != nil {
-		write500(wr, fmt.Errorf("data loader fail; %w", err))
+		writeError(wr, req, http.StatusInternalServerError,
+			fmt.Errorf("data loader fail; %w", err))
 		return
 	}
-	var tmpl *htmlTmpl.Template
-	tmpl, err = common.ParseAsHtmlTemplate(app.AsTmpl())
+	page, err := ws.dLoader.renderApp(
+		req.URL.Path, session.ConvertToBucket(mySess), &pageConfig{
+			routePrefix: ws.routePrefix,
+			extraCss:    ws.extraCss,
+		})
 	if err != nil {
-		write500(wr, fmt.Errorf("template parsing fail; %w", err))
-		return
-	}
-	ws.dLoader.appState.SetInitialFileIndex(req.URL.Path)
-	err = tmpl.ExecuteTemplate(
-		wr, app.TmplName,
-		mdrip.MakeParams(ws.dLoader.navLeftRoot, ws.dLoader.appState))
-	if err != nil {
-		write500(wr, fmt.Errorf("template rendering failure; %w", err))
+		writeError(wr, req, http.StatusInternalServerError, err)
 		return
 	}
+	wr.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = wr.Write(page)
 }
 
 func (ws *Server) handleSaveSession(w http.ResponseWriter, r *http.Request) {
-	slog.Debug("Saving session", "req", r.URL)
-	s, err := ws.store.Get(r, cookieName)
+	logger(r).Debug("Saving session", "req", r.URL)
+	s, err := ws.store.Get(r, ws.cookieName)
 	if err != nil {
-		write500(w, err)
+		writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	// Params that are absent leave the saved values alone,
+	// so that e.g. the theme may be saved by itself.
+	session.AssureDefaults(s)
+	old := session.ConvertToBucket(s)
+	isNavOn, err1 := parseBoolParam(config.KeyIsNavOn, r, old.IsNavOn)
+	isTitleOn, err2 := parseBoolParam(config.KeyIsTitleOn, r, old.IsHeaderOn)
+	mdFileIndex, err3 := parseIntParam(config.KeyMdFileIndex, r, old.MdFileIndex)
+	blockIndex, err4 := parseIntParam(config.KeyBlockIndex, r, old.BlockIndex)
+	theme, err5 := parseThemeParam(r, old.Theme)
+	if err = errors.Join(err1, err2, err3, err4, err5); err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
 		return
 	}
-	s.Values[config.KeyIsNavOn] = getBoolParam(config.KeyIsNavOn, r, false)
-	s.Values[config.KeyIsTitleOn] = getBoolParam(config.KeyIsTitleOn, r, false)
-	s.Values[config.KeyMdFileIndex] = getIntParam(config.KeyMdFileIndex, r, 0)
-	s.Values[config.KeyBlockIndex] = getIntParam(config.KeyBlockIndex, r, 0)
+	s.Values[config.KeyIsNavOn] = isNavOn
+	s.Values[config.KeyIsTitleOn] = isTitleOn
+	s.Values[config.KeyMdFileIndex] = mdFileIndex
+	s.Values[config.KeyBlockIndex] = blockIndex
+	s.Values[config.KeyTheme] = theme
 	if err = s.Save(r, w); err != nil {
-		slog.Error("unable to save session", "err", err)
+		logger(r).Error("unable to save session", "err", err)
 	}
 	_, _ = fmt.Fprintln(w, "Ok")
-	slog.Debug("Saved session.")
+	logger(r).Debug("Saved session.")
 }
 
 func (ws *Server) handleGetHtmlForFile(wr http.ResponseWriter, req *http.Request) {
-	slog.Debug("handleGetHtmlForFile ", "req", req.URL)
+	logger(req).Debug("handleGetHtmlForFile ", "req", req.URL)
 	f, err := ws.getRenderedMdFile(req)
 	if err != nil {
-		write500(wr, fmt.Errorf("handleGetHtmlForFile render; %w", err))
+		writeError(wr, req, http.StatusInternalServerError,
+			fmt.Errorf("handleGetHtmlForFile render; %w", err))
 		return
 	}
+	// An HTML fragment doesn't always sniff as HTML.
+	wr.Header().Set("Content-Type", "text/html; charset=utf-8")
 	_, err = wr.Write([]byte(f.Html))
 	if err != nil {
-		write500(wr, fmt.Errorf("handleGetHtmlForFile write; %w", err))
+		writeError(wr, req, http.StatusInternalServerError,
+			fmt.Errorf("handleGetHtmlForFile write; %w", err))
+		return
+	}
+	logger(req).Debug("handleGetHtmlForFile success")
+}
+
+// handleGetBlocksForFile sends a BlockInfo for each block in the file.
+// Unlike handleGetLabelsForFile, which sends only the block names,
+// it says how to treat each block.
+func (ws *Server) handleGetBlocksForFile(wr http.ResponseWriter, req *http.Request) {
+	logger(req).Debug("handleGetBlocksForFile ", "req", req.URL)
+	f, err := ws.getRenderedMdFile(req)
+	if err != nil {
+		writeError(wr, req, http.StatusInternalServerError,
+			fmt.Errorf("handleGetBlocksForFile render; %w", err))
+		return
+	}
+	infos := make([]BlockInfo, len(f.Blocks))
+	for i, b := range f.Blocks {
+		infos[i] = BlockInfo{
+			UniqName: b.UniqName(),
+			Language: b.Language(),
+			Labels:   b.Labels().Strings(),
+			Runnable: b.IsRunnable(),
+		}
+	}
+	jsn, err := json.Marshal(infos)
+	if err != nil {
+		writeError(wr, req, http.StatusInternalServerError,
+			fmt.Errorf("handleGetBlocksForFile marshal; %w", err))
 		return
 	}
-	slog.Debug("handleGetHtmlForFile success")
+	wr.Header().Set("Content-Type", "application/json")
+	_, _ = wr.Write(jsn)
 }
 
 func (ws *Server) handleGetLabelsForFile(wr http.ResponseWriter, req *http.Request) {
-	slog.Debug("handleGetLabelsForFile ", "req", req.URL)
+	logger(req).Debug("handleGetLabelsForFile ", "req", req.URL)
 	f, err := ws.getRenderedMdFile(req)
 	if err != nil {
-		write500(wr, fmt.Errorf("handleGetLabelsForFile render; %w", err))
+		writeError(wr, req, http.StatusInternalServerError,
+			fmt.Errorf("handleGetLabelsForFile render; %w", err))
 		return
 	}
 	var jsn []byte
 	jsn, err = json.Marshal(loader.NewBlockNameList(f.Blocks))
 	if err != nil {
-		write500(wr, fmt.Errorf("handleGetLabelsForFile marshal; %w", err))
+		writeError(wr, req, http.StatusInternalServerError,
+			fmt.Errorf("handleGetLabelsForFile marshal; %w", err))
 		return
 	}
+	wr.Header().Set("Content-Type", "application/json")
 	if _, err = wr.Write(jsn); err != nil {
-		write500(wr, fmt.Errorf("handleGetLabelsForFile write; %w", err))
+		writeError(wr, req, http.StatusInternalServerError,
+			fmt.Errorf("handleGetLabelsForFile write; %w", err))
 		return
 	}
-	slog.Debug("handleGetLabelsForFile success")
+	logger(req).Debug("handleGetLabelsForFile success")
 }
 
 func (ws *Server) handleGetJs(wr http.ResponseWriter, req *http.Request) {
-	slog.Debug("handleGetJs", "req", req.URL)
+	logger(req).Debug("handleGetJs", "req", req.URL)
 	ws.minifier.Write(wr, &minify.Args{
 		MimeType: app.MimeJs,
 		Tmpl: minify.TmplArgs{
-			Name: mdrip.TmplNameJs,
-			Body: mdrip.AsTmplJs(),
-			Params: mdrip.MakeBaseParams(
-				ws.dLoader.appState.Facts.MaxNavWordLength),
+			Name:   mdrip.TmplNameJs,
+			Body:   mdrip.AsTmplJs(),
+			Params: ws.makeJsParams(),
 		},
 	})
 }
 
+func (ws *Server) makeJsParams() *mdrip.TmplParams {
+	p := mdrip.MakeBaseParams(ws.dLoader.maxNavWordLength())
+	p.AddRoutePrefix(ws.routePrefix)
+	p.RunTimeoutGraceMs = int(runTimeoutGrace.Milliseconds())
+	if ws.runTimeout > 0 {
+		p.RunTimeoutMs = int((ws.runTimeout + runTimeoutGrace).Milliseconds())
+	}
+	return p
+}
+
 func (ws *Server) handleGetCss(wr http.ResponseWriter, req *http.Request) {
-	slog.Debug("handleGetCss", "req", req.URL)
+	logger(req).Debug("handleGetCss", "req", req.URL)
 	ws.minifier.Write(wr, &minify.Args{
 		MimeType: app.MimeCss,
 		Tmpl: minify.TmplArgs{
-			Name: mdrip.TmplNameCss,
-			Body: mdrip.AsTmplCss(),
-			Params: mdrip.MakeBaseParams(
-				ws.dLoader.appState.Facts.MaxNavWordLength),
+			Name:   mdrip.TmplNameCss,
+			Body:   mdrip.AsTmplCss(),
+			Params: mdrip.MakeBaseParams(ws.dLoader.maxNavWordLength()),
 		},
 	})
 }
-func (ws *Server) handleFavicon(w http.ResponseWriter, _ *http.Request) {
-	Lissajous(w, 7, 3, 1)
-}
 
 func (ws *Server) handleLissajous(w http.ResponseWriter, r *http.Request) {
-	mySess, _ := ws.store.Get(r, cookieName)
+	size, err1 := parseIntParam("s", r, 300)
+	cycles, err2 := parseIntParam("c", r, 30)
+	nFrames, err3 := parseIntParam("n", r, 100)
+	err := errors.Join(err1, err2, err3)
+	if err == nil {
+		err = ws.lissajous.check(size, cycles, nFrames)
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	mySess, _ := ws.store.Get(r, ws.cookieName)
 	_ = mySess.Save(r, w)
-	Lissajous(w,
-		getIntParam("s", r, 300),
-		getIntParam("c", r, 30),
-		getIntParam("n", r, 100))
+	Lissajous(w, size, cycles, nFrames)
 }
 
-// handleReload forces a data reload.
+// handleReload forces a data reload, and responds with the new load
+// status, saying whether the content changed, so that a client can
+// skip re-rendering after a reload that changed nothing.
 func (ws *Server) handleReload(wr http.ResponseWriter, req *http.Request) {
-	slog.Debug("Handling data reload", "url", req.URL)
+	logger(req).Debug("Handling data reload", "url", req.URL)
 	if err := ws.reload(wr, req); err != nil {
-		write500(wr, fmt.Errorf("handleReload; %w", err))
+		writeError(wr, req, http.StatusInternalServerError,
+			fmt.Errorf("handleReload; %w", err))
 		return
 	}
+	ws.writeLoadStatus(wr, req)
 }
 
-// handleDebugPage forces a data reload and shows a debug page.
+// handleGetLoadStatus reports the most recent load status, allowing
+// clients to poll for reloads triggered by someone else.
+func (ws *Server) handleGetLoadStatus(wr http.ResponseWriter, req *http.Request) {
+	logger(req).Debug("handleGetLoadStatus", "req", req.URL)
+	ws.writeLoadStatus(wr, req)
+}
+
+func (ws *Server) handleGetCwd(wr http.ResponseWriter, req *http.Request) {
+	cr, ok := ws.executor.(cwdReporter)
+	if !ok {
+		writeError(wr, req, http.StatusNotImplemented, shell.ErrNoCwd)
+		return
+	}
+	dir, err := cr.Cwd()
+	if errors.Is(err, shell.ErrNoCwd) {
+		writeError(wr, req, http.StatusNotImplemented, err)
+		return
+	}
+	if errors.Is(err, shell.ErrUnavailable) {
+		writeError(wr, req, http.StatusServiceUnavailable, err)
+		return
+	}
+	if err != nil {
+		writeError(wr, req, http.StatusInternalServerError, err)
+		return
+	}
+	wr.Header().Set("Content-Type", "text/plain")
+	_, _ = fmt.Fprint(wr, dir)
+}
+
+// resetTimeout is how long to wait for the shell to stop, and
+// then to start, when resetting it.
+const resetTimeout = 10 * time.Second
+
+// handleReset restarts the shell running code blocks, so that blocks
+// run afterward don't see what earlier blocks did, e.g. exported
+// variables or a cd.
+func (ws *Server) handleReset(wr http.ResponseWriter, req *http.Request) {
+	logger(req).Info("resetting shell")
+	r, ok := ws.executor.(restarter)
+	if !ok {
+		writeError(wr, req, http.StatusNotImplemented, shell.ErrNoRestart)
+		return
+	}
+	err := r.Restart(resetTimeout)
+	if errors.Is(err, shell.ErrNoRestart) {
+		writeError(wr, req, http.StatusNotImplemented, err)
+		return
+	}
+	if errors.Is(err, shell.ErrUnavailable) {
+		writeError(wr, req, http.StatusServiceUnavailable, err)
+		return
+	}
+	if err != nil {
+		writeError(wr, req, http.StatusInternalServerError,
+			fmt.Errorf("unable to reset shell; %w", err))
+		return
+	}
+	res := ResetResult{Reset: true}
+	if cr, ok := ws.executor.(cwdReporter); ok {
+		res.Cwd, _ = cr.Cwd()
+	}
+	jsn, err := json.Marshal(res)
+	if err != nil {
+		writeError(wr, req, http.StatusInternalServerError, err)
+		return
+	}
+	wr.Header().Set("Content-Type", "application/json")
+	_, _ = wr.Write(jsn)
+}
+
+func (ws *Server) writeLoadStatus(wr http.ResponseWriter, req *http.Request) {
+	jsn, err := json.Marshal(ws.dLoader.Status())
+	if err != nil {
+		writeError(wr, req, http.StatusInternalServerError,
+			fmt.Errorf("load status marshal; %w", err))
+		return
+	}
+	wr.Header().Set("Content-Type", "application/json")
+	if _, err = wr.Write(jsn); err != nil {
+		writeError(wr, req, http.StatusInternalServerError,
+			fmt.Errorf("load status write; %w", err))
+	}
+}
+
+// handleGetVersion reports how the server was built, as JSON.
+func (ws *Server) handleGetVersion(wr http.ResponseWriter, req *http.Request) {
+	jsn, err := json.Marshal(provenance.GetProvenance())
+	if err != nil {
+		writeError(wr, req, http.StatusInternalServerError,
+			fmt.Errorf("version marshal; %w", err))
+		return
+	}
+	wr.Header().Set("Content-Type", "application/json")
+	_, _ = wr.Write(jsn)
+}
+
+// handleDebugPage forces a data reload and shows a debug page,
+// as text, or given the format param, as a DebugDump in JSON form.
 func (ws *Server) handleDebugPage(wr http.ResponseWriter, req *http.Request) {
-	slog.Debug("Rendering debug page", "url", req.URL)
+	logger(req).Debug("Rendering debug page", "url", req.URL)
+	format := req.URL.Query().Get(config.KeyFormat)
+	if format != "" && format != config.FormatText && format != config.FormatJson {
+		writeError(wr, req, http.StatusBadRequest,
+			fmt.Errorf("unknown %s value %q", config.KeyFormat, format))
+		return
+	}
 	if err := ws.reload(wr, req); err != nil {
-		write500(wr, fmt.Errorf("handleDebugPage; %w", err))
+		writeError(wr, req, http.StatusInternalServerError,
+			fmt.Errorf("handleDebugPage; %w", err))
+		return
+	}
+	if format != config.FormatJson {
+		ws.dLoader.dump(wr)
+		if shells := ws.shells(); len(shells) > 0 {
+			_, _ = fmt.Fprintln(wr, "\nshells:")
+			for _, si := range shells {
+				_, _ = fmt.Fprintln(wr, "  "+si.String())
+			}
+		}
 		return
 	}
-	ws.dLoader.folder.Accept(loader.NewVisitorDump(wr))
-	loader.PrintBlocks(wr, ws.dLoader.AllBlocks())
+	dump := ws.dLoader.dumpJson()
+	dump.Shells = ws.shells()
+	jsn, err := json.Marshal(dump)
+	if err != nil {
+		writeError(wr, req, http.StatusInternalServerError,
+			fmt.Errorf("handleDebugPage marshal; %w", err))
+		return
+	}
+	wr.Header().Set("Content-Type", "application/json")
+	_, _ = wr.Write(jsn)
 }
 
-func (ws *Server) handleQuit(w http.ResponseWriter, _ *http.Request) {
-	slog.Debug("Received quit.")
+// shells describes the shells running the code blocks, if the
+// executor can describe them.
+func (ws *Server) shells() []shell.ShellInfo {
+	ir, ok := ws.executor.(infoReporter)
+	if !ok {
+		return nil
+	}
+	if si := ir.Info(); si != (shell.ShellInfo{}) {
+		return []shell.ShellInfo{si}
+	}
+	return nil
+}
+
+func (ws *Server) handleQuit(w http.ResponseWriter, req *http.Request) {
+	logger(req).Debug("Received quit.")
 	_, _ = fmt.Fprint(w, "\nbye bye\n")
 	go func() {
 		time.Sleep(2 * time.Second)
+		// Shut down first, to stop the shell and remove any Unix
+		// socket file.
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		_ = ws.Shutdown(ctx)
 		os.Exit(0)
 	}()
 }
 
+// handleHealthz reports that the server is up, for load balancers
+// and proxies.
+func (ws *Server) handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	_, _ = fmt.Fprintln(w, "ok")
+}
+
+// findBlock returns the block named by the request's block name
+// param, if it has one, else the block at the given index.  If there's
+// no such block, it writes an error and returns nil.
+func findBlock(wr http.ResponseWriter, req *http.Request,
+	mdFile *parsren.RenderedMdFile, blockIndex int) *loader.CodeBlock {
+	name := req.URL.Query().Get(config.KeyBlockName)
+	if name == "" {
+		if !inRange(wr, req, config.KeyBlockIndex, blockIndex, len(mdFile.Blocks)) {
+			return nil
+		}
+		return mdFile.Blocks[blockIndex]
+	}
+	for _, b := range mdFile.Blocks {
+		if b.UniqName() == name {
+			return b
+		}
+	}
+	writeError(wr, req, http.StatusNotFound,
+		fmt.Errorf("no block named %q in %s", name, mdFile.Path))
+	return nil
+}
+
 func (ws *Server) handleRunCodeBlock(wr http.ResponseWriter, req *http.Request) {
-	slog.Debug(" ")
-	slog.Debug("Running code block", "url", req.URL)
+	logger(req).Debug(" ")
+	logger(req).Debug("Running code block", "url", req.URL)
 	arg := req.URL.Query().Get(config.KeyMdSessID)
 	if len(arg) == 0 {
-		http.Error(wr, "No session id for block codeWriter", http.StatusBadRequest)
+		writeError(wr, req, http.StatusBadRequest,
+			errors.New("no session id for block executor"))
 		return
 	}
 	sessID := session.TypeSessID(arg)
-	mdFileIndex := getIntParam(config.KeyMdFileIndex, req, -1)
-	blockIndex := getIntParam(config.KeyBlockIndex, req, -1)
-	slog.Debug("args:",
+	mdFileIndex, err1 := parseIntParam(config.KeyMdFileIndex, req, -1)
+	blockIndex, err2 := parseIntParam(config.KeyBlockIndex, req, -1)
+	rows, err3 := parseIntParam(config.KeyRows, req, 0)
+	cols, err4 := parseIntParam(config.KeyCols, req, 0)
+	trace, err5 := parseBoolParam(config.KeyTrace, req, false)
+	if err := errors.Join(err1, err2, err3, err4, err5); err != nil {
+		writeError(wr, req, http.StatusBadRequest, err)
+		return
+	}
+	opts := RunRequest{
+		Interp:   req.URL.Query().Get(config.KeyInterp),
+		Trace:    trace,
+		Color:    req.URL.Query().Get(config.KeyColor),
+		Download: req.URL.Query().Get(config.KeyDownload),
+		Rows:     rows,
+		Cols:     cols,
+	}
+	if err := decodeRunRequest(req, &opts); err != nil {
+		writeError(wr, req, http.StatusBadRequest, err)
+		return
+	}
+	if err := checkFileName(opts.Download); err != nil {
+		writeError(wr, req, http.StatusBadRequest, err)
+		return
+	}
+	if opts.Color == "" {
+		opts.Color = config.ColorStrip
+	}
+	logger(req).Debug("args:",
 		config.KeyMdSessID, sessID,
 		config.KeyMdFileIndex, mdFileIndex,
 		config.KeyBlockIndex, blockIndex,
+		config.KeyBlockName, req.URL.Query().Get(config.KeyBlockName),
+		config.KeyTrace, opts.Trace,
+		config.KeyInterp, opts.Interp,
+		config.KeyColor, opts.Color,
+		"timeoutSec", opts.TimeoutSec,
+		config.KeyDownload, opts.Download,
+		config.KeyRows, opts.Rows,
+		config.KeyCols, opts.Cols,
 	)
 
-	if !inRange(
-		wr, config.KeyMdFileIndex,
-		mdFileIndex, len(ws.dLoader.RenderedFiles())) {
+	// Grab the files once, since a reload may replace them.
+	files := ws.dLoader.RenderedFiles()
+	if !inRange(wr, req, config.KeyMdFileIndex, mdFileIndex, len(files)) {
+		return
+	}
+	mdFile := files[mdFileIndex]
+
+	block := findBlock(wr, req, mdFile, blockIndex)
+	if block == nil {
+		return
+	}
+	if !block.IsRunnable() && opts.Interp == "" {
+		// The UI offers no way to run such a block, but a request may
+		// name one anyway.  Naming an interpreter makes it runnable,
+		// as an interpreter label would.
+		writeError(wr, req, http.StatusBadRequest,
+			fmt.Errorf("block %q is not runnable", block.UniqName()))
 		return
 	}
-	mdFile := ws.dLoader.RenderedFiles()[mdFileIndex]
 
-	if !inRange(wr, config.KeyBlockIndex, blockIndex, len(mdFile.Blocks)) {
+	interp := opts.Interp
+	if interp == "" {
+		interp = block.Interpreter()
+	}
+	code := block.Code()
+	if err := ws.checkAllowed(code, interp); err != nil {
+		writeError(wr, req, http.StatusForbidden, err)
+		return
+	}
+	if interp != "" {
+		code = shell.PipedTo(interp, code)
+	}
+	if opts.Trace {
+		code = shell.Traced(code)
+	}
+	code = shell.WithEnv(loader.ParseEnvOverrides(block.Labels()), code)
+	var convert func(string) string
+	switch opts.Color {
+	case config.ColorStrip:
+		convert = ansi.Strip
+	case config.ColorHtml:
+		convert = func(s string) string { return string(ansi.ToHtml(s)) }
+	case config.ColorKeep:
+		convert = func(s string) string { return s }
+	default:
+		writeError(wr, req, http.StatusBadRequest,
+			fmt.Errorf("unknown %s value %q", config.KeyColor, opts.Color))
+		return
+	}
+	release := ws.acquireRun(wr, req)
+	if release == nil {
+		return
+	}
+	defer release()
+	ctx := shell.WithSource(req.Context(), block.Code())
+	timeout := shell.ResolveTimeout(
+		time.Duration(opts.TimeoutSec)*time.Second,
+		loader.ParseTimeout(block.Labels()),
+		ws.runTimeout)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if ts, ok := ws.executor.(terminalSizer); ok && opts.Rows > 0 && opts.Cols > 0 {
+		if err := ts.SetTerminalSize(opts.Rows, opts.Cols); err != nil {
+			// The block can run anyway, just in a terminal of another size.
+			logger(req).Warn("unable to size terminal", "err", err)
+		}
+	}
+	res := RunResult{TimeoutMs: timeout.Milliseconds()}
+	start := time.Now()
+	out, err := ws.executor.Execute(ctx, code)
+	ws.audit(req, sessID, block, interp, start, time.Since(start), out, err)
+	if errors.Is(err, shell.ErrUnavailable) {
+		writeError(wr, req, http.StatusServiceUnavailable, err)
+		return
+	}
+	if out != nil {
+		res.Stdout = convert(strings.Join(out.Stdout, "\n"))
+		res.Stderr = convert(strings.Join(out.Stderr, "\n"))
+		res.ExitCode = out.ExitCode
+		if u := out.Usage; u != nil {
+			res.Usage = &RunUsage{
+				UserMs:   u.User.Milliseconds(),
+				SystemMs: u.System.Milliseconds(),
+				MaxRssKb: u.MaxRssKb,
+			}
+		}
+	}
+	if err != nil {
+		logger(req).Error("unable to run block", "err", err)
+		res.Error = err.Error()
+		res.Reason = runFailureReason(ctx, err)
+		res.TimedOut = res.Reason == ReasonTimeout
+	}
+	res.StderrIsWarning = err == nil && res.ExitCode == 0 &&
+		res.Stderr != "" && !opts.Trace
+	if opts.Download != "" && err == nil && res.ExitCode == 0 {
+		// A file wants plain text, whatever the color param says.
+		writeDownload(wr, opts.Download, ansi.Strip(strings.Join(out.Stdout, "\n")))
 		return
 	}
-	block := mdFile.Blocks[blockIndex]
+	jsn, err := json.Marshal(res)
+	if err != nil {
+		writeError(wr, req, http.StatusInternalServerError, err)
+		return
+	}
+	wr.Header().Set("Content-Type", "application/json")
+	_, _ = wr.Write(jsn)
+}
+
+// runFailureReason returns the Reason for a run in the context
+// failing with the error.  The shell may report running out of time
+// in its own words, so the context's error is consulted too.
+func runFailureReason(ctx context.Context, err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return ReasonTimeout
+	case errors.Is(err, context.Canceled), errors.Is(ctx.Err(), context.Canceled):
+		return ReasonCancelled
+	}
+	return ReasonError
+}
+
+// checkFileName returns an error if the name, if not empty, isn't
+// fit to name a downloaded file, e.g. if it holds a path separator.
+func checkFileName(name string) error {
+	if name == "" {
+		return nil
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, "/\\") ||
+		strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return fmt.Errorf("bad %s file name %q", config.KeyDownload, name)
+	}
+	return nil
+}
 
-	if _, err := ws.codeWriter.Write([]byte(block.Code())); err != nil {
-		slog.Error("codeWriter failed", "err", err)
+// writeDownload sends the output as an attachment with the given
+// file name, so that browsers save it rather than show it.
+// Like all captured output, it lacks the empty lines, if any,
+// that the block wrote.
+func writeDownload(wr http.ResponseWriter, name, output string) {
+	if output != "" {
+		output += "\n"
 	}
-	_, _ = fmt.Fprintln(wr, "Ok")
+	wr.Header().Set("Content-Type", "application/octet-stream")
+	wr.Header().Set("X-Content-Type-Options", "nosniff")
+	wr.Header().Set("Content-Disposition",
+		mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	_, _ = wr.Write([]byte(output))
 }
@@ -0,0 +1,1349 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/monopole/mdrip/v2/internal/parsren/usegold"
+	"github.com/monopole/mdrip/v2/internal/provenance"
+	"github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/monopole/mdrip/v2/internal/shell/shelltest"
+	"github.com/monopole/mdrip/v2/internal/web/app"
+	"github.com/monopole/mdrip/v2/internal/web/config"
+	. "github.com/monopole/mdrip/v2/internal/web/server"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	shPath  = "/bin/sh"
+	timeout = 3 * time.Second
+)
+
+// makeServer returns a server for a folder holding one markdown
+// file with the given content.
+func makeServer(t *testing.T, md string, ex shell.Executor) *Server {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(
+		filepath.Join(dir, "a.md"), []byte(md), 0644))
+	return makeServerInDir(t, dir, ex)
+}
+
+// makeServerInDir returns a server for the markdown in the given folder.
+func makeServerInDir(t testing.TB, dir string, ex shell.Executor) *Server {
+	dl := NewDataLoader(
+		loader.New(afero.NewOsFs(),
+			loader.IsMarkDownFile, loader.InNotIgnorableFolder),
+		[]string{dir}, usegold.NewGParser(), "test")
+	if !assert.NoError(t, dl.LoadAndRender()) {
+		t.FailNow()
+	}
+	s, err := NewServer(dl, ex)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return s
+}
+
+func doRequest(h http.Handler, method, url string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, url, nil))
+	return rec
+}
+
+func TestHandleGetBlocksForFile(t *testing.T) {
+	s := makeServer(t, "# hey\n"+
+		"<!-- @setup -->\n```bash {name=install}\necho a\n```\n"+
+		"```yaml\nkind: Pod\n```\n"+
+		"```yaml {interp=yq}\nkind: Pod\n```\n",
+		&shell.Echo{})
+	rec := doRequest(s.Handler(), http.MethodGet, config.Dynamic(config.RouteBlocksForFile)+
+		"?"+config.KeyMdFileIndex+"=0")
+	if !assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String()) {
+		t.FailNow()
+	}
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `[
+  {"uniqName": "install", "language": "bash",
+   "labels": ["setup", "name=install"], "runnable": true},
+  {"uniqName": "kindPod", "language": "yaml", "labels": [], "runnable": false},
+  {"uniqName": "kindPod2", "language": "yaml",
+   "labels": ["interp=yq"], "runnable": true}
+]`, rec.Body.String())
+}
+
+func TestHandleGetCwd(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	sh := shell.NewManagedShell(shPath)
+	if !assert.NoError(t, sh.Start(timeout)) {
+		t.FailNow()
+	}
+	defer func() { _ = sh.Stop(timeout) }()
+	h := makeServer(t, "# hey\n```\ncd /tmp\n```\n", sh).Handler()
+
+	rec := doRequest(h, http.MethodPost, runBlockUrl(""))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = doRequest(h, http.MethodGet, config.Dynamic(config.RouteCwd))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "/tmp", rec.Body.String())
+}
+
+func doPost(h http.Handler, url, contentType, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func runBlockUrl(query string) string {
+	return config.Dynamic(config.RouteRunBlock) +
+		"?" + config.KeyMdSessID + "=abc&" +
+		config.KeyMdFileIndex + "=0&" + config.KeyBlockIndex + "=0" + query
+}
+
+func TestHandleRunCodeBlockUsage(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	sh := shell.NewManagedShell(shPath)
+	sh.SetIsolated(true)
+	h := makeServer(t, "# hey\n```\n"+
+		"i=0; while [ $i -lt 100000 ]; do i=$((i+1)); done; echo $i\n```\n",
+		sh).Handler()
+	rec := doRequest(h, http.MethodPost, runBlockUrl(""))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var res RunResult
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.Equal(t, "100000", res.Stdout)
+	if assert.NotNil(t, res.Usage) {
+		assert.Positive(t, res.Usage.UserMs+res.Usage.SystemMs)
+	}
+}
+
+func TestHandleRunCodeBlockTerminalSize(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	for n, tc := range map[string]struct {
+		query       string
+		contentType string
+		body        string
+		code        int
+		stdout      string
+	}{
+		"default": {code: http.StatusOK, stdout: "24 80"},
+		"query": {
+			query: "&" + config.KeyRows + "=30&" + config.KeyCols + "=100",
+			code:  http.StatusOK, stdout: "30 100"},
+		"json": {
+			contentType: "application/json", body: `{"rows": 50, "cols": 120}`,
+			code: http.StatusOK, stdout: "50 120"},
+		"onlyCols": {
+			query: "&" + config.KeyCols + "=100",
+			code:  http.StatusOK, stdout: "24 80"},
+		"malformed": {
+			query: "&" + config.KeyCols + "=wide",
+			code:  http.StatusBadRequest},
+		"negative": {
+			contentType: "application/json", body: `{"rows": -1, "cols": 120}`,
+			code: http.StatusBadRequest},
+	} {
+		t.Run(n, func(t *testing.T) {
+			ms := shell.NewManagedShell(shPath)
+			ms.SetInteractive(true)
+			assert.NoError(t, ms.Start(timeout))
+			defer func() { _ = ms.Stop(timeout) }()
+			h := makeServer(t, "# hey\n```\nstty size\n```\n", ms).Handler()
+			rec := doPost(h, runBlockUrl(tc.query), tc.contentType, tc.body)
+			if !assert.Equal(t, tc.code, rec.Code, rec.Body.String()) ||
+				tc.code != http.StatusOK {
+				return
+			}
+			var res RunResult
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert.Equal(t, tc.stdout, res.Stdout)
+		})
+	}
+}
+
+func TestHandleRunCodeBlockColor(t *testing.T) {
+	// Like the output of ls --color.
+	h := makeServer(t, "# hey\n```\nls --color\n```\n",
+		shelltest.NewFakeExecutor().On("ls --color", shell.Result{
+			Stdout: []string{
+				"\x1b[0m\x1b[01;34mbin\x1b[0m  \x1b[01;32mrun.sh\x1b[0m"},
+			Stderr: []string{"\x1b[1;31moops\x1b[0m"},
+		})).Handler()
+	for n, tc := range map[string]struct {
+		query  string
+		code   int
+		stdout string
+		stderr string
+	}{
+		"default": {
+			code:   http.StatusOK,
+			stdout: "bin  run.sh",
+			stderr: "oops",
+		},
+		"strip": {
+			query:  "&" + config.KeyColor + "=" + config.ColorStrip,
+			code:   http.StatusOK,
+			stdout: "bin  run.sh",
+			stderr: "oops",
+		},
+		"keep": {
+			query:  "&" + config.KeyColor + "=" + config.ColorKeep,
+			code:   http.StatusOK,
+			stdout: "\x1b[0m\x1b[01;34mbin\x1b[0m  \x1b[01;32mrun.sh\x1b[0m",
+			stderr: "\x1b[1;31moops\x1b[0m",
+		},
+		"html": {
+			query: "&" + config.KeyColor + "=" + config.ColorHtml,
+			code:  http.StatusOK,
+			stdout: "<span style='color:#2472c8;font-weight:bold'>bin</span>  " +
+				"<span style='color:#0dbc79;font-weight:bold'>run.sh</span>",
+			stderr: "<span style='color:#cd3131;font-weight:bold'>oops</span>",
+		},
+		"bogus": {
+			query: "&" + config.KeyColor + "=plaid",
+			code:  http.StatusBadRequest,
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			rec := doRequest(h, http.MethodPost, runBlockUrl(tc.query))
+			if !assert.Equal(t, tc.code, rec.Code) || tc.code != http.StatusOK {
+				return
+			}
+			var res RunResult
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert.Equal(t, tc.stdout, res.Stdout)
+			assert.Equal(t, tc.stderr, res.Stderr)
+			assert.Empty(t, res.Error)
+		})
+	}
+}
+
+func TestHandleRunCodeBlockDownload(t *testing.T) {
+	h := makeServer(t, "# hey\n```\nkubectl get pod -o yaml\n```\n"+
+		"```\nkubectl get bogus\n```\n",
+		shelltest.NewFakeExecutor().
+			On("kubectl get pod -o yaml", shell.Result{
+				Stdout: []string{"kind: \x1b[32mPod\x1b[0m", "metadata:", "  name: demo"},
+				Stderr: []string{"a warning"},
+			}).
+			On("kubectl get bogus", shell.Result{
+				Stderr: []string{"no such resource"}, ExitCode: 1,
+			})).Handler()
+	for n, tc := range map[string]struct {
+		url         string
+		code        int
+		disposition string
+		body        string
+	}{
+		"download": {
+			url:         runBlockUrl("&" + config.KeyDownload + "=pod.yaml"),
+			code:        http.StatusOK,
+			disposition: "attachment; filename=pod.yaml",
+			body:        "kind: Pod\nmetadata:\n  name: demo\n",
+		},
+		"colorHtml": {
+			url: runBlockUrl("&" + config.KeyDownload + "=pod.yaml&" +
+				config.KeyColor + "=" + config.ColorHtml),
+			code:        http.StatusOK,
+			disposition: "attachment; filename=pod.yaml",
+			body:        "kind: Pod\nmetadata:\n  name: demo\n",
+		},
+		"colorKeep": {
+			url: runBlockUrl("&" + config.KeyDownload + "=pod.yaml&" +
+				config.KeyColor + "=" + config.ColorKeep),
+			code:        http.StatusOK,
+			disposition: "attachment; filename=pod.yaml",
+			body:        "kind: Pod\nmetadata:\n  name: demo\n",
+		},
+		"quotedName": {
+			url:         runBlockUrl("&" + config.KeyDownload + "=my%20pod.yaml"),
+			code:        http.StatusOK,
+			disposition: `attachment; filename="my pod.yaml"`,
+			body:        "kind: Pod\nmetadata:\n  name: demo\n",
+		},
+		"failureSendsJson": {
+			url: config.Dynamic(config.RouteRunBlock) + "?" +
+				config.KeyMdSessID + "=abc&" + config.KeyMdFileIndex + "=0&" +
+				config.KeyBlockIndex + "=1&" + config.KeyDownload + "=bogus.yaml",
+			code: http.StatusOK,
+		},
+		"pathInName": {
+			url:  runBlockUrl("&" + config.KeyDownload + "=../etc/passwd"),
+			code: http.StatusBadRequest,
+		},
+		"dotDot": {
+			url:  runBlockUrl("&" + config.KeyDownload + "=.."),
+			code: http.StatusBadRequest,
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			rec := doRequest(h, http.MethodPost, tc.url)
+			if !assert.Equal(t, tc.code, rec.Code) || tc.code != http.StatusOK {
+				return
+			}
+			if tc.disposition == "" {
+				assert.Empty(t, rec.Header().Get("Content-Disposition"))
+				assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+				var res RunResult
+				assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+				assert.Equal(t, 1, res.ExitCode)
+				assert.Equal(t, "no such resource", res.Stderr)
+				return
+			}
+			assert.Equal(t, tc.disposition, rec.Header().Get("Content-Disposition"))
+			assert.Equal(t, "application/octet-stream", rec.Header().Get("Content-Type"))
+			assert.Equal(t, tc.body, rec.Body.String())
+		})
+	}
+}
+
+// TestHandleRunCodeBlockSteps runs a file's blocks by index, one
+// at a time, as the web app's run next step button does, skipping
+// the block labelled skip.
+func TestHandleRunCodeBlockSteps(t *testing.T) {
+	ex := shelltest.NewFakeExecutor().
+		On("echo one", shell.Result{Stdout: []string{"one"}}).
+		On("echo three", shell.Result{Stdout: []string{"three"}})
+	h := makeServer(t, "# hey\n```\necho one\n```\n"+
+		"<!-- @skip -->\n```\necho two\n```\n"+
+		"```\necho three\n```\n", ex).Handler()
+	stepUrl := func(bix int) string {
+		return config.Dynamic(config.RouteRunBlock) + "?" +
+			config.KeyMdSessID + "=abc&" + config.KeyMdFileIndex + "=0&" +
+			config.KeyBlockIndex + "=" + strconv.Itoa(bix)
+	}
+	for _, step := range []struct {
+		bix    int
+		stdout string
+	}{
+		{bix: 0, stdout: "one"},
+		{bix: 2, stdout: "three"},
+	} {
+		rec := doRequest(h, http.MethodPost, stepUrl(step.bix))
+		if !assert.Equal(t, http.StatusOK, rec.Code) {
+			continue
+		}
+		var res RunResult
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert.Equal(t, step.stdout, res.Stdout)
+		assert.Zero(t, res.ExitCode)
+	}
+	assert.Equal(t, []string{"echo one\n", "echo three\n"}, ex.Calls())
+
+	// Past the last step.
+	rec := doRequest(h, http.MethodPost, stepUrl(3))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "out of range")
+	assert.Len(t, ex.Calls(), 2)
+}
+
+func TestContentTypes(t *testing.T) {
+	const (
+		html = "text/html; charset=utf-8"
+		jsn  = "application/json"
+	)
+	h := makeServer(t, "# hey\n```\necho hi\n```\n", &shell.Echo{}).Handler()
+	fileQuery := "?" + config.KeyMdFileIndex + "=0"
+	for n, tc := range map[string]struct {
+		method string
+		url    string
+		want   string
+	}{
+		"app":    {url: "/a.md", want: html},
+		"html":   {url: config.Dynamic(config.RouteHtmlForFile) + fileQuery, want: html},
+		"labels": {url: config.Dynamic(config.RouteLabelsForFile) + fileQuery, want: jsn},
+		"blocks": {url: config.Dynamic(config.RouteBlocksForFile) + fileQuery, want: jsn},
+		"status": {url: config.Dynamic(config.RouteLoadStatus), want: jsn},
+		"reload": {
+			method: http.MethodPost,
+			url:    config.Dynamic(config.RouteReload),
+			want:   jsn,
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			rec := doRequest(h, method, tc.url)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, tc.want, rec.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestHandleDebugPage(t *testing.T) {
+	h := makeServer(t, "# hey\n"+
+		"<!-- @setup @slow -->\n```bash {name=install}\necho a\n```\n"+
+		"```yaml\nkind: Pod\n```\n", &shell.Echo{}).Handler()
+	url := config.Dynamic(config.RouteDebug)
+	for n, tc := range map[string]struct {
+		format string
+		code   int
+	}{
+		"default": {code: http.StatusOK},
+		"text":    {format: config.FormatText, code: http.StatusOK},
+		"json":    {format: config.FormatJson, code: http.StatusOK},
+		"bogus":   {format: "xml", code: http.StatusBadRequest},
+	} {
+		t.Run(n, func(t *testing.T) {
+			u := url
+			if tc.format != "" {
+				u += "?" + config.KeyFormat + "=" + tc.format
+			}
+			rec := doRequest(h, http.MethodGet, u)
+			if !assert.Equal(t, tc.code, rec.Code) || tc.code != http.StatusOK {
+				return
+			}
+			if tc.format != config.FormatJson {
+				assert.Contains(t, rec.Body.String(), "a.md : ")
+				assert.Contains(t, rec.Body.String(), "echo a\n# ----------")
+				return
+			}
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			var got DebugDump
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+			assert.Equal(t, 2, got.NumBlocks)
+			if !assert.NotNil(t, got.Tree) || !assert.Len(t, got.Tree.Files, 1) {
+				return
+			}
+			f := got.Tree.Files[0]
+			assert.Equal(t, "a.md", f.Name)
+			if assert.Len(t, f.Blocks, 2) {
+				assert.Equal(t, "install", f.Blocks[0].UniqName)
+				assert.Equal(t, "bash", f.Blocks[0].Language)
+				assert.Equal(t, []string{"setup", "slow", "name=install"}, f.Blocks[0].Labels)
+				assert.Equal(t, "echo a\n", f.Blocks[0].Code)
+				assert.Equal(t, "yaml", f.Blocks[1].Language)
+				assert.NotEmpty(t, f.Blocks[1].UniqName)
+			}
+		})
+	}
+}
+
+// infoEcho is an Echo that describes a shell.
+type infoEcho struct {
+	shell.Echo
+	info shell.ShellInfo
+}
+
+func (ie *infoEcho) Info() shell.ShellInfo { return ie.info }
+
+func TestHandleDebugPageShells(t *testing.T) {
+	info := shell.ShellInfo{
+		Path: "/bin/bash", PID: 4242, Alive: true, CommandsRun: 7,
+		StartedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	h := makeServer(t, "# hey\n```\necho a\n```\n", &infoEcho{info: info}).Handler()
+	url := config.Dynamic(config.RouteDebug)
+
+	rec := doRequest(h, http.MethodGet, url)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(),
+		"/bin/bash pid 4242 started 2024-03-01T12:00:00Z, alive, 7 commands run")
+
+	rec = doRequest(h, http.MethodGet, url+"?"+config.KeyFormat+"="+config.FormatJson)
+	var got DebugDump
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, []shell.ShellInfo{info}, got.Shells)
+
+	// An executor that can't describe a shell lists none.
+	rec = doRequest(makeServer(t, "# hey\n", &shell.Echo{}).Handler(),
+		http.MethodGet, url+"?"+config.KeyFormat+"="+config.FormatJson)
+	got = DebugDump{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Empty(t, got.Shells)
+}
+
+func TestErrorResponses(t *testing.T) {
+	h := makeServer(t, "# hey\n```\necho hi\n```\n", &shell.Echo{}).Handler()
+	for n, tc := range map[string]struct {
+		accept string
+		isJson bool
+	}{
+		"none":        {},
+		"browser":     {accept: "text/html,application/xhtml+xml,*/*;q=0.8"},
+		"json":        {accept: "application/json", isJson: true},
+		"problemJson": {accept: "application/problem+json", isJson: true},
+		"jsonAmongOthers": {
+			accept: "text/plain;q=0.5, application/json", isJson: true},
+		"jsonRefused": {accept: "application/json;q=0"},
+	} {
+		t.Run(n, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, runBlockUrl("")+
+				"&"+config.KeyColor+"=purple", nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			const msg = `unknown color value "purple"`
+			if !tc.isJson {
+				assert.True(t, strings.HasPrefix(
+					rec.Header().Get("Content-Type"), "text/plain"))
+				assert.Equal(t, msg+"\n", rec.Body.String())
+				return
+			}
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			var res ErrorResponse
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert.Equal(t, ErrorResponse{Error: msg, Code: http.StatusBadRequest}, res)
+		})
+	}
+}
+
+func TestRequestId(t *testing.T) {
+	h := makeServer(t, "# hey\n", &shell.Echo{}).Handler()
+	for n, tc := range map[string]struct {
+		sent string
+		want string
+	}{
+		"kept":        {sent: "abc-123.x:y_z", want: "abc-123.x:y_z"},
+		"made":        {},
+		"badReplaced": {sent: "has spaces"},
+	} {
+		t.Run(n, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+			if tc.sent != "" {
+				req.Header.Set(HeaderRequestId, tc.sent)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			got := rec.Header().Get(HeaderRequestId)
+			if tc.want != "" {
+				assert.Equal(t, tc.want, got)
+				return
+			}
+			assert.Regexp(t, "^[0-9a-f]{16}$", got)
+		})
+	}
+}
+
+func TestRequestIdInLogs(t *testing.T) {
+	h := makeServer(t, "# hey\n```\necho hi\n```\n", &shell.Echo{}).Handler()
+	var buf bytes.Buffer
+	old := slog.Default()
+	slog.SetDefault(slog.New(
+		slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer slog.SetDefault(old)
+	req := httptest.NewRequest(http.MethodPost, runBlockUrl(""), nil)
+	req.Header.Set(HeaderRequestId, "req-42")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.NotEmpty(t, lines)
+	for _, line := range lines {
+		assert.Contains(t, line, "requestId=req-42")
+	}
+	assert.Contains(t, buf.String(), "Running code block")
+}
+
+func TestHandleGetCwdUnsupported(t *testing.T) {
+	h := makeServer(t, "# hey\n", &shell.Echo{}).Handler()
+	rec := doRequest(h, http.MethodGet, config.Dynamic(config.RouteCwd))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+func TestHandleReset(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	sh := shell.NewManagedShell(shPath)
+	if !assert.NoError(t, sh.Start(timeout)) {
+		t.FailNow()
+	}
+	defer func() { _ = sh.Stop(timeout) }()
+	h := makeServer(t, "# hey\n```\nexport GREETING=hello; cd /\n```\n"+
+		"```\necho ${GREETING:-gone}\n```\n", sh).Handler()
+	echoUrl := config.Dynamic(config.RouteRunBlock) + "?" +
+		config.KeyMdSessID + "=abc&" +
+		config.KeyMdFileIndex + "=0&" + config.KeyBlockIndex + "=1"
+	echo := func() RunResult {
+		rec := doRequest(h, http.MethodPost, echoUrl)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var res RunResult
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		return res
+	}
+
+	rec := doRequest(h, http.MethodPost, runBlockUrl(""))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "hello", echo().Stdout)
+
+	rec = doRequest(h, http.MethodPost, config.Dynamic(config.RouteReset))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var res ResetResult
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.True(t, res.Reset)
+	assert.NotEqual(t, "/", res.Cwd)
+
+	assert.Equal(t, "gone", echo().Stdout)
+}
+
+func TestHandleResetUnsupported(t *testing.T) {
+	for n, tc := range map[string]struct {
+		ex   shell.Executor
+		want int
+	}{
+		"echo":        {ex: &shell.Echo{}, want: http.StatusNotImplemented},
+		"history":     {ex: shell.NewHistory(&shell.Echo{}, filepath.Join(t.TempDir(), "h")), want: http.StatusNotImplemented},
+		"unavailable": {ex: shell.NewUnavailable(errors.New("no bash")), want: http.StatusServiceUnavailable},
+	} {
+		t.Run(n, func(t *testing.T) {
+			h := makeServer(t, "# hey\n", tc.ex).Handler()
+			rec := doRequest(h, http.MethodPost, config.Dynamic(config.RouteReset))
+			assert.Equal(t, tc.want, rec.Code)
+		})
+	}
+}
+
+func TestHandleFavicon(t *testing.T) {
+	for n, tc := range map[string]struct {
+		static bool
+		mime   string
+	}{
+		"lissajous": {static: false, mime: "image/gif"},
+		"static":    {static: true, mime: "image/png"},
+	} {
+		t.Run(n, func(t *testing.T) {
+			s := makeServer(t, "# hey\n", &shell.Echo{})
+			s.SetStaticFavicon(tc.static)
+			rec := doRequest(s.Handler(), http.MethodGet, "/favicon.ico")
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, tc.mime, rec.Header().Get("Content-Type"))
+			assert.Equal(t, tc.mime, http.DetectContentType(rec.Body.Bytes()))
+		})
+	}
+}
+
+func TestHandleRenderWebAppExtraCss(t *testing.T) {
+	const theme = "https://example.com/theme.css"
+	s := makeServer(t, "# hey\n", &shell.Echo{})
+	s.SetExtraCss([]string{theme})
+	rec := doRequest(s.Handler(), http.MethodGet, "/")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	page := rec.Body.String()
+	mine := strings.Index(page, "href='"+config.Dynamic(config.RouteCss)+"'")
+	extra := strings.Index(page, "<link rel='stylesheet' type='text/css' href='"+theme+"' />")
+	assert.Positive(t, mine)
+	assert.Greater(t, extra, mine, "extra css must follow mdrip's css")
+	assert.Contains(t, page, "<div class='"+app.ContainerClass+"'>")
+}
+
+func TestServeWithRoutePrefix(t *testing.T) {
+	s := makeServer(t, "# hey\n```\necho hi\n```\n", &shell.Echo{})
+	assert.NoError(t, s.SetRoutePrefix("/mdrip/"))
+	assert.NoError(t, s.SetCookieName("mdripTest"))
+	h := s.Handler()
+	for n, tc := range map[string]struct {
+		method string
+		url    string
+		code   int
+		has    []string
+	}{
+		"app": {
+			url:  "/mdrip/a.md",
+			code: http.StatusOK,
+			has: []string{
+				"href='/mdrip" + config.Dynamic(config.RouteCss) + "'",
+				"src='/mdrip" + config.Dynamic(config.RouteJs) + "'",
+			},
+		},
+		"js": {
+			url:  "/mdrip" + config.Dynamic(config.RouteJs),
+			code: http.StatusOK,
+			has: []string{
+				"/mdrip" + config.Dynamic(config.RouteRunBlock),
+				"/mdrip" + config.Dynamic(config.RouteSave),
+			},
+		},
+		"run": {
+			method: http.MethodPost,
+			url:    "/mdrip" + runBlockUrl(""),
+			code:   http.StatusOK,
+		},
+		"healthz":  {url: "/mdrip/healthz", code: http.StatusOK},
+		"noPrefix": {url: config.Dynamic(config.RouteJs), code: http.StatusNotFound},
+		"noRunWithoutPrefix": {
+			method: http.MethodPost,
+			url:    runBlockUrl(""),
+			code:   http.StatusNotFound,
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			rec := doRequest(h, method, tc.url)
+			assert.Equal(t, tc.code, rec.Code, rec.Body.String())
+			for _, want := range tc.has {
+				assert.Contains(t, rec.Body.String(), want)
+			}
+		})
+	}
+	t.Run("cookie", func(t *testing.T) {
+		rec := doRequest(h, http.MethodPost,
+			"/mdrip"+config.Dynamic(config.RouteSave)+"?"+config.KeyIsNavOn+"=true")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		cookies := rec.Result().Cookies()
+		if assert.Len(t, cookies, 1) {
+			assert.Equal(t, "mdripTest", cookies[0].Name)
+			assert.Equal(t, "/mdrip/", cookies[0].Path)
+		}
+	})
+}
+
+func TestSetRoutePrefixAndCookieName(t *testing.T) {
+	s := makeServer(t, "# hey\n", &shell.Echo{})
+	assert.NoError(t, s.SetRoutePrefix(""))
+	assert.NoError(t, s.SetRoutePrefix("/"))
+	assert.Error(t, s.SetRoutePrefix("mdrip"))
+	assert.Error(t, s.SetRoutePrefix("/md?rip"))
+	assert.Error(t, s.SetCookieName(""))
+	assert.Error(t, s.SetCookieName("md rip"))
+}
+
+func TestCookieOptions(t *testing.T) {
+	for n, tc := range map[string]struct {
+		maxAge   time.Duration
+		secure   bool
+		sameSite string
+		check    func(t *testing.T, c *http.Cookie)
+	}{
+		"defaults": {
+			maxAge: DefaultCookieMaxAge,
+			check: func(t *testing.T, c *http.Cookie) {
+				assert.Equal(t, 8*60*60, c.MaxAge)
+				assert.True(t, c.HttpOnly)
+				assert.False(t, c.Secure)
+				// There's no SameSite attribute.
+				assert.Zero(t, c.SameSite)
+			},
+		},
+		"hardened": {
+			maxAge:   30 * time.Minute,
+			secure:   true,
+			sameSite: "Lax",
+			check: func(t *testing.T, c *http.Cookie) {
+				assert.Equal(t, 30*60, c.MaxAge)
+				assert.True(t, c.HttpOnly)
+				assert.True(t, c.Secure)
+				assert.Equal(t, http.SameSiteLaxMode, c.SameSite)
+			},
+		},
+		"strictBrowserSession": {
+			sameSite: "strict",
+			check: func(t *testing.T, c *http.Cookie) {
+				assert.Equal(t, 0, c.MaxAge)
+				assert.Equal(t, http.SameSiteStrictMode, c.SameSite)
+			},
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			s := makeServer(t, "# hey\n", &shell.Echo{})
+			assert.NoError(t, s.SetCookieMaxAge(tc.maxAge))
+			s.SetCookieSecure(tc.secure)
+			assert.NoError(t, s.SetCookieSameSite(tc.sameSite))
+			cookies := saveSession(t, s.Handler(), config.KeyIsNavOn+"=true")
+			if assert.Len(t, cookies, 1) {
+				tc.check(t, cookies[0])
+			}
+		})
+	}
+	s := makeServer(t, "# hey\n", &shell.Echo{})
+	assert.Error(t, s.SetCookieMaxAge(-time.Second))
+	assert.Error(t, s.SetCookieSameSite("sometimes"))
+}
+
+// saveSession saves the params in the query in a new session,
+// returning the session's cookies.
+func saveSession(t *testing.T, h http.Handler, query string) []*http.Cookie {
+	rec := doRequest(h, http.MethodPost, config.Dynamic(config.RouteSave)+"?"+query)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	return rec.Result().Cookies()
+}
+
+// renderApp gets the web app in the session having the cookies.
+func renderApp(t *testing.T, h http.Handler, cookies []*http.Cookie) string {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	return rec.Body.String()
+}
+
+func TestHandleRenderWebAppSession(t *testing.T) {
+	h := makeServer(t, "# hey\n", &shell.Echo{}).Handler()
+	const (
+		navOff   = `"IsNavVisible":false`
+		navOn    = `"IsNavVisible":true`
+		titleOff = `"IsTitleVisible":false`
+		titleOn  = `"IsTitleVisible":true`
+		dark     = "data-theme='" + config.ThemeDark + "'"
+		light    = "data-theme='" + config.ThemeLight + "'"
+	)
+	for n, tc := range map[string]struct {
+		// query, if not empty, holds params to save in the session.
+		query string
+		want  []string
+	}{
+		"noSession": {
+			want: []string{navOff, titleOn, dark}},
+		"navOn": {
+			query: config.KeyIsNavOn + "=true",
+			want:  []string{navOn, titleOn, dark}},
+		"titleOff": {
+			query: config.KeyIsTitleOn + "=false",
+			want:  []string{navOff, titleOff, dark}},
+		"lightTheme": {
+			query: config.KeyTheme + "=" + config.ThemeLight,
+			want:  []string{navOff, titleOn, light}},
+		"everything": {
+			query: config.KeyIsNavOn + "=true&" + config.KeyIsTitleOn +
+				"=false&" + config.KeyTheme + "=" + config.ThemeLight,
+			want: []string{navOn, titleOff, light}},
+	} {
+		t.Run(n, func(t *testing.T) {
+			var cookies []*http.Cookie
+			if tc.query != "" {
+				cookies = saveSession(t, h, tc.query)
+				assert.NotEmpty(t, cookies)
+			}
+			page := renderApp(t, h, cookies)
+			for _, w := range tc.want {
+				assert.Contains(t, page, w)
+			}
+		})
+	}
+	// The page cache mustn't leak one session's choices to another.
+	_ = renderApp(t, h, saveSession(t, h, config.KeyIsNavOn+"=true"))
+	assert.Contains(t, renderApp(t, h, nil), navOff)
+}
+
+func TestHandleGetVersion(t *testing.T) {
+	h := makeServer(t, "# hey\n", &shell.Echo{}).Handler()
+	rec := doRequest(h, http.MethodGet, config.Dynamic(config.RouteVersion))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var got map[string]string
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, provenance.DefaultVersion, got["version"])
+	assert.Equal(t, provenance.DefaultGitCommit, got["gitCommit"])
+	assert.Equal(t, provenance.DefaultBuildDate, got["buildDate"])
+	assert.Equal(t, runtime.Version(), got["goVersion"])
+}
+
+func TestLissajousLimits(t *testing.T) {
+	s := makeServer(t, "# hey\n", &shell.Echo{})
+	assert.Error(t, s.SetLissajousLimits(LissajousLimits{MaxSize: 10}))
+	assert.NoError(t, s.SetLissajousLimits(
+		LissajousLimits{MaxSize: 10, MaxCycles: 3, MaxFrames: 2}))
+	h := s.Handler()
+	image := config.Dynamic(config.RouteLissajous)
+	rec := doRequest(h, http.MethodGet, image+"?s=10&c=3&n=2")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = doRequest(h, http.MethodGet, image+"?s=11&c=3&n=2")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "s=11 out of range 1-10")
+	// The defaults exceed these limits.
+	rec = doRequest(h, http.MethodGet, image)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestParamParsing(t *testing.T) {
+	h := makeServer(t, "# hey\n```\necho hi\n```\n", &shell.Echo{}).Handler()
+	image := config.Dynamic(config.RouteLissajous)
+	save := config.Dynamic(config.RouteSave)
+	for n, tc := range map[string]struct {
+		method string
+		url    string
+		code   int
+	}{
+		"imageDefaults": {
+			url: image + "?s=5&n=2", code: http.StatusOK},
+		"imageBadInt": {
+			url: image + "?s=5&n=2&c=abc", code: http.StatusBadRequest},
+		"imageHuge": {
+			url: image + "?s=1000000", code: http.StatusBadRequest},
+		"imageTooManyFrames": {
+			url: image + "?s=5&n=100000", code: http.StatusBadRequest},
+		"imageZero": {
+			url: image + "?s=0", code: http.StatusBadRequest},
+		"imageNegative": {
+			url: image + "?s=5&n=2&c=-3", code: http.StatusBadRequest},
+		"saveGood": {
+			method: http.MethodPost,
+			url:    save + "?" + config.KeyIsNavOn + "=true&" + config.KeyBlockIndex + "=3",
+			code:   http.StatusOK},
+		"saveBadBool": {
+			method: http.MethodPost,
+			url:    save + "?" + config.KeyIsNavOn + "=maybe",
+			code:   http.StatusBadRequest},
+		"saveBadInt": {
+			method: http.MethodPost,
+			url:    save + "?" + config.KeyMdFileIndex + "=1st",
+			code:   http.StatusBadRequest},
+		"saveBadTheme": {
+			method: http.MethodPost,
+			url:    save + "?" + config.KeyTheme + "=plaid",
+			code:   http.StatusBadRequest},
+		"runGood": {
+			method: http.MethodPost, url: runBlockUrl(""), code: http.StatusOK},
+		"runBadIndex": {
+			method: http.MethodPost,
+			url: config.Dynamic(config.RouteRunBlock) + "?" +
+				config.KeyMdSessID + "=abc&" + config.KeyMdFileIndex + "=0&" +
+				config.KeyBlockIndex + "=x",
+			code: http.StatusBadRequest},
+		"runIndexOutOfRange": {
+			method: http.MethodPost,
+			url: config.Dynamic(config.RouteRunBlock) + "?" +
+				config.KeyMdSessID + "=abc&" + config.KeyMdFileIndex + "=7",
+			code: http.StatusBadRequest},
+		"runGoodTrace": {
+			method: http.MethodPost,
+			url:    runBlockUrl("&" + config.KeyTrace + "=true"),
+			code:   http.StatusOK},
+		"runBadTrace": {
+			method: http.MethodPost,
+			url:    runBlockUrl("&" + config.KeyTrace + "=yes"),
+			code:   http.StatusBadRequest},
+	} {
+		t.Run(n, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			rec := doRequest(h, method, tc.url)
+			assert.Equal(t, tc.code, rec.Code, rec.Body.String())
+		})
+	}
+}
+
+func TestHandleRunCodeBlockBody(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := shell.NewManagedShell(shPath)
+	assert.NoError(t, ms.Start(timeout))
+	defer func() { _ = ms.Stop(timeout) }()
+	h := makeServer(t, "# hey\n```\necho hi\n```\n", ms).Handler()
+	for n, tc := range map[string]struct {
+		contentType string
+		body        string
+		code        int
+		stdout      string
+	}{
+		"noBody": {
+			code: http.StatusOK, stdout: "hi"},
+		"textIgnored": {
+			contentType: "text/plain", body: `{"interp": "cat"}`,
+			code: http.StatusOK, stdout: "hi"},
+		"json": {
+			contentType: "application/json", body: `{"interp": "cat"}`,
+			code: http.StatusOK, stdout: "echo hi"},
+		"jsonWithCharset": {
+			contentType: "application/json; charset=utf-8",
+			body:        `{"interp": "cat", "timeoutSec": 10}`,
+			code:        http.StatusOK, stdout: "echo hi"},
+		"jsonEmptyObject": {
+			contentType: "application/json", body: `{}`,
+			code: http.StatusOK, stdout: "hi"},
+		"malformed": {
+			contentType: "application/json", body: `{"interp": `,
+			code: http.StatusBadRequest},
+		"wrongType": {
+			contentType: "application/json", body: `{"timeoutSec": "ten"}`,
+			code: http.StatusBadRequest},
+		"unknownField": {
+			contentType: "application/json", body: `{"command": "rm -rf /"}`,
+			code: http.StatusBadRequest},
+		"trailingData": {
+			contentType: "application/json", body: `{} {}`,
+			code: http.StatusBadRequest},
+		"negativeTimeout": {
+			contentType: "application/json", body: `{"timeoutSec": -1}`,
+			code: http.StatusBadRequest},
+		"badColor": {
+			contentType: "application/json", body: `{"color": "plaid"}`,
+			code: http.StatusBadRequest},
+	} {
+		t.Run(n, func(t *testing.T) {
+			rec := doPost(h, runBlockUrl(""), tc.contentType, tc.body)
+			if !assert.Equal(t, tc.code, rec.Code, rec.Body.String()) ||
+				tc.code != http.StatusOK {
+				return
+			}
+			var res RunResult
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert.Equal(t, tc.stdout, res.Stdout)
+			assert.Empty(t, res.Error)
+		})
+	}
+}
+
+func TestHandleRunCodeBlockExitCode(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := shell.NewManagedShell(shPath)
+	assert.NoError(t, ms.Start(timeout))
+	defer func() { _ = ms.Stop(timeout) }()
+	h := makeServer(t, "# hey\n```\necho before\n(exit 3)\n```\n", ms).Handler()
+	for n, query := range map[string]string{
+		"plain":  "",
+		"traced": "&" + config.KeyTrace + "=true",
+	} {
+		t.Run(n, func(t *testing.T) {
+			rec := doRequest(h, http.MethodPost, runBlockUrl(query))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			var res RunResult
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert.Equal(t, "before", res.Stdout)
+			assert.Equal(t, 3, res.ExitCode)
+		})
+	}
+}
+
+// blockingExecutor runs nothing, waiting instead for its context to end.
+type blockingExecutor struct{}
+
+func (blockingExecutor) Execute(ctx context.Context, _ string) (*shell.Result, error) {
+	<-ctx.Done()
+	return &shell.Result{}, ctx.Err()
+}
+
+func TestHandleRunCodeBlockTimeout(t *testing.T) {
+	s := makeServer(t, "# hey\n```\nsleep 99\n```\n", blockingExecutor{})
+	s.SetRunTimeout(20 * time.Millisecond)
+	h := s.Handler()
+	for n, tc := range map[string]struct {
+		body string
+	}{
+		"serverTimeout":  {},
+		"requestTimeout": {body: `{"timeoutSec": 1}`},
+	} {
+		t.Run(n, func(t *testing.T) {
+			var rec *httptest.ResponseRecorder
+			if tc.body == "" {
+				rec = doRequest(h, http.MethodPost, runBlockUrl(""))
+			} else {
+				rec = doPost(h, runBlockUrl(""), "application/json", tc.body)
+			}
+			if !assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String()) {
+				return
+			}
+			var res RunResult
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert.Contains(t, res.Error, context.DeadlineExceeded.Error())
+			assert.True(t, res.TimedOut)
+			assert.Equal(t, ReasonTimeout, res.Reason)
+		})
+	}
+}
+
+func TestHandleRunCodeBlockFailureReason(t *testing.T) {
+	oops := errors.New("oops")
+	ex := shelltest.NewFakeExecutor().
+		FailOn("false", shell.Result{}, oops).
+		On("(exit 3)", shell.Result{ExitCode: 3})
+	h := makeServer(t, "# hey\n```\nfalse\n```\n```\n(exit 3)\n```\n", ex).Handler()
+	for n, tc := range map[string]struct {
+		blockIndex string
+		reason     string
+	}{
+		"error":       {blockIndex: "0", reason: ReasonError},
+		"nonZeroExit": {blockIndex: "1"},
+	} {
+		t.Run(n, func(t *testing.T) {
+			rec := doRequest(h, http.MethodPost, config.Dynamic(config.RouteRunBlock)+
+				"?"+config.KeyMdSessID+"=abc&"+config.KeyMdFileIndex+"=0&"+
+				config.KeyBlockIndex+"="+tc.blockIndex)
+			if !assert.Equal(t, http.StatusOK, rec.Code) {
+				return
+			}
+			var res RunResult
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert.False(t, res.TimedOut)
+			assert.Equal(t, tc.reason, res.Reason)
+		})
+	}
+}
+
+func TestHandleRunCodeBlockShellTimeout(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	ms := shell.NewManagedShell(shPath)
+	assert.NoError(t, ms.Start(timeout))
+	defer func() { _ = ms.Stop(timeout) }()
+	s := makeServer(t, "# hey\n```\necho started\nsleep 2\necho done\n```\n", ms)
+	s.SetRunTimeout(200 * time.Millisecond)
+	rec := doRequest(s.Handler(), http.MethodPost, runBlockUrl(""))
+	if !assert.Equal(t, http.StatusOK, rec.Code) {
+		return
+	}
+	var res RunResult
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.NotEmpty(t, res.Error)
+	assert.True(t, res.TimedOut, res.Error)
+	assert.Equal(t, ReasonTimeout, res.Reason)
+	assert.Equal(t, int64(200), res.TimeoutMs)
+	// What came before the timeout is kept.
+	assert.Equal(t, "started", res.Stdout)
+}
+
+// deadlineExecutor records the time left before its context's deadline.
+type deadlineExecutor struct {
+	left time.Duration
+}
+
+func (de *deadlineExecutor) Execute(ctx context.Context, _ string) (*shell.Result, error) {
+	de.left = 0
+	if deadline, ok := ctx.Deadline(); ok {
+		de.left = time.Until(deadline)
+	}
+	return &shell.Result{}, nil
+}
+
+func TestHandleRunCodeBlockByName(t *testing.T) {
+	const md = "# hey\n```\necho a\n```\n" +
+		"<!-- @name=greet -->\n```\necho b\n```\n"
+	for n, tc := range map[string]struct {
+		name     string
+		wantCode int
+		wantOut  string
+	}{
+		"explicit":  {name: "greet", wantCode: http.StatusOK, wantOut: "b"},
+		"generated": {name: "echo", wantCode: http.StatusOK, wantOut: "a"},
+		"missing":   {name: "nope", wantCode: http.StatusNotFound},
+	} {
+		t.Run(n, func(t *testing.T) {
+			s := makeServer(t, md, shelltest.NewFakeExecutor().
+				On("echo a\n", shell.Result{Stdout: []string{"a"}}).
+				On("echo b\n", shell.Result{Stdout: []string{"b"}}))
+			rec := doPost(s.Handler(),
+				runBlockUrl("&"+config.KeyBlockName+"="+tc.name), "text/plain", "")
+			if !assert.Equal(t, tc.wantCode, rec.Code, rec.Body.String()) {
+				return
+			}
+			if tc.wantCode != http.StatusOK {
+				assert.Contains(t, rec.Body.String(), `no block named "nope"`)
+				return
+			}
+			var res RunResult
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert.Equal(t, tc.wantOut, res.Stdout)
+		})
+	}
+}
+
+func TestHandleRunCodeBlockNotRunnable(t *testing.T) {
+	const md = "# hey\n```\necho a\n```\n```yaml\nkind: Pod\n```\n"
+	ex := shelltest.NewFakeExecutor()
+	h := makeServer(t, md, ex).Handler()
+	for _, q := range []string{
+		config.KeyBlockIndex + "=1",
+		config.KeyBlockName + "=kindPod",
+	} {
+		rec := doPost(h, config.Dynamic(config.RouteRunBlock)+"?"+
+			config.KeyMdSessID+"=abc&"+config.KeyMdFileIndex+"=0&"+q,
+			"text/plain", "")
+		assert.Equal(t, http.StatusBadRequest, rec.Code, q)
+		assert.Contains(t, rec.Body.String(), `block "kindPod" is not runnable`)
+	}
+	assert.Empty(t, ex.Calls())
+}
+
+func TestHandleRunCodeBlockTimeoutPrecedence(t *testing.T) {
+	const md = "# hey\n```\necho plain\n```\n" +
+		"<!-- @timeout=7 -->\n```\necho labelled\n```\n"
+	for n, tc := range map[string]struct {
+		server time.Duration
+		bix    string
+		body   string
+		want   time.Duration
+	}{
+		"none":               {bix: "0"},
+		"server":             {server: 3 * time.Second, bix: "0", want: 3 * time.Second},
+		"labelBeatsServer":   {server: 3 * time.Second, bix: "1", want: 7 * time.Second},
+		"labelAlone":         {bix: "1", want: 7 * time.Second},
+		"requestBeatsLabel":  {server: 3 * time.Second, bix: "1", body: `{"timeoutSec": 11}`, want: 11 * time.Second},
+		"requestBeatsServer": {server: 3 * time.Second, bix: "0", body: `{"timeoutSec": 11}`, want: 11 * time.Second},
+		"zeroRequestIgnored": {server: 3 * time.Second, bix: "1", body: `{"timeoutSec": 0}`, want: 7 * time.Second},
+	} {
+		t.Run(n, func(t *testing.T) {
+			ex := &deadlineExecutor{}
+			s := makeServer(t, md, ex)
+			s.SetRunTimeout(tc.server)
+			url := config.Dynamic(config.RouteRunBlock) +
+				"?" + config.KeyMdSessID + "=abc&" +
+				config.KeyMdFileIndex + "=0&" + config.KeyBlockIndex + "=" + tc.bix
+			body := tc.body
+			if body == "" {
+				body = "{}"
+			}
+			rec := doPost(s.Handler(), url, "application/json", body)
+			if !assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String()) {
+				return
+			}
+			var res RunResult
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert.Equal(t, tc.want.Milliseconds(), res.TimeoutMs)
+			if tc.want == 0 {
+				assert.Zero(t, ex.left, "should have no deadline")
+				return
+			}
+			assert.InDelta(t, tc.want, ex.left, float64(time.Second))
+		})
+	}
+}
+
+func TestHandleRunCodeBlock(t *testing.T) {
+	const md = "# hey\n```\necho hi\n```\n" +
+		"<!-- @interp=python3 -->\n```\nprint('hi')\n```\n" +
+		"```\nexit 3\n```\n" +
+		"```\nsleep 99\n```\n" +
+		"```\nwarn 0\n```\n" +
+		"```\nwarn 1\n```\n"
+	oops := errors.New("oops")
+	runUrl := func(bix int, query string) string {
+		return config.Dynamic(config.RouteRunBlock) +
+			"?" + config.KeyMdSessID + "=abc&" +
+			config.KeyMdFileIndex + "=0&" +
+			config.KeyBlockIndex + "=" + strconv.Itoa(bix) + query
+	}
+	for n, tc := range map[string]struct {
+		url  string
+		ran  string
+		want RunResult
+	}{
+		"plain": {
+			url:  runUrl(0, ""),
+			ran:  "echo hi\n",
+			want: RunResult{Stdout: "hi"},
+		},
+		"interpQuery": {
+			url: runUrl(0, "&"+config.KeyInterp+"=cat"),
+			ran: shell.PipedTo("cat", "echo hi\n"),
+		},
+		"interpLabel": {
+			url:  runUrl(1, ""),
+			ran:  shell.PipedTo("python3", "print('hi')\n"),
+			want: RunResult{Stdout: "hi from python"},
+		},
+		"traced": {
+			url:  runUrl(0, "&"+config.KeyTrace+"=true"),
+			ran:  shell.Traced("echo hi\n"),
+			want: RunResult{Stdout: "hi", Stderr: "+ echo hi"},
+		},
+		"exitCode": {
+			url:  runUrl(2, ""),
+			ran:  "exit 3\n",
+			want: RunResult{ExitCode: 3},
+		},
+		"error": {
+			url:  runUrl(3, ""),
+			ran:  "sleep 99\n",
+			want: RunResult{Stdout: "zzz", Error: "oops", Reason: ReasonError},
+		},
+		"stderrOnSuccess": {
+			url:  runUrl(4, ""),
+			ran:  "warn 0\n",
+			want: RunResult{Stdout: "done", Stderr: "careful", StderrIsWarning: true},
+		},
+		"stderrOnFailure": {
+			url:  runUrl(5, ""),
+			ran:  "warn 1\n",
+			want: RunResult{Stderr: "careful", ExitCode: 1},
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			fake := shelltest.NewFakeExecutor().
+				On("echo hi", shell.Result{Stdout: []string{"hi"}}).
+				OnMatch(`^set -x\n`, shell.Result{
+					Stdout: []string{"hi"}, Stderr: []string{"+ echo hi"}}).
+				OnMatch(`^python3 `, shell.Result{Stdout: []string{"hi from python"}}).
+				On("exit 3", shell.Result{ExitCode: 3}).
+				On("warn 0", shell.Result{
+					Stdout: []string{"done"}, Stderr: []string{"careful"}}).
+				On("warn 1", shell.Result{Stderr: []string{"careful"}, ExitCode: 1}).
+				FailOn(`^sleep`, shell.Result{Stdout: []string{"zzz"}}, oops)
+			h := makeServer(t, md, fake).Handler()
+			rec := doRequest(h, http.MethodPost, tc.url)
+			if !assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String()) {
+				return
+			}
+			assert.Equal(t, []string{tc.ran}, fake.Calls())
+			var res RunResult
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert.Equal(t, tc.want, res)
+		})
+	}
+}
+
+func TestServeWithoutShell(t *testing.T) {
+	ms := shell.NewManagedShell("/no/such/shell")
+	err := ms.Start(timeout)
+	if !assert.Error(t, err) {
+		t.FailNow()
+	}
+	h := makeServer(t, "# hey\n```\necho hi\n```\n", shell.NewUnavailable(err)).Handler()
+	for n, tc := range map[string]struct {
+		method string
+		url    string
+		code   int
+	}{
+		"healthz": {url: "/healthz", code: http.StatusOK},
+		"page":    {url: "/a.md", code: http.StatusOK},
+		"html": {
+			url: config.Dynamic(config.RouteHtmlForFile) +
+				"?" + config.KeyMdFileIndex + "=0",
+			code: http.StatusOK},
+		"run": {
+			method: http.MethodPost,
+			url:    runBlockUrl(""),
+			code:   http.StatusServiceUnavailable},
+		"cwd": {
+			url:  config.Dynamic(config.RouteCwd),
+			code: http.StatusServiceUnavailable},
+	} {
+		t.Run(n, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			rec := doRequest(h, method, tc.url)
+			assert.Equal(t, tc.code, rec.Code)
+			if tc.code == http.StatusServiceUnavailable {
+				assert.Contains(t, rec.Body.String(), "/no/such/shell")
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@
 package server
 
 import (
+	"fmt"
 	"image"
 	"image/color"
 	"image/gif"
@@ -23,6 +24,43 @@ const (
 	blackIndex = 1 // next color in palette
 )
 
+// LissajousLimits caps the parameters of a request for a Lissajous
+// image, since the time and memory needed to make one grow with each.
+type LissajousLimits struct {
+	// MaxSize caps the size, i.e. half the width, of the canvas.
+	MaxSize int
+	// MaxCycles caps the number of x oscillator revolutions.
+	MaxCycles int
+	// MaxFrames caps the number of animation frames.
+	MaxFrames int
+}
+
+// DefaultLissajousLimits allow images a little bigger than the
+// default one, i.e. size 300, 30 cycles and 100 frames.
+var DefaultLissajousLimits = LissajousLimits{
+	MaxSize:   400,
+	MaxCycles: 100,
+	MaxFrames: 150,
+}
+
+// check returns an error for parameters beyond the limits,
+// or less than one.
+func (l LissajousLimits) check(size, cycles, nFrames int) error {
+	for _, p := range []struct {
+		name   string
+		v, max int
+	}{
+		{"s", size, l.MaxSize},
+		{"c", cycles, l.MaxCycles},
+		{"n", nFrames, l.MaxFrames},
+	} {
+		if p.v < 1 || p.v > p.max {
+			return fmt.Errorf("%s=%d out of range 1-%d", p.name, p.v, p.max)
+		}
+	}
+	return nil
+}
+
 // Lissajous returns an image.
 // image canvas covers [-size to +size]
 // nFrames is number of animation frames
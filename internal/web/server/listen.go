@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// UnixPrefix marks a Serve address as the path to a Unix domain
+// socket, e.g. "unix:/run/mdrip.sock".
+const UnixPrefix = "unix:"
+
+// listen returns a listener on the given address, either
+// "host:port" or a UnixPrefix'd socket path.
+func listen(addr string) (net.Listener, error) {
+	path, isUnix := strings.CutPrefix(addr, UnixPrefix)
+	if !isUnix {
+		return net.Listen("tcp", addr)
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	// The listener removes the socket file when closed.
+	return net.Listen("unix", path)
+}
+
+// removeStaleSocket removes the socket at the given path if
+// no one is listening on it, e.g. if a previous server was killed.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("%s is in use", path)
+	}
+	return os.Remove(path)
+}
+
+// shutdownOnSignal shuts the server down on SIGINT or SIGTERM, e.g.
+// on Ctrl-C, so that the shell running code blocks isn't orphaned,
+// and any Unix socket file is removed, before the program exits.
+// The returned stop function waits for a shutdown in progress.
+func (ws *Server) shutdownOnSignal() (stop func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		select {
+		case sig := <-ch:
+			slog.Info("shutting down", "signal", sig)
+			ctx, cancel := context.WithTimeout(
+				context.Background(), shutdownTimeout)
+			defer cancel()
+			if err := ws.Shutdown(ctx); err != nil {
+				slog.Warn("unclean shutdown", "err", err)
+			}
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(done)
+		<-finished
+	}
+}
+
+// shutdownTimeout limits the time spent shutting down on a signal.
+const shutdownTimeout = 5 * time.Second
+
+// stopper is implemented by executors running a shell that must
+// be stopped, given a context bounding the wait for it.
+type stopper interface {
+	StopContext(ctx context.Context) error
+}
+
+// Shutdown gracefully shuts the server down, causing Serve to return.
+// It stops accepting connections, waits for requests in progress,
+// e.g. to run a code block, to finish, then stops the executor's
+// shell, if it has one.  If the context is done first, connections
+// still open are closed, and the shell is stopped anyway.
+func (ws *Server) Shutdown(ctx context.Context) error {
+	ws.mu.Lock()
+	srv, rSrv := ws.httpSrv, ws.redirectSrv
+	ws.mu.Unlock()
+	var errs []error
+	if rSrv != nil {
+		errs = append(errs, rSrv.Shutdown(ctx))
+	}
+	if srv != nil {
+		if err := srv.Shutdown(ctx); err != nil {
+			errs = append(errs, err, srv.Close())
+		}
+	}
+	if st, ok := ws.executor.(stopper); ok {
+		d := shutdownTimeout
+		if deadline, ok := ctx.Deadline(); ok {
+			d = max(time.Until(deadline), 100*time.Millisecond)
+		}
+		// Not canceled with the context, so that the shell gets
+		// a moment to stop, even once the context is done.
+		sctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), d)
+		defer cancel()
+		if err := st.StopContext(sctx); err != nil {
+			errs = append(errs, fmt.Errorf("unable to stop shell; %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close immediately closes the server's listeners and connections,
+// causing Serve to return.
+func (ws *Server) Close() error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.redirectSrv != nil {
+		_ = ws.redirectSrv.Close()
+	}
+	if ws.httpSrv == nil {
+		return nil
+	}
+	return ws.httpSrv.Close()
+}
+
+func (ws *Server) setHttpServers(srv, redirectSrv *http.Server) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.httpSrv, ws.redirectSrv = srv, redirectSrv
+}
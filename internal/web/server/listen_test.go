@@ -0,0 +1,212 @@
+package server_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/monopole/mdrip/v2/internal/web/config"
+	. "github.com/monopole/mdrip/v2/internal/web/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeUnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "mdrip.sock")
+	// A socket left behind by a killed server shouldn't get in the way.
+	stale, err := net.Listen("unix", sock)
+	assert.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	assert.NoError(t, stale.Close())
+
+	s := makeServer(t, "# hey\n", &shell.Echo{})
+	done := make(chan error)
+	go func() { done <- s.Serve(UnixPrefix + sock) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	var resp *http.Response
+	assert.Eventually(t, func() bool {
+		resp, err = client.Get("http://mdrip/healthz")
+		return err == nil
+	}, timeout, 10*time.Millisecond)
+	if assert.NoError(t, err) {
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "ok\n", string(body))
+	}
+
+	assert.NoError(t, s.Close())
+	select {
+	case err = <-done:
+		assert.NoError(t, err)
+	case <-time.After(timeout):
+		t.Fatal("server didn't stop")
+	}
+	_, err = os.Stat(sock)
+	assert.ErrorIs(t, err, os.ErrNotExist, "socket should be removed")
+}
+
+func TestServeUnixSocketNotASocket(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "precious.txt")
+	assert.NoError(t, os.WriteFile(f, []byte("hey"), 0644))
+	s := makeServer(t, "# hey\n", &shell.Echo{})
+	assert.Error(t, s.Serve(UnixPrefix+f))
+	_, err := os.Stat(f)
+	assert.NoError(t, err, "file should still exist")
+}
+
+func TestServeTwoServers(t *testing.T) {
+	dir := t.TempDir()
+	var clients []*http.Client
+	for i, md := range []string{"# apples\n", "# bananas\n"} {
+		sock := filepath.Join(dir, "mdrip"+strconv.Itoa(i)+".sock")
+		s := makeServer(t, md, &shell.Echo{})
+		done := make(chan error)
+		go func() { done <- s.Serve(UnixPrefix + sock) }()
+		defer func() {
+			assert.NoError(t, s.Close())
+			assert.NoError(t, <-done)
+		}()
+		clients = append(clients, &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+			},
+		}})
+	}
+	for i, want := range []string{"apples", "bananas"} {
+		var (
+			resp *http.Response
+			err  error
+		)
+		assert.Eventually(t, func() bool {
+			resp, err = clients[i].Get("http://mdrip" +
+				config.Dynamic(config.RouteHtmlForFile) +
+				"?" + config.KeyMdFileIndex + "=0")
+			return err == nil
+		}, timeout, 10*time.Millisecond)
+		if assert.NoError(t, err) {
+			body, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Contains(t, string(body), want)
+		}
+	}
+	// Routes belong to each server, not to the process.
+	_, pattern := http.DefaultServeMux.Handler(
+		httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Empty(t, pattern)
+}
+
+// serveOnSocket starts serving in the background at a Unix socket,
+// returning a client for it, once it's up, and Serve's result.
+func serveOnSocket(t *testing.T, s *Server) (*http.Client, <-chan error) {
+	sock := filepath.Join(t.TempDir(), "mdrip.sock")
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(UnixPrefix + sock) }()
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	assert.Eventually(t, func() bool {
+		resp, err := client.Get("http://mdrip/healthz")
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err == nil
+	}, timeout, 10*time.Millisecond)
+	return client, done
+}
+
+func TestShutdown(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	for n, tc := range map[string]struct {
+		shutdown func(s *Server) error
+	}{
+		"call": {
+			shutdown: func(s *Server) error {
+				return s.Shutdown(context.Background())
+			},
+		},
+		"signal": {
+			shutdown: func(*Server) error {
+				return syscall.Kill(os.Getpid(), syscall.SIGTERM)
+			},
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			sh := shell.NewManagedShell(shPath)
+			if !assert.NoError(t, sh.Start(timeout)) {
+				t.FailNow()
+			}
+			s := makeServer(t, "# hey\n```\nsleep 1; echo finished\n```\n", sh)
+			client, done := serveOnSocket(t, s)
+
+			// A block running at shutdown gets to finish.
+			ran := make(chan string, 1)
+			go func() {
+				resp, err := client.Post("http://mdrip"+runBlockUrl(""), "", nil)
+				if !assert.NoError(t, err) {
+					ran <- ""
+					return
+				}
+				body, _ := io.ReadAll(resp.Body)
+				_ = resp.Body.Close()
+				ran <- string(body)
+			}()
+			time.Sleep(300 * time.Millisecond)
+			assert.NoError(t, tc.shutdown(s))
+
+			select {
+			case err := <-done:
+				assert.NoError(t, err)
+			case <-time.After(timeout):
+				t.Fatal("server didn't stop")
+			}
+			assert.Contains(t, <-ran, "finished")
+			// The shell is reaped.
+			_, err := sh.Execute(context.Background(), "echo hi")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestShutdownKillsStuckShell(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	sh := shell.NewManagedShell(shPath)
+	if !assert.NoError(t, sh.Start(timeout)) {
+		t.FailNow()
+	}
+	s := makeServer(t, "# hey\n", sh)
+	// The abandoned run keeps the shell busy.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	_, err := sh.Execute(ctx, "sleep 5")
+	assert.ErrorIs(t, err, shell.ErrAbandoned)
+
+	// The shell gets a moment, though the context is done.
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	start := time.Now()
+	err = s.Shutdown(ctx)
+	assert.ErrorContains(t, err, "unable to stop shell")
+	assert.ErrorContains(t, err, "killed")
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
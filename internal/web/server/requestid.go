@@ -0,0 +1,45 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"regexp"
+
+	"github.com/monopole/mdrip/v2/internal/utils"
+)
+
+// HeaderRequestId is the header holding a request's ID.  An ID sent
+// by the client, e.g. by a proxy, is used as is; otherwise the server
+// makes one.  Either way, it's sent back in the response, and tags
+// the logs written while handling the request.
+const HeaderRequestId = "X-Request-ID"
+
+// goodRequestId matches IDs safe to copy into logs and headers.
+var goodRequestId = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// withRequestId assigns each request an ID, and puts a logger
+// tagged with it in the request's context.
+func withRequestId(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(HeaderRequestId)
+		if !goodRequestId.MatchString(id) {
+			id = makeRequestId()
+		}
+		w.Header().Set(HeaderRequestId, id)
+		l := utils.Logger(req.Context()).With("requestId", id)
+		h.ServeHTTP(w, req.WithContext(utils.WithLogger(req.Context(), l)))
+	})
+}
+
+func makeRequestId() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// logger returns the logger for the request.
+func logger(req *http.Request) *slog.Logger {
+	return utils.Logger(req.Context())
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/monopole/mdrip/v2/internal/web/config"
+	"github.com/monopole/mdrip/v2/runner"
+)
+
+// FileResult is sent in JSON form in response to running a file.
+type FileResult struct {
+	// Path is the path to the file run.
+	Path string `json:"path"`
+	// Passed is true if every block run passed.
+	Passed bool `json:"passed"`
+	// Results hold the blocks run: all of them, or, if failing fast,
+	// those up to and including the first failure.
+	Results []TagBlockResult `json:"results"`
+	// Error describes the first failure, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// handleRunFile runs the runnable blocks of the file given by the
+// file index param, in order, handling their labels as in
+// runner.RunFile.  The include and exclude params narrow the
+// selection, as in handleRunTag.  Unless the failFast param is false,
+// the run stops at the first failing block; otherwise every block is
+// run, and every failure recorded.
+func (ws *Server) handleRunFile(wr http.ResponseWriter, req *http.Request) {
+	failFast, err1 := parseBoolParam(config.KeyFailFast, req, true)
+	mdFileIndex, err2 := parseIntParam(config.KeyMdFileIndex, req, -1)
+	if err := errors.Join(err1, err2); err != nil {
+		writeError(wr, req, http.StatusBadRequest, err)
+		return
+	}
+	files := ws.dLoader.RenderedFiles()
+	if !inRange(wr, req, config.KeyMdFileIndex, mdFileIndex, len(files)) {
+		return
+	}
+	mdFile := files[mdFileIndex]
+	opts := runner.Options{
+		Include:      getLabelsParam(config.KeyInclude, req),
+		Exclude:      getLabelsParam(config.KeyExclude, req),
+		Executor:     ws.executor,
+		BlockTimeout: ws.runTimeout,
+		KeepGoing:    !failFast,
+	}
+	var blocks []*loader.CodeBlock
+	for _, b := range mdFile.Blocks {
+		if b.IsRunnable() && opts.Selects(b) {
+			blocks = append(blocks, b)
+		}
+	}
+	if len(blocks) == 0 {
+		writeError(wr, req, http.StatusNotFound,
+			errors.New("no runnable blocks selected in file"))
+		return
+	}
+	logger(req).Info("running file", "path", mdFile.Path,
+		config.KeyFailFast, failFast, "numBlocks", len(blocks))
+	results, err, ok := ws.runBlocks(wr, req, blocks, opts)
+	if !ok {
+		return
+	}
+	res := FileResult{
+		Path:    string(mdFile.Path),
+		Passed:  err == nil,
+		Results: results,
+	}
+	if err != nil {
+		logger(req).Info("file failed", "path", mdFile.Path, "err", err)
+		res.Error = err.Error()
+	}
+	jsn, err := json.Marshal(res)
+	if err != nil {
+		writeError(wr, req, http.StatusInternalServerError, err)
+		return
+	}
+	wr.Header().Set("Content-Type", "application/json")
+	_, _ = wr.Write(jsn)
+}
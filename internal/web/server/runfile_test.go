@@ -0,0 +1,118 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/monopole/mdrip/v2/internal/shell/shelltest"
+	"github.com/monopole/mdrip/v2/internal/web/config"
+	. "github.com/monopole/mdrip/v2/internal/web/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleRunFile(t *testing.T) {
+	const md = "# A\n```\necho one\n```\n" +
+		"```\nfalse\n```\n" +
+		"```yaml\nkind: Pod\n```\n" +
+		"<!-- @teardown -->\n```\necho three\n```\n"
+	for n, tc := range map[string]struct {
+		fix        string
+		query      string
+		noFailure  bool
+		code       int
+		wantRun    []string
+		wantPassed []bool
+	}{
+		"failFastByDefault": {
+			code:       http.StatusOK,
+			wantRun:    []string{"echo one", "false"},
+			wantPassed: []bool{true, false},
+		},
+		"failFast": {
+			query:      "&" + config.KeyFailFast + "=true",
+			code:       http.StatusOK,
+			wantRun:    []string{"echo one", "false"},
+			wantPassed: []bool{true, false},
+		},
+		"continueOnError": {
+			query:      "&" + config.KeyFailFast + "=false",
+			code:       http.StatusOK,
+			wantRun:    []string{"echo one", "false", "echo three"},
+			wantPassed: []bool{true, false, true},
+		},
+		"allPass": {
+			noFailure:  true,
+			code:       http.StatusOK,
+			wantRun:    []string{"echo one", "false", "echo three"},
+			wantPassed: []bool{true, true, true},
+		},
+		"exclude": {
+			query:      "&" + config.KeyFailFast + "=false&" + config.KeyExclude + "=teardown",
+			code:       http.StatusOK,
+			wantRun:    []string{"echo one", "false"},
+			wantPassed: []bool{true, false},
+		},
+		"include": {
+			noFailure:  true,
+			query:      "&" + config.KeyInclude + "=teardown",
+			code:       http.StatusOK,
+			wantRun:    []string{"echo three"},
+			wantPassed: []bool{true},
+		},
+		"noneSelected": {
+			query: "&" + config.KeyInclude + "=nope",
+			code:  http.StatusNotFound,
+		},
+		"badFailFast": {
+			query: "&" + config.KeyFailFast + "=maybe",
+			code:  http.StatusBadRequest,
+		},
+		"badFile": {
+			fix:  "3",
+			code: http.StatusBadRequest,
+		},
+		"malformedFile": {
+			fix:  "zero",
+			code: http.StatusBadRequest,
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			ex := shelltest.NewFakeExecutor()
+			if !tc.noFailure {
+				ex.On("false", shell.Result{ExitCode: 1})
+			}
+			h := makeServer(t, md, ex).Handler()
+			fix := tc.fix
+			if fix == "" {
+				fix = "0"
+			}
+			rec := doPost(h, config.Dynamic(config.RouteRunFile)+"?"+
+				config.KeyMdFileIndex+"="+fix+tc.query, "text/plain", "")
+			if !assert.Equal(t, tc.code, rec.Code, rec.Body.String()) ||
+				tc.code != http.StatusOK {
+				return
+			}
+			var res FileResult
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert.Equal(t, tc.noFailure, res.Passed)
+			if tc.noFailure {
+				assert.Empty(t, res.Error)
+			} else {
+				assert.Contains(t, res.Error, "a.md:5: block failed with exit 1")
+			}
+			passed := make([]bool, len(res.Results))
+			for i, r := range res.Results {
+				passed[i] = r.Passed
+			}
+			assert.Equal(t, tc.wantPassed, passed)
+			var ran []string
+			for _, c := range ex.Calls() {
+				ran = append(ran, strings.TrimSpace(c))
+			}
+			assert.Equal(t, tc.wantRun, ran)
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SetMaxRuns limits the number of runs, i.e. requests to run a block
+// or a tag, that may be under way at once; zero means no limit.
+// A run beyond the limit waits up to the given time for another to
+// finish, then gives up, with status 429 Too Many Requests, so that
+// a busy host isn't swamped, yet isn't limited to one run at a time.
+// A wait of zero means give up at once.
+func (ws *Server) SetMaxRuns(n int, wait time.Duration) error {
+	if n < 0 {
+		return fmt.Errorf("max runs %d is negative", n)
+	}
+	if wait < 0 {
+		return fmt.Errorf("run queue timeout %s is negative", wait)
+	}
+	ws.runSlots = nil
+	if n > 0 {
+		ws.runSlots = make(chan struct{}, n)
+	}
+	ws.runQueueWait = wait
+	return nil
+}
+
+// acquireRun waits for a run slot, returning a function to free it.
+// If none frees up in time, it writes an error and returns nil.
+func (ws *Server) acquireRun(wr http.ResponseWriter, req *http.Request) func() {
+	if ws.runSlots == nil {
+		return func() {}
+	}
+	release := func() { <-ws.runSlots }
+	select {
+	case ws.runSlots <- struct{}{}:
+		return release
+	default:
+	}
+	if ws.runQueueWait > 0 {
+		logger(req).Debug("waiting for a run slot", "maxRuns", cap(ws.runSlots))
+		timer := time.NewTimer(ws.runQueueWait)
+		defer timer.Stop()
+		select {
+		case ws.runSlots <- struct{}{}:
+			return release
+		case <-req.Context().Done():
+			// The client is gone; there's no one to tell.
+			return nil
+		case <-timer.C:
+		}
+	}
+	wr.Header().Set("Retry-After",
+		strconv.Itoa(max(1, int(ws.runQueueWait.Seconds()))))
+	writeError(wr, req, http.StatusTooManyRequests,
+		fmt.Errorf("too many runs under way; the limit is %d", cap(ws.runSlots)))
+	return nil
+}
@@ -0,0 +1,91 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/stretchr/testify/assert"
+)
+
+// gatedExecutor signals each run's start, then holds the run
+// until the gate is closed.
+type gatedExecutor struct {
+	started chan struct{}
+	gate    chan struct{}
+}
+
+func newGatedExecutor() *gatedExecutor {
+	return &gatedExecutor{
+		started: make(chan struct{}, 10),
+		gate:    make(chan struct{}),
+	}
+}
+
+func (ge *gatedExecutor) Execute(ctx context.Context, _ string) (*shell.Result, error) {
+	ge.started <- struct{}{}
+	select {
+	case <-ge.gate:
+		return &shell.Result{}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestSetMaxRuns(t *testing.T) {
+	s := makeServer(t, "# hey\n```\necho hi\n```\n", &shell.Echo{})
+	assert.NoError(t, s.SetMaxRuns(0, 0))
+	assert.NoError(t, s.SetMaxRuns(2, time.Second))
+	assert.Error(t, s.SetMaxRuns(-1, 0))
+	assert.Error(t, s.SetMaxRuns(1, -time.Second))
+}
+
+func TestMaxRuns(t *testing.T) {
+	for n, tc := range map[string]struct {
+		wait time.Duration
+		// release means open the gate while the second run waits.
+		release  bool
+		wantCode int
+	}{
+		"rejectAtOnce":    {wantCode: http.StatusTooManyRequests},
+		"queueThenReject": {wait: 50 * time.Millisecond, wantCode: http.StatusTooManyRequests},
+		"queueThenRun": {
+			wait: 5 * time.Second, release: true, wantCode: http.StatusOK},
+	} {
+		t.Run(n, func(t *testing.T) {
+			ex := newGatedExecutor()
+			s := makeServer(t, "# hey\n```\necho hi\n```\n", ex)
+			assert.NoError(t, s.SetMaxRuns(1, tc.wait))
+			h := s.Handler()
+
+			first := make(chan *httptest.ResponseRecorder)
+			go func() { first <- doRequest(h, http.MethodPost, runBlockUrl("")) }()
+			<-ex.started
+
+			second := make(chan *httptest.ResponseRecorder)
+			go func() { second <- doRequest(h, http.MethodPost, runBlockUrl("")) }()
+			if tc.release {
+				select {
+				case <-ex.started:
+					t.Fatal("second run started despite the limit")
+				case <-time.After(50 * time.Millisecond):
+				}
+				close(ex.gate)
+			}
+			rec := <-second
+			assert.Equal(t, tc.wantCode, rec.Code, rec.Body.String())
+			if tc.wantCode == http.StatusTooManyRequests {
+				assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+				close(ex.gate)
+			}
+			assert.Equal(t, http.StatusOK, (<-first).Code)
+
+			// The slots are free again.
+			rec = doRequest(h, http.MethodPost, runBlockUrl(""))
+			assert.Equal(t, http.StatusOK, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/monopole/mdrip/v2/internal/ansi"
+	"github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/monopole/mdrip/v2/internal/web/config"
+	"github.com/monopole/mdrip/v2/runner"
+)
+
+// TagBlockResult is the outcome of one of the blocks run for a tag.
+type TagBlockResult struct {
+	// Name is the block's name, unique within its file.
+	Name string `json:"name"`
+	// Path is the path to the file holding the block.
+	Path string `json:"path"`
+	// Line is the line number of the block's opening fence.
+	Line int `json:"line"`
+	// Skipped is true if the block was labelled @skip.
+	Skipped bool   `json:"skipped,omitempty"`
+	Stdout  string `json:"stdout"`
+	Stderr  string `json:"stderr"`
+	// ExitCode is the exit status of the block's last command.
+	ExitCode int `json:"exitCode"`
+	// Passed is true if the block was skipped, or ran to completion
+	// as its labels say it should.
+	Passed bool `json:"passed"`
+	// Error is set if the block didn't finish cleanly.
+	Error string `json:"error,omitempty"`
+}
+
+// TagResult is sent in JSON form in response to running a tag.
+type TagResult struct {
+	// Label is the tag run, if any.
+	Label string `json:"label,omitempty"`
+	// Results hold the blocks run, up to and including any failure.
+	Results []TagBlockResult `json:"results"`
+	// Error describes the first failure, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// handleRunTag runs the blocks having the label param (or all
+// blocks, if there's no label param) across all files, in document
+// order, until one fails.  The include and exclude params narrow
+// the selection, e.g. to all blocks but those labelled teardown.
+// The blocks' labels are handled as in runner.RunFile, e.g. @skip
+// and @exit=2.
+func (ws *Server) handleRunTag(wr http.ResponseWriter, req *http.Request) {
+	label := req.URL.Query().Get(config.KeyLabel)
+	opts := runner.Options{
+		Include:      getLabelsParam(config.KeyInclude, req),
+		Exclude:      getLabelsParam(config.KeyExclude, req),
+		Executor:     ws.executor,
+		BlockTimeout: ws.runTimeout,
+	}
+	candidates := ws.dLoader.AllBlocks()
+	if label != "" {
+		candidates = ws.dLoader.BlocksByLabel(loader.Label(label))
+	}
+	var blocks []*loader.CodeBlock
+	for _, b := range candidates {
+		if b.IsRunnable() && opts.Selects(b) {
+			blocks = append(blocks, b)
+		}
+	}
+	if len(blocks) == 0 {
+		writeError(wr, req, http.StatusNotFound,
+			errors.New("no runnable blocks selected"))
+		return
+	}
+	logger(req).Info("running tag", config.KeyLabel, label, "numBlocks", len(blocks))
+	results, err, ok := ws.runBlocks(wr, req, blocks, opts)
+	if !ok {
+		return
+	}
+	res := TagResult{Label: label, Results: results}
+	if err != nil {
+		logger(req).Info("tag failed", config.KeyLabel, label, "err", err)
+		res.Error = err.Error()
+	}
+	jsn, err := json.Marshal(res)
+	if err != nil {
+		writeError(wr, req, http.StatusInternalServerError, err)
+		return
+	}
+	wr.Header().Set("Content-Type", "application/json")
+	_, _ = wr.Write(jsn)
+}
+
+// runBlocks runs the blocks per runner.RunBlocks, returning their
+// results, and the first failure, if any.  If the blocks can't be run,
+// e.g. because one isn't allowed, it writes an error response and
+// returns false.
+func (ws *Server) runBlocks(
+	wr http.ResponseWriter, req *http.Request, blocks []*loader.CodeBlock,
+	opts runner.Options) (results []TagBlockResult, failure error, ok bool) {
+	for _, b := range blocks {
+		if err := ws.checkAllowed(b.Code(), b.Interpreter()); err != nil {
+			writeError(wr, req, http.StatusForbidden,
+				fmt.Errorf("block %q; %w", b.UniqName(), err))
+			return nil, nil, false
+		}
+	}
+	release := ws.acquireRun(wr, req)
+	if release == nil {
+		return nil, nil, false
+	}
+	defer release()
+	brs, err := runner.RunBlocks(req.Context(), blocks, opts)
+	ws.auditBlocks(req, blocks, brs)
+	if errors.Is(err, shell.ErrUnavailable) {
+		writeError(wr, req, http.StatusServiceUnavailable, err)
+		return nil, nil, false
+	}
+	results = make([]TagBlockResult, len(brs))
+	for i := range brs {
+		r := &brs[i]
+		results[i] = TagBlockResult{
+			Name:     r.Name,
+			Path:     r.Path,
+			Line:     r.Line,
+			Skipped:  r.Skipped,
+			Stdout:   ansi.Strip(strings.Join(r.Stdout, "\n")),
+			Stderr:   ansi.Strip(strings.Join(r.Stderr, "\n")),
+			ExitCode: r.ExitCode,
+			Passed:   r.Passed(),
+		}
+		if r.Err != nil {
+			results[i].Error = r.Err.Error()
+		} else if r.AssertionErr != nil {
+			results[i].Error = r.AssertionErr.Error()
+		}
+	}
+	return results, err, true
+}
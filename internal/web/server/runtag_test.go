@@ -0,0 +1,118 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/monopole/mdrip/v2/internal/shell/shelltest"
+	"github.com/monopole/mdrip/v2/internal/web/config"
+	. "github.com/monopole/mdrip/v2/internal/web/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleRunTag(t *testing.T) {
+	dir := t.TempDir()
+	for name, md := range map[string]string{
+		"a.md": "# A\n<!-- @smoke -->\n```\necho a1\n```\n" +
+			"```\necho a2\n```\n" +
+			"<!-- @smoke @skip -->\n```\necho a3\n```\n" +
+			"<!-- @broken -->\n```\nfalse\n```\n",
+		"b.md": "# B\n```bash {smoke, exit=3}\nexit 3\n```\n" +
+			"<!-- @smoke -->\n```yaml\nkind: Pod\n```\n" +
+			"<!-- @smoke @broken -->\n```\necho b3\n```\n",
+	} {
+		assert.NoError(t, os.WriteFile(
+			filepath.Join(dir, name), []byte(md), 0644))
+	}
+	for n, tc := range map[string]struct {
+		label     string
+		query     string
+		code      int
+		wantRun   []string
+		wantNames []string
+		wantSkips []bool
+		wantErr   string
+	}{
+		"acrossFiles": {
+			label:     "smoke",
+			code:      http.StatusOK,
+			wantRun:   []string{"echo a1", "exit 3", "echo b3"},
+			wantNames: []string{"smoke", "smoke2", "smoke", "smoke3"},
+			wantSkips: []bool{false, true, false, false},
+		},
+		"stopsAtFailure": {
+			label:     "broken",
+			code:      http.StatusOK,
+			wantRun:   []string{"false"},
+			wantNames: []string{"broken"},
+			wantErr:   "a.md:14: block failed with exit 1",
+		},
+		"noLabelExclude": {
+			query:     "&" + config.KeyExclude + "=smoke,broken",
+			code:      http.StatusOK,
+			wantRun:   []string{"echo a2"},
+			wantNames: []string{"echoA2"},
+		},
+		"include": {
+			label:     "smoke",
+			query:     "&" + config.KeyInclude + "=broken",
+			code:      http.StatusOK,
+			wantRun:   []string{"echo b3"},
+			wantNames: []string{"smoke3"},
+		},
+		"allExcluded": {
+			label: "smoke",
+			query: "&" + config.KeyExclude + "=smoke",
+			code:  http.StatusNotFound,
+		},
+		"unknownLabel": {
+			label: "nope",
+			code:  http.StatusNotFound,
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			ex := shelltest.NewFakeExecutor().
+				On("false", shell.Result{ExitCode: 1}).
+				On("exit 3", shell.Result{ExitCode: 3})
+			h := makeServerInDir(t, dir, ex).Handler()
+			rec := doPost(h, config.Dynamic(config.RouteRunTag)+
+				"?"+config.KeyLabel+"="+tc.label+tc.query, "text/plain", "")
+			if !assert.Equal(t, tc.code, rec.Code, rec.Body.String()) ||
+				tc.code != http.StatusOK {
+				return
+			}
+			var res TagResult
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert.Equal(t, tc.label, res.Label)
+			var names []string
+			skips := make([]bool, len(res.Results))
+			for i, r := range res.Results {
+				names = append(names, r.Name)
+				skips[i] = r.Skipped
+			}
+			assert.Equal(t, tc.wantNames, names)
+			if tc.wantSkips != nil {
+				assert.Equal(t, tc.wantSkips, skips)
+			}
+			var ran []string
+			for _, c := range ex.Calls() {
+				ran = append(ran, strings.TrimSpace(c))
+			}
+			assert.Equal(t, tc.wantRun, ran)
+			if tc.wantErr == "" {
+				assert.Empty(t, res.Error)
+				for _, r := range res.Results {
+					assert.True(t, r.Passed, r.Name)
+				}
+				return
+			}
+			assert.Contains(t, res.Error, tc.wantErr)
+			assert.False(t, res.Results[len(res.Results)-1].Passed)
+		})
+	}
+}
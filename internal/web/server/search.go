@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/monopole/mdrip/v2/internal/web/config"
+)
+
+const (
+	// maxSearchHits limits the number of hits in a SearchResult.
+	maxSearchHits = 100
+	// maxSnippetLen is the maximum length, in runes, of a snippet.
+	maxSnippetLen = 80
+)
+
+// SearchHit is a line of markdown matching a search.
+type SearchHit struct {
+	// FileIndex is the index of the file holding the line.
+	FileIndex int `json:"fileIndex"`
+	// Path is the path to the file holding the line.
+	Path string `json:"path"`
+	// Line is the one-relative number of the line.
+	Line int `json:"line"`
+	// Snippet is the line, trimmed, or the part of it around the match.
+	Snippet string `json:"snippet"`
+}
+
+// SearchResult is sent in JSON form in response to a search.
+type SearchResult struct {
+	Hits []SearchHit `json:"hits"`
+	// Truncated is true if there were more than maxSearchHits hits.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// handleSearch searches the markdown source of the loaded files,
+// ignoring case.  The query is plain text unless the regex param
+// is true.
+func (ws *Server) handleSearch(wr http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query().Get(config.KeySearchQuery)
+	if q == "" {
+		writeError(wr, req, http.StatusBadRequest,
+			fmt.Errorf("no %s param to search for", config.KeySearchQuery))
+		return
+	}
+	isRegex, err := parseBoolParam(config.KeySearchRegex, req, false)
+	if err != nil {
+		writeError(wr, req, http.StatusBadRequest, err)
+		return
+	}
+	if !isRegex {
+		q = regexp.QuoteMeta(q)
+	}
+	// Compile the query alone first, so errors quote only the query.
+	if _, err = regexp.Compile(q); err != nil {
+		writeError(wr, req, http.StatusBadRequest,
+			fmt.Errorf("bad search regex; %w", err))
+		return
+	}
+	jsn, err := json.Marshal(ws.dLoader.search(regexp.MustCompile("(?i)" + q)))
+	if err != nil {
+		writeError(wr, req, http.StatusInternalServerError,
+			fmt.Errorf("search marshal; %w", err))
+		return
+	}
+	wr.Header().Set("Content-Type", "application/json")
+	_, _ = wr.Write(jsn)
+}
+
+// search returns the lines of the loaded files that match,
+// in file order.
+func (dl *DataLoader) search(re *regexp.Regexp) *SearchResult {
+	dl.mu.RLock()
+	defer dl.mu.RUnlock()
+	src := make(sourceCollector)
+	if dl.folder != nil {
+		dl.folder.Accept(src)
+	}
+	res := &SearchResult{Hits: []SearchHit{}}
+	for _, f := range dl.pRen.RenderedMdFiles() {
+		for i, line := range bytes.Split(src[f.Path], []byte("\n")) {
+			loc := re.FindIndex(line)
+			if loc == nil {
+				continue
+			}
+			if len(res.Hits) == maxSearchHits {
+				res.Truncated = true
+				return res
+			}
+			res.Hits = append(res.Hits, SearchHit{
+				FileIndex: f.Index,
+				Path:      string(f.Path),
+				Line:      i + 1,
+				Snippet:   snippet(string(line), loc[0]),
+			})
+		}
+	}
+	return res
+}
+
+// snippet returns the line, trimmed, or if that's too long,
+// the part of it starting a little before the given byte offset.
+func snippet(line string, offset int) string {
+	head := []rune(strings.TrimLeft(line[:offset], " \t"))
+	tail := []rune(strings.TrimRight(line[offset:], " \t\r"))
+	if len(head)+len(tail) <= maxSnippetLen {
+		return string(head) + string(tail)
+	}
+	const lead = maxSnippetLen / 4
+	if len(head) > lead {
+		head = append([]rune("…"), head[len(head)-lead:]...)
+	}
+	if room := maxSnippetLen - len(head); len(tail) > room {
+		tail = append(tail[:room], '…')
+	}
+	return string(head) + string(tail)
+}
+
+// sourceCollector gathers the markdown in a tree, by file path.
+type sourceCollector map[loader.FilePath][]byte
+
+func (v sourceCollector) VisitTopFolder(fl *loader.MyTopFolder) {
+	fl.VisitChildren(v)
+}
+
+func (v sourceCollector) VisitFolder(fl *loader.MyFolder) {
+	fl.VisitChildren(v)
+}
+
+func (v sourceCollector) VisitFile(fi *loader.MyFile) {
+	v[fi.Path()] = fi.C()
+}
+
+func (v sourceCollector) Error() error { return nil }
@@ -0,0 +1,118 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/monopole/mdrip/v2/internal/web/config"
+	. "github.com/monopole/mdrip/v2/internal/web/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleSearch(t *testing.T) {
+	dir := t.TempDir()
+	for name, md := range map[string]string{
+		"a.md": "# Apples\n\nApples are red.\n\n```\necho apple\n```\n",
+		"b.md": "# Bananas\n\nBananas aren't apples (a.k.a. pomes).\n  " +
+			strings.Repeat("x", 100) + " apple " + strings.Repeat("y", 100) + "\n",
+	} {
+		assert.NoError(t, os.WriteFile(
+			filepath.Join(dir, name), []byte(md), 0644))
+	}
+	h := makeServerInDir(t, dir, &shell.Echo{}).Handler()
+	aPath, bPath := "a.md", "b.md"
+	for n, tc := range map[string]struct {
+		query string
+		regex string
+		code  int
+		hits  []SearchHit
+	}{
+		"ignoresCase": {
+			query: "APPLE",
+			code:  http.StatusOK,
+			hits: []SearchHit{
+				{FileIndex: 0, Path: aPath, Line: 1, Snippet: "# Apples"},
+				{FileIndex: 0, Path: aPath, Line: 3, Snippet: "Apples are red."},
+				{FileIndex: 0, Path: aPath, Line: 6, Snippet: "echo apple"},
+				{FileIndex: 1, Path: bPath, Line: 3,
+					Snippet: "Bananas aren't apples (a.k.a. pomes)."},
+				{FileIndex: 1, Path: bPath, Line: 4,
+					Snippet: "…" + strings.Repeat("x", 19) + " apple " +
+						strings.Repeat("y", 53) + "…"},
+			},
+		},
+		"plainTextIsLiteral": {
+			query: "a.k.a.",
+			code:  http.StatusOK,
+			hits: []SearchHit{
+				{FileIndex: 1, Path: bPath, Line: 3,
+					Snippet: "Bananas aren't apples (a.k.a. pomes)."},
+			},
+		},
+		"regex": {
+			query: "^# [AB]",
+			regex: "true",
+			code:  http.StatusOK,
+			hits: []SearchHit{
+				{FileIndex: 0, Path: aPath, Line: 1, Snippet: "# Apples"},
+				{FileIndex: 1, Path: bPath, Line: 1, Snippet: "# Bananas"},
+			},
+		},
+		"noHits": {
+			query: "cherry",
+			code:  http.StatusOK,
+			hits:  []SearchHit{},
+		},
+		"noQuery": {
+			code: http.StatusBadRequest,
+		},
+		"badRegex": {
+			query: "(apple",
+			regex: "true",
+			code:  http.StatusBadRequest,
+		},
+		"badRegexParam": {
+			query: "apple",
+			regex: "maybe",
+			code:  http.StatusBadRequest,
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			v := url.Values{}
+			if tc.query != "" {
+				v.Set(config.KeySearchQuery, tc.query)
+			}
+			if tc.regex != "" {
+				v.Set(config.KeySearchRegex, tc.regex)
+			}
+			rec := doRequest(h, http.MethodGet,
+				config.Dynamic(config.RouteSearch)+"?"+v.Encode())
+			if !assert.Equal(t, tc.code, rec.Code, rec.Body.String()) ||
+				tc.code != http.StatusOK {
+				return
+			}
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			var res SearchResult
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert.Equal(t, tc.hits, res.Hits)
+			assert.False(t, res.Truncated)
+		})
+	}
+}
+
+func TestHandleSearchTruncated(t *testing.T) {
+	h := makeServer(t, strings.Repeat("apple\n", 150), &shell.Echo{}).Handler()
+	rec := doRequest(h, http.MethodGet,
+		config.Dynamic(config.RouteSearch)+"?"+config.KeySearchQuery+"=apple")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var res SearchResult
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.Len(t, res.Hits, 100)
+	assert.True(t, res.Truncated)
+}
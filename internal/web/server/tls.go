@@ -0,0 +1,71 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+)
+
+// SetTls arranges for Serve to use HTTPS with the given certificate
+// and key files, which are loaded now so that trouble with them is
+// reported at startup.  If both paths are empty, Serve uses HTTP.
+func (ws *Server) SetTls(certFile, keyFile string) error {
+	if certFile == "" && keyFile == "" {
+		ws.tlsCert = nil
+		return nil
+	}
+	if certFile == "" || keyFile == "" {
+		return errors.New("TLS needs both a cert file and a key file")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf(
+			"unable to load TLS cert %q and key %q; %w", certFile, keyFile, err)
+	}
+	ws.tlsCert = &cert
+	return nil
+}
+
+// SetHttpRedirect arranges for Serve, when using TLS, to also serve
+// HTTP at the given "host:port" address, redirecting all requests
+// to HTTPS.
+func (ws *Server) SetHttpRedirect(addr string) {
+	ws.redirectAddr = addr
+}
+
+// serveRedirect serves redirects to the TLS server at tlsAddr.
+// The returned server is already running.
+func serveRedirect(addr, tlsAddr string) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to serve redirects; %w", err)
+	}
+	_, tlsPort, _ := net.SplitHostPort(tlsAddr)
+	srv := &http.Server{Handler: redirectToHttps(tlsPort)}
+	go func() {
+		if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("redirect server failure", "err", err)
+		}
+	}()
+	return srv, nil
+}
+
+// redirectToHttps redirects requests to the same host at the given port,
+// using HTTPS.
+func redirectToHttps(tlsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if tlsPort != "" && tlsPort != "443" {
+			host = net.JoinHostPort(host, tlsPort)
+		}
+		u := *r.URL
+		u.Scheme, u.Host = "https", host
+		http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,128 @@
+package server_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeCert writes a self-signed certificate for 127.0.0.1,
+// returning the paths to the cert and key files.
+func writeCert(t *testing.T) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mdrip test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	assert.NoError(t, os.WriteFile(certFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	assert.NoError(t, os.WriteFile(keyFile,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return
+}
+
+// freeAddr returns a local address that's likely free.
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer func() { _ = ln.Close() }()
+	return ln.Addr().String()
+}
+
+func TestSetTlsErrors(t *testing.T) {
+	certFile, keyFile := writeCert(t)
+	s := makeServer(t, "# hey\n", &shell.Echo{})
+	assert.NoError(t, s.SetTls("", ""))
+	assert.NoError(t, s.SetTls(certFile, keyFile))
+	for n, tc := range map[string]struct {
+		cert, key string
+		want      string
+	}{
+		"noKey":      {cert: certFile, want: "both"},
+		"noCert":     {key: keyFile, want: "both"},
+		"noSuchFile": {cert: "/no/such/cert.pem", key: keyFile, want: "/no/such/cert.pem"},
+		"swapped":    {cert: keyFile, key: certFile, want: "unable to load"},
+	} {
+		t.Run(n, func(t *testing.T) {
+			err := s.SetTls(tc.cert, tc.key)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tc.want)
+			}
+		})
+	}
+}
+
+func TestServeTls(t *testing.T) {
+	certFile, keyFile := writeCert(t)
+	s := makeServer(t, "# hey\n", &shell.Echo{})
+	assert.NoError(t, s.SetTls(certFile, keyFile))
+	addr, redirectAddr := freeAddr(t), freeAddr(t)
+	s.SetHttpRedirect(redirectAddr)
+	done := make(chan error)
+	go func() { done <- s.Serve(addr) }()
+
+	pem, err := os.ReadFile(certFile)
+	assert.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(pem)
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	var resp *http.Response
+	assert.Eventually(t, func() bool {
+		resp, err = client.Get("https://" + addr + "/healthz")
+		return err == nil
+	}, timeout, 10*time.Millisecond)
+	if assert.NoError(t, err) {
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	resp, err = client.Get("http://" + redirectAddr + "/a.md?x=1")
+	if assert.NoError(t, err) {
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+		assert.Equal(t,
+			"https://"+addr+"/a.md?x=1", resp.Header.Get("Location"))
+	}
+
+	assert.NoError(t, s.Close())
+	select {
+	case err = <-done:
+		assert.NoError(t, err)
+	case <-time.After(timeout):
+		t.Fatal("server didn't stop")
+	}
+	_, err = client.Get("http://" + redirectAddr + "/")
+	assert.Error(t, err, "redirect server should be closed")
+}
@@ -1,18 +1,23 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
-	"github.com/monopole/mdrip/v2/internal/parsren"
-	"github.com/monopole/mdrip/v2/internal/web/config"
-	"log/slog"
+	"io"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/monopole/mdrip/v2/internal/parsren"
+	"github.com/monopole/mdrip/v2/internal/web/config"
 )
 
 func (ws *Server) getRenderedMdFile(req *http.Request) (*parsren.RenderedMdFile, error) {
 	mdFileIndex := getIntParam(config.KeyMdFileIndex, req, -1)
 	files := ws.dLoader.RenderedFiles()
-	if mdFileIndex < 0 || mdFileIndex > len(files) {
+	if mdFileIndex < 0 || mdFileIndex >= len(files) {
 		return nil, fmt.Errorf(
 			"mdFileIndex==%d out of range 0..%d", mdFileIndex, len(files))
 	}
@@ -21,10 +26,20 @@ func (ws *Server) getRenderedMdFile(req *http.Request) (*parsren.RenderedMdFile,
 
 // reload performs a data reload.
 func (ws *Server) reload(wr http.ResponseWriter, req *http.Request) error {
-	mySess, _ := ws.store.Get(req, cookieName)
+	mySess, _ := ws.store.Get(req, ws.cookieName)
 	_ = mySess.Save(req, wr)
-	ws.dLoader.makeLastLoadTimeVeryOld()
-	return ws.dLoader.LoadAndRender()
+	return ws.dLoader.Reload()
+}
+
+// getLabelsParam returns the labels in the comma separated list
+// held by the named param, or nil if there's no such param.
+func getLabelsParam(n string, r *http.Request) (result []loader.Label) {
+	for _, s := range strings.Split(r.URL.Query().Get(n), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			result = append(result, loader.Label(s))
+		}
+	}
+	return
 }
 
 func getIntParam(n string, r *http.Request, d int) int {
@@ -35,25 +50,136 @@ func getIntParam(n string, r *http.Request, d int) int {
 	return v
 }
 
-func getBoolParam(n string, r *http.Request, d bool) bool {
-	v, err := strconv.ParseBool(r.URL.Query().Get(n))
+// parseIntParam is like getIntParam, except that a malformed value
+// is reported as an error rather than replaced by the default.
+// The default is used only if the param is absent.
+func parseIntParam(n string, r *http.Request, d int) (int, error) {
+	arg := r.URL.Query().Get(n)
+	if arg == "" {
+		return d, nil
+	}
+	v, err := strconv.Atoi(arg)
 	if err != nil {
-		return d
+		return d, fmt.Errorf("%s=%q is not an integer", n, arg)
 	}
-	return v
+	return v, nil
+}
+
+// parseBoolParam is the boolean analog of parseIntParam.
+func parseBoolParam(n string, r *http.Request, d bool) (bool, error) {
+	arg := r.URL.Query().Get(n)
+	if arg == "" {
+		return d, nil
+	}
+	v, err := strconv.ParseBool(arg)
+	if err != nil {
+		return d, fmt.Errorf("%s=%q is not a boolean", n, arg)
+	}
+	return v, nil
+}
+
+// parseThemeParam returns the value of the theme param,
+// or d if it's absent.
+func parseThemeParam(r *http.Request, d string) (string, error) {
+	switch arg := r.URL.Query().Get(config.KeyTheme); arg {
+	case "":
+		return d, nil
+	case config.ThemeDark, config.ThemeLight:
+		return arg, nil
+	default:
+		return d, fmt.Errorf("%s=%q is not a known theme", config.KeyTheme, arg)
+	}
+}
+
+// maxRunRequestSize limits the size of a JSON RunRequest body.
+const maxRunRequestSize = 1 << 16
+
+// decodeRunRequest overwrites the options with those found in the
+// request body, if the body holds JSON.  Other bodies are ignored.
+func decodeRunRequest(req *http.Request, opts *RunRequest) error {
+	ct := req.Header.Get("Content-Type")
+	if ct == "" {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return fmt.Errorf("bad Content-Type %q; %w", ct, err)
+	}
+	if mediaType != "application/json" {
+		return nil
+	}
+	dec := json.NewDecoder(io.LimitReader(req.Body, maxRunRequestSize))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(opts); err != nil {
+		return fmt.Errorf("bad run request; %w", err)
+	}
+	if dec.More() {
+		return fmt.Errorf("bad run request; trailing data after JSON object")
+	}
+	if opts.TimeoutSec < 0 {
+		return fmt.Errorf("bad run request; negative timeoutSec %d", opts.TimeoutSec)
+	}
+	if opts.Rows < 0 || opts.Cols < 0 {
+		return fmt.Errorf("bad run request; negative size %dx%d", opts.Cols, opts.Rows)
+	}
+	return nil
 }
 
-func write500(w http.ResponseWriter, e error) {
-	slog.Error(e.Error())
-	http.Error(w, e.Error(), http.StatusInternalServerError)
+// ErrorResponse is sent in JSON form, in place of plain text,
+// to clients that accept JSON when a request fails.
+type ErrorResponse struct {
+	Error string `json:"error"`
+	// Code is the HTTP status code.
+	Code int `json:"code"`
+}
+
+// writeError responds with the status and error, in JSON form if
+// the request accepts JSON, and as plain text otherwise.
+func writeError(w http.ResponseWriter, r *http.Request, status int, e error) {
+	if status >= http.StatusInternalServerError {
+		logger(r).Error(e.Error())
+	} else {
+		logger(r).Debug(e.Error())
+	}
+	if !acceptsJson(r) {
+		http.Error(w, e.Error(), status)
+		return
+	}
+	jsn, err := json.Marshal(&ErrorResponse{Error: e.Error(), Code: status})
+	if err != nil {
+		http.Error(w, e.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_, _ = w.Write(jsn)
+}
+
+// acceptsJson is true if the request's Accept header names JSON,
+// e.g. application/json or application/problem+json, explicitly.
+// Wildcards don't count, since browsers send them for everything.
+func acceptsJson(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(v, ",") {
+			mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil || params["q"] == "0" {
+				continue
+			}
+			if mt == "application/json" ||
+				(strings.HasPrefix(mt, "application/") && strings.HasSuffix(mt, "+json")) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-func inRange(wr http.ResponseWriter, name string, arg, n int) bool {
-	if arg >= 0 || arg < n {
+func inRange(wr http.ResponseWriter, r *http.Request, name string, arg, n int) bool {
+	if arg >= 0 && arg < n {
 		return true
 	}
-	http.Error(wr,
-		fmt.Sprintf("%s %d out of range 0-%d",
-			name, arg, n-1), http.StatusBadRequest)
+	writeError(wr, r, http.StatusBadRequest,
+		fmt.Errorf("%s %d out of range 0-%d", name, arg, n-1))
 	return false
 }
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/monopole/mdrip/v2/internal/loader"
+)
+
+// DefaultDebounce is how long to wait after the last file system event
+// before reloading, so that a burst of edits (e.g. an editor writing a
+// swap file, then the file) triggers just one reload.
+const DefaultDebounce = 500 * time.Millisecond
+
+// Watch starts watching the served markdown for changes, reloading
+// the data when a markdown file changes.  Errors arising after the
+// watch starts are logged and otherwise ignored, so that trouble with
+// the watcher doesn't take down the server.
+// The returned function stops the watch.
+func (ws *Server) Watch(debounce time.Duration) (func(), error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err = addWatches(w, ws.dLoader.paths); err != nil {
+		_ = w.Close()
+		return nil, err
+	}
+	go ws.watchLoop(w, debounce)
+	return func() { _ = w.Close() }, nil
+}
+
+// addWatches adds all the folders at and below the given paths.
+// fsnotify doesn't recurse on its own.
+func addWatches(w *fsnotify.Watcher, paths []string) error {
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			// Watch the folder holding the file; editors often
+			// replace files rather than writing them in place.
+			if err = w.Add(filepath.Dir(p)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = addFolderWatches(w, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addFolderWatches(w *fsnotify.Watcher, top string) error {
+	return filepath.WalkDir(top, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && p != top &&
+			loader.InNotIgnorableFolder(info) != nil {
+			return filepath.SkipDir
+		}
+		return w.Add(p)
+	})
+}
+
+func (ws *Server) watchLoop(w *fsnotify.Watcher, debounce time.Duration) {
+	var timer *time.Timer
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if ev.Has(fsnotify.Create) {
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+					if err = addFolderWatches(w, ev.Name); err != nil {
+						slog.Warn("unable to watch new folder",
+							"path", ev.Name, "err", err)
+					}
+				}
+			}
+			if !isRelevantChange(ev.Name) {
+				continue
+			}
+			slog.Debug("file change", "event", ev)
+			if timer == nil {
+				timer = time.AfterFunc(debounce, ws.reloadAfterChange)
+			} else {
+				timer.Reset(debounce)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			slog.Warn("file watcher trouble", "err", err)
+		}
+	}
+}
+
+func isRelevantChange(path string) bool {
+	base := filepath.Base(path)
+	return filepath.Ext(base) == ".md" || base == loader.OrderingFileName
+}
+
+// reloadAfterChange reloads the data, which also drops the cached
+// pages of the web app.  There's no cache of minified assets to
+// bust; the JS and CSS are minified per request, from the data
+// loaded at the time.
+func (ws *Server) reloadAfterChange() {
+	slog.Info("markdown changed, reloading")
+	if err := ws.dLoader.Reload(); err != nil {
+		slog.Error("reload after change failed", "err", err)
+	}
+}
@@ -0,0 +1,33 @@
+package server_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(
+		filepath.Join(dir, "a.md"), []byte("# A\n"), 0644))
+	s := makeServerInDir(t, dir, &shell.Echo{})
+	h := s.Handler()
+	// Render the app, so that there's a cached page to drop.
+	assert.NotContains(t, renderApp(t, h, nil), "zebra.md")
+	stop, err := s.Watch(10 * time.Millisecond)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer stop()
+
+	assert.NoError(t, os.WriteFile(
+		filepath.Join(dir, "zebra.md"), []byte("# Zebra\n"), 0644))
+	assert.Eventually(t, func() bool {
+		return strings.Contains(renderApp(t, h, nil), "zebra.md")
+	}, 5*time.Second, 20*time.Millisecond)
+}
@@ -1,20 +1,31 @@
 package server
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/sessions"
+	"github.com/monopole/mdrip/v2/internal/shell"
 	"github.com/monopole/mdrip/v2/internal/utils"
 	"github.com/monopole/mdrip/v2/internal/web/config"
 	"github.com/monopole/mdrip/v2/internal/web/server/minify"
+	"github.com/spf13/afero"
 )
 
 const (
-	cookieName = utils.PgmName
+	// defaultCookieName names the session cookie, unless SetCookieName
+	// says otherwise.
+	defaultCookieName = utils.PgmName
+	// DefaultCookieMaxAge is how long the session cookie lasts,
+	// unless SetCookieMaxAge says otherwise.
+	DefaultCookieMaxAge = 8 * time.Hour
 )
 
 var (
@@ -33,56 +44,380 @@ type Server struct {
 	// it's useful to store app state.  FWIW, it attempts to put you on the same
 	// codeblock if you reload (start a new session).
 	store sessions.Store
-	// codeWriter accepts codeblocks for execution or simply printing.
-	codeWriter io.Writer
+	// cookieName names the session cookie.
+	cookieName string
+	// routePrefix, if not empty, is the path, e.g. "/mdrip",
+	// below which all routes are served.
+	routePrefix string
+	// executor runs code blocks, or simply prints them.
+	executor shell.Executor
+	// staticFavicon, if true, means serve an embedded favicon
+	// rather than generating one.
+	staticFavicon bool
+	// runTimeout, if positive, limits the time a code block may run,
+	// unless the run request or the block names its own limit.
+	runTimeout time.Duration
+	// runSlots, if not nil, holds a token for each run under way,
+	// the channel's capacity limiting them; see SetMaxRuns.
+	runSlots chan struct{}
+	// runQueueWait is how long a run waits for a slot.
+	runQueueWait time.Duration
+	// auditLog, if true, means log a record of each block run.
+	auditLog bool
+	// redactor, if not nil, masks secrets in audit records.
+	redactor Redactor
+	// lissajous limits the parameters of requests for Lissajous images.
+	lissajous LissajousLimits
+	// allowed, if not empty, are patterns matching the commands
+	// blocks may run; see SetAllowedCommands.
+	allowed []*regexp.Regexp
+	// allowFirstWord means match allowed against commands' first words.
+	allowFirstWord bool
+	// extraCss are the URLs of stylesheets to load after mdrip's own.
+	extraCss []string
+	// tlsCert, if not nil, means serve HTTPS.
+	tlsCert *tls.Certificate
+	// redirectAddr, if not empty, is where to redirect HTTP to HTTPS.
+	redirectAddr string
+	// mu guards httpSrv and redirectSrv.
+	mu sync.Mutex
+	// httpSrv is the running server, if any.
+	httpSrv *http.Server
+	// redirectSrv is the running redirect server, if any.
+	redirectSrv *http.Server
 }
 
-// NewServer returns a new web server.
-func NewServer(dl *DataLoader, r io.Writer) (*Server, error) {
+// cwdReporter is implemented by executors that can report
+// the working directory of the shell running the code.
+type cwdReporter interface {
+	Cwd() (string, error)
+}
+
+// restarter is implemented by executors whose shell can be
+// restarted, given how long to wait for the shell to stop and start.
+type restarter interface {
+	Restart(d time.Duration) error
+}
+
+// terminalSizer is implemented by executors whose shell runs
+// commands on a terminal that can be sized.
+type terminalSizer interface {
+	SetTerminalSize(rows, cols int) error
+}
+
+// infoReporter is implemented by executors that can describe
+// the shell running the code.
+type infoReporter interface {
+	Info() shell.ShellInfo
+}
+
+// RunRequest holds options for running a code block.  The options
+// may be sent as query params, or in JSON form as the body of a
+// request with Content-Type application/json, in which case the
+// fields present override the query params.
+type RunRequest struct {
+	// Interp names a program (e.g. python3) to run the block.
+	Interp string `json:"interp,omitempty"`
+	// Trace asks the shell to echo commands as it runs them.
+	Trace bool `json:"trace,omitempty"`
+	// Color is one of the config.Color* values.
+	Color string `json:"color,omitempty"`
+	// TimeoutSec, if positive, limits the block's run time,
+	// overriding the block's timeout label and the server's default.
+	TimeoutSec int `json:"timeoutSec,omitempty"`
+	// Download, if set, is a file name.  If the block succeeds, its
+	// stdout is sent as an attachment of that name, rather than as
+	// a RunResult; if not, the RunResult is sent as usual.
+	Download string `json:"download,omitempty"`
+	// Rows and Cols, if both positive, are the size of the reader's
+	// window, in text, to which the terminal of an interactive shell
+	// is sized before the block runs; see
+	// shell.ManagedShell.SetTerminalSize.
+	Rows int `json:"rows,omitempty"`
+	Cols int `json:"cols,omitempty"`
+}
+
+// RunResult holds the output of a code block, and is sent in
+// JSON form in response to running a block.  The output is empty
+// if the executor can't capture it, e.g. if it's tmux.
+// Per the request's color param, the output may be HTML, in which
+// case it's escaped and ready for use as innerHTML.
+type RunResult struct {
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+	// ExitCode is the exit status of the block's last command.
+	ExitCode int `json:"exitCode"`
+	// StderrIsWarning is true if the block succeeded, i.e. finished
+	// cleanly with exit status 0, yet wrote to stderr, as tools do to
+	// warn, such that the stderr is better shown as a warning than an
+	// error.  It's false for a traced run, whose stderr is the trace.
+	StderrIsWarning bool `json:"stderrIsWarning,omitempty"`
+	// Error is set if the block didn't finish cleanly.
+	Error string `json:"error,omitempty"`
+	// Reason, set along with Error, is one of the Reason* values,
+	// saying why the block didn't finish cleanly.
+	Reason string `json:"reason,omitempty"`
+	// TimedOut is true if the block was stopped for running longer
+	// than TimeoutMs.
+	TimedOut bool `json:"timedOut,omitempty"`
+	// TimeoutMs is the limit the block ran under, per
+	// shell.ResolveTimeout, or zero if there was none.
+	TimeoutMs int64 `json:"timeoutMs,omitempty"`
+	// Usage is the block's resource usage, if known, i.e. if the
+	// block ran in a process of its own, per shell.Usage.
+	Usage *RunUsage `json:"usage,omitempty"`
+}
+
+// RunUsage is the resource usage of a block's run.
+type RunUsage struct {
+	// UserMs and SystemMs are the CPU time, in milliseconds,
+	// spent in user mode and in the kernel.
+	UserMs   int64 `json:"userMs"`
+	SystemMs int64 `json:"systemMs"`
+	// MaxRssKb is the peak resident memory in kilobytes,
+	// or zero if unknown.
+	MaxRssKb int64 `json:"maxRssKb"`
+}
+
+// Values for RunResult's Reason.
+const (
+	// ReasonTimeout means the block ran out of time.
+	ReasonTimeout = "timeout"
+	// ReasonCancelled means the request was cancelled, e.g. because
+	// the client went away.
+	ReasonCancelled = "cancelled"
+	// ReasonError means the block couldn't be run to completion
+	// for some other reason.
+	ReasonError = "error"
+)
+
+// BlockInfo describes a code block, and is sent, in a list holding
+// all the blocks of a file, in response to a request for the file's
+// blocks.  It says enough for a client to decide whether to offer
+// to run the block.
+type BlockInfo struct {
+	// UniqName is the block's name, unique within its file.
+	UniqName string `json:"uniqName"`
+	// Language is the language named in the block's fence, if any.
+	Language string `json:"language"`
+	// Labels are the block's labels, without the '@'.
+	Labels []string `json:"labels"`
+	// Runnable is true if the block may be sent to a shell.
+	Runnable bool `json:"runnable"`
+}
+
+// ResetResult is sent in JSON form in response to a request to
+// reset the shell.
+type ResetResult struct {
+	// Reset is true if the shell was restarted.
+	Reset bool `json:"reset"`
+	// Cwd is the new shell's working directory, if it can say.
+	Cwd string `json:"cwd,omitempty"`
+}
+
+// NewServer returns a new web server, sending code blocks to the executor.
+func NewServer(dl *DataLoader, ex shell.Executor) (*Server, error) {
 	s := sessions.NewCookieStore(keyAuth, keyEncrypt)
 	s.Options = &sessions.Options{
 		Path:     "/",
-		MaxAge:   8 * 60 * 60, // 8 hours (Max-Age has units seconds)
+		MaxAge:   int(DefaultCookieMaxAge.Seconds()),
 		HttpOnly: true,
 	}
 	return &Server{
 		dLoader:    dl,
 		store:      s,
+		cookieName: defaultCookieName,
 		minifier:   minify.MakeMinifier(),
-		codeWriter: r,
+		executor:   ex,
+		lissajous:  DefaultLissajousLimits,
 	}, nil
 }
 
-// Serve offers an HTTP service.
-func (ws *Server) Serve(hostAndPort string) (err error) {
-	http.HandleFunc("/favicon.ico", ws.handleFavicon)
-	http.HandleFunc(config.Dynamic(config.RouteLissajous), ws.handleLissajous)
-	http.HandleFunc(config.Dynamic(config.RouteQuit), ws.handleQuit)
-	http.HandleFunc(config.Dynamic(config.RouteDebug), ws.handleDebugPage)
-	http.HandleFunc(config.Dynamic(config.RouteReload), ws.handleReload)
-	// http.Handle(session.Dynamic(session.RouteWebSocket), ws.openWebSocket)
-	http.HandleFunc(config.Dynamic(config.RouteJs), ws.handleGetJs)
-	http.HandleFunc(config.Dynamic(config.RouteCss), ws.handleGetCss)
-	http.HandleFunc(config.Dynamic(config.RouteLabelsForFile), ws.handleGetLabelsForFile)
-	http.HandleFunc(config.Dynamic(config.RouteHtmlForFile), ws.handleGetHtmlForFile)
-	http.HandleFunc(config.Dynamic(config.RouteRunBlock), ws.handleRunCodeBlock)
-	http.HandleFunc(config.Dynamic(config.RouteSave), ws.handleSaveSession)
-
-	// In server mode, the dLoader.paths slice has exactly one entry,
-	// since in server mode we allow only one *relative* path argument
-	// to simplify how the URL in the browser works.
-	dir := strings.TrimSuffix(ws.dLoader.paths[0], "/")
-	http.Handle("/", ws.makeMetaHandler(http.FileServer(http.Dir(dir))))
-	fmt.Println(utils.PgmName + " serving " + dir + " at " + hostAndPort)
-	if err = http.ListenAndServe(hostAndPort, nil); err != nil {
+// runTimeoutGrace is how much longer than the server the client
+// waits for a block to run, so that the server normally gets to
+// report a timeout.
+const runTimeoutGrace = 2 * time.Second
+
+// SetRunTimeout limits the time a code block may run, unless the
+// run request or the block's timeout label names its own limit;
+// zero means the executor's default.
+// The web app gives up on a run a little after this.
+func (ws *Server) SetRunTimeout(d time.Duration) {
+	ws.runTimeout = d
+}
+
+// SetRoutePrefix arranges to serve everything, the app, markdown
+// and all, below the given path, e.g. "/mdrip", so that the server
+// can sit behind a proxy alongside other apps.  The session cookie
+// is confined to the prefix too.
+func (ws *Server) SetRoutePrefix(prefix string) error {
+	prefix = strings.TrimRight(prefix, "/")
+	if prefix != "" &&
+		(!strings.HasPrefix(prefix, "/") || strings.ContainsAny(prefix, "?#")) {
+		return fmt.Errorf("route prefix %q must be a path starting with /", prefix)
+	}
+	ws.routePrefix = prefix
+	if cs, ok := ws.store.(*sessions.CookieStore); ok {
+		cs.Options.Path = prefix + "/"
+	}
+	return nil
+}
+
+// SetCookieName names the session cookie, so that it needn't
+// collide with the cookies of other apps on the same host.
+func (ws *Server) SetCookieName(name string) error {
+	if name == "" || strings.ContainsAny(name, " \t;,=\"") {
+		return fmt.Errorf("bad cookie name %q", name)
+	}
+	ws.cookieName = name
+	return nil
+}
+
+// SetCookieMaxAge sets how long the session cookie lasts, to the
+// second; zero means until the browser closes.
+func (ws *Server) SetCookieMaxAge(d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("cookie max age %s must not be negative", d)
+	}
+	if cs, ok := ws.store.(*sessions.CookieStore); ok {
+		// This sets the age beyond which the cookie is refused, too.
+		cs.MaxAge(int(d.Seconds()))
+	}
+	return nil
+}
+
+// SetCookieSecure, if true, marks the session cookie Secure, so that
+// browsers send it only over HTTPS.
+func (ws *Server) SetCookieSecure(on bool) {
+	if cs, ok := ws.store.(*sessions.CookieStore); ok {
+		cs.Options.Secure = on
+	}
+}
+
+// sameSiteModes are the SameSite cookie attributes, by name.
+var sameSiteModes = map[string]http.SameSite{
+	"":       http.SameSiteDefaultMode,
+	"lax":    http.SameSiteLaxMode,
+	"strict": http.SameSiteStrictMode,
+	"none":   http.SameSiteNoneMode,
+}
+
+// SetCookieSameSite sets the SameSite attribute of the session cookie
+// to "lax", "strict" or "none"; empty means no attribute.  Browsers
+// refuse SameSite=None cookies that aren't Secure.
+func (ws *Server) SetCookieSameSite(mode string) error {
+	ss, ok := sameSiteModes[strings.ToLower(mode)]
+	if !ok {
+		return fmt.Errorf(
+			"bad cookie SameSite mode %q; want lax, strict or none", mode)
+	}
+	if cs, ok := ws.store.(*sessions.CookieStore); ok {
+		cs.Options.SameSite = ss
+	}
+	return nil
+}
+
+// SetLissajousLimits limits the parameters of requests for Lissajous
+// images; requests beyond the limits are refused.
+func (ws *Server) SetLissajousLimits(l LissajousLimits) error {
+	if l.MaxSize < 1 || l.MaxCycles < 1 || l.MaxFrames < 1 {
+		return fmt.Errorf("lissajous limits %+v must be positive", l)
+	}
+	ws.lissajous = l
+	return nil
+}
+
+// SetExtraCss arranges for the web app to load the stylesheets at
+// the given URLs after its own, e.g. to apply a theme.
+func (ws *Server) SetExtraCss(urls []string) {
+	ws.extraCss = urls
+}
+
+// Serve offers an HTTP (or, per SetTls, HTTPS) service at the given
+// address, either "host:port" or a Unix socket path with UnixPrefix.
+// It returns nil if the server is closed via Close or Shutdown,
+// which it does itself on SIGINT or SIGTERM.
+func (ws *Server) Serve(addr string) (err error) {
+	fmt.Println(utils.PgmName + " serving " + ws.servedDir() + " at " + addr + ws.routePrefix)
+	ln, err := listen(addr)
+	if err != nil {
 		slog.Error("unable to start server", "err", err)
+		return err
+	}
+	defer ws.shutdownOnSignal()()
+	srv := &http.Server{Handler: ws.Handler()}
+	if ws.tlsCert == nil {
+		ws.setHttpServers(srv, nil)
+		err = srv.Serve(ln)
+	} else {
+		var rSrv *http.Server
+		if ws.redirectAddr != "" {
+			if rSrv, err = serveRedirect(ws.redirectAddr, addr); err != nil {
+				_ = ln.Close()
+				slog.Error("unable to start server", "err", err)
+				return err
+			}
+		}
+		ws.setHttpServers(srv, rSrv)
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{*ws.tlsCert}}
+		err = srv.ServeTLS(ln, "", "")
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
 	}
+	slog.Error("server failure", "err", err)
 	return err
 }
 
+// Handler returns a handler for all the server's routes.  The routes
+// are registered on a mux of the server's own rather than on
+// http.DefaultServeMux, so that one process can run many servers.
+func (ws *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/favicon.ico", ws.handleFavicon)
+	mux.HandleFunc("/healthz", ws.handleHealthz)
+	mux.HandleFunc(config.Dynamic(config.RouteLissajous), ws.handleLissajous)
+	mux.HandleFunc(config.Dynamic(config.RouteQuit), ws.handleQuit)
+	mux.HandleFunc(config.Dynamic(config.RouteDebug), ws.handleDebugPage)
+	mux.HandleFunc(config.Dynamic(config.RouteReload), ws.handleReload)
+	mux.HandleFunc(config.Dynamic(config.RouteLoadStatus), ws.handleGetLoadStatus)
+	mux.HandleFunc(config.Dynamic(config.RouteCwd), ws.handleGetCwd)
+	mux.HandleFunc(config.Dynamic(config.RouteReset), ws.handleReset)
+	mux.HandleFunc(config.Dynamic(config.RouteVersion), ws.handleGetVersion)
+	mux.HandleFunc(config.Dynamic(config.RouteSearch), ws.handleSearch)
+	mux.HandleFunc(config.Dynamic(config.RouteFiles), ws.handleGetFiles)
+	// mux.Handle(session.Dynamic(session.RouteWebSocket), ws.openWebSocket)
+	mux.HandleFunc(config.Dynamic(config.RouteJs), ws.handleGetJs)
+	mux.HandleFunc(config.Dynamic(config.RouteCss), ws.handleGetCss)
+	mux.HandleFunc(config.Dynamic(config.RouteLabelsForFile), ws.handleGetLabelsForFile)
+	mux.HandleFunc(config.Dynamic(config.RouteHtmlForFile), ws.handleGetHtmlForFile)
+	mux.HandleFunc(config.Dynamic(config.RouteBlocksForFile), ws.handleGetBlocksForFile)
+	mux.HandleFunc(config.Dynamic(config.RouteRunBlock), ws.handleRunCodeBlock)
+	mux.HandleFunc(config.Dynamic(config.RouteRunTag), ws.handleRunTag)
+	mux.HandleFunc(config.Dynamic(config.RouteRunFile), ws.handleRunFile)
+	mux.HandleFunc(config.Dynamic(config.RouteSave), ws.handleSaveSession)
+	mux.Handle("/", ws.makeMetaHandler(http.FileServer(
+		afero.NewHttpFs(ws.dLoader.ldr.Fs()).Dir(ws.servedDir()))))
+	if ws.routePrefix == "" {
+		return withRequestId(mux)
+	}
+	// The routes above see paths with the prefix removed.
+	outer := http.NewServeMux()
+	outer.Handle(ws.routePrefix+"/", http.StripPrefix(ws.routePrefix, mux))
+	return withRequestId(outer)
+}
+
+// servedDir is the folder holding the markdown.
+// In server mode, the dLoader.paths slice has exactly one entry,
+// since in server mode we allow only one *relative* path argument
+// to simplify how the URL in the browser works.
+func (ws *Server) servedDir() string {
+	return strings.TrimSuffix(ws.dLoader.paths[0], "/")
+}
+
 func (ws *Server) makeMetaHandler(fsHandler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		slog.Debug("got request for", "url", req.URL)
+		logger(req).Debug("got request for", "url", req.URL)
 		if strings.HasSuffix(req.URL.Path, "/") ||
 			// trigger markdown rendering
 			strings.HasSuffix(req.URL.Path, ".md") {
@@ -0,0 +1,206 @@
+package server_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/monopole/mdrip/v2/internal/parsren/usegold"
+	"github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/monopole/mdrip/v2/internal/shell/shelltest"
+	"github.com/monopole/mdrip/v2/internal/web/config"
+	. "github.com/monopole/mdrip/v2/internal/web/server"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+const fixtureMd = `# Fixture
+
+<!-- @hello -->
+` + "```" + `
+echo hello
+` + "```" + `
+
+Some prose.
+
+<!-- @goodbye -->
+` + "```" + `
+echo goodbye
+` + "```" + `
+`
+
+// startServer serves the fixture, held in memory, until the test ends.
+func startServer(t *testing.T, ex shell.Executor) *httptest.Server {
+	fs := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(fs, "/docs/a.md", []byte(fixtureMd), 0644))
+	dl := NewDataLoader(
+		loader.New(fs, loader.IsMarkDownFile, loader.InNotIgnorableFolder),
+		[]string{"/docs"}, usegold.NewGParser(), "fixture")
+	if !assert.NoError(t, dl.LoadAndRender()) {
+		t.FailNow()
+	}
+	s, err := NewServer(dl, ex)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	srv := httptest.NewServer(s.Handler())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestServerRoutes(t *testing.T) {
+	fake := shelltest.NewFakeExecutor().
+		On("echo hello", shell.Result{Stdout: []string{"hello"}})
+	srv := startServer(t, fake)
+	fileQuery := "?" + config.KeyMdFileIndex + "=0"
+	runQuery := "?" + config.KeyMdSessID + "=abc&" +
+		config.KeyMdFileIndex + "=0&" + config.KeyBlockIndex + "="
+	for n, tc := range map[string]struct {
+		method string
+		path   string
+		code   int
+		ctype  string
+		check  func(t *testing.T, body []byte)
+	}{
+		"js": {
+			path:  config.Dynamic(config.RouteJs),
+			code:  http.StatusOK,
+			ctype: "application/javascript",
+			check: func(t *testing.T, body []byte) {
+				assert.Contains(t, string(body), "SessionController")
+			},
+		},
+		"css": {
+			path:  config.Dynamic(config.RouteCss),
+			code:  http.StatusOK,
+			ctype: "text/css",
+			check: func(t *testing.T, body []byte) {
+				assert.Contains(t, string(body), ".mdripApp")
+			},
+		},
+		"html": {
+			path:  config.Dynamic(config.RouteHtmlForFile) + fileQuery,
+			code:  http.StatusOK,
+			ctype: "text/html",
+			check: func(t *testing.T, body []byte) {
+				assert.Contains(t, string(body), "Fixture</h1>")
+				assert.Contains(t, string(body), "Some prose.")
+			},
+		},
+		"htmlBadFile": {
+			path: config.Dynamic(config.RouteHtmlForFile) + "?" +
+				config.KeyMdFileIndex + "=7",
+			code: http.StatusInternalServerError,
+		},
+		"labels": {
+			path:  config.Dynamic(config.RouteLabelsForFile) + fileQuery,
+			code:  http.StatusOK,
+			ctype: "application/json",
+			check: func(t *testing.T, body []byte) {
+				var labels []string
+				assert.NoError(t, json.Unmarshal(body, &labels))
+				assert.Equal(t, []string{"hello", "goodbye"}, labels)
+			},
+		},
+		"blocks": {
+			path:  config.Dynamic(config.RouteBlocksForFile) + fileQuery,
+			code:  http.StatusOK,
+			ctype: "application/json",
+			check: func(t *testing.T, body []byte) {
+				var blocks []BlockInfo
+				assert.NoError(t, json.Unmarshal(body, &blocks))
+				assert.Equal(t, []BlockInfo{
+					{UniqName: "hello", Labels: []string{"hello"}, Runnable: true},
+					{UniqName: "goodbye", Labels: []string{"goodbye"}, Runnable: true},
+				}, blocks)
+			},
+		},
+		"run": {
+			method: http.MethodPost,
+			path:   config.Dynamic(config.RouteRunBlock) + runQuery + "0",
+			code:   http.StatusOK,
+			ctype:  "application/json",
+			check: func(t *testing.T, body []byte) {
+				var res RunResult
+				assert.NoError(t, json.Unmarshal(body, &res))
+				assert.Equal(t, RunResult{Stdout: "hello"}, res)
+			},
+		},
+		"runBadBlock": {
+			method: http.MethodPost,
+			path:   config.Dynamic(config.RouteRunBlock) + runQuery + "2",
+			code:   http.StatusBadRequest,
+		},
+		"runNoSession": {
+			method: http.MethodPost,
+			path: config.Dynamic(config.RouteRunBlock) + fileQuery +
+				"&" + config.KeyBlockIndex + "=0",
+			code: http.StatusBadRequest,
+		},
+		"save": {
+			method: http.MethodPost,
+			path: config.Dynamic(config.RouteSave) + "?" +
+				config.KeyIsNavOn + "=true&" + config.KeyTheme + "=" + config.ThemeLight,
+			code: http.StatusOK,
+			check: func(t *testing.T, body []byte) {
+				assert.Equal(t, "Ok\n", string(body))
+			},
+		},
+		"saveBadParam": {
+			method: http.MethodPost,
+			path: config.Dynamic(config.RouteSave) + "?" +
+				config.KeyIsNavOn + "=maybe",
+			code: http.StatusBadRequest,
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req, err := http.NewRequest(method, srv.URL+tc.path, nil)
+			if !assert.NoError(t, err) {
+				return
+			}
+			resp, err := srv.Client().Do(req)
+			if !assert.NoError(t, err) {
+				return
+			}
+			body, err := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			assert.NoError(t, err)
+			if !assert.Equal(t, tc.code, resp.StatusCode, string(body)) {
+				return
+			}
+			if tc.ctype != "" {
+				assert.True(t,
+					strings.HasPrefix(resp.Header.Get("Content-Type"), tc.ctype),
+					"Content-Type is %q", resp.Header.Get("Content-Type"))
+			}
+			if tc.check != nil {
+				tc.check(t, body)
+			}
+		})
+	}
+	assert.Equal(t, []string{"echo hello\n"}, fake.Calls())
+}
+
+func TestServerSaveSetsCookie(t *testing.T) {
+	srv := startServer(t, &shell.Echo{})
+	resp, err := srv.Client().Post(srv.URL+config.Dynamic(config.RouteSave)+
+		"?"+config.KeyIsNavOn+"=true", "", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	cookies := resp.Cookies()
+	if assert.Len(t, cookies, 1) {
+		assert.Equal(t, "mdrip", cookies[0].Name)
+		assert.True(t, cookies[0].HttpOnly)
+	}
+}
@@ -0,0 +1,418 @@
+// Package runner runs the code blocks in markdown files, for use in
+// tests that assert documented commands still work, e.g.
+//
+//	results, err := runner.RunFile(ctx, "README.md", runner.Options{})
+//
+// It's the library form of "mdrip test", and doesn't involve the
+// web server.
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/monopole/mdrip/v2/internal/parsren/usegold"
+	"github.com/monopole/mdrip/v2/internal/shell"
+	"github.com/monopole/shexec"
+	"github.com/spf13/afero"
+)
+
+const (
+	// DefaultBlockTimeout is the default limit on the time a block may run.
+	DefaultBlockTimeout = 30 * time.Second
+	// DefaultSleep is the default pause after a block labelled @sleep.
+	DefaultSleep = 2 * time.Second
+
+	durationStartup  = 10 * time.Second
+	durationShutdown = 3 * time.Second
+)
+
+// Options configure a run.  The zero value is usable.
+type Options struct {
+	// Shell is the path to the shell; the default is shell.DefaultPath.
+	Shell string
+	// ShellArgs are arguments for the shell, e.g. "--norc", or, if
+	// Shell is a launcher like docker, e.g. "exec", "-i", "web", "sh".
+	ShellArgs []string
+	// Label, if not empty, limits the run to blocks having the label.
+	Label string
+	// Include, if not empty, limits the run to blocks having any
+	// of these labels.
+	Include []loader.Label
+	// Exclude leaves out of the run blocks having any of these labels.
+	Exclude []loader.Label
+	// BlockTimeout limits the time any one block may run,
+	// unless the block has a timeout label.
+	BlockTimeout time.Duration
+	// Sleep is the pause after running a block labelled @sleep.
+	Sleep time.Duration
+	// KeepGoing means keep running blocks after a block fails.
+	KeepGoing bool
+	// Executor, if not nil, runs the blocks, including those naming
+	// an interpreter, rather than a shell started for the run.
+	// Shell is then ignored.
+	Executor shell.Executor
+	// HistoryFile, if not empty, is the path to a file to which
+	// each block run is appended, per shell.History.
+	HistoryFile string
+	// ProfileScript, if not empty, is a path to a script, or the
+	// script itself, run in the shell before any block, per
+	// shell.ManagedShell.SetProfileScript.  It's ignored given
+	// an Executor.
+	ProfileScript string
+	// Isolated means run each block in its own subshell, per
+	// shell.ManagedShell.SetIsolated, so that, e.g., a cd in one
+	// block doesn't affect the next.  It's ignored given an Executor.
+	Isolated bool
+	// Interactive means run the blocks on a pseudo-terminal, per
+	// shell.ManagedShell.SetInteractive, for programs that need one.
+	// Their stderr is then reported as stdout.  It's ignored given
+	// an Executor.
+	Interactive bool
+	// TrimOutput means drop blank lines from the blocks' stdout and
+	// stderr, per shell.ManagedShell.SetTrimOutput, as a UI would.
+	// By default they're kept, so that output whose whitespace
+	// matters comes back as is.  It's ignored given an Executor.
+	TrimOutput bool
+}
+
+// Selects is true if the options select the block for a run, per
+// Label, Include and Exclude.  It doesn't consider @skip, which
+// RunBlocks handles.
+func (o *Options) Selects(b *loader.CodeBlock) bool {
+	if o.Label != "" && !b.HasLabel(loader.Label(o.Label)) {
+		return false
+	}
+	if len(o.Include) > 0 && !hasAny(b, o.Include) {
+		return false
+	}
+	return !hasAny(b, o.Exclude)
+}
+
+// hasAny is true if the block has any of the labels.
+func hasAny(b *loader.CodeBlock, labels []loader.Label) bool {
+	for _, l := range labels {
+		if b.HasLabel(l) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *Options) setDefaults() {
+	if o.Shell == "" {
+		o.Shell = shell.DefaultPath
+	}
+	if o.BlockTimeout <= 0 {
+		o.BlockTimeout = DefaultBlockTimeout
+	}
+	if o.Sleep <= 0 {
+		o.Sleep = DefaultSleep
+	}
+}
+
+// BlockResult is the outcome of one code block.
+type BlockResult struct {
+	// Name is the block's name, unique within its file.
+	Name string
+	// Path is the path to the file holding the block.
+	Path string
+	// Line is the line number of the block's opening fence
+	// in that file, or zero if unknown.
+	Line int
+	// Code is the code in the block.
+	Code string
+	// Skipped is true if the block was labelled @skip.
+	Skipped bool
+	Stdout  []string
+	Stderr  []string
+	// ExitCode is the exit status of the block's last command.
+	ExitCode int
+	// ExpectedExit is the exit status the block should have,
+	// zero unless set by a label; it may be loader.AnyFailure.
+	ExpectedExit int
+	// Timeout is the limit the block ran under, per
+	// shell.ResolveTimeout, before any cut by the context's deadline.
+	Timeout time.Duration
+	// Err is set if the block couldn't be run to completion,
+	// e.g. it timed out.
+	Err error
+	// AssertionErr is set if the block's stdout didn't satisfy
+	// an assertion made by the block's labels.
+	AssertionErr error
+	// Usage is the block's resource usage, if it ran in a process of
+	// its own, e.g. given Options.Isolated, else nil.
+	Usage *shell.Usage
+	// Start is when the block started running, zero if it didn't.
+	Start time.Time
+	// Duration is how long the block ran.
+	Duration time.Duration
+}
+
+// Passed is true if the block was skipped, or ran to completion
+// with the expected exit status.
+func (br *BlockResult) Passed() bool {
+	return br.Skipped ||
+		(br.Err == nil && br.AssertionErr == nil &&
+			loader.ExitMatches(br.ExitCode, br.ExpectedExit))
+}
+
+// RunFile runs the runnable code blocks found in the markdown at the
+// given path (a file or a folder), in order, in one shell, so that
+// environment changes made by one block are seen by the next.
+// The returned error is non-nil if the markdown couldn't be loaded,
+// the shell couldn't start, or a block failed.  In the latter case
+// the results are returned too, up to and including the failure
+// (or all of them, given Options.KeepGoing).
+func RunFile(
+	ctx context.Context, path string, opts Options) ([]BlockResult, error) {
+	fld, err := loader.New(afero.NewOsFs(),
+		loader.IsMarkDownFile, loader.InNotIgnorableFolder).LoadTrees(
+		[]string{path})
+	if err != nil {
+		return nil, err
+	}
+	p := usegold.NewGParser()
+	fld.Accept(p)
+	if err = p.Error(); err != nil {
+		return nil, err
+	}
+	return RunBlocks(ctx, p.Filter(func(b *loader.CodeBlock) bool {
+		return b.IsRunnable() && opts.Selects(b)
+	}), opts)
+}
+
+// RunBlocks runs the given blocks, as described in RunFile.
+func RunBlocks(
+	ctx context.Context, blocks []*loader.CodeBlock,
+	opts Options) ([]BlockResult, error) {
+	opts.setDefaults()
+	ex := opts.Executor
+	if ex == nil {
+		sh := shell.NewManagedShell(opts.Shell, opts.ShellArgs...)
+		sh.SetProfileScript(opts.ProfileScript)
+		sh.SetIsolated(opts.Isolated)
+		sh.SetInteractive(opts.Interactive)
+		sh.SetTrimOutput(opts.TrimOutput)
+		if err := sh.Start(durationStartup); err != nil {
+			return nil, err
+		}
+		defer func() { _ = sh.Stop(durationShutdown) }()
+		var own shell.Executor = sh
+		if opts.HistoryFile != "" {
+			own = shell.NewHistory(sh, opts.HistoryFile)
+		}
+		ex = &ownShell{own}
+	} else if opts.HistoryFile != "" {
+		ex = shell.NewHistory(ex, opts.HistoryFile)
+	}
+	var (
+		results []BlockResult
+		failed  error
+	)
+	for _, b := range blocks {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		res := runBlock(ctx, ex, b, opts)
+		results = append(results, res)
+		if res.Passed() {
+			if res.Skipped {
+				continue
+			}
+			if b.HasLabel(loader.SleepLabel) {
+				if err := sleep(ctx, opts.Sleep); err != nil {
+					return results, err
+				}
+			}
+			continue
+		}
+		if failed == nil {
+			failed = res.failure(b)
+		}
+		if !opts.KeepGoing {
+			break
+		}
+	}
+	return results, failed
+}
+
+// failure describes the failure of the block, e.g.
+//
+//	path/to/doc.md:42: block failed with exit 1
+func (br *BlockResult) failure(b *loader.CodeBlock) error {
+	if br.Err != nil {
+		return fmt.Errorf("%s: block %s failed; %w", b.Location(), br.Name, br.Err)
+	}
+	if br.AssertionErr != nil {
+		return fmt.Errorf("%s: %w", b.Location(), br.AssertionErr)
+	}
+	switch br.ExpectedExit {
+	case 0:
+		return fmt.Errorf(
+			"%s: block failed with exit %d", b.Location(), br.ExitCode)
+	case loader.AnyFailure:
+		return fmt.Errorf(
+			"%s: block succeeded, but was expected to fail", b.Location())
+	}
+	return fmt.Errorf("%s: block failed with exit %d, expected exit %d",
+		b.Location(), br.ExitCode, br.ExpectedExit)
+}
+
+func runBlock(
+	ctx context.Context, ex shell.Executor,
+	b *loader.CodeBlock, opts Options) BlockResult {
+	res := BlockResult{
+		Name: b.UniqName(),
+		Path: string(b.Path()),
+		Line: b.Line(),
+		Code: b.Code(),
+	}
+	if b.HasLabel(loader.SkipLabel) {
+		res.Skipped = true
+		return res
+	}
+	res.ExpectedExit, _ = loader.ParseExpectedExit(b.Labels())
+	asserts, err := loader.ParseOutputAssertions(b.Labels())
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	res.Timeout = shell.ResolveTimeout(
+		0, loader.ParseTimeout(b.Labels()), opts.BlockTimeout)
+	d := res.Timeout
+	if deadline, ok := ctx.Deadline(); ok {
+		d = min(d, time.Until(deadline))
+	}
+	res.Start = time.Now()
+	execBlock(ctx, ex, b, d, opts.TrimOutput, &res)
+	res.Duration = time.Since(res.Start)
+	if res.Err == nil {
+		res.AssertionErr = checkOutput(asserts, res.Stdout)
+	}
+	return res
+}
+
+// ownShell is the shell started for a run, perhaps keeping a history.
+type ownShell struct {
+	shell.Executor
+}
+
+// execBlock runs the block, recording its output and exit status.
+// A block naming an interpreter runs in a process of its own, unless
+// the executor came from Options, in which case the block is piped
+// to the interpreter via the executor.  The output of its own
+// process is trimmed, or not, as the shell's is.
+func execBlock(
+	ctx context.Context, ex shell.Executor, b *loader.CodeBlock,
+	d time.Duration, trim bool, res *BlockResult) {
+	code := b.Code()
+	env := loader.ParseEnvOverrides(b.Labels())
+	_, isOwn := ex.(*ownShell)
+	if interp := b.Interpreter(); interp != "" && isOwn {
+		var c recaller = shexec.NewRecallCommander(b.Code())
+		if !trim {
+			c = &blankKeeper{cmd: b.Code()}
+		}
+		argv := []string{interp}
+		if len(env) > 0 {
+			argv = append(append([]string{"env"}, env...), argv...)
+		}
+		err := shell.RunOnce(d, c, argv[0], argv[1:]...)
+		res.Stdout, res.Stderr = c.DataOut(), c.DataErr()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			res.ExitCode = exitErr.ExitCode()
+		} else {
+			res.Err = err
+		}
+		return
+	}
+	if interp := b.Interpreter(); interp != "" {
+		code = shell.PipedTo(interp, code)
+	}
+	code = shell.WithEnv(env, code)
+	ctx, cancel := context.WithTimeout(shell.WithSource(ctx, b.Code()), d)
+	defer cancel()
+	out, err := ex.Execute(ctx, code)
+	if out != nil {
+		res.Stdout, res.Stderr, res.ExitCode = out.Stdout, out.Stderr, out.ExitCode
+		res.Usage = out.Usage
+	}
+	res.Err = err
+}
+
+// recaller is a commander that remembers the output it sees.
+type recaller interface {
+	shexec.Commander
+	DataOut() []string
+	DataErr() []string
+}
+
+// blankKeeper is like shexec.RecallCommander, but keeps blank lines.
+type blankKeeper struct {
+	cmd      string
+	out, err lines
+}
+
+func (c *blankKeeper) Command() string          { return c.cmd }
+func (c *blankKeeper) ParseOut() io.WriteCloser { return &c.out }
+func (c *blankKeeper) ParseErr() io.WriteCloser { return &c.err }
+func (c *blankKeeper) DataOut() []string        { return c.out }
+func (c *blankKeeper) DataErr() []string        { return c.err }
+
+// lines remembers every line written to it.
+type lines []string
+
+func (l *lines) Write(data []byte) (int, error) {
+	*l = append(*l, string(data))
+	return len(data), nil
+}
+
+func (l *lines) Close() error { return nil }
+
+// checkOutput returns an error describing the first assertion
+// that the output fails to satisfy, if any.
+func checkOutput(asserts []loader.OutputAssertion, stdout []string) error {
+	out := strings.Join(stdout, "\n")
+	for _, a := range asserts {
+		var want string
+		switch {
+		case a.Pattern != nil:
+			if a.Pattern.MatchString(out) {
+				continue
+			}
+			want = "want match: " + a.Pattern.String()
+		default:
+			if strings.Contains(out, a.Substring) {
+				continue
+			}
+			want = "want substring: " + a.Substring
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "output assertion @%s failed\n- %s\n+ got stdout:", a.Label, want)
+		if len(stdout) == 0 {
+			b.WriteString(" <empty>")
+		}
+		for _, line := range stdout {
+			b.WriteString("\n+   " + line)
+		}
+		return errors.New(b.String())
+	}
+	return nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
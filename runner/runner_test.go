@@ -0,0 +1,447 @@
+package runner_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/monopole/mdrip/v2/internal/loader"
+	"github.com/monopole/mdrip/v2/internal/shell"
+	. "github.com/monopole/mdrip/v2/runner"
+	"github.com/stretchr/testify/assert"
+)
+
+const shPath = "/bin/sh"
+
+func writeMd(t *testing.T, content string) string {
+	f := filepath.Join(t.TempDir(), "doc.md")
+	assert.NoError(t, os.WriteFile(f, []byte(content), 0644))
+	return f
+}
+
+func TestRunFile(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	f := writeMd(t, `
+# Example
+
+`+"```"+`
+greeting=hello
+echo $greeting
+`+"```"+`
+
+<!-- @skip -->
+`+"```"+`
+rm -rf /
+`+"```"+`
+
+Not something to run:
+`+"```yaml"+`
+kind: Pod
+`+"```"+`
+
+<!-- @sleep -->
+`+"```"+`
+echo "$greeting again" 1>&2
+`+"```"+`
+`)
+	start := time.Now()
+	results, err := RunFile(context.Background(), f, Options{
+		Shell: shPath,
+		Sleep: 10 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	if !assert.Equal(t, 3, len(results)) {
+		t.FailNow()
+	}
+	assert.Equal(t, []string{"hello"}, results[0].Stdout)
+	assert.True(t, results[0].Passed())
+	assert.True(t, results[1].Skipped)
+	assert.Empty(t, results[1].Stdout)
+	assert.True(t, results[1].Start.IsZero())
+	assert.False(t, results[2].Start.Before(results[0].Start))
+	assert.Equal(t, []string{"hello again"}, results[2].Stderr)
+	for i, line := range []int{4, 10, 20} {
+		assert.Equal(t, f, results[i].Path)
+		assert.Equal(t, line, results[i].Line)
+		assert.NoError(t, results[i].Err)
+	}
+}
+
+func TestRunFileFailure(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	f := writeMd(t, `
+`+"```"+`
+ls /nonexistent
+`+"```"+`
+
+`+"```"+`
+echo after
+`+"```"+`
+`)
+	for n, tc := range map[string]struct {
+		keepGoing  bool
+		numResults int
+	}{
+		"stop":      {numResults: 1},
+		"keepGoing": {keepGoing: true, numResults: 2},
+	} {
+		t.Run(n, func(t *testing.T) {
+			results, err := RunFile(context.Background(), f, Options{
+				Shell:     shPath,
+				KeepGoing: tc.keepGoing,
+			})
+			if assert.Error(t, err) {
+				assert.Equal(t, f+":2: block failed with exit 2", err.Error())
+			}
+			if !assert.Equal(t, tc.numResults, len(results)) {
+				t.FailNow()
+			}
+			assert.False(t, results[0].Passed())
+			assert.Equal(t, 2, results[0].ExitCode)
+			assert.Equal(t, f, results[0].Path)
+			assert.Equal(t, 2, results[0].Line)
+			assert.NotEmpty(t, results[0].Stderr)
+			if tc.keepGoing {
+				assert.True(t, results[1].Passed())
+				assert.Equal(t, []string{"after"}, results[1].Stdout)
+			}
+		})
+	}
+}
+
+func TestRunFileNoSuchFile(t *testing.T) {
+	_, err := RunFile(context.Background(), "/no/such/doc.md", Options{})
+	assert.Error(t, err)
+}
+
+func TestRunFileExpectedExit(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	for n, tc := range map[string]struct {
+		labels  string
+		code    string
+		wantErr string
+	}{
+		"defaultPass": {
+			code: "true",
+		},
+		"defaultFail": {
+			code:    "(exit 3)",
+			wantErr: ":3: block failed with exit 3",
+		},
+		"expectFailPass": {
+			labels: "@expect_fail",
+			code:   "(exit 3)",
+		},
+		"expectFailFail": {
+			labels:  "@expect_fail",
+			code:    "true",
+			wantErr: ":3: block succeeded, but was expected to fail",
+		},
+		"exit2Pass": {
+			labels: "@exit=2",
+			code:   "(exit 2)",
+		},
+		"exit2Fail": {
+			labels:  "@exit=2",
+			code:    "(exit 1)",
+			wantErr: ":3: block failed with exit 1, expected exit 2",
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			f := writeMd(t, "\n<!-- "+tc.labels+" -->\n```\n"+tc.code+"\n```\n")
+			results, err := RunFile(
+				context.Background(), f, Options{Shell: shPath})
+			if !assert.Equal(t, 1, len(results)) {
+				t.FailNow()
+			}
+			if tc.wantErr == "" {
+				assert.NoError(t, err)
+				assert.True(t, results[0].Passed())
+				return
+			}
+			assert.False(t, results[0].Passed())
+			if assert.Error(t, err) {
+				assert.Equal(t, f+tc.wantErr, err.Error())
+			}
+		})
+	}
+}
+
+func TestRunFileOutputAssertions(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	const code = "echo 'version v1.22.3'\necho built"
+	for n, tc := range map[string]struct {
+		labels  string
+		wantErr string
+	}{
+		"substringPass": {
+			labels: "@assert=v1.22",
+		},
+		"substringFail": {
+			labels: "@assert=v2",
+			wantErr: `:3: output assertion @assert=v2 failed
+- want substring: v2
++ got stdout:
++   version v1.22.3
++   built`,
+		},
+		"regexpPass": {
+			labels: "@assertre=^version\\sv1\\.[0-9]+",
+		},
+		"regexpFail": {
+			labels: "@assertre=^built$",
+			wantErr: `:3: output assertion @assertre=^built$ failed
+- want match: ^built$
++ got stdout:
++   version v1.22.3
++   built`,
+		},
+		"regexpMultiLinePass": {
+			labels: "@assertre=(?m)^built$",
+		},
+		"bothMustPass": {
+			labels: "@assert=built @assert=nope",
+			wantErr: `:3: output assertion @assert=nope failed
+- want substring: nope
++ got stdout:
++   version v1.22.3
++   built`,
+		},
+		"badRegexp": {
+			labels:  "@assertre=(oops",
+			wantErr: ":3: block echoVersiV122 failed; bad regexp",
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			f := writeMd(t, "\n<!-- "+tc.labels+" -->\n```\n"+code+"\n```\n")
+			results, err := RunFile(
+				context.Background(), f, Options{Shell: shPath})
+			if !assert.Equal(t, 1, len(results)) {
+				t.FailNow()
+			}
+			if tc.wantErr == "" {
+				assert.NoError(t, err)
+				assert.True(t, results[0].Passed())
+				return
+			}
+			assert.False(t, results[0].Passed())
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), f+tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunFileTimeout(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	for n, tc := range map[string]struct {
+		labels  string
+		opts    time.Duration
+		want    time.Duration
+		timeout bool
+	}{
+		"default":       {want: DefaultBlockTimeout},
+		"option":        {opts: time.Minute, want: time.Minute},
+		"labelBeatsOpt": {labels: "@timeout=1", opts: time.Minute, want: time.Second, timeout: true},
+	} {
+		t.Run(n, func(t *testing.T) {
+			code := "true"
+			if tc.timeout {
+				code = "sleep 2"
+			}
+			f := writeMd(t, "\n<!-- "+tc.labels+" -->\n```\n"+code+"\n```\n")
+			results, err := RunFile(context.Background(), f, Options{
+				Shell:        shPath,
+				BlockTimeout: tc.opts,
+			})
+			if !assert.Equal(t, 1, len(results)) {
+				t.FailNow()
+			}
+			assert.Equal(t, tc.want, results[0].Timeout)
+			if tc.timeout {
+				assert.Error(t, err)
+				assert.Error(t, results[0].Err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestRunFileIncludeExclude(t *testing.T) {
+	f := writeMd(t, "<!-- @setup -->\n```\necho setup\n```\n"+
+		"<!-- @smoke -->\n```\necho smoke\n```\n"+
+		"<!-- @smoke @slow -->\n```\necho slow\n```\n"+
+		"<!-- @teardown -->\n```\necho teardown\n```\n")
+	for n, tc := range map[string]struct {
+		include []loader.Label
+		exclude []loader.Label
+		want    []string
+	}{
+		"all": {
+			want: []string{"setup", "smoke", "slow", "teardown"},
+		},
+		"includeOnly": {
+			include: []loader.Label{"setup", "smoke"},
+			want:    []string{"setup", "smoke", "slow"},
+		},
+		"excludeOnly": {
+			exclude: []loader.Label{"teardown"},
+			want:    []string{"setup", "smoke", "slow"},
+		},
+		"combined": {
+			include: []loader.Label{"smoke", "teardown"},
+			exclude: []loader.Label{"slow", "teardown"},
+			want:    []string{"smoke"},
+		},
+	} {
+		t.Run(n, func(t *testing.T) {
+			results, err := RunFile(context.Background(), f, Options{
+				Shell:   shPath,
+				Include: tc.include,
+				Exclude: tc.exclude,
+			})
+			assert.NoError(t, err)
+			var got []string
+			for _, r := range results {
+				got = append(got, strings.Join(r.Stdout, ""))
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestRunFileHistory(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	f := writeMd(t, "```\necho one\n```\n<!-- @skip -->\n```\necho no\n```\n"+
+		"<!-- @env=CODE=3 -->\n```\n(exit $CODE)\n```\n")
+	hist := filepath.Join(t.TempDir(), "history")
+	_, err := RunFile(context.Background(), f, Options{
+		Shell:       shPath,
+		HistoryFile: hist,
+		KeepGoing:   true,
+	})
+	assert.Error(t, err)
+	entries, err := shell.ReadHistory(hist)
+	assert.NoError(t, err)
+	if assert.Equal(t, 2, len(entries)) {
+		assert.Equal(t, "echo one\n", entries[0].Code)
+		assert.Equal(t, 0, entries[0].ExitCode)
+		// The block's code as written, without the env wrapping.
+		assert.Equal(t, "(exit $CODE)\n", entries[1].Code)
+		assert.Equal(t, 3, entries[1].ExitCode)
+	}
+}
+
+func TestRunFileProfileScript(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	f := writeMd(t, "```\ngreet world\n```\n")
+	results, err := RunFile(context.Background(), f, Options{
+		Shell:         shPath,
+		ProfileScript: "greet() { echo \"hello $1\"; }",
+	})
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(results)) {
+		assert.Equal(t, []string{"hello world"}, results[0].Stdout)
+	}
+
+	_, err = RunFile(context.Background(), f, Options{
+		Shell:         shPath,
+		ProfileScript: "false",
+	})
+	assert.ErrorContains(t, err, "profile script")
+}
+
+func TestRunFileEnvOverrides(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	f := writeMd(t, "<!-- @env=DEBUG=1 @env=WHO=the_world -->\n"+
+		"```\nsh -c 'echo \"$DEBUG $WHO\"'\ncd /tmp\n```\n"+
+		"```\necho \"${DEBUG-unset} ${WHO-unset}\"; pwd\n```\n")
+	results, err := RunFile(context.Background(), f, Options{Shell: shPath})
+	assert.NoError(t, err)
+	if assert.Equal(t, 2, len(results)) {
+		assert.Equal(t, []string{"1 the_world"}, results[0].Stdout)
+		// Unset afterward, though the cd stays.
+		assert.Equal(t, []string{"unset unset", "/tmp"}, results[1].Stdout)
+	}
+}
+
+func TestRunFileIsolated(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	dir, err := os.Getwd()
+	assert.NoError(t, err)
+	f := writeMd(t, "```\ncd /tmp\npwd\n```\n```\npwd\n```\n")
+	for n, tc := range map[string]struct {
+		isolated bool
+		want     string
+	}{
+		"shared":   {want: "/tmp"},
+		"isolated": {isolated: true, want: dir},
+	} {
+		t.Run(n, func(t *testing.T) {
+			results, err := RunFile(context.Background(), f, Options{
+				Shell:    shPath,
+				Isolated: tc.isolated,
+			})
+			assert.NoError(t, err)
+			if assert.Equal(t, 2, len(results)) {
+				assert.Equal(t, []string{"/tmp"}, results[0].Stdout)
+				assert.Equal(t, []string{tc.want}, results[1].Stdout)
+			}
+		})
+	}
+}
+
+func TestRunFileTrimOutput(t *testing.T) {
+	if _, err := os.Stat(shPath); err != nil {
+		t.Skip("skipping since " + shPath + " not found")
+	}
+	const printBlanks = "printf 'a\\n\\nb\\n\\n'; printf 'x\\n\\n' >&2\n"
+	f := writeMd(t, "```\n"+printBlanks+"```\n"+
+		"<!-- @interp=sh -->\n```\n"+printBlanks+"```\n")
+	for n, tc := range map[string]struct {
+		trim    bool
+		wantOut []string
+		wantErr []string
+	}{
+		"keep": {wantOut: []string{"a", "", "b", ""}, wantErr: []string{"x", ""}},
+		"trim": {trim: true, wantOut: []string{"a", "b"}, wantErr: []string{"x"}},
+	} {
+		t.Run(n, func(t *testing.T) {
+			results, err := RunFile(context.Background(), f, Options{
+				Shell:      shPath,
+				TrimOutput: tc.trim,
+			})
+			assert.NoError(t, err)
+			// The shell and the interpreter treat blank lines alike.
+			if assert.Equal(t, 2, len(results)) {
+				for _, r := range results {
+					assert.Equal(t, tc.wantOut, r.Stdout)
+					assert.Equal(t, tc.wantErr, r.Stderr)
+				}
+			}
+		})
+	}
+}